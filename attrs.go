@@ -52,7 +52,7 @@ func (el *Element) SetAttr(space, local, value string) {
 		}
 	}
 	el.StartElement.Attr = append(el.StartElement.Attr, xml.Attr{
-		Name:  xml.Name{space, local},
+		Name:  xml.Name{Space: space, Local: local},
 		Value: value,
 	})
 }
@@ -81,7 +81,7 @@ func (el *Element) AddClass(class string) {
 		}
 	}
 	el.StartElement.Attr = append(el.StartElement.Attr, xml.Attr{
-		Name:  xml.Name{"", "class"},
+		Name:  xml.Name{Local: "class"},
 		Value: class,
 	})
 }
@@ -0,0 +1,93 @@
+package xmltree
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// The encoding/xml package returns the contents of a CDATA section as
+// ordinary character data. To tell them apart, Parse keeps the source
+// of the document as it is read, and checks how each run of character
+// data was written.
+
+var cdataStart = []byte("<![CDATA[")
+
+// A recorder keeps the bytes read from a document which have not yet
+// been consumed by the xml.Decoder.
+type recorder struct {
+	r    io.Reader
+	buf  []byte
+	base int64 // offset of buf[0] in the document
+	off  bool  // offsets are unreliable
+}
+
+func (rec *recorder) Read(p []byte) (int, error) {
+	n, err := rec.r.Read(p)
+	if !rec.off {
+		rec.buf = append(rec.buf, p[:n]...)
+	}
+	return n, err
+}
+
+// cdata reports whether tok, which the decoder read from the given
+// offset, is a CDATA section. Bytes before the offset are discarded.
+func (rec *recorder) cdata(start int64, tok xml.Token) bool {
+	if rec.off {
+		return false
+	}
+	if i := start - rec.base; i > 0 && i <= int64(len(rec.buf)) {
+		rec.buf = rec.buf[i:]
+		rec.base = start
+	}
+	switch tok := tok.(type) {
+	case xml.ProcInst:
+		// Offsets reported by the decoder count decoded bytes, which
+		// only match the source in UTF-8.
+		if tok.Target == "xml" {
+			switch enc := strings.ToLower(procInstParam(string(tok.Inst), "encoding")); enc {
+			case "", "utf-8", "utf8", "us-ascii":
+			default:
+				rec.off, rec.buf = true, nil
+			}
+		}
+	case xml.CharData:
+		return start == rec.base && bytes.HasPrefix(rec.buf, cdataStart)
+	}
+	return false
+}
+
+// procInstParam returns the value of a pseudo-attribute such as
+// version or encoding in the target of an XML declaration.
+func procInstParam(inst, param string) string {
+	i := strings.Index(inst, param)
+	if i < 0 {
+		return ""
+	}
+	v := strings.TrimLeft(inst[i+len(param):], " \t\r\n")
+	if !strings.HasPrefix(v, "=") {
+		return ""
+	}
+	v = strings.TrimLeft(v[1:], " \t\r\n")
+	if v == "" || v[0] != '\'' && v[0] != '"' {
+		return ""
+	}
+	if j := strings.IndexByte(v[1:], v[0]); j >= 0 {
+		return v[1 : j+1]
+	}
+	return ""
+}
+
+// isText reports whether el is a text node in the XPath data model.
+func isText(el *Element) bool {
+	return el.Type == XML_CharData || el.Type == XML_CDATA
+}
+
+// writeCDATA writes s as a CDATA section. Any "]]>" within s is split
+// across two sections.
+func writeCDATA(w io.Writer, s string) {
+	io.WriteString(w, "<![CDATA[")
+	io.WriteString(w, strings.ReplaceAll(s, "]]>", "]]]]><![CDATA[>"))
+	io.WriteString(w, "]]>")
+}
@@ -0,0 +1,46 @@
+package xmltree
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCDATA(t *testing.T) {
+	const doc = `<textfilecontent54_object id="obj:1"><pattern operation="pattern match"><![CDATA[^\s*umask\s+0?(\d{3})\s*(#.*)?$]]></pattern>` +
+		`<script>if (a &lt; b &amp;&amp; c) <![CDATA[{ x = "]]]]><![CDATA[>"; }]]></script></textfilecontent54_object>`
+	root := parseFullDoc(t, []byte(doc))
+
+	pattern := root.Children[0]
+	if len(pattern.Children) != 1 || pattern.Children[0].Type != XML_CDATA {
+		t.Fatalf("CDATA section not kept: %#v", pattern)
+	}
+	if got, want := pattern.Text(), `^\s*umask\s+0?(\d{3})\s*(#.*)?$`; got != want {
+		t.Errorf("Text() = %q, wanted %q", got, want)
+	}
+	if got, want := root.Children[1].Text(), `if (a < b && c) { x = "]]>"; }`; got != want {
+		t.Errorf("Text() = %q, wanted %q", got, want)
+	}
+	if got := root.String(); got != doc {
+		t.Errorf("CDATA did not round trip:\n%s\nwanted:\n%s", got, doc)
+	}
+
+	// CDATA compares equal to the same text written with references.
+	escaped := parseFullDoc(t, []byte(`<textfilecontent54_object id="obj:1"><pattern operation="pattern match">^\s*umask\s+0?(\d{3})\s*(#.*)?$</pattern>`+
+		`<script>if (a &lt; b &amp;&amp; c) { x = "]]&gt;"; }</script></textfilecontent54_object>`))
+	if !Equal(root, escaped) {
+		t.Errorf("CDATA sections compare unequal to escaped text")
+	}
+}
+
+func TestCDATAEncoding(t *testing.T) {
+	// Offsets reported for other encodings do not match the source,
+	// so CDATA sections are read as text.
+	const doc = `<?xml version="1.0" encoding="ISO-8859-1"?><a><![CDATA[x < y]]></a>`
+	root, err := Parse(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Content != "x < y" {
+		t.Errorf("got content %q", root.Content)
+	}
+}
@@ -0,0 +1,785 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"go/format"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pschou/go-xmltree"
+)
+
+const xsdNS = "http://www.w3.org/2001/XMLSchema"
+
+// basicTypes maps the built-in types of XML Schema to the Go types which
+// hold them. Other built-in types are held in strings.
+var basicTypes = map[string]string{
+	"boolean":            "bool",
+	"float":              "float32",
+	"double":             "float64",
+	"decimal":            "float64",
+	"integer":            "int64",
+	"nonPositiveInteger": "int64",
+	"negativeInteger":    "int64",
+	"long":               "int64",
+	"int":                "int32",
+	"short":              "int16",
+	"byte":               "int8",
+	"nonNegativeInteger": "uint64",
+	"positiveInteger":    "uint64",
+	"unsignedLong":       "uint64",
+	"unsignedInt":        "uint32",
+	"unsignedShort":      "uint16",
+	"unsignedByte":       "uint8",
+}
+
+// A schemaDoc is a schema document, with the properties which apply to
+// the definitions within it.
+type schemaDoc struct {
+	el       *xmltree.Element
+	target   string
+	qualElem bool // elementFormDefault="qualified"
+	qualAttr bool // attributeFormDefault="qualified"
+}
+
+// A def is a top-level definition in a schema document.
+type def struct {
+	el  *xmltree.Element
+	doc *schemaDoc
+}
+
+// A goType is a Go type being generated.
+type goType struct {
+	name    string
+	doc     string
+	basic   string   // the underlying type of a simple type
+	enum    []string // the enumerated values of a simple type
+	xmlName string   // the tag of the XMLName field of a root element
+	embed   []string
+	fields  []field
+}
+
+type field struct {
+	name, typ, tag string
+}
+
+// A generator generates Go types from schema documents.
+type generator struct {
+	load func(path string) (*xmltree.Element, error)
+
+	docs     []*schemaDoc
+	loaded   map[string]bool
+	elements map[xml.Name]def
+	complex  map[xml.Name]def
+	simple   map[xml.Name]def
+	groups   map[xml.Name]def
+	attrs    map[xml.Name]def
+	attrGrps map[xml.Name]def
+	uses     map[*xmltree.Element]int // references to complex types
+
+	types   []*goType
+	byDef   map[*xmltree.Element]*goType
+	names   map[string]bool
+	imports map[string]bool
+}
+
+func newGenerator(load func(path string) (*xmltree.Element, error)) *generator {
+	return &generator{
+		load:     load,
+		loaded:   make(map[string]bool),
+		elements: make(map[xml.Name]def),
+		complex:  make(map[xml.Name]def),
+		simple:   make(map[xml.Name]def),
+		groups:   make(map[xml.Name]def),
+		attrs:    make(map[xml.Name]def),
+		attrGrps: make(map[xml.Name]def),
+		uses:     make(map[*xmltree.Element]int),
+		byDef:    make(map[*xmltree.Element]*goType),
+		names:    make(map[string]bool),
+		imports:  map[string]bool{"encoding/xml": true},
+	}
+}
+
+func isXS(el *xmltree.Element, local string) bool {
+	return el.Type == xmltree.XML_Tag && el.Name.Space == xsdNS && el.Name.Local == local
+}
+
+// xsChildren returns the XML Schema children of el.
+func xsChildren(el *xmltree.Element) []*xmltree.Element {
+	var list []*xmltree.Element
+	for i := range el.Children {
+		if c := &el.Children[i]; c.Type == xmltree.XML_Tag && c.Name.Space == xsdNS && c.Name.Local != "annotation" {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// addSchema adds a schema document, read from path if it is not empty,
+// and those it includes and imports. target is the namespace of the
+// including document, for an included schema without a namespace of
+// its own.
+func (g *generator) addSchema(el *xmltree.Element, path, target string) error {
+	if !isXS(el, "schema") {
+		return fmt.Errorf("%s: not an xs:schema", path)
+	}
+	if path != "" {
+		g.loaded[path] = true
+	}
+	doc := &schemaDoc{
+		el:       el,
+		target:   target,
+		qualElem: el.Attr("", "elementFormDefault") == "qualified",
+		qualAttr: el.Attr("", "attributeFormDefault") == "qualified",
+	}
+	if _, ok := attrValue(el, "targetNamespace"); ok {
+		doc.target = el.Attr("", "targetNamespace")
+	}
+	g.docs = append(g.docs, doc)
+	for _, c := range xsChildren(el) {
+		name := xml.Name{Space: doc.target, Local: c.Attr("", "name")}
+		switch c.Name.Local {
+		case "element":
+			g.elements[name] = def{c, doc}
+		case "complexType":
+			g.complex[name] = def{c, doc}
+		case "simpleType":
+			g.simple[name] = def{c, doc}
+		case "group":
+			g.groups[name] = def{c, doc}
+		case "attribute":
+			g.attrs[name] = def{c, doc}
+		case "attributeGroup":
+			g.attrGrps[name] = def{c, doc}
+		case "include", "import", "redefine":
+			loc := c.Attr("", "schemaLocation")
+			if loc == "" || strings.Contains(loc, "://") || g.load == nil {
+				continue
+			}
+			if path != "" && !filepath.IsAbs(loc) {
+				loc = filepath.Join(filepath.Dir(path), loc)
+			}
+			if g.loaded[loc] {
+				continue
+			}
+			sub, err := g.load(loc)
+			if err != nil {
+				return err
+			}
+			t := doc.target
+			if c.Name.Local == "import" {
+				t = c.Attr("", "namespace")
+			}
+			if err := g.addSchema(sub, loc, t); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func attrValue(el *xmltree.Element, local string) (string, bool) {
+	for _, a := range el.StartElement.Attr {
+		if a.Name.Space == "" && a.Name.Local == local {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// countUses counts the references to each complex type, so that a type
+// used only by one global element can be merged with it.
+func (g *generator) countUses() {
+	for _, doc := range g.docs {
+		doc.el.WalkFunc(func(el *xmltree.Element) error {
+			var ref string
+			switch {
+			case isXS(el, "element"):
+				ref = el.Attr("", "type")
+			case isXS(el, "extension"), isXS(el, "restriction"):
+				ref = el.Attr("", "base")
+			}
+			if ref != "" {
+				if d, ok := g.complex[el.Resolve(ref)]; ok {
+					g.uses[d.el]++
+					if !isXS(el, "element") {
+						g.uses[d.el]++
+					}
+				}
+			}
+			return nil
+		})
+	}
+}
+
+// generate generates types for every top-level element and type, and
+// returns the formatted source of a Go file.
+func (g *generator) generate(pkg string) ([]byte, error) {
+	g.countUses()
+	for _, doc := range g.docs {
+		for _, c := range xsChildren(doc.el) {
+			var err error
+			switch c.Name.Local {
+			case "element":
+				_, err = g.rootElement(def{c, doc})
+			case "complexType":
+				_, err = g.complexType(def{c, doc})
+			case "simpleType":
+				_, err = g.simpleType(def{c, doc})
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by xmltree-gen; DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkg)
+	var imports []string
+	for path := range g.imports {
+		imports = append(imports, path)
+	}
+	sort.Strings(imports)
+	for i, path := range imports {
+		// Standard packages come first, apart from the others.
+		if i > 0 && strings.Contains(path, ".") != strings.Contains(imports[i-1], ".") {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "\t%q\n", path)
+	}
+	b.WriteString(")\n")
+	for _, t := range g.types {
+		t.write(&b)
+	}
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %v", err)
+	}
+	return src, nil
+}
+
+func (t *goType) write(b *bytes.Buffer) {
+	fmt.Fprintf(b, "\n// %s\n", t.doc)
+	if t.basic != "" {
+		fmt.Fprintf(b, "type %s %s\n", t.name, t.basic)
+		if len(t.enum) > 0 {
+			fmt.Fprintf(b, "\n// Values of %s.\nconst (\n", t.name)
+			used := make(map[string]bool)
+			for i, v := range t.enum {
+				name := t.name + goName(v)
+				if name == t.name || used[name] {
+					name = t.name + strconv.Itoa(i+1)
+				}
+				used[name] = true
+				fmt.Fprintf(b, "\t%s %s = %q\n", name, t.name, v)
+			}
+			b.WriteString(")\n")
+		}
+		return
+	}
+	fmt.Fprintf(b, "type %s struct {\n", t.name)
+	if t.xmlName != "" {
+		fmt.Fprintf(b, "\tXMLName xml.Name `xml:%q`\n", t.xmlName)
+	}
+	for _, e := range t.embed {
+		fmt.Fprintf(b, "\t%s\n", e)
+	}
+	for _, f := range t.fields {
+		fmt.Fprintf(b, "\t%s %s `xml:%q`\n", f.name, f.typ, f.tag)
+	}
+	b.WriteString("}\n")
+}
+
+// initialisms are the words written in upper case in Go names.
+var initialisms = map[string]bool{
+	"ID": true, "URI": true, "URL": true, "UUID": true, "XML": true, "HTML": true, "HTTP": true,
+}
+
+// goName converts an XML name into an exported Go identifier.
+func goName(s string) string {
+	var b strings.Builder
+	words := strings.FieldsFunc(s, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	for _, w := range words {
+		if initialisms[strings.ToUpper(w)] {
+			b.WriteString(strings.ToUpper(w))
+			continue
+		}
+		r := []rune(w)
+		r[0] = unicode.ToUpper(r[0])
+		b.WriteString(string(r))
+	}
+	name := b.String()
+	if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+		name = "X" + name
+	}
+	return name
+}
+
+// typeName returns an unused name for a Go type.
+func (g *generator) typeName(name string) string {
+	n := name
+	for i := 2; g.names[n]; i++ {
+		n = name + strconv.Itoa(i)
+	}
+	g.names[n] = true
+	return n
+}
+
+// defName returns the name for the Go type of a named schema type,
+// leaving off a Type suffix.
+func (g *generator) defName(name string) string {
+	n := goName(name)
+	if s := strings.TrimSuffix(n, "Type"); s != "" && !g.names[s] {
+		n = s
+	}
+	return g.typeName(n)
+}
+
+func (g *generator) newType(el *xmltree.Element, name, doc string) *goType {
+	t := &goType{name: name, doc: name + " " + doc}
+	g.byDef[el] = t
+	g.types = append(g.types, t)
+	return t
+}
+
+// described returns "name", or "name in the namespace ns".
+func described(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Local + " in the namespace " + name.Space
+}
+
+// rootElement returns the type of a top-level element.
+func (g *generator) rootElement(d def) (*goType, error) {
+	if t, ok := g.byDef[d.el]; ok {
+		return t, nil
+	}
+	name := xml.Name{Space: d.doc.target, Local: d.el.Attr("", "name")}
+	tag := name.Local
+	if name.Space != "" {
+		tag = name.Space + " " + name.Local
+	}
+	doc := "is the element " + described(name) + "."
+
+	if ref := d.el.Attr("", "type"); ref != "" {
+		qn := d.el.Resolve(ref)
+		if ct, ok := g.complex[qn]; ok {
+			if _, done := g.byDef[ct.el]; !done && g.uses[ct.el] == 1 {
+				// The type is only used by this element.
+				t := g.newType(ct.el, g.typeName(goName(name.Local)), doc)
+				g.byDef[d.el] = t
+				t.xmlName = tag
+				return t, g.complexContent(t, ct.el, ct.doc)
+			}
+			base, err := g.complexType(ct)
+			if err != nil {
+				return nil, err
+			}
+			t := g.newType(d.el, g.typeName(goName(name.Local)), doc)
+			t.xmlName, t.embed = tag, []string{base.name}
+			return t, nil
+		}
+	}
+	if ct := firstXS(d.el, "complexType"); ct != nil {
+		t := g.newType(d.el, g.typeName(goName(name.Local)), doc)
+		t.xmlName = tag
+		return t, g.complexContent(t, ct, d.doc)
+	}
+	typ, _, err := g.elementType(d.el, d.doc, name.Local)
+	if err != nil {
+		return nil, err
+	}
+	t := g.newType(d.el, g.typeName(goName(name.Local)), doc)
+	t.xmlName = tag
+	if typ == "xmltree.Element" {
+		t.fields = []field{{"Content", "string", ",innerxml"}}
+	} else {
+		t.fields = []field{{"Value", typ, ",chardata"}}
+	}
+	return t, nil
+}
+
+func firstXS(el *xmltree.Element, local string) *xmltree.Element {
+	for _, c := range xsChildren(el) {
+		if c.Name.Local == local {
+			return c
+		}
+	}
+	return nil
+}
+
+// complexType returns the type of a named complex type.
+func (g *generator) complexType(d def) (*goType, error) {
+	if t, ok := g.byDef[d.el]; ok {
+		return t, nil
+	}
+	name := d.el.Attr("", "name")
+	t := g.newType(d.el, g.defName(name), "is the complex type "+described(xml.Name{Space: d.doc.target, Local: name})+".")
+	return t, g.complexContent(t, d.el, d.doc)
+}
+
+// simpleType returns the type of a named simple type.
+func (g *generator) simpleType(d def) (*goType, error) {
+	if t, ok := g.byDef[d.el]; ok {
+		return t, nil
+	}
+	name := d.el.Attr("", "name")
+	t := g.newType(d.el, g.defName(name), "is the simple type "+described(xml.Name{Space: d.doc.target, Local: name})+".")
+	basic, err := g.basicOf(d.el)
+	if err != nil {
+		return nil, err
+	}
+	t.basic = basic
+	if r := firstXS(d.el, "restriction"); r != nil {
+		for _, c := range xsChildren(r) {
+			if c.Name.Local == "enumeration" {
+				t.enum = append(t.enum, c.Attr("", "value"))
+			}
+		}
+	}
+	return t, nil
+}
+
+// basicOf returns the Go type underlying a simple type definition.
+func (g *generator) basicOf(st *xmltree.Element) (string, error) {
+	r := firstXS(st, "restriction")
+	if r == nil {
+		return "string", nil // a list or union
+	}
+	if base := r.Attr("", "base"); base != "" {
+		return g.simpleRef(r, base)
+	}
+	if inner := firstXS(r, "simpleType"); inner != nil {
+		return g.basicOf(inner)
+	}
+	return "string", nil
+}
+
+// simpleRef returns the Go type of a simple type named by the QName ref,
+// written in el.
+func (g *generator) simpleRef(el *xmltree.Element, ref string) (string, error) {
+	qn := el.Resolve(ref)
+	if qn.Space == xsdNS {
+		if t, ok := basicTypes[qn.Local]; ok {
+			return t, nil
+		}
+		return "string", nil
+	}
+	d, ok := g.simple[qn]
+	if !ok {
+		return "", fmt.Errorf("undefined simple type %s", ref)
+	}
+	t, err := g.simpleType(d)
+	if err != nil {
+		return "", err
+	}
+	return t.name, nil
+}
+
+// elementType returns the Go type of the content of an element
+// declaration, and whether it is a struct.
+func (g *generator) elementType(el *xmltree.Element, doc *schemaDoc, local string) (string, bool, error) {
+	if ref := el.Attr("", "type"); ref != "" {
+		qn := el.Resolve(ref)
+		if qn.Space == xsdNS && qn.Local == "anyType" {
+			g.imports["github.com/pschou/go-xmltree"] = true
+			return "xmltree.Element", true, nil
+		}
+		if d, ok := g.complex[qn]; ok {
+			t, err := g.complexType(d)
+			if err != nil {
+				return "", false, err
+			}
+			return t.name, true, nil
+		}
+		t, err := g.simpleRef(el, ref)
+		return t, false, err
+	}
+	if ct := firstXS(el, "complexType"); ct != nil {
+		if t, ok := g.byDef[ct]; ok {
+			return t.name, true, nil
+		}
+		t := g.newType(ct, g.typeName(goName(local)), "is the content of the element "+local+".")
+		return t.name, true, g.complexContent(t, ct, doc)
+	}
+	if st := firstXS(el, "simpleType"); st != nil {
+		t, err := g.basicOf(st)
+		return t, false, err
+	}
+	g.imports["github.com/pschou/go-xmltree"] = true
+	return "xmltree.Element", true, nil
+}
+
+// A structBuilder adds fields to a struct, keeping their names unique.
+type structBuilder struct {
+	t     *goType
+	names map[string]bool
+	tags  map[string]int // elements already having a field, by tag
+}
+
+func (s *structBuilder) add(name, typ, tag string) {
+	key := strings.TrimSuffix(tag, ",omitempty")
+	if i, ok := s.tags[key]; ok && !strings.Contains(tag, ",") {
+		// An element appearing twice in a content model.
+		if f := &s.t.fields[i]; !strings.HasPrefix(f.typ, "[]") {
+			f.typ = "[]" + strings.TrimPrefix(f.typ, "*")
+			f.tag = key
+		}
+		return
+	}
+	n := name
+	for i := 2; s.names[n]; i++ {
+		n = name + strconv.Itoa(i)
+	}
+	s.names[n] = true
+	if !strings.Contains(key, ",") {
+		s.tags[key] = len(s.t.fields)
+	}
+	s.t.fields = append(s.t.fields, field{n, typ, tag})
+}
+
+// complexContent adds the fields for the content of a complex type to t.
+func (g *generator) complexContent(t *goType, ct *xmltree.Element, doc *schemaDoc) error {
+	s := &structBuilder{t: t, names: map[string]bool{"XMLName": true}, tags: make(map[string]int)}
+	if err := g.content(s, ct, doc); err != nil {
+		return err
+	}
+	if ct.Attr("", "mixed") == "true" {
+		s.add("Text", "string", ",chardata")
+	}
+	return nil
+}
+
+// content adds the fields for the particles and attributes within el.
+func (g *generator) content(s *structBuilder, el *xmltree.Element, doc *schemaDoc) error {
+	for _, c := range xsChildren(el) {
+		var err error
+		switch c.Name.Local {
+		case "sequence", "choice", "all", "group", "element", "any":
+			err = g.particle(s, c, doc, false, false)
+		case "attribute", "attributeGroup", "anyAttribute":
+			err = g.attribute(s, c, doc)
+		case "simpleContent":
+			err = g.simpleContent(s, c, doc)
+		case "complexContent":
+			for _, d := range xsChildren(c) {
+				if base := d.Attr("", "base"); d.Name.Local == "extension" && base != "" {
+					if bd, ok := g.complex[d.Resolve(base)]; ok {
+						bt, err := g.complexType(bd)
+						if err != nil {
+							return err
+						}
+						s.t.embed = append(s.t.embed, bt.name)
+					}
+				}
+				if err := g.content(s, d, doc); err != nil {
+					return err
+				}
+			}
+			if c.Attr("", "mixed") == "true" {
+				s.add("Text", "string", ",chardata")
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *generator) simpleContent(s *structBuilder, el *xmltree.Element, doc *schemaDoc) error {
+	for _, d := range xsChildren(el) {
+		base := d.Attr("", "base")
+		if base == "" {
+			continue
+		}
+		if bd, ok := g.complex[d.Resolve(base)]; ok {
+			bt, err := g.complexType(bd)
+			if err != nil {
+				return err
+			}
+			s.t.embed = append(s.t.embed, bt.name)
+		} else {
+			typ, err := g.simpleRef(d, base)
+			if err != nil {
+				return err
+			}
+			s.add("Value", typ, ",chardata")
+		}
+		for _, c := range xsChildren(d) {
+			switch c.Name.Local {
+			case "attribute", "attributeGroup", "anyAttribute":
+				if err := g.attribute(s, c, doc); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// occurs returns the minOccurs and maxOccurs of a particle, with -1 for
+// unbounded.
+func occurs(el *xmltree.Element) (int, int) {
+	min, max := 1, 1
+	if v, ok := attrValue(el, "minOccurs"); ok {
+		min, _ = strconv.Atoi(v)
+	}
+	if v, ok := attrValue(el, "maxOccurs"); ok {
+		if v == "unbounded" {
+			max = -1
+		} else {
+			max, _ = strconv.Atoi(v)
+		}
+	}
+	return min, max
+}
+
+// particle adds fields for a particle. optional and repeated are set
+// when an enclosing particle makes the elements optional or repeated.
+func (g *generator) particle(s *structBuilder, el *xmltree.Element, doc *schemaDoc, optional, repeated bool) error {
+	min, max := occurs(el)
+	optional = optional || min == 0
+	repeated = repeated || max != 1
+	switch el.Name.Local {
+	case "element":
+		return g.elementField(s, el, doc, optional, repeated)
+	case "sequence", "all", "choice":
+		items := xsChildren(el)
+		for _, c := range items {
+			opt := optional || el.Name.Local == "choice" && len(items) > 1
+			if err := g.particle(s, c, doc, opt, repeated); err != nil {
+				return err
+			}
+		}
+	case "group":
+		ref := el.Attr("", "ref")
+		d, ok := g.groups[el.Resolve(ref)]
+		if !ok {
+			return fmt.Errorf("undefined group %s", ref)
+		}
+		for _, c := range xsChildren(d.el) {
+			if err := g.particle(s, c, d.doc, optional, repeated); err != nil {
+				return err
+			}
+		}
+	case "any":
+		g.imports["github.com/pschou/go-xmltree"] = true
+		s.add("Any", "[]xmltree.Element", ",any")
+	}
+	return nil
+}
+
+func (g *generator) elementField(s *structBuilder, el *xmltree.Element, doc *schemaDoc, optional, repeated bool) error {
+	var typ string
+	var isStruct bool
+	var name xml.Name
+	if ref := el.Attr("", "ref"); ref != "" {
+		name = el.Resolve(ref)
+		d, ok := g.elements[name]
+		if !ok {
+			return fmt.Errorf("undefined element %s", ref)
+		}
+		t, err := g.rootElement(d)
+		if err != nil {
+			return err
+		}
+		typ, isStruct = t.name, true
+	} else {
+		name.Local = el.Attr("", "name")
+		if form := el.Attr("", "form"); form == "qualified" || form == "" && doc.qualElem {
+			name.Space = doc.target
+		}
+		var err error
+		if typ, isStruct, err = g.elementType(el, doc, name.Local); err != nil {
+			return err
+		}
+	}
+	tag := name.Local
+	if name.Space != "" {
+		tag = name.Space + " " + name.Local
+	}
+	switch {
+	case repeated:
+		typ = "[]" + typ
+	case optional && isStruct:
+		typ = "*" + typ
+		tag += ",omitempty"
+	case optional:
+		tag += ",omitempty"
+	}
+	s.add(goName(name.Local), typ, tag)
+	return nil
+}
+
+func (g *generator) attribute(s *structBuilder, el *xmltree.Element, doc *schemaDoc) error {
+	switch el.Name.Local {
+	case "anyAttribute":
+		s.add("AnyAttrs", "[]xml.Attr", ",any,attr")
+		return nil
+	case "attributeGroup":
+		ref := el.Attr("", "ref")
+		d, ok := g.attrGrps[el.Resolve(ref)]
+		if !ok {
+			return fmt.Errorf("undefined attribute group %s", ref)
+		}
+		for _, c := range xsChildren(d.el) {
+			if err := g.attribute(s, c, d.doc); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	use := el.Attr("", "use")
+	if use == "prohibited" {
+		return nil
+	}
+	decl := el
+	var name xml.Name
+	if ref := el.Attr("", "ref"); ref != "" {
+		name = el.Resolve(ref)
+		if name.Space == "http://www.w3.org/XML/1998/namespace" {
+			decl = nil
+		} else if d, ok := g.attrs[name]; ok {
+			decl = d.el
+		} else {
+			return fmt.Errorf("undefined attribute %s", ref)
+		}
+	} else {
+		name.Local = el.Attr("", "name")
+		if form := el.Attr("", "form"); form == "qualified" || form == "" && doc.qualAttr {
+			name.Space = doc.target
+		}
+	}
+	typ := "string"
+	if decl != nil {
+		var err error
+		if ref := decl.Attr("", "type"); ref != "" {
+			typ, err = g.simpleRef(decl, ref)
+		} else if st := firstXS(decl, "simpleType"); st != nil {
+			typ, err = g.basicOf(st)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	tag := name.Local + ",attr"
+	if name.Space != "" {
+		tag = name.Space + " " + tag
+	}
+	if use != "required" {
+		tag += ",omitempty"
+	}
+	fieldName := goName(name.Local)
+	if s.names[fieldName] {
+		fieldName += "Attr"
+	}
+	s.add(fieldName, typ, tag)
+	return nil
+}
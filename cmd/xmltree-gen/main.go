@@ -0,0 +1,132 @@
+// Command xmltree-gen generates Go types for XML documents, for use with
+// xmltree.Unmarshal or encoding/xml.
+//
+// Usage:
+//
+//	xmltree-gen [-pkg name] [-o file] file ...
+//
+// If the files are XML Schema documents, a type is generated for each
+// global element, complex type and simple type they declare, including
+// those of the schemas they include and import. Otherwise the files are
+// taken to be sample documents, and the types are generated from the
+// schema inferred from them by xsd.InferSchema.
+//
+// Element and attribute names are qualified with their namespaces in
+// the struct tags, so that documents using any prefixes decode. Each
+// global element has a type with an XMLName field, which can be passed
+// to Unmarshal.
+//
+// The exit status is 0 on success and 2 on error.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pschou/go-xmltree"
+	"github.com/pschou/go-xmltree/xsd"
+)
+
+// A cli holds the standard streams of the command.
+type cli struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command and returns the exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr}
+	switch err := c.run(args); {
+	case err == nil:
+		return 0
+	case err == flag.ErrHelp:
+		return 2
+	default:
+		fmt.Fprintf(stderr, "xmltree-gen: %v\n", err)
+		return 2
+	}
+}
+
+func (c *cli) run(args []string) error {
+	fs := flag.NewFlagSet("xmltree-gen", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(c.stderr, "usage: xmltree-gen [-pkg name] [-o file] file ...")
+		fs.PrintDefaults()
+	}
+	pkg := fs.String("pkg", "main", "generate code in the package `name`")
+	out := fs.String("o", "", "write the code to `file` instead of standard output")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	names := fs.Args()
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+
+	var docs []*xmltree.Element
+	for _, name := range names {
+		root, err := c.parse(name)
+		if err != nil {
+			return err
+		}
+		docs = append(docs, root)
+	}
+	g := newGenerator(func(path string) (*xmltree.Element, error) { return c.parse(path) })
+	if isXS(docs[0], "schema") {
+		for i, doc := range docs {
+			path := names[i]
+			if path == "-" {
+				path = ""
+			}
+			if g.loaded[path] {
+				continue
+			}
+			if err := g.addSchema(doc, path, ""); err != nil {
+				return err
+			}
+		}
+	} else {
+		schema, err := xsd.InferSchema(docs...)
+		if err != nil {
+			return err
+		}
+		if err := g.addSchema(schema, "", ""); err != nil {
+			return err
+		}
+	}
+	src, err := g.generate(*pkg)
+	if err != nil {
+		return err
+	}
+	if *out != "" {
+		return os.WriteFile(*out, src, 0o644)
+	}
+	_, err = c.stdout.Write(src)
+	return err
+}
+
+// parse reads an XML document from a file, or from standard input for
+// "-".
+func (c *cli) parse(name string) (*xmltree.Element, error) {
+	var r io.Reader = c.stdin
+	if name != "-" {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+	root, err := xmltree.Parse(r)
+	if err != nil && name != "-" {
+		err = fmt.Errorf("%s: %v", name, err)
+	}
+	return root, err
+}
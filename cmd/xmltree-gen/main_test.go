@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// squash collapses white space, so that outputs can be compared
+// regardless of the alignment of fields.
+func squash(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+const schema = `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:b="urn:book"
+    targetNamespace="urn:book" elementFormDefault="qualified">
+  <xs:include schemaLocation="common.xsd"/>
+  <xs:element name="library">
+    <xs:complexType>
+      <xs:sequence>
+        <xs:element ref="b:book" maxOccurs="unbounded"/>
+        <xs:element name="note" type="xs:anyType" minOccurs="0"/>
+      </xs:sequence>
+      <xs:attribute name="version" type="xs:decimal" use="required"/>
+    </xs:complexType>
+  </xs:element>
+  <xs:element name="book" type="b:bookType"/>
+  <xs:complexType name="bookType">
+    <xs:complexContent>
+      <xs:extension base="b:itemType">
+        <xs:sequence>
+          <xs:element name="title" type="xs:string"/>
+          <xs:choice>
+            <xs:element name="isbn" type="xs:string"/>
+            <xs:element name="issn" type="xs:string"/>
+          </xs:choice>
+          <xs:element name="pages" type="xs:unsignedInt" minOccurs="0"/>
+          <xs:element name="author" type="b:personType" minOccurs="0"/>
+          <xs:any namespace="##other" minOccurs="0" maxOccurs="unbounded"/>
+        </xs:sequence>
+        <xs:attribute name="format" type="b:format"/>
+        <xs:attribute name="title" type="xs:string"/>
+      </xs:extension>
+    </xs:complexContent>
+  </xs:complexType>
+  <xs:simpleType name="format">
+    <xs:restriction base="xs:token">
+      <xs:enumeration value="hardcover"/>
+      <xs:enumeration value="e-book"/>
+    </xs:restriction>
+  </xs:simpleType>
+</xs:schema>`
+
+const common = `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:b="urn:book" elementFormDefault="qualified">
+  <xs:complexType name="itemType">
+    <xs:attribute name="id" type="xs:ID" use="required"/>
+  </xs:complexType>
+  <xs:complexType name="personType">
+    <xs:simpleContent>
+      <xs:extension base="xs:string">
+        <xs:attribute name="born" type="xs:gYear"/>
+      </xs:extension>
+    </xs:simpleContent>
+  </xs:complexType>
+</xs:schema>`
+
+func TestSchema(t *testing.T) {
+	dir := t.TempDir()
+	for name, text := range map[string]string{"book.xsd": schema, "common.xsd": common} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	if status := run([]string{"-pkg", "book", filepath.Join(dir, "book.xsd")}, nil, &stdout, &stderr); status != 0 {
+		t.Fatalf("status %d: %s", status, stderr.String())
+	}
+	out := stdout.String()
+	for _, want := range []string{
+		"// Code generated by xmltree-gen; DO NOT EDIT.\n\npackage book\n",
+		"import (\n\t\"encoding/xml\"\n\n\t\"github.com/pschou/go-xmltree\"\n)",
+		"type Library struct {\n\tXMLName xml.Name `xml:\"urn:book library\"`",
+		"Book    []Book           `xml:\"urn:book book\"`",
+		"Note    *xmltree.Element `xml:\"urn:book note,omitempty\"`",
+		"Version float64          `xml:\"version,attr\"`",
+		"type Book struct {\n\tXMLName xml.Name `xml:\"urn:book book\"`\n\tItem\n",
+		"Isbn      string     `xml:\"urn:book isbn,omitempty\"`",
+		"Pages     uint32     `xml:\"urn:book pages,omitempty\"`",
+		"Author    *Person    `xml:\"urn:book author,omitempty\"`",
+		"Any       []xmltree.Element `xml:\",any\"`",
+		"Format    Format     `xml:\"format,attr,omitempty\"`",
+		"TitleAttr string     `xml:\"title,attr,omitempty\"`",
+		"type Format string",
+		"FormatEBook Format = \"e-book\"",
+		"type Item struct {\n\tID string `xml:\"id,attr\"`",
+		"type Person struct {\n\tValue string `xml:\",chardata\"`\n\tBorn  string `xml:\"born,attr,omitempty\"`",
+	} {
+		if !strings.Contains(squash(out), squash(want)) {
+			t.Errorf("output does not contain %s:\n%s", want, out)
+		}
+	}
+}
+
+func TestSamples(t *testing.T) {
+	dir := t.TempDir()
+	samples := []string{
+		`<feed xmlns="urn:feed" xmlns:m="urn:meta"><entry id="1" m:lang="en"><title>A</title><size>10</size></entry><entry id="2"><title>B</title><size>7</size></entry></feed>`,
+		`<feed xmlns="urn:feed"><entry id="3"><title>C</title></entry></feed>`,
+	}
+	var args []string
+	for i, text := range samples {
+		name := filepath.Join(dir, string(rune('a'+i))+".xml")
+		if err := os.WriteFile(name, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		args = append(args, name)
+	}
+	out := filepath.Join(dir, "feed.go")
+	var stdout, stderr bytes.Buffer
+	if status := run(append([]string{"-o", out}, args...), nil, &stdout, &stderr); status != 0 {
+		t.Fatalf("status %d: %s", status, stderr.String())
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"package main\n",
+		"type Feed struct {\n\tXMLName xml.Name `xml:\"urn:feed feed\"`\n\tEntry   []Entry  `xml:\"urn:feed entry\"`",
+		"Title    string     `xml:\"urn:feed title\"`",
+		"Size     int64      `xml:\"urn:feed size,omitempty\"`",
+		"ID       int64      `xml:\"id,attr\"`",
+		"AnyAttrs []xml.Attr `xml:\",any,attr\"`",
+	} {
+		if !strings.Contains(squash(string(data)), squash(want)) {
+			t.Errorf("output does not contain %s:\n%s", want, data)
+		}
+	}
+	if strings.Contains(string(data), "go-xmltree") {
+		t.Errorf("output imports xmltree needlessly:\n%s", data)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		args  []string
+		stdin string
+		err   string
+	}{
+		{nil, `<a>`, "xmltree-gen: "},
+		{[]string{"missing.xml"}, ``, "missing.xml"},
+		{nil, `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema"><xs:element name="a" type="b"/></xs:schema>`, "undefined simple type b"},
+		{[]string{"-bogus"}, ``, "flag provided but not defined"},
+	}
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		status := run(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+		if status != 2 || !strings.Contains(stderr.String(), tt.err) {
+			t.Errorf("%v: status %d, stderr %q, want %q", tt.args, status, stderr.String(), tt.err)
+		}
+	}
+}
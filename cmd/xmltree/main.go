@@ -0,0 +1,646 @@
+// Command xmltree formats, searches, compares and converts XML documents
+// using the xmltree package.
+//
+// Usage:
+//
+//	xmltree <command> [flags] [file ...]
+//
+// The commands are:
+//
+//	fmt      reformat documents with indentation, keeping mixed content intact
+//	query    print the elements selected by an XPath expression or by name
+//	diff     compare two documents, ignoring insignificant differences
+//	ns       list the namespaces used in a document, or simplify them
+//	strip    remove empty elements
+//	convert  convert between XML, HTML, JSON and YAML
+//
+// Documents are read from the named files, or from standard input if no
+// file is named or the name is "-". Run "xmltree <command> -h" for the
+// flags of a command.
+//
+// The exit status is 0 on success and 2 on error. diff exits with 1 if
+// the documents differ.
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pschou/go-xmltree"
+	"github.com/pschou/go-xmltree/yamlconv"
+)
+
+type command struct {
+	run   func(cmd *cli, args []string) error
+	usage string
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"fmt":     {runFmt, "fmt [-indent str] [-width n] [-attrs n] [-sort order] [-single] [-empty style] [-w] [file ...]"},
+		"query":   {runQuery, "query [-ns prefix=uri] [-text | -count] xpath [file ...]\n       xmltree query -name name [-attr name=value] [-depth n] [-text | -count] [file ...]"},
+		"diff":    {runDiff, "diff [-ordered] [-whitespace] [-comments] [-id attr] [-q] file1 file2"},
+		"ns":      {runNS, "ns [-simplify] [file ...]"},
+		"strip":   {runStrip, "strip [-indent str] [file ...]"},
+		"convert": {runConvert, "convert [-from format] [-to format] [-style style] [-array name] [file]"},
+	}
+}
+
+// errDiffer is returned by diff when the documents are not equal.
+var errDiffer = errors.New("documents differ")
+
+// A cli holds the standard streams of a command.
+type cli struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+	name           string
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command named by args[0] and returns the exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "help" {
+		usage(stderr)
+		return 2
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "xmltree: unknown command %q\n", args[0])
+		usage(stderr)
+		return 2
+	}
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr, name: args[0]}
+	switch err := cmd.run(c, args[1:]); {
+	case err == nil:
+		return 0
+	case err == errDiffer:
+		return 1
+	case err == flag.ErrHelp:
+		return 2
+	default:
+		fmt.Fprintf(stderr, "xmltree %s: %v\n", args[0], err)
+		return 2
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: xmltree <command> [flags] [file ...]")
+	fmt.Fprintln(w)
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "       xmltree %s\n", commands[name].usage)
+	}
+}
+
+// flags returns a FlagSet for the command, writing its errors and
+// usage to stderr.
+func (c *cli) flags() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "usage: xmltree %s\n", commands[c.name].usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// files returns the files named by args, or standard input.
+func files(args []string) []string {
+	if len(args) == 0 {
+		return []string{"-"}
+	}
+	return args
+}
+
+// open returns the contents of a file, or of standard input for "-".
+func (c *cli) open(name string) (io.Reader, error) {
+	if name == "-" {
+		return c.stdin, nil
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+// parse reads an XML document from a file or standard input.
+func (c *cli) parse(name string) (*xmltree.Element, error) {
+	return c.parseWithOptions(name, nil)
+}
+
+// parseWithOptions is like parse, but the tree built is controlled by
+// opts.
+func (c *cli) parseWithOptions(name string, opts *xmltree.ParseOptions) (*xmltree.Element, error) {
+	r, err := c.open(name)
+	if err != nil {
+		return nil, err
+	}
+	root, err := xmltree.ParseWithOptions(r, opts)
+	if err != nil && name != "-" {
+		err = fmt.Errorf("%s: %v", name, err)
+	}
+	return root, err
+}
+
+// write writes an element to standard output, indented if indent or
+// prefix is not empty.
+func (c *cli) write(el *xmltree.Element, prefix, indent string) error {
+	_, err := c.stdout.Write(encode(el, prefix, indent))
+	return err
+}
+
+// encode returns the XML encoding of el, ending in a line break.
+func encode(el *xmltree.Element, prefix, indent string) []byte {
+	var data []byte
+	if indent == "" && prefix == "" {
+		data = xmltree.Marshal(el)
+	} else {
+		data = xmltree.MarshalIndent(el, prefix, indent)
+	}
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	return data
+}
+
+// A listFlag collects the values of a flag which may be repeated.
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// parseName parses a name written as local, prefix:local or
+// {uri}local. Prefixes are resolved using ns.
+func parseName(s string, ns map[string]string) (xml.Name, error) {
+	if strings.HasPrefix(s, "{") {
+		i := strings.IndexByte(s, '}')
+		if i < 0 {
+			return xml.Name{}, fmt.Errorf("missing } in name %q", s)
+		}
+		return xml.Name{Space: s[1:i], Local: s[i+1:]}, nil
+	}
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		uri, ok := ns[s[:i]]
+		if !ok {
+			return xml.Name{}, fmt.Errorf("undeclared namespace prefix %q", s[:i])
+		}
+		return xml.Name{Space: uri, Local: s[i+1:]}, nil
+	}
+	return xml.Name{Local: s}, nil
+}
+
+// splitPair splits a flag value of the form key=value.
+func splitPair(s string) (string, string, error) {
+	i := strings.IndexByte(s, '=')
+	if i < 0 {
+		return "", "", fmt.Errorf("expected key=value, got %q", s)
+	}
+	return s[:i], s[i+1:], nil
+}
+
+func runFmt(c *cli, args []string) error {
+	fs := c.flags()
+	var opts xmltree.FormatOptions
+	fs.StringVar(&opts.Indent, "indent", "  ", "indent each level with `str`")
+	fs.StringVar(&opts.Prefix, "prefix", "", "begin each line with `str`")
+	fs.IntVar(&opts.Width, "width", 0, "wrap the attributes of start tags longer than `n` characters")
+	fs.IntVar(&opts.MaxAttrs, "attrs", 0, "wrap the attributes of start tags with more than `n` attributes")
+	fs.BoolVar(&opts.IndentComments, "comments", false, "re-indent comments which span several lines")
+	sortAttrs := fs.String("sort", "", "sort attributes by `order`: name, or ns for namespace declarations first")
+	fs.BoolVar(&opts.SingleQuote, "single", false, "quote attribute values with single quotes")
+	selfClose := fs.String("empty", "space", "write empty elements in `style`: space (<a />), tight (<a/>) or never (<a></a>)")
+	write := fs.Bool("w", false, "write the result to the file instead of standard output")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch *sortAttrs {
+	case "":
+	case "name":
+		opts.AttrOrder = xmltree.AttrsByName
+	case "ns":
+		opts.AttrOrder = xmltree.AttrsNSFirst
+	default:
+		return fmt.Errorf("unknown attribute order %q", *sortAttrs)
+	}
+	switch *selfClose {
+	case "space":
+	case "tight":
+		opts.SelfClose = xmltree.SelfCloseTight
+	case "never":
+		opts.SelfClose = xmltree.SelfCloseNever
+	default:
+		return fmt.Errorf("unknown empty element style %q", *selfClose)
+	}
+	// White space is kept so that Format can leave mixed content as
+	// it is.
+	parseOpts := &xmltree.ParseOptions{Whitespace: xmltree.WhitespacePreserve}
+	for _, name := range files(fs.Args()) {
+		root, err := c.parseWithOptions(name, parseOpts)
+		if err != nil {
+			return err
+		}
+		if !*write || name == "-" {
+			if err := xmltree.Format(c.stdout, root, &opts); err != nil {
+				return err
+			}
+			continue
+		}
+		var buf bytes.Buffer
+		if err := xmltree.Format(&buf, root, &opts); err != nil {
+			return err
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(name, buf.Bytes(), info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runQuery(c *cli, args []string) error {
+	fs := c.flags()
+	var nsFlags, attrFlags listFlag
+	fs.Var(&nsFlags, "ns", "bind a namespace `prefix=uri` for the expression or -name (repeatable)")
+	name := fs.String("name", "", "select elements by `name`, written as local, prefix:local or {uri}local, instead of by XPath")
+	fs.Var(&attrFlags, "attr", "with -name, select only elements with the attribute `name=value` (repeatable)")
+	depth := fs.Int("depth", 0, "with -name, search at most `n` levels deep")
+	text := fs.Bool("text", false, "print the text of each result rather than its XML")
+	count := fs.Bool("count", false, "print the number of results; the expression must select a node-set")
+	indent := fs.String("indent", "", "indent results with `str`")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ns := make(map[string]string)
+	for _, s := range nsFlags {
+		prefix, uri, err := splitPair(s)
+		if err != nil {
+			return err
+		}
+		ns[prefix] = uri
+	}
+
+	var sel *xmltree.Selector
+	var expr *xmltree.XPath
+	var src string
+	rest := fs.Args()
+	if *name != "" {
+		n, err := parseName(*name, ns)
+		if err != nil {
+			return err
+		}
+		sel = &xmltree.Selector{Name: n, Depth: *depth}
+		for _, s := range attrFlags {
+			k, v, err := splitPair(s)
+			if err != nil {
+				return err
+			}
+			an, err := parseName(k, ns)
+			if err != nil {
+				return err
+			}
+			sel.Attr = append(sel.Attr, xml.Attr{Name: an, Value: v})
+		}
+	} else {
+		if len(rest) == 0 {
+			fs.Usage()
+			return flag.ErrHelp
+		}
+		var err error
+		src = rest[0]
+		if expr, err = xmltree.CompileXPath(src); err != nil {
+			return err
+		}
+		rest = rest[1:]
+	}
+
+	total := 0
+	for _, file := range files(rest) {
+		root, err := c.parse(file)
+		if err != nil {
+			return err
+		}
+		var results []interface{}
+		if sel != nil {
+			for _, el := range root.Find(sel) {
+				if hasAttrs(el, sel.Attr) {
+					results = append(results, el)
+				}
+			}
+		} else {
+			xc := xmltree.NewXPathContext(root)
+			xc.Namespaces = ns
+			if len(nsFlags) == 0 {
+				// As with Element.Query, use the namespaces declared
+				// on the root element.
+				if xc.Namespaces, err = rootNamespaces(xc); err != nil {
+					return err
+				}
+			}
+			v, err := xc.Eval(expr, xc.Root())
+			if err != nil {
+				return err
+			}
+			nodes, ok := v.([]xmltree.Node)
+			if !ok {
+				if *count {
+					return fmt.Errorf("-count needs a node-set, but %q is a scalar expression", src)
+				}
+				fmt.Fprintln(c.stdout, xmltree.XPathString(v))
+				continue
+			}
+			for _, n := range nodes {
+				if n.Type == xmltree.ElementNode || n.Type == xmltree.DocumentNode {
+					results = append(results, n.Element)
+				} else {
+					results = append(results, n.String())
+				}
+			}
+		}
+		total += len(results)
+		if *count {
+			continue
+		}
+		for _, r := range results {
+			switch r := r.(type) {
+			case string:
+				fmt.Fprintln(c.stdout, r)
+			case *xmltree.Element:
+				if *text {
+					fmt.Fprintln(c.stdout, r.Text())
+				} else if err := c.write(r, "", *indent); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if *count {
+		fmt.Fprintln(c.stdout, total)
+	}
+	return nil
+}
+
+// rootNamespaces returns the namespaces in scope at the root element.
+func rootNamespaces(xc *xmltree.XPathContext) (map[string]string, error) {
+	nodes, err := xc.Select(xmltree.MustCompileXPath("/*/namespace::*"), xc.Root())
+	if err != nil {
+		return nil, err
+	}
+	ns := make(map[string]string, len(nodes))
+	for _, n := range nodes {
+		ns[n.Name().Local] = n.String()
+	}
+	return ns, nil
+}
+
+// hasAttrs reports whether el has all of the given attribute values.
+// An attribute with no namespace matches in any namespace.
+func hasAttrs(el *xmltree.Element, attrs []xml.Attr) bool {
+match:
+	for _, a := range attrs {
+		for _, b := range el.StartElement.Attr {
+			if a.Name.Local == b.Name.Local && (a.Name.Space == "" || a.Name.Space == b.Name.Space) {
+				if a.Value != b.Value {
+					return false
+				}
+				continue match
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func runDiff(c *cli, args []string) error {
+	fs := c.flags()
+	ordered := fs.Bool("ordered", false, "make the order of child elements significant")
+	whitespace := fs.Bool("whitespace", false, "make differences in white space significant")
+	comments := fs.Bool("comments", false, "make differences in comments significant")
+	var ids listFlag
+	fs.Var(&ids, "id", "treat the `attr` attribute as identifying an element among its siblings (repeatable)")
+	quiet := fs.Bool("q", false, "report only whether the documents differ")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	a, err := c.parse(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := c.parse(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	opts := &xmltree.EqualOptions{
+		Ordered:        *ordered,
+		Whitespace:     *whitespace,
+		IgnoreComments: !*comments,
+	}
+	if xmltree.EqualWithOptions(a, b, opts) {
+		return nil
+	}
+	if *quiet {
+		fmt.Fprintf(c.stdout, "%s and %s differ\n", fs.Arg(0), fs.Arg(1))
+		return errDiffer
+	}
+	changes := xmltree.Diff(a, b, &xmltree.DiffOptions{
+		Unordered:  !*ordered,
+		Whitespace: *whitespace,
+		Identity:   ids,
+	})
+	for _, ch := range changes {
+		if !*comments && (ch.Old != nil && ch.Old.Type == xmltree.XML_Comment || ch.New != nil && ch.New.Type == xmltree.XML_Comment) {
+			continue
+		}
+		fmt.Fprintln(c.stdout, ch)
+	}
+	return errDiffer
+}
+
+func runNS(c *cli, args []string) error {
+	fs := c.flags()
+	simplify := fs.Bool("simplify", false, "make the most used namespace the default and print the document")
+	indent := fs.String("indent", "", "with -simplify, indent the document with `str`")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	all := xmltree.MustCompileXPath("//namespace::*")
+	for _, name := range files(fs.Args()) {
+		root, err := c.parse(name)
+		if err != nil {
+			return err
+		}
+		if *simplify {
+			root.SimplifyNS()
+			if err := c.write(root, "", *indent); err != nil {
+				return err
+			}
+			continue
+		}
+		xc := xmltree.NewXPathContext(root)
+		nodes, err := xc.Select(all, xc.Root())
+		if err != nil {
+			return err
+		}
+		seen := make(map[[2]string]bool)
+		for _, n := range nodes {
+			decl := [2]string{n.Name().Local, n.String()}
+			if seen[decl] {
+				continue
+			}
+			seen[decl] = true
+			if decl[0] == "" {
+				fmt.Fprintf(c.stdout, "xmlns=%q\n", decl[1])
+			} else {
+				fmt.Fprintf(c.stdout, "xmlns:%s=%q\n", decl[0], decl[1])
+			}
+		}
+	}
+	return nil
+}
+
+func runStrip(c *cli, args []string) error {
+	fs := c.flags()
+	indent := fs.String("indent", "", "indent the result with `str`")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	for _, name := range files(fs.Args()) {
+		root, err := c.parse(name)
+		if err != nil {
+			return err
+		}
+		root.RemoveEmpty()
+		if err := c.write(root, "", *indent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var jsonStyles = map[string]xmltree.JSONStyle{
+	"lossless":   xmltree.JSONLossless,
+	"badgerfish": xmltree.JSONBadgerFish,
+	"parker":     xmltree.JSONParker,
+	"gdata":      xmltree.JSONGData,
+	"friendly":   xmltree.JSONFriendly,
+}
+
+// formatOf guesses the format of a file from its extension.
+func formatOf(name string) string {
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".json", ".html":
+		return ext[1:]
+	case ".htm", ".xhtml":
+		return "html"
+	case ".yaml", ".yml":
+		return "yaml"
+	}
+	return "xml"
+}
+
+func runConvert(c *cli, args []string) error {
+	fs := c.flags()
+	from := fs.String("from", "", "read `format`: xml, html, json or yaml (default from the file name, or xml)")
+	to := fs.String("to", "json", "write `format`: xml, html, json or yaml")
+	style := fs.String("style", "lossless", "JSON and YAML `style`: lossless, friendly, badgerfish, parker or gdata")
+	indent := fs.String("indent", "", "indent XML output with `str`")
+	var arrays listFlag
+	fs.Var(&arrays, "array", "always write elements named `name` as JSON arrays (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	name := files(fs.Args())[0]
+	if *from == "" {
+		*from = formatOf(name)
+	}
+	conv := &xmltree.JSONConvention{}
+	var ok bool
+	if conv.Style, ok = jsonStyles[*style]; !ok {
+		return fmt.Errorf("unknown style %q", *style)
+	}
+	for _, s := range arrays {
+		n, err := parseName(s, nil)
+		if err != nil {
+			return err
+		}
+		conv.Arrays = append(conv.Arrays, n)
+	}
+
+	r, err := c.open(name)
+	if err != nil {
+		return err
+	}
+	var root *xmltree.Element
+	switch *from {
+	case "xml":
+		root, err = xmltree.Parse(r)
+	case "html":
+		root, err = xmltree.ParseHTML(r)
+	case "json":
+		root, err = xmltree.FromJSON(r, conv)
+	case "yaml":
+		root, err = yamlconv.FromYAML(r, conv)
+	default:
+		return fmt.Errorf("unknown input format %q", *from)
+	}
+	if err != nil {
+		return err
+	}
+
+	var out []byte
+	switch *to {
+	case "xml":
+		return c.write(root, "", *indent)
+	case "html":
+		if err := xmltree.EncodeHTML(c.stdout, root); err != nil {
+			return err
+		}
+		_, err = io.WriteString(c.stdout, "\n")
+		return err
+	case "json":
+		if out, err = xmltree.ToJSON(root, conv); err == nil {
+			out = append(out, '\n')
+		}
+	case "yaml":
+		out, err = yamlconv.ToYAML(root, conv)
+	default:
+		return fmt.Errorf("unknown output format %q", *to)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = c.stdout.Write(out)
+	return err
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sample = `<r:root xmlns:r="urn:r" xmlns="urn:d"><a id="1">x</a><a id="2"><b/></a><!-- c --><r:c/></r:root>`
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	other := filepath.Join(dir, "other.xml")
+	err := os.WriteFile(other, []byte(`<root xmlns="urn:r"><a xmlns="urn:d" id="2"><b/></a><a xmlns="urn:d" id="1">y</a><c/></root>`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	same := filepath.Join(dir, "same.xml")
+	if err := os.WriteFile(same, []byte(sample), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args   []string
+		status int
+		out    string
+	}{
+		{[]string{"fmt"}, 0, `<r:root xmlns="urn:d" xmlns:r="urn:r">
+  <a id="1">x</a>
+  <a id="2">
+    <b />
+  </a>
+  <!-- c -->
+  <r:c />
+</r:root>
+`},
+		{[]string{"fmt", "-sort", "ns", "-single", "-empty", "tight", "-attrs", "1"}, 0, `<r:root
+  xmlns='urn:d'
+  xmlns:r='urn:r'>
+  <a id='1'>x</a>
+  <a id='2'>
+    <b/>
+  </a>
+  <!-- c -->
+  <r:c/>
+</r:root>
+`},
+		{[]string{"query", "//a[@id=2]"}, 0, `<a id="2" xmlns="urn:d" xmlns:r="urn:r"><b /></a>` + "\n"},
+		{[]string{"query", "-ns", "d=urn:d", "-text", "//d:a[1]"}, 0, "x\n"},
+		{[]string{"query", "count(//*)"}, 0, "5\n"},
+		{[]string{"query", "//@id"}, 0, "1\n2\n"},
+		{[]string{"query", "-name", "a", "-attr", "id=2", "-count"}, 0, "1\n"},
+		{[]string{"query", "-count", "//a"}, 0, "2\n"},
+		{[]string{"query", "-count", "count(//*)"}, 2, ""},
+		{[]string{"query", "-name", "{urn:r}c"}, 0, `<r:c xmlns="urn:d" xmlns:r="urn:r" />` + "\n"},
+		{[]string{"ns"}, 0, "xmlns=\"urn:d\"\nxmlns:r=\"urn:r\"\n"},
+		{[]string{"strip"}, 0, `<r:root xmlns="urn:d" xmlns:r="urn:r"><a id="1">x</a><a id="2" /><!-- c --></r:root>` + "\n"},
+		{[]string{"convert", "-style", "friendly"}, 0, `{"r:root":{"@xmlns":"urn:d","@xmlns:r":"urn:r","a":[{"@id":"1","#text":"x"},{"@id":"2","b":null}],"r:c":null}}` + "\n"},
+		{[]string{"diff", "-", same}, 0, ""},
+		{[]string{"diff", "-", other}, 1, "text modified /r:root/a[1] \"x\" -> \"y\"\n"},
+		{[]string{"diff", "-q", "-", other}, 1, "- and " + other + " differ\n"},
+		{[]string{"query"}, 2, ""},
+		{[]string{"nosuch"}, 2, ""},
+	}
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		status := run(tt.args, strings.NewReader(sample), &stdout, &stderr)
+		if status != tt.status {
+			t.Errorf("%v: exit status %d, want %d: %s", tt.args, status, tt.status, stderr.String())
+		}
+		if got := stdout.String(); got != tt.out {
+			t.Errorf("%v: got\n%s\nwant\n%s", tt.args, got, tt.out)
+		}
+	}
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	for _, format := range []string{"json", "yaml"} {
+		var converted, back, stderr bytes.Buffer
+		if status := run([]string{"convert", "-to", format}, strings.NewReader(sample), &converted, &stderr); status != 0 {
+			t.Fatalf("%s: %s", format, stderr.String())
+		}
+		if status := run([]string{"convert", "-from", format, "-to", "xml"}, &converted, &back, &stderr); status != 0 {
+			t.Fatalf("%s: %s", format, stderr.String())
+		}
+		want := `<r:root xmlns="urn:d" xmlns:r="urn:r"><a id="1">x</a><a id="2"><b /></a><!-- c --><r:c /></r:root>` + "\n"
+		if back.String() != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", format, back.String(), want)
+		}
+	}
+}
+
+func TestFmtWrite(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "doc.xml")
+	if err := os.WriteFile(name, []byte(`<a><b>c</b></a>`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	if status := run([]string{"fmt", "-w", "-indent", "\t", name}, nil, &stdout, &stderr); status != 0 {
+		t.Fatal(stderr.String())
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<a>\n\t<b>c</b>\n</a>\n"; string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("unexpected output %q", stdout.String())
+	}
+}
@@ -1,68 +1,258 @@
 package xmltree
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/xml"
+	"io"
 	"sort"
 	"strings"
 )
 
+// EqualOptions controls the comparison made by EqualWithOptions. The
+// zero value gives the same comparison as Equal.
+type EqualOptions struct {
+	// Ordered makes the order of child nodes significant.
+	Ordered bool
+	// Prefixes makes the namespace prefixes used for element and
+	// attribute names significant, in addition to the namespaces.
+	Prefixes bool
+	// Whitespace makes differences in white space significant. By
+	// default leading and trailing white space is ignored, runs of
+	// white space compare equal, and character data consisting only of
+	// white space is skipped.
+	Whitespace bool
+	// IgnoreComments skips comments when comparing child nodes.
+	IgnoreComments bool
+	// IgnoreProcInsts skips processing instructions and directives
+	// when comparing child nodes.
+	IgnoreProcInsts bool
+	// IgnoreAttrs lists attributes which are not compared. If the Space
+	// of a name is empty, attributes with that local name in any
+	// namespace are ignored.
+	IgnoreAttrs []xml.Name
+}
+
 // Equal returns true if two xmltree.Elements are equal, ignoring
 // differences in white space, sub-element order, and namespace prefixes.
+// Neither Element is modified.
 func Equal(a, b *Element) bool {
-	return equal(a, b, 0)
+	return EqualWithOptions(a, b, nil)
+}
+
+// EqualWithOptions is like Equal, but the comparison is controlled by
+// opts. A nil opts is the same as a zero EqualOptions. The comparison is
+// symmetric, and neither Element is modified.
+func EqualWithOptions(a, b *Element, opts *EqualOptions) bool {
+	if opts == nil {
+		opts = new(EqualOptions)
+	}
+	c := comparer{opts: opts, sums: make(map[*Element][sha256.Size]byte)}
+	return c.equal(a, b, 0)
+}
+
+const maxCompareDepth = 1000
+
+type comparer struct {
+	opts *EqualOptions
+	sums map[*Element][sha256.Size]byte
+}
+
+func (c *comparer) text(s string) string {
+	if c.opts.Whitespace {
+		return s
+	}
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// children returns the child nodes of el which take part in the
+// comparison. The Content of an element without children is returned as
+// a character data node, and adjacent character data nodes are joined,
+// so that the result does not depend on how the document was parsed.
+func (c *comparer) children(el *Element) []*Element {
+	if len(el.Children) == 0 {
+		if el.Type != XML_Tag || c.text(el.Content) == "" {
+			return nil
+		}
+		return []*Element{{Type: XML_CharData, Content: el.Content}}
+	}
+	list := make([]*Element, 0, len(el.Children))
+	for i := range el.Children {
+		child := &el.Children[i]
+		switch child.Type {
+		case XML_Comment:
+			if c.opts.IgnoreComments {
+				continue
+			}
+		case XML_ProcInst, XML_Directive:
+			if c.opts.IgnoreProcInsts {
+				continue
+			}
+		case XML_CharData, XML_CDATA:
+			// A CDATA section is compared as the text it contains.
+			if n := len(list); n > 0 && list[n-1].Type == XML_CharData {
+				list[n-1] = &Element{
+					Type:    XML_CharData,
+					Content: list[n-1].Content + child.Content,
+				}
+				continue
+			}
+			if child.Type == XML_CDATA {
+				child = &Element{Type: XML_CharData, Content: child.Content}
+			}
+		}
+		list = append(list, child)
+	}
+	// Drop character data which is nothing but white space.
+	keep := list[:0]
+	for _, child := range list {
+		if child.Type != XML_CharData || c.text(child.Content) != "" {
+			keep = append(keep, child)
+		}
+	}
+	return keep
 }
 
-type byName []Element
+func (c *comparer) ignoreAttr(name xml.Name) bool {
+	if name.Space == "xmlns" || name.Local == "xmlns" && name.Space == "" {
+		return true
+	}
+	for _, ign := range c.opts.IgnoreAttrs {
+		if ign.Local == name.Local && (ign.Space == "" || ign.Space == name.Space) {
+			return true
+		}
+	}
+	return false
+}
 
-func (l byName) Len() int { return len(l) }
-func (l byName) Less(i, j int) bool {
-	return l[i].Name.Space+l[i].Name.Local < l[j].Name.Space+l[j].Name.Local
+// attrs returns the attributes of el which take part in the comparison,
+// sorted by name.
+func (c *comparer) attrs(el *Element) []xml.Attr {
+	list := make([]xml.Attr, 0, len(el.StartElement.Attr))
+	for _, a := range el.StartElement.Attr {
+		if !c.ignoreAttr(a.Name) {
+			list = append(list, a)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name.Space != list[j].Name.Space {
+			return list[i].Name.Space < list[j].Name.Space
+		}
+		return list[i].Name.Local < list[j].Name.Local
+	})
+	return list
 }
-func (l byName) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
 
-func equal(a, b *Element, depth int) bool {
-	const maxDepth = 1000
-	if depth > maxDepth {
+// equalNode compares two nodes, not including their children.
+func (c *comparer) equalNode(a, b *Element) bool {
+	if a.Type != b.Type {
 		return false
 	}
-	if !equalElement(a, b) {
+	if a.Type != XML_Tag {
+		return c.text(a.Content) == c.text(b.Content)
+	}
+	if a.Name != b.Name {
 		return false
 	}
-	if len(a.Children) != len(b.Children) {
+	if c.opts.Prefixes && a.Prefix(a.Name) != b.Prefix(b.Name) {
 		return false
 	}
-	if len(a.Children) == 0 {
-		return strings.TrimSpace(a.Content) == strings.TrimSpace(b.Content)
+	attrsA, attrsB := c.attrs(a), c.attrs(b)
+	if len(attrsA) != len(attrsB) {
+		return false
 	}
-	sort.Sort(byName(a.Children))
-	sort.Sort(byName(b.Children))
-	for i := range a.Children {
-		if !equal(&a.Children[i], &b.Children[i], depth+1) {
+	for i := range attrsA {
+		if attrsA[i] != attrsB[i] {
+			return false
+		}
+		if c.opts.Prefixes && a.Prefix(attrsA[i].Name) != b.Prefix(attrsB[i].Name) {
 			return false
 		}
 	}
 	return true
 }
 
-func equalElement(a, b *Element) bool {
-	if a.Name != b.Name {
+func (c *comparer) equal(a, b *Element, depth int) bool {
+	if depth > maxCompareDepth {
 		return false
 	}
-	attrs := make(map[xml.Name]string)
-	for _, a := range a.StartElement.Attr {
-		if a.Name.Space == "xmlns" || a.Name.Local == "xmlns" {
-			continue
+	if !c.equalNode(a, b) {
+		return false
+	}
+	childrenA, childrenB := c.children(a), c.children(b)
+	if len(childrenA) != len(childrenB) {
+		return false
+	}
+	if !c.opts.Ordered {
+		// Sort copies of the child lists by content, so that equal
+		// children line up without reordering the documents.
+		c.sortBySum(childrenA, depth+1)
+		c.sortBySum(childrenB, depth+1)
+	}
+	for i := range childrenA {
+		if !c.equal(childrenA[i], childrenB[i], depth+1) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *comparer) sortBySum(list []*Element, depth int) {
+	sums := make(map[*Element][sha256.Size]byte, len(list))
+	for _, el := range list {
+		sums[el] = c.sum(el, depth)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		si, sj := sums[list[i]], sums[list[j]]
+		return bytes.Compare(si[:], sj[:]) < 0
+	})
+}
+
+// writeNode writes the parts of a node which take part in the
+// comparison, not including its children.
+func (c *comparer) writeNode(w io.Writer, el *Element) {
+	buf := []byte{byte(el.Type)}
+	if el.Type == XML_Tag {
+		buf = append(buf, el.Name.Space+"\x00"+el.Name.Local+"\x00"...)
+		if c.opts.Prefixes {
+			buf = append(buf, el.Prefix(el.Name)+"\x00"...)
 		}
-		attrs[a.Name] = a.Value
+		for _, a := range c.attrs(el) {
+			buf = append(buf, a.Name.Space+"\x00"+a.Name.Local+"\x00"+a.Value+"\x00"...)
+			if c.opts.Prefixes {
+				buf = append(buf, el.Prefix(a.Name)+"\x00"...)
+			}
+		}
+	} else {
+		buf = append(buf, c.text(el.Content)+"\x00"...)
 	}
+	w.Write(buf)
+}
 
-	for _, a := range b.StartElement.Attr {
-		if a.Name.Space == "xmlns" || a.Name.Local == "xmlns" {
-			continue
+// sum returns a digest of a node and its children. Nodes which compare
+// equal have the same digest.
+func (c *comparer) sum(el *Element, depth int) [sha256.Size]byte {
+	if s, ok := c.sums[el]; ok {
+		return s
+	}
+	var buf bytes.Buffer
+	c.writeNode(&buf, el)
+	if depth < maxCompareDepth {
+		children := c.children(el)
+		sums := make([][sha256.Size]byte, len(children))
+		for i, child := range children {
+			sums[i] = c.sum(child, depth+1)
 		}
-		if v, ok := attrs[a.Name]; !ok || v != a.Value {
-			return false
+		if !c.opts.Ordered {
+			sort.Slice(sums, func(i, j int) bool {
+				return bytes.Compare(sums[i][:], sums[j][:]) < 0
+			})
+		}
+		for _, s := range sums {
+			buf.Write(s[:])
 		}
 	}
-	return true
+	s := sha256.Sum256(buf.Bytes())
+	c.sums[el] = s
+	return s
 }
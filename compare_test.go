@@ -0,0 +1,58 @@
+package xmltree
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestEqualDoesNotMutate(t *testing.T) {
+	a := parseDoc(t, []byte(`<list><z/><b>2</b><a/><b>1</b></list>`))
+	b := parseDoc(t, []byte(`<list><b>1</b><a/><b>2</b><z/></list>`))
+	before := a.String()
+	if !Equal(a, b) || !Equal(b, a) {
+		t.Error("documents differing only in child order should be equal")
+	}
+	if after := a.String(); after != before {
+		t.Errorf("Equal reordered its argument: %s -> %s", before, after)
+	}
+	if EqualWithOptions(a, b, &EqualOptions{Ordered: true}) {
+		t.Error("ordered comparison ignored child order")
+	}
+}
+
+func TestEqualSymmetricAttrs(t *testing.T) {
+	a := parseDoc(t, []byte(`<a x="1"/>`))
+	b := parseDoc(t, []byte(`<a x="1" y="2"/>`))
+	if Equal(a, b) || Equal(b, a) {
+		t.Error("extra attribute was ignored")
+	}
+	opts := &EqualOptions{IgnoreAttrs: []xml.Name{{Local: "y"}}}
+	if !EqualWithOptions(a, b, opts) || !EqualWithOptions(b, a, opts) {
+		t.Error("ignored attribute was compared")
+	}
+}
+
+func TestEqualOptions(t *testing.T) {
+	for _, tt := range []struct {
+		a, b  string
+		opts  EqualOptions
+		equal bool
+	}{
+		{`<a xmlns:p="urn:x"><p:b/></a>`, `<a xmlns:q="urn:x"><q:b/></a>`, EqualOptions{}, true},
+		{`<a xmlns:p="urn:x"><p:b/></a>`, `<a xmlns:q="urn:x"><q:b/></a>`, EqualOptions{Prefixes: true}, false},
+		{`<a>x<!-- note -->y</a>`, `<a>xy</a>`, EqualOptions{}, false},
+		{`<a>x<!-- note -->y</a>`, `<a>xy</a>`, EqualOptions{IgnoreComments: true}, true},
+		{`<a><?pi data?><b/></a>`, `<a><b/></a>`, EqualOptions{IgnoreProcInsts: true}, true},
+		{`<a>  one   two </a>`, `<a>one two</a>`, EqualOptions{}, true},
+		{`<a>  one   two </a>`, `<a>one two</a>`, EqualOptions{Whitespace: true}, false},
+	} {
+		a := parseFullDoc(t, []byte(tt.a))
+		b := parseFullDoc(t, []byte(tt.b))
+		if got := EqualWithOptions(a, b, &tt.opts); got != tt.equal {
+			t.Errorf("EqualWithOptions(%s, %s, %+v) = %t, wanted %t", tt.a, tt.b, tt.opts, got, tt.equal)
+		}
+		if got := EqualWithOptions(b, a, &tt.opts); got != tt.equal {
+			t.Errorf("EqualWithOptions(%s, %s, %+v) = %t, wanted %t", tt.b, tt.a, tt.opts, got, tt.equal)
+		}
+	}
+}
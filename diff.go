@@ -0,0 +1,443 @@
+package xmltree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A ChangeKind describes the type of a Change reported by Diff.
+type ChangeKind uint8
+
+const (
+	// An element or other node present in b but not in a.
+	ChangeInserted ChangeKind = iota
+	// An element or other node present in a but not in b.
+	ChangeDeleted
+	// An element present in both trees whose position among its
+	// siblings has changed.
+	ChangeMoved
+	// A comment, processing instruction or directive whose content has
+	// changed.
+	ChangeModified
+	// An attribute present in b but not in a.
+	ChangeAttrInserted
+	// An attribute present in a but not in b.
+	ChangeAttrDeleted
+	// An attribute whose value has changed.
+	ChangeAttrModified
+	// Character data which has changed, either the Content of an element
+	// or a character data node.
+	ChangeText
+)
+
+var changeKindNames = [...]string{
+	ChangeInserted:     "inserted",
+	ChangeDeleted:      "deleted",
+	ChangeMoved:        "moved",
+	ChangeModified:     "modified",
+	ChangeAttrInserted: "attribute inserted",
+	ChangeAttrDeleted:  "attribute deleted",
+	ChangeAttrModified: "attribute modified",
+	ChangeText:         "text modified",
+}
+
+func (k ChangeKind) String() string {
+	if int(k) < len(changeKindNames) {
+		return changeKindNames[k]
+	}
+	return "ChangeKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// A Change is a single difference between two Element trees.
+type Change struct {
+	Kind ChangeKind
+	// Path is the location of the changed node in the first tree, and
+	// NewPath its location in the second tree. Path is empty for
+	// inserted nodes and NewPath is empty for deleted nodes. Paths are
+	// written in an XPath-like syntax, using the namespace prefixes in
+	// scope at the node.
+	Path, NewPath string
+	// The nodes in the first and second tree. For attribute changes
+	// these are the elements owning the attribute.
+	Old, New *Element
+	// For attribute changes, the name of the attribute.
+	Attr xml.Name
+	// The old and new values of a changed attribute, text or other
+	// content.
+	OldValue, NewValue string
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case ChangeInserted:
+		return fmt.Sprintf("%s %s", c.Kind, c.NewPath)
+	case ChangeDeleted:
+		return fmt.Sprintf("%s %s", c.Kind, c.Path)
+	case ChangeMoved:
+		return fmt.Sprintf("%s %s -> %s", c.Kind, c.Path, c.NewPath)
+	case ChangeAttrInserted:
+		return fmt.Sprintf("%s %s/@%s=%q", c.Kind, c.NewPath, c.New.Prefix(c.Attr), c.NewValue)
+	case ChangeAttrDeleted:
+		return fmt.Sprintf("%s %s/@%s=%q", c.Kind, c.Path, c.Old.Prefix(c.Attr), c.OldValue)
+	case ChangeAttrModified:
+		return fmt.Sprintf("%s %s/@%s %q -> %q", c.Kind, c.Path, c.Old.Prefix(c.Attr), c.OldValue, c.NewValue)
+	}
+	return fmt.Sprintf("%s %s %q -> %q", c.Kind, c.Path, c.OldValue, c.NewValue)
+}
+
+// DiffOptions controls how Diff matches and compares two trees.
+type DiffOptions struct {
+	// Unordered ignores the order of child nodes. No ChangeMoved
+	// entries are produced.
+	Unordered bool
+	// Whitespace makes differences in white space significant. By
+	// default leading and trailing white space is ignored, runs of
+	// white space compare equal, and character data consisting only of
+	// white space is skipped.
+	Whitespace bool
+	// Identity lists the local names of attributes, such as "id", which
+	// identify an element among its siblings. Elements with the same
+	// name and identity are treated as the same element, so changes to
+	// them are reported as modifications rather than as a deletion and
+	// an insertion.
+	Identity []string
+}
+
+// Diff reports the differences between two Element trees as a list of
+// Changes which, applied to a, would produce b. Children are first
+// matched by identical content, and then by name and identity. A nil
+// opts is the same as a zero DiffOptions.
+func Diff(a, b *Element, opts *DiffOptions) []Change {
+	if opts == nil {
+		opts = new(DiffOptions)
+	}
+	d := differ{opts: opts, sums: make(map[*Element][sha256.Size]byte)}
+	pa, pb := "/"+qualify(&a.Scope, a.Name), "/"+qualify(&b.Scope, b.Name)
+	if d.key(a) != d.key(b) {
+		return []Change{
+			{Kind: ChangeDeleted, Path: pa, Old: a},
+			{Kind: ChangeInserted, NewPath: pb, New: b},
+		}
+	}
+	d.node(a, b, pa, pb, 0)
+	return d.changes
+}
+
+type differ struct {
+	opts    *DiffOptions
+	sums    map[*Element][sha256.Size]byte
+	changes []Change
+}
+
+func (d *differ) add(c Change) {
+	d.changes = append(d.changes, c)
+}
+
+func (d *differ) text(s string) string {
+	if d.opts.Whitespace {
+		return s
+	}
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// leafText returns the character data held in the Content of an
+// element without children.
+func (d *differ) leafText(el *Element) string {
+	if len(el.Children) > 0 {
+		return ""
+	}
+	return d.text(el.Content)
+}
+
+// identity returns the value of the first identity attribute of el.
+func (d *differ) identity(el *Element) (string, string, bool) {
+	for _, local := range d.opts.Identity {
+		for _, a := range el.StartElement.Attr {
+			if a.Name.Local == local {
+				return local, a.Value, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// key identifies a node among its siblings, regardless of content.
+func (d *differ) key(el *Element) string {
+	if el.Type != XML_Tag {
+		return strconv.Itoa(int(el.Type))
+	}
+	key := el.Name.Space + " " + el.Name.Local
+	if local, value, ok := d.identity(el); ok {
+		key += " " + local + "=" + value
+	}
+	return key
+}
+
+// children returns the indices of el's children which take part in
+// the comparison.
+func (d *differ) children(el *Element) []int {
+	idx := make([]int, 0, len(el.Children))
+	for i := range el.Children {
+		c := &el.Children[i]
+		if c.Type == XML_CharData && d.text(c.Content) == "" {
+			continue
+		}
+		idx = append(idx, i)
+	}
+	return idx
+}
+
+// sum returns a digest of the content of a node, so that identical
+// subtrees can be matched without comparing them repeatedly.
+func (d *differ) sum(el *Element, depth int) [sha256.Size]byte {
+	if s, ok := d.sums[el]; ok {
+		return s
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(byte(el.Type))
+	buf.WriteString(el.Name.Space + "\x00" + el.Name.Local + "\x00")
+	attrs := make([]string, 0, len(el.StartElement.Attr))
+	for _, a := range el.StartElement.Attr {
+		attrs = append(attrs, a.Name.Space+"\x00"+a.Name.Local+"\x00"+a.Value)
+	}
+	sort.Strings(attrs)
+	for _, a := range attrs {
+		buf.WriteString(a + "\x00")
+	}
+	if el.Type == XML_Tag {
+		buf.WriteString(d.leafText(el))
+	} else {
+		buf.WriteString(d.text(el.Content))
+	}
+	buf.WriteByte(0)
+	if depth < recursionLimit {
+		var sums [][sha256.Size]byte
+		for _, i := range d.children(el) {
+			sums = append(sums, d.sum(&el.Children[i], depth+1))
+		}
+		if d.opts.Unordered {
+			sort.Slice(sums, func(i, j int) bool {
+				return bytes.Compare(sums[i][:], sums[j][:]) < 0
+			})
+		}
+		for _, s := range sums {
+			buf.Write(s[:])
+		}
+	}
+	s := sha256.Sum256(buf.Bytes())
+	d.sums[el] = s
+	return s
+}
+
+// segment returns the path segment of a node, given its position among
+// siblings of the same kind and name.
+func (d *differ) segment(el *Element, pos int) string {
+	switch el.Type {
+	case XML_CharData, XML_CDATA:
+		return fmt.Sprintf("text()[%d]", pos)
+	case XML_Comment:
+		return fmt.Sprintf("comment()[%d]", pos)
+	case XML_ProcInst:
+		return fmt.Sprintf("processing-instruction()[%d]", pos)
+	case XML_Directive:
+		return fmt.Sprintf("directive()[%d]", pos)
+	}
+	name := qualify(&el.Scope, el.Name)
+	if local, value, ok := d.identity(el); ok {
+		return fmt.Sprintf("%s[@%s=%s]", name, local, strconv.Quote(value))
+	}
+	return fmt.Sprintf("%s[%d]", name, pos)
+}
+
+// paths returns the path of each child of el.
+func (d *differ) paths(el *Element, path string) []string {
+	count := make(map[string]int)
+	paths := make([]string, len(el.Children))
+	for i := range el.Children {
+		c := &el.Children[i]
+		k := strconv.Itoa(int(c.Type)) + " " + c.Name.Space + " " + c.Name.Local
+		if isText(c) {
+			k = "text"
+		}
+		count[k]++
+		paths[i] = path + "/" + d.segment(c, count[k])
+	}
+	return paths
+}
+
+func (d *differ) node(a, b *Element, pa, pb string, depth int) {
+	if a.Type != XML_Tag {
+		if ta, tb := d.text(a.Content), d.text(b.Content); ta != tb {
+			kind := ChangeModified
+			if a.Type == XML_CharData {
+				kind = ChangeText
+			}
+			d.add(Change{Kind: kind, Path: pa, NewPath: pb, Old: a, New: b,
+				OldValue: a.Content, NewValue: b.Content})
+		}
+		return
+	}
+	if d.sum(a, depth) == d.sum(b, depth) {
+		return
+	}
+	d.attrs(a, b, pa, pb)
+	if ta, tb := d.leafText(a), d.leafText(b); ta != tb {
+		d.add(Change{Kind: ChangeText, Path: pa, NewPath: pb, Old: a, New: b,
+			OldValue: a.Content, NewValue: b.Content})
+	}
+	if depth < recursionLimit {
+		d.nodes(a, b, pa, pb, depth)
+	}
+}
+
+func (d *differ) attrs(a, b *Element, pa, pb string) {
+	old := make(map[xml.Name]string, len(a.StartElement.Attr))
+	for _, attr := range a.StartElement.Attr {
+		old[attr.Name] = attr.Value
+	}
+	seen := make(map[xml.Name]bool, len(b.StartElement.Attr))
+	for _, attr := range b.StartElement.Attr {
+		seen[attr.Name] = true
+		if v, ok := old[attr.Name]; !ok {
+			d.add(Change{Kind: ChangeAttrInserted, Path: pa, NewPath: pb, Old: a, New: b,
+				Attr: attr.Name, NewValue: attr.Value})
+		} else if v != attr.Value {
+			d.add(Change{Kind: ChangeAttrModified, Path: pa, NewPath: pb, Old: a, New: b,
+				Attr: attr.Name, OldValue: v, NewValue: attr.Value})
+		}
+	}
+	for _, attr := range a.StartElement.Attr {
+		if !seen[attr.Name] {
+			d.add(Change{Kind: ChangeAttrDeleted, Path: pa, NewPath: pb, Old: a, New: b,
+				Attr: attr.Name, OldValue: attr.Value})
+		}
+	}
+}
+
+// A childMatch pairs up the children of two elements.
+type childMatch struct {
+	// Indices of the children taking part in the comparison.
+	ca, cb []int
+	// For each entry of ca, the index into cb of its counterpart, and
+	// vice versa, or -1 if it has none.
+	matchA, matchB []int
+	// For each entry of ca, whether its counterpart is out of order.
+	moved []bool
+}
+
+// match pairs up the children of a and b. Identical subtrees are
+// paired first, and then whatever is left by name and identity,
+// preferring document order in both cases.
+func (d *differ) match(a, b *Element, depth int) childMatch {
+	m := childMatch{ca: d.children(a), cb: d.children(b)}
+	m.matchA = make([]int, len(m.ca))
+	m.matchB = make([]int, len(m.cb))
+	for i := range m.matchA {
+		m.matchA[i] = -1
+	}
+	for j := range m.matchB {
+		m.matchB[j] = -1
+	}
+
+	pair := func(keyOf func(*Element) string) {
+		pending := make(map[string][]int)
+		for j, n := range m.cb {
+			if m.matchB[j] < 0 {
+				k := keyOf(&b.Children[n])
+				pending[k] = append(pending[k], j)
+			}
+		}
+		for i, n := range m.ca {
+			if m.matchA[i] >= 0 {
+				continue
+			}
+			k := keyOf(&a.Children[n])
+			if list := pending[k]; len(list) > 0 {
+				m.matchA[i], m.matchB[list[0]] = list[0], i
+				pending[k] = list[1:]
+			}
+		}
+	}
+	pair(func(el *Element) string {
+		s := d.sum(el, depth+1)
+		return string(s[:])
+	})
+	pair(d.key)
+
+	m.moved = make([]bool, len(m.ca))
+	if !d.opts.Unordered {
+		var pairs []int
+		for i := range m.ca {
+			if m.matchA[i] >= 0 {
+				pairs = append(pairs, i)
+			}
+		}
+		keep := longestIncreasing(pairs, m.matchA)
+		for _, i := range pairs {
+			m.moved[i] = !keep[i]
+		}
+	}
+	return m
+}
+
+// nodes matches up the children of a and b, and reports the
+// differences between them.
+func (d *differ) nodes(a, b *Element, pa, pb string, depth int) {
+	m := d.match(a, b, depth)
+	patha, pathb := d.paths(a, pa), d.paths(b, pb)
+	for i, n := range m.ca {
+		x := &a.Children[n]
+		if m.matchA[i] < 0 {
+			d.add(Change{Kind: ChangeDeleted, Path: patha[n], Old: x})
+			continue
+		}
+		k := m.cb[m.matchA[i]]
+		y := &b.Children[k]
+		if m.moved[i] {
+			d.add(Change{Kind: ChangeMoved, Path: patha[n], NewPath: pathb[k], Old: x, New: y})
+		}
+		d.node(x, y, patha[n], pathb[k], depth+1)
+	}
+	for j, k := range m.cb {
+		if m.matchB[j] < 0 {
+			d.add(Change{Kind: ChangeInserted, NewPath: pathb[k], New: &b.Children[k]})
+		}
+	}
+}
+
+// longestIncreasing returns the members of idx which form the longest
+// subsequence for which match[i] is increasing. These are the matched
+// nodes which kept their relative order; the rest have moved.
+func longestIncreasing(idx []int, match []int) map[int]bool {
+	var (
+		tails []int // index into idx of the smallest tail of each length
+		prev  = make([]int, len(idx))
+	)
+	for k, i := range idx {
+		n := sort.Search(len(tails), func(t int) bool {
+			return match[idx[tails[t]]] >= match[i]
+		})
+		if n > 0 {
+			prev[k] = tails[n-1]
+		} else {
+			prev[k] = -1
+		}
+		if n == len(tails) {
+			tails = append(tails, k)
+		} else {
+			tails[n] = k
+		}
+	}
+	keep := make(map[int]bool, len(tails))
+	if len(tails) > 0 {
+		for k := tails[len(tails)-1]; k >= 0; k = prev[k] {
+			keep[idx[k]] = true
+		}
+	}
+	return keep
+}
@@ -0,0 +1,88 @@
+package xmltree
+
+import (
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a := parseFullDoc(t, []byte(`<oval_definitions xmlns="http://oval.mitre.org/XMLSchema/oval-definitions-5">
+	  <definitions>
+	    <definition id="def:1" version="1">
+	      <title>CVE-1</title>
+	      <criteria operator="AND">
+	        <criterion test_ref="tst:1"/>
+	        <criterion test_ref="tst:2"/>
+	      </criteria>
+	    </definition>
+	    <definition id="def:2" version="1"><title>CVE-2</title></definition>
+	    <definition id="def:3" version="1"><title>CVE-3</title></definition>
+	  </definitions>
+	  <tests>
+	    <test id="tst:1"/>
+	    <test id="tst:2"/>
+	  </tests>
+	</oval_definitions>`))
+	b := parseFullDoc(t, []byte(`<oval_definitions xmlns="http://oval.mitre.org/XMLSchema/oval-definitions-5">
+	  <definitions>
+	    <definition id="def:3" version="1"><title>CVE-3</title></definition>
+	    <definition id="def:1" version="2">
+	      <title>CVE-1 (updated)</title>
+	      <criteria operator="AND">
+	        <criterion test_ref="tst:1"/>
+	        <criterion test_ref="tst:3"/>
+	      </criteria>
+	    </definition>
+	    <definition id="def:4" version="1"><title>CVE-4</title></definition>
+	  </definitions>
+	  <tests>
+	    <test id="tst:1"/>
+	    <test id="tst:3"/>
+	  </tests>
+	</oval_definitions>`))
+
+	changes := Diff(a, b, &DiffOptions{Identity: []string{"id"}})
+	for _, c := range changes {
+		t.Log(c)
+	}
+	want := []struct {
+		kind ChangeKind
+		path string
+	}{
+		{ChangeMoved, `/oval_definitions/definitions[1]/definition[@id="def:1"]`},
+		{ChangeAttrModified, `/oval_definitions/definitions[1]/definition[@id="def:1"]`},
+		{ChangeText, `/oval_definitions/definitions[1]/definition[@id="def:1"]/title[1]`},
+		{ChangeAttrModified, `/oval_definitions/definitions[1]/definition[@id="def:1"]/criteria[1]/criterion[2]`},
+		{ChangeDeleted, `/oval_definitions/definitions[1]/definition[@id="def:2"]`},
+		{ChangeInserted, ``},
+		{ChangeDeleted, `/oval_definitions/tests[1]/test[@id="tst:2"]`},
+		{ChangeInserted, ``},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("got %d changes, wanted %d", len(changes), len(want))
+	}
+	for i, w := range want {
+		if changes[i].Kind != w.kind || changes[i].Path != w.path {
+			t.Errorf("change %d: got %s, wanted %s %s", i, changes[i], w.kind, w.path)
+		}
+	}
+
+	if changes := Diff(a, a, nil); len(changes) != 0 {
+		t.Errorf("identical documents produced changes: %v", changes)
+	}
+	if changes := Diff(a, b, &DiffOptions{Unordered: true, Identity: []string{"id"}}); len(changes) != len(want)-1 {
+		t.Errorf("unordered diff should not report moves: %v", changes)
+	}
+}
+
+func TestDiffWhitespace(t *testing.T) {
+	a := parseFullDoc(t, []byte(`<p>some <b>bold</b>  text</p>`))
+	b := parseFullDoc(t, []byte(`<p>some <b>bold</b> text </p>`))
+	if changes := Diff(a, b, nil); len(changes) != 0 {
+		t.Errorf("white space differences reported by default: %v", changes)
+	}
+	a = parseFullDoc(t, []byte(`<pre xml:space="preserve">a  b</pre>`))
+	b = parseFullDoc(t, []byte(`<pre xml:space="preserve">a b</pre>`))
+	if changes := Diff(a, b, &DiffOptions{Whitespace: true}); len(changes) != 1 || changes[0].Kind != ChangeText {
+		t.Errorf("expected a single text change, got %v", changes)
+	}
+}
@@ -0,0 +1,321 @@
+// Package dtd reads XML document type definitions from DOCTYPE
+// declarations, and validates trees of xmltree Elements against them.
+//
+// Both the internal subset and, if it can be loaded, the external subset
+// are read, including parameter entities and conditional sections. The
+// declarations are kept in a DTD, which may be used to validate
+// documents, to supply default attribute values, and to resolve the
+// entities declared in it while parsing. DTDs are not aware of
+// namespaces, so elements and attributes are named by their qualified
+// names as written in the document; namespace declarations are not
+// checked.
+package dtd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/pschou/go-xmltree"
+	"golang.org/x/net/html/charset"
+)
+
+// A DTD holds the declarations of a document type definition.
+type DTD struct {
+	// Name is the name of the root element, from the DOCTYPE
+	// declaration.
+	Name string
+	// PublicID and SystemID identify the external subset, if any.
+	PublicID string
+	SystemID string
+
+	Elements      map[string]*ElementDecl
+	Attlists      map[string][]*AttDecl // by element name, in the order declared
+	Entities      map[string]*Entity    // general entities
+	ParamEntities map[string]*Entity
+	Notations     map[string]*Notation
+}
+
+// ContentKind is the kind of content allowed by an element declaration.
+type ContentKind int
+
+const (
+	EmptyContent   ContentKind = iota // EMPTY
+	AnyContent                        // ANY
+	MixedContent                      // (#PCDATA | ...)*
+	ElementContent                    // a content model of elements only
+)
+
+// An ElementDecl is an element type declaration.
+type ElementDecl struct {
+	Name    string
+	Content ContentKind
+	// Model is the content model of an element with element content.
+	// For mixed content, it is a choice of the elements allowed, or nil
+	// if only text is allowed.
+	Model *Particle
+
+	re *regexp.Regexp // matches the names of the children, as <a><b>
+}
+
+// ParticleKind is the kind of a Particle in a content model.
+type ParticleKind int
+
+const (
+	NameParticle ParticleKind = iota
+	SeqParticle
+	ChoiceParticle
+)
+
+// A Particle is part of a content model.
+type Particle struct {
+	Kind   ParticleKind
+	Name   string      // the element name, for NameParticle
+	Items  []*Particle // for SeqParticle and ChoiceParticle
+	Occurs byte        // 0 for exactly once, or one of '?', '*' and '+'
+}
+
+// String returns the particle as written in a DTD.
+func (p *Particle) String() string {
+	var s string
+	switch p.Kind {
+	case NameParticle:
+		s = p.Name
+	default:
+		sep := ","
+		if p.Kind == ChoiceParticle {
+			sep = "|"
+		}
+		items := make([]string, len(p.Items))
+		for i, item := range p.Items {
+			items[i] = item.String()
+		}
+		s = "(" + strings.Join(items, sep) + ")"
+	}
+	if p.Occurs != 0 {
+		s += string(p.Occurs)
+	}
+	return s
+}
+
+// DefaultKind says how the value of an attribute is defaulted.
+type DefaultKind int
+
+const (
+	DefaultImplied  DefaultKind = iota // #IMPLIED
+	DefaultRequired                    // #REQUIRED
+	DefaultFixed                       // #FIXED "value"
+	DefaultValue                       // "value"
+)
+
+// An AttDecl is the declaration of an attribute in an attribute-list
+// declaration.
+type AttDecl struct {
+	Element string
+	Name    string
+	// Type is one of CDATA, ID, IDREF, IDREFS, ENTITY, ENTITIES,
+	// NMTOKEN, NMTOKENS and NOTATION, or empty for an enumerated type.
+	Type string
+	// Values lists the values allowed for enumerated and NOTATION
+	// types.
+	Values  []string
+	Default DefaultKind
+	// Value is the default or fixed value, normalized.
+	Value string
+}
+
+// An Entity is an entity declaration. Internal entities have a Value,
+// and external entities have a SystemID. Unparsed entities also name a
+// Notation.
+type Entity struct {
+	Name     string
+	Value    string // the replacement text, with references to characters and parameter entities expanded
+	PublicID string
+	SystemID string
+	Notation string
+}
+
+// A Notation is a notation declaration.
+type Notation struct {
+	Name     string
+	PublicID string
+	SystemID string
+}
+
+// Options controls how a DTD is read.
+type Options struct {
+	// ParseOptions are used by ParseDocument to build the tree.
+	xmltree.ParseOptions
+
+	// Load returns the text of an external subset or external
+	// parameter entity. The system identifier is passed as written.
+	// If Load is nil, external subsets and parameter entities are
+	// skipped.
+	Load func(publicID, systemID string) ([]byte, error)
+}
+
+var errNotDoctype = errors.New("dtd: not a DOCTYPE declaration")
+
+// Parse reads the DTD in a DOCTYPE declaration, given as an Element of
+// type XML_Directive. The internal subset is read first, followed by the
+// external subset, if any. A nil opts is the same as a zero Options.
+//
+// The trees built by xmltree.Parse do not keep the declarations before
+// the root element, so a DOCTYPE declaration is not found in them. Use
+// ParseDocument to read a document along with its DTD, or ParseDoctype
+// to read a declaration held as text.
+func Parse(directive *xmltree.Element, opts *Options) (*DTD, error) {
+	if directive.Type != xmltree.XML_Directive {
+		return nil, errNotDoctype
+	}
+	return parseDoctype(directive.Content, opts)
+}
+
+// ParseDoctype is like Parse, but reads the DOCTYPE declaration as
+// written in a document, from <!DOCTYPE to the closing >.
+func ParseDoctype(decl []byte, opts *Options) (*DTD, error) {
+	s := strings.TrimSpace(string(decl))
+	if !strings.HasPrefix(s, "<!") || !strings.HasSuffix(s, ">") {
+		return nil, errNotDoctype
+	}
+	return parseDoctype(s[2:len(s)-1], opts)
+}
+
+func parseDoctype(decl string, opts *Options) (*DTD, error) {
+	if !isDoctype(decl) {
+		return nil, errNotDoctype
+	}
+	p := newParser(opts)
+	if err := p.doctype(decl); err != nil {
+		return nil, err
+	}
+	return p.d, nil
+}
+
+// ParseSubset reads the declarations in a separate DTD file, such as an
+// external subset. The Name of the DTD returned is empty, so documents
+// validated against it may have any root element.
+func ParseSubset(text []byte, opts *Options) (*DTD, error) {
+	p := newParser(opts)
+	p.push(stripTextDecl(string(text)), "")
+	if err := p.subset(""); err != nil {
+		return nil, err
+	}
+	if err := p.finish(); err != nil {
+		return nil, err
+	}
+	return p.d, nil
+}
+
+func isDoctype(s string) bool {
+	return strings.HasPrefix(s, "DOCTYPE") && len(s) > 7 && isSpace(s[7])
+}
+
+// ParseDocument reads an XML document along with its DOCTYPE
+// declaration. The DTD supplies the replacement text of entities
+// referenced in the document, and the default values of attributes not
+// given in it. Entities in opts.Entity take precedence over those
+// declared in the DTD. The DTD returned is nil if the document has no
+// DOCTYPE declaration.
+//
+// Entities are replaced by their text, so markup in their replacement
+// text is not parsed.
+func ParseDocument(doc io.Reader, opts *Options) (*xmltree.Element, *DTD, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	data, err := io.ReadAll(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.CharsetReader = charset.NewReaderLabel
+	var d *DTD
+prolog:
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.Directive:
+			if isDoctype(string(tok)) {
+				directive := &xmltree.Element{Type: xmltree.XML_Directive, Content: string(tok)}
+				if d, err = Parse(directive, opts); err != nil {
+					return nil, nil, err
+				}
+			}
+		case xml.StartElement:
+			break prolog
+		}
+	}
+
+	popts := opts.ParseOptions
+	if d != nil {
+		popts.Entity = d.EntityText()
+		for name, text := range opts.Entity {
+			popts.Entity[name] = text
+		}
+	}
+	root, err := xmltree.ParseWithOptions(bytes.NewReader(data), &popts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if d != nil {
+		d.AddDefaults(root)
+	}
+	return root, d, nil
+}
+
+// EntityText returns the replacement text of the internal general
+// entities, with references to other entities replaced, for use as
+// ParseOptions.Entity.
+func (d *DTD) EntityText() map[string]string {
+	m := make(map[string]string)
+	for name, e := range d.Entities {
+		if e.SystemID == "" {
+			m[name] = d.expand(e.Value, map[string]bool{name: true})
+		}
+	}
+	return m
+}
+
+// predefined are the entities predefined by XML.
+var predefined = map[string]string{"lt": "<", "gt": ">", "amp": "&", "apos": "'", "quot": `"`}
+
+// expand replaces references to general entities in s. Entities which
+// are undefined, external, or would recurse are left as written.
+func (d *DTD) expand(s string, open map[string]bool) string {
+	if !strings.Contains(s, "&") {
+		return s
+	}
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '&')
+		if i < 0 {
+			break
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+		end := strings.IndexByte(s, ';')
+		if end < 0 {
+			break
+		}
+		name := s[1:end]
+		if text, ok := predefined[name]; ok {
+			b.WriteString(text)
+		} else if e, ok := d.Entities[name]; ok && e.SystemID == "" && !open[name] {
+			open[name] = true
+			b.WriteString(d.expand(e.Value, open))
+			delete(open, name)
+		} else {
+			b.WriteString(s[:end+1])
+		}
+		s = s[end+1:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
@@ -0,0 +1,160 @@
+package dtd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-xmltree"
+)
+
+func directive(s string) *xmltree.Element {
+	return &xmltree.Element{Type: xmltree.XML_Directive, Content: s}
+}
+
+func TestParse(t *testing.T) {
+	external := map[string]string{
+		"book.dtd": `<?xml version="1.0" encoding="UTF-8"?>
+<!ENTITY % inline "#PCDATA|em|%extra;">
+<!ELEMENT book (title, chapter+, appendix*)>
+<!ELEMENT title (%inline;)*>
+<!ELEMENT chapter ((title, para*) | ref)>
+<!ELEMENT para (%inline;)*>
+<!ELEMENT em (#PCDATA)>
+<!ELEMENT appendix ANY>
+<!ELEMENT ref EMPTY>
+<![%draft;[
+<!ELEMENT note (#PCDATA)>
+]]>
+<![IGNORE[ <!ELEMENT ignored EMPTY> <![INCLUDE[ ]]> ]]>
+<!ATTLIST book
+    id      ID                #REQUIRED
+    lang    NMTOKEN           "en"
+    version CDATA             #FIXED "1.0"
+    status  (draft|final)     'draft'
+    cover   ENTITY            #IMPLIED>
+<!ATTLIST ref to IDREF #REQUIRED>
+<!ENTITY title "Go &amp; XML">
+<!NOTATION gif PUBLIC "-//image/gif">
+<!ENTITY cover SYSTEM "cover.gif" NDATA gif>
+<!ENTITY % ext SYSTEM "ext.ent">
+%ext;`,
+		"ext.ent": `<!ENTITY publisher "The &pub; Press">`,
+	}
+	opts := &Options{Load: func(public, system string) ([]byte, error) {
+		if text, ok := external[system]; ok {
+			return []byte(text), nil
+		}
+		return nil, fmt.Errorf("no entity %s", system)
+	}}
+	d, err := Parse(directive(`DOCTYPE book SYSTEM "book.dtd" [
+  <!-- Declarations in the internal subset come first. -->
+  <!ENTITY % extra "code">
+  <!ENTITY % draft "INCLUDE">
+  <!ELEMENT code (#PCDATA)>
+  <!ATTLIST book lang NMTOKEN "fr">
+  <!ENTITY pub "Big">
+  <!ENTITY title "Overridden">
+  <?pi ignored?>
+]`), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Name != "book" || d.SystemID != "book.dtd" {
+		t.Errorf("got name %q, system %q", d.Name, d.SystemID)
+	}
+	models := map[string]string{
+		"book":    "(title,chapter+,appendix*)",
+		"chapter": "((title,para*)|ref)",
+		"para":    "(em|code)*",
+		"em":      "<nil>",
+	}
+	for name, want := range models {
+		decl, ok := d.Elements[name]
+		if !ok {
+			t.Errorf("element %s not declared", name)
+		} else if got := fmt.Sprint(decl.Model); got != want {
+			t.Errorf("%s: got model %s, want %s", name, got, want)
+		}
+	}
+	if d.Elements["appendix"].Content != AnyContent || d.Elements["ref"].Content != EmptyContent {
+		t.Error("wrong ANY or EMPTY content")
+	}
+	if d.Elements["note"] == nil || d.Elements["ignored"] != nil {
+		t.Error("conditional sections not handled")
+	}
+
+	var attrs []string
+	for _, a := range d.Attlists["book"] {
+		attrs = append(attrs, fmt.Sprintf("%s:%s%v:%d:%s", a.Name, a.Type, a.Values, a.Default, a.Value))
+	}
+	want := "lang:NMTOKEN[]:3:fr id:ID[]:1: version:CDATA[]:2:1.0 status:[draft final]:3:draft cover:ENTITY[]:0:"
+	if got := strings.Join(attrs, " "); got != want {
+		t.Errorf("got attributes\n%s\nwant\n%s", got, want)
+	}
+
+	text := d.EntityText()
+	if text["title"] != "Overridden" || text["publisher"] != "The Big Press" {
+		t.Errorf("got entity text %q", text)
+	}
+	if _, ok := text["cover"]; ok {
+		t.Error("unparsed entity in entity text")
+	}
+	if e := d.Entities["cover"]; e.Notation != "gif" || d.Notations["gif"].PublicID != "-//image/gif" {
+		t.Errorf("got entity %+v", e)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for decl, want := range map[string]string{
+		`DOCTYPE a [<!ELEMENT a EMPTY><!ELEMENT a ANY>]`:       "element a declared twice",
+		`DOCTYPE a [<!ELEMENT a (b,c|d)>]`:                     "bad content model",
+		`DOCTYPE a [<!ELEMENT a (#PCDATA|b)>]`:                 "must end with )*",
+		`DOCTYPE a [<!ELEMENT a %b;>]`:                         "undeclared parameter entity %b;",
+		`DOCTYPE a [<!ENTITY % b "%b;"> <!ELEMENT a %b;>]`:     "undeclared parameter entity %b;",
+		`DOCTYPE a [<!ENTITY % b "<!ELEMENT a (x|y,z)>"> %b;]`: "bad content model, in entity %b;",
+		`DOCTYPE a [<!ATTLIST a b STRING #IMPLIED>]`:           "bad type for attribute b of a",
+		`DOCTYPE a [<!ATTLIST a b ID #IMPLIED c ID #IMPLIED>]`: "more than one ID attribute",
+		`DOCTYPE a [<!ATTLIST a b NOTATION (png) #IMPLIED>]`:   "undeclared notation png",
+		`DOCTYPE a [<!ENTITY b SYSTEM "b.png" NDATA png>]`:     "undeclared notation png in entity b",
+		`DOCTYPE a [<!ELEMENT a EMPTY>`:                        `missing "]"`,
+		`DOCTYPE a [] junk`:                                    `unexpected 'j'`,
+	} {
+		_, err := Parse(directive(decl), nil)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", decl, err, want)
+		}
+	}
+	if _, err := Parse(directive(`ENTITY a "b"`), nil); err != errNotDoctype {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestParseDoctype(t *testing.T) {
+	d, err := ParseDoctype([]byte(`
+<!DOCTYPE note PUBLIC "-//note" "note.dtd" [
+  <!ELEMENT note (#PCDATA)>
+  <!ATTLIST note lang NMTOKEN "en">
+]>`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Name != "note" || d.PublicID != "-//note" || d.SystemID != "note.dtd" {
+		t.Errorf("got DTD %s %q %q", d.Name, d.PublicID, d.SystemID)
+	}
+	if e := d.Elements["note"]; e == nil || e.Content != MixedContent {
+		t.Errorf("got element %+v", e)
+	}
+	root, err := xmltree.Parse(strings.NewReader(`<note>hi</note>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Validate(root); err != nil {
+		t.Error(err)
+	}
+	for _, decl := range []string{`DOCTYPE note []`, `<!ENTITY a "b">`} {
+		if _, err := ParseDoctype([]byte(decl), nil); err != errNotDoctype {
+			t.Errorf("%s: got %v", decl, err)
+		}
+	}
+}
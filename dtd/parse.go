@@ -0,0 +1,853 @@
+package dtd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxEntityDepth limits the nesting of parameter entity references.
+const maxEntityDepth = 64
+
+// A parser reads declarations. Parameter entity references are replaced
+// by pushing their text onto a stack of inputs, which are popped as they
+// are exhausted.
+type parser struct {
+	d     *DTD
+	opts  Options
+	stack []*input
+}
+
+type input struct {
+	text   string
+	pos    int
+	entity string // the parameter entity being read, if any
+}
+
+func newParser(opts *Options) *parser {
+	p := &parser{d: &DTD{
+		Elements:      make(map[string]*ElementDecl),
+		Attlists:      make(map[string][]*AttDecl),
+		Entities:      make(map[string]*Entity),
+		ParamEntities: make(map[string]*Entity),
+		Notations:     make(map[string]*Notation),
+	}}
+	if opts != nil {
+		p.opts = *opts
+	}
+	return p
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		if p.stack[i].entity != "" {
+			return fmt.Errorf("dtd: %s, in entity %%%s;", msg, p.stack[i].entity)
+		}
+	}
+	return fmt.Errorf("dtd: %s", msg)
+}
+
+func (p *parser) push(text, entity string) {
+	p.stack = append(p.stack, &input{text: text, entity: entity})
+}
+
+// top returns the current input, popping those which are exhausted,
+// other than the first.
+func (p *parser) top() *input {
+	for len(p.stack) > 1 {
+		in := p.stack[len(p.stack)-1]
+		if in.pos < len(in.text) {
+			break
+		}
+		p.stack = p.stack[:len(p.stack)-1]
+	}
+	return p.stack[len(p.stack)-1]
+}
+
+// peek returns the next byte, or 0 at the end of the input.
+func (p *parser) peek() byte {
+	in := p.top()
+	if in.pos < len(in.text) {
+		return in.text[in.pos]
+	}
+	return 0
+}
+
+func (p *parser) eof() bool {
+	in := p.top()
+	return in.pos >= len(in.text)
+}
+
+// consume skips s if it is next in the input.
+func (p *parser) consume(s string) bool {
+	in := p.top()
+	if strings.HasPrefix(in.text[in.pos:], s) {
+		in.pos += len(s)
+		return true
+	}
+	return false
+}
+
+// skipTo skips past the next occurrence of s in the current input.
+func (p *parser) skipTo(s string) error {
+	in := p.top()
+	i := strings.Index(in.text[in.pos:], s)
+	if i < 0 {
+		return p.errorf("missing %q", s)
+	}
+	in.pos += i + len(s)
+	return nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isNameStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == ':' || c >= 0x80
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || c >= '0' && c <= '9' || c == '-' || c == '.'
+}
+
+// space skips white space, replacing any parameter entity references
+// found, and reports whether there was any.
+func (p *parser) space() (bool, error) {
+	found := false
+	for {
+		in := p.top()
+		switch {
+		case in.pos < len(in.text) && isSpace(in.text[in.pos]):
+			in.pos++
+			found = true
+		case in.pos+1 < len(in.text) && in.text[in.pos] == '%' && isNameStart(in.text[in.pos+1]):
+			if err := p.paramRef(); err != nil {
+				return found, err
+			}
+			found = true
+		default:
+			return found, nil
+		}
+	}
+}
+
+// needSpace skips white space which the syntax requires.
+func (p *parser) needSpace(where string) error {
+	found, err := p.space()
+	if err == nil && !found {
+		err = p.errorf("missing white space %s", where)
+	}
+	return err
+}
+
+// paramRef replaces the parameter entity reference next in the input
+// by its text, padded with spaces.
+func (p *parser) paramRef() error {
+	p.consume("%")
+	name := p.name()
+	if !p.consume(";") {
+		return p.errorf("missing ; after %%%s", name)
+	}
+	e, ok := p.d.ParamEntities[name]
+	if !ok {
+		return p.errorf("undeclared parameter entity %%%s;", name)
+	}
+	for _, in := range p.stack {
+		if in.entity == name {
+			return p.errorf("recursive parameter entity %%%s;", name)
+		}
+	}
+	if len(p.stack) > maxEntityDepth {
+		return p.errorf("parameter entities nested too deeply")
+	}
+	text, err := p.entityText(e)
+	if err != nil {
+		return err
+	}
+	p.push(" "+text+" ", name)
+	return nil
+}
+
+// entityText returns the text of a parameter entity, loading it if it
+// is external.
+func (p *parser) entityText(e *Entity) (string, error) {
+	if e.SystemID == "" {
+		return e.Value, nil
+	}
+	if p.opts.Load == nil {
+		return "", nil
+	}
+	b, err := p.opts.Load(e.PublicID, e.SystemID)
+	if err != nil {
+		return "", err
+	}
+	return stripTextDecl(string(b)), nil
+}
+
+// stripTextDecl removes the text declaration, <?xml ...?>, from the
+// start of an external entity.
+func stripTextDecl(s string) string {
+	s = strings.TrimPrefix(s, "\uFEFF")
+	if strings.HasPrefix(s, "<?xml") && len(s) > 5 && isSpace(s[5]) {
+		if i := strings.Index(s, "?>"); i >= 0 {
+			return s[i+2:]
+		}
+	}
+	return s
+}
+
+// name reads a name from the current input.
+func (p *parser) name() string {
+	in := p.top()
+	start := in.pos
+	if in.pos < len(in.text) && isNameStart(in.text[in.pos]) {
+		for in.pos < len(in.text) && isNameChar(in.text[in.pos]) {
+			in.pos++
+		}
+	}
+	return in.text[start:in.pos]
+}
+
+// nmtoken reads a name token from the current input.
+func (p *parser) nmtoken() string {
+	in := p.top()
+	start := in.pos
+	for in.pos < len(in.text) && isNameChar(in.text[in.pos]) {
+		in.pos++
+	}
+	return in.text[start:in.pos]
+}
+
+func (p *parser) needName(what string) (string, error) {
+	if _, err := p.space(); err != nil {
+		return "", err
+	}
+	name := p.name()
+	if name == "" {
+		return "", p.errorf("missing %s", what)
+	}
+	return name, nil
+}
+
+// literal reads a quoted string from the current input.
+func (p *parser) literal(what string) (string, error) {
+	if _, err := p.space(); err != nil {
+		return "", err
+	}
+	in := p.top()
+	if in.pos >= len(in.text) || in.text[in.pos] != '"' && in.text[in.pos] != '\'' {
+		return "", p.errorf("missing %s", what)
+	}
+	end := strings.IndexByte(in.text[in.pos+1:], in.text[in.pos])
+	if end < 0 {
+		return "", p.errorf("unterminated %s", what)
+	}
+	s := in.text[in.pos+1 : in.pos+1+end]
+	in.pos += end + 2
+	return s, nil
+}
+
+func (p *parser) end(what string) error {
+	if _, err := p.space(); err != nil {
+		return err
+	}
+	if !p.consume(">") {
+		return p.errorf("missing > at the end of %s", what)
+	}
+	return nil
+}
+
+// doctype reads a DOCTYPE declaration, without the <! and >.
+func (p *parser) doctype(s string) error {
+	p.push(s, "")
+	p.consume("DOCTYPE")
+	var err error
+	if p.d.Name, err = p.needName("the name of the root element"); err != nil {
+		return err
+	}
+	if p.d.PublicID, p.d.SystemID, err = p.externalID(false); err != nil {
+		return err
+	}
+	p.space()
+	if p.consume("[") {
+		if err := p.subset("]"); err != nil {
+			return err
+		}
+		p.space()
+	}
+	if !p.eof() {
+		return p.errorf("unexpected %q after the internal subset", p.peek())
+	}
+	if p.d.SystemID != "" && p.opts.Load != nil {
+		b, err := p.opts.Load(p.d.PublicID, p.d.SystemID)
+		if err != nil {
+			return err
+		}
+		p.stack = nil
+		p.push(stripTextDecl(string(b)), "")
+		if err := p.subset(""); err != nil {
+			return err
+		}
+	}
+	return p.finish()
+}
+
+// externalID reads an optional SYSTEM or PUBLIC identifier. For a
+// notation, the system identifier of a PUBLIC one is optional.
+func (p *parser) externalID(notation bool) (public, system string, err error) {
+	if _, err = p.space(); err != nil {
+		return
+	}
+	switch {
+	case p.consume("SYSTEM"):
+		system, err = p.literal("system identifier")
+	case p.consume("PUBLIC"):
+		if public, err = p.literal("public identifier"); err != nil {
+			return
+		}
+		if _, err = p.space(); err != nil {
+			return
+		}
+		if c := p.peek(); !notation || c == '"' || c == '\'' {
+			system, err = p.literal("system identifier")
+		}
+	}
+	return
+}
+
+// subset reads declarations until the given end marker, or the end of
+// the input if end is empty.
+func (p *parser) subset(end string) error {
+	for {
+		if _, err := p.space(); err != nil {
+			return err
+		}
+		if p.eof() {
+			if end != "" {
+				return p.errorf("missing %q", end)
+			}
+			return nil
+		}
+		var err error
+		switch {
+		case end != "" && p.consume(end):
+			return nil
+		case p.consume("<!--"):
+			err = p.skipTo("-->")
+		case p.consume("<?"):
+			err = p.skipTo("?>")
+		case p.consume("<!["):
+			err = p.conditional()
+		case p.consume("<!ELEMENT"):
+			err = p.elementDecl()
+		case p.consume("<!ATTLIST"):
+			err = p.attlistDecl()
+		case p.consume("<!ENTITY"):
+			err = p.entityDecl()
+		case p.consume("<!NOTATION"):
+			err = p.notationDecl()
+		default:
+			in := p.top()
+			s := in.text[in.pos:]
+			if len(s) > 20 {
+				s = s[:20] + "..."
+			}
+			err = p.errorf("unexpected %q", s)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// conditional reads an INCLUDE or IGNORE section.
+func (p *parser) conditional() error {
+	if _, err := p.space(); err != nil {
+		return err
+	}
+	keyword := p.name()
+	if _, err := p.space(); err != nil {
+		return err
+	}
+	if !p.consume("[") {
+		return p.errorf("missing [ in conditional section")
+	}
+	switch keyword {
+	case "INCLUDE":
+		return p.subset("]]>")
+	case "IGNORE":
+		// Skip nested sections.
+		in := p.top()
+		for depth := 1; depth > 0; {
+			open := strings.Index(in.text[in.pos:], "<![")
+			close := strings.Index(in.text[in.pos:], "]]>")
+			switch {
+			case close < 0:
+				return p.errorf("unterminated IGNORE section")
+			case open >= 0 && open < close:
+				in.pos += open + 3
+				depth++
+			default:
+				in.pos += close + 3
+				depth--
+			}
+		}
+		return nil
+	}
+	return p.errorf("bad conditional section keyword %q", keyword)
+}
+
+func (p *parser) elementDecl() error {
+	if err := p.needSpace("after <!ELEMENT"); err != nil {
+		return err
+	}
+	name, err := p.needName("element name")
+	if err != nil {
+		return err
+	}
+	if _, ok := p.d.Elements[name]; ok {
+		return p.errorf("element %s declared twice", name)
+	}
+	decl := &ElementDecl{Name: name}
+	if err := p.needSpace("after the element name " + name); err != nil {
+		return err
+	}
+	switch {
+	case p.consume("EMPTY"):
+		decl.Content = EmptyContent
+	case p.consume("ANY"):
+		decl.Content = AnyContent
+	case p.consume("("):
+		if _, err := p.space(); err != nil {
+			return err
+		}
+		if p.consume("#PCDATA") {
+			decl.Content = MixedContent
+			decl.Model, err = p.mixed()
+		} else {
+			decl.Content = ElementContent
+			decl.Model, err = p.group()
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		return p.errorf("bad content specification for element %s", name)
+	}
+	if err := p.end("the declaration of element " + name); err != nil {
+		return err
+	}
+	p.d.Elements[name] = decl
+	return nil
+}
+
+// mixed reads the rest of a mixed content declaration, after #PCDATA.
+func (p *parser) mixed() (*Particle, error) {
+	var names []*Particle
+	for {
+		if _, err := p.space(); err != nil {
+			return nil, err
+		}
+		if p.consume(")") {
+			break
+		}
+		if !p.consume("|") {
+			return nil, p.errorf("expected | or ) in mixed content")
+		}
+		name, err := p.needName("element name in mixed content")
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, &Particle{Kind: NameParticle, Name: name})
+	}
+	star := p.consume("*")
+	if len(names) == 0 {
+		return nil, nil
+	}
+	if !star {
+		return nil, p.errorf("mixed content with elements must end with )*")
+	}
+	return &Particle{Kind: ChoiceParticle, Items: names, Occurs: '*'}, nil
+}
+
+// group reads the rest of a sequence or choice, after the (.
+func (p *parser) group() (*Particle, error) {
+	g := &Particle{Kind: SeqParticle}
+	var sep byte
+	for {
+		item, err := p.cp()
+		if err != nil {
+			return nil, err
+		}
+		g.Items = append(g.Items, item)
+		if _, err := p.space(); err != nil {
+			return nil, err
+		}
+		c := p.peek()
+		switch {
+		case c == ')':
+			p.consume(")")
+			if sep == '|' {
+				g.Kind = ChoiceParticle
+			}
+			g.Occurs = p.occurs()
+			return g, nil
+		case (c == ',' || c == '|') && (sep == 0 || sep == c):
+			p.consume(string(c))
+			sep = c
+		default:
+			return nil, p.errorf("bad content model")
+		}
+	}
+}
+
+// cp reads a content particle.
+func (p *parser) cp() (*Particle, error) {
+	if _, err := p.space(); err != nil {
+		return nil, err
+	}
+	if p.consume("(") {
+		return p.group()
+	}
+	name := p.name()
+	if name == "" {
+		return nil, p.errorf("bad content model")
+	}
+	return &Particle{Kind: NameParticle, Name: name, Occurs: p.occurs()}, nil
+}
+
+func (p *parser) occurs() byte {
+	switch c := p.peek(); c {
+	case '?', '*', '+':
+		p.top().pos++
+		return c
+	}
+	return 0
+}
+
+var attTypes = map[string]bool{
+	"CDATA": true, "ID": true, "IDREF": true, "IDREFS": true, "ENTITY": true,
+	"ENTITIES": true, "NMTOKEN": true, "NMTOKENS": true, "NOTATION": true,
+}
+
+func (p *parser) attlistDecl() error {
+	if err := p.needSpace("after <!ATTLIST"); err != nil {
+		return err
+	}
+	elem, err := p.needName("element name")
+	if err != nil {
+		return err
+	}
+	for {
+		if _, err := p.space(); err != nil {
+			return err
+		}
+		if p.consume(">") {
+			return nil
+		}
+		a := &AttDecl{Element: elem}
+		if a.Name, err = p.needName("attribute name"); err != nil {
+			return err
+		}
+		if err := p.needSpace("after the attribute name " + a.Name); err != nil {
+			return err
+		}
+		if !p.consume("(") {
+			a.Type = p.name()
+			if !attTypes[a.Type] {
+				return p.errorf("bad type for attribute %s of %s", a.Name, elem)
+			}
+			if a.Type == "NOTATION" {
+				if err := p.needSpace("after NOTATION"); err != nil {
+					return err
+				}
+				if !p.consume("(") {
+					return p.errorf("missing ( after NOTATION")
+				}
+			}
+		}
+		if a.Type == "" || a.Type == "NOTATION" {
+			if a.Values, err = p.enumeration(a.Type == "NOTATION"); err != nil {
+				return err
+			}
+		}
+		if err := p.needSpace("after the type of attribute " + a.Name); err != nil {
+			return err
+		}
+		switch {
+		case p.consume("#REQUIRED"):
+			a.Default = DefaultRequired
+		case p.consume("#IMPLIED"):
+			a.Default = DefaultImplied
+		default:
+			a.Default = DefaultValue
+			if p.consume("#FIXED") {
+				a.Default = DefaultFixed
+				if err := p.needSpace("after #FIXED"); err != nil {
+					return err
+				}
+			}
+			value, err := p.literal("default value")
+			if err != nil {
+				return err
+			}
+			a.Value = p.attrValue(value, a.Type)
+		}
+		// The first declaration of an attribute is binding.
+		exists := false
+		for _, b := range p.d.Attlists[elem] {
+			if b.Name == a.Name {
+				exists = true
+			}
+		}
+		if !exists {
+			p.d.Attlists[elem] = append(p.d.Attlists[elem], a)
+		}
+	}
+}
+
+// enumeration reads the rest of a list of values, after the (.
+func (p *parser) enumeration(names bool) ([]string, error) {
+	var values []string
+	for {
+		if _, err := p.space(); err != nil {
+			return nil, err
+		}
+		var v string
+		if names {
+			v = p.name()
+		} else {
+			v = p.nmtoken()
+		}
+		if v == "" {
+			return nil, p.errorf("bad enumerated type")
+		}
+		values = append(values, v)
+		if _, err := p.space(); err != nil {
+			return nil, err
+		}
+		if p.consume(")") {
+			return values, nil
+		}
+		if !p.consume("|") {
+			return nil, p.errorf("expected | or ) in enumerated type")
+		}
+	}
+}
+
+// attrValue normalizes an attribute value, replacing references.
+func (p *parser) attrValue(s, typ string) string {
+	s = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(replaceCharRefs(s))
+	s = p.d.expand(s, make(map[string]bool))
+	if typ != "CDATA" {
+		s = strings.Join(strings.Fields(s), " ")
+	}
+	return s
+}
+
+// charRef matches a character reference.
+var charRef = regexp.MustCompile(`&#(?:[0-9]+|x[0-9a-fA-F]+);`)
+
+func replaceCharRefs(s string) string {
+	return charRef.ReplaceAllStringFunc(s, func(ref string) string {
+		var n uint64
+		var err error
+		if ref[2] == 'x' {
+			n, err = strconv.ParseUint(ref[3:len(ref)-1], 16, 32)
+		} else {
+			n, err = strconv.ParseUint(ref[2:len(ref)-1], 10, 32)
+		}
+		if err != nil || !utf8.ValidRune(rune(n)) {
+			return ref
+		}
+		return string(rune(n))
+	})
+}
+
+func (p *parser) entityDecl() error {
+	if err := p.needSpace("after <!ENTITY"); err != nil {
+		return err
+	}
+	param := false
+	if p.consume("%") {
+		param = true
+		if err := p.needSpace("after %"); err != nil {
+			return err
+		}
+	}
+	name, err := p.needName("entity name")
+	if err != nil {
+		return err
+	}
+	e := &Entity{Name: name}
+	if err := p.needSpace("after the entity name " + name); err != nil {
+		return err
+	}
+	if c := p.peek(); c == '"' || c == '\'' {
+		value, err := p.literal("entity value")
+		if err != nil {
+			return err
+		}
+		if e.Value, err = p.entityValue(value); err != nil {
+			return err
+		}
+	} else {
+		if e.PublicID, e.SystemID, err = p.externalID(false); err != nil {
+			return err
+		}
+		if e.SystemID == "" {
+			return p.errorf("missing value of entity %s", name)
+		}
+		if !param {
+			if _, err := p.space(); err != nil {
+				return err
+			}
+			if p.consume("NDATA") {
+				if e.Notation, err = p.needName("notation name"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := p.end("the declaration of entity " + name); err != nil {
+		return err
+	}
+	// The first declaration of an entity is binding.
+	m := p.d.Entities
+	if param {
+		m = p.d.ParamEntities
+	}
+	if _, ok := m[name]; !ok {
+		m[name] = e
+	}
+	return nil
+}
+
+// entityValue forms the replacement text of an entity, replacing
+// character and parameter entity references.
+func (p *parser) entityValue(s string) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '%')
+		if i < 0 {
+			break
+		}
+		end := strings.IndexByte(s[i:], ';')
+		if end < 0 || i+1 >= len(s) || !isNameStart(s[i+1]) {
+			return "", p.errorf("bad parameter entity reference in entity value")
+		}
+		name := s[i+1 : i+end]
+		e, ok := p.d.ParamEntities[name]
+		if !ok {
+			return "", p.errorf("undeclared parameter entity %%%s;", name)
+		}
+		text, err := p.entityText(e)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(s[:i])
+		b.WriteString(text)
+		s = s[i+end+1:]
+	}
+	b.WriteString(s)
+	return replaceCharRefs(b.String()), nil
+}
+
+func (p *parser) notationDecl() error {
+	if err := p.needSpace("after <!NOTATION"); err != nil {
+		return err
+	}
+	name, err := p.needName("notation name")
+	if err != nil {
+		return err
+	}
+	n := &Notation{Name: name}
+	if n.PublicID, n.SystemID, err = p.externalID(true); err != nil {
+		return err
+	}
+	if n.PublicID == "" && n.SystemID == "" {
+		return p.errorf("missing identifier of notation %s", name)
+	}
+	if err := p.end("the declaration of notation " + name); err != nil {
+		return err
+	}
+	if _, ok := p.d.Notations[name]; ok {
+		return p.errorf("notation %s declared twice", name)
+	}
+	p.d.Notations[name] = n
+	return nil
+}
+
+// finish checks the references between declarations, and compiles the
+// content models.
+func (p *parser) finish() error {
+	for _, e := range p.d.Entities {
+		if e.Notation != "" && p.d.Notations[e.Notation] == nil {
+			return fmt.Errorf("dtd: undeclared notation %s in entity %s", e.Notation, e.Name)
+		}
+	}
+	for elem, attrs := range p.d.Attlists {
+		ids := 0
+		for _, a := range attrs {
+			switch a.Type {
+			case "ID":
+				if ids++; ids > 1 {
+					return fmt.Errorf("dtd: element %s has more than one ID attribute", elem)
+				}
+			case "NOTATION":
+				for _, v := range a.Values {
+					if p.d.Notations[v] == nil {
+						return fmt.Errorf("dtd: undeclared notation %s in attribute %s of %s", v, a.Name, elem)
+					}
+				}
+			}
+		}
+	}
+	for _, decl := range p.d.Elements {
+		var expr string
+		switch {
+		case decl.Content == ElementContent:
+			expr = pattern(decl.Model)
+		case decl.Content == MixedContent && decl.Model != nil:
+			expr = pattern(decl.Model)
+		case decl.Content == MixedContent:
+			expr = ""
+		default:
+			continue
+		}
+		re, err := regexp.Compile("^" + expr + "$")
+		if err != nil {
+			return fmt.Errorf("dtd: content model of %s: %v", decl.Name, err)
+		}
+		decl.re = re
+	}
+	return nil
+}
+
+// pattern returns a regular expression matching the names of a list of
+// children, each written as <name>.
+func pattern(p *Particle) string {
+	var s string
+	switch p.Kind {
+	case NameParticle:
+		s = "<" + regexp.QuoteMeta(p.Name) + ">"
+	default:
+		sep := ""
+		if p.Kind == ChoiceParticle {
+			sep = "|"
+		}
+		items := make([]string, len(p.Items))
+		for i, item := range p.Items {
+			items[i] = pattern(item)
+		}
+		s = "(?:" + strings.Join(items, sep) + ")"
+	}
+	if p.Occurs != 0 {
+		s = "(?:" + s + ")" + string(p.Occurs)
+	}
+	return s
+}
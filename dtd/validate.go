@@ -0,0 +1,284 @@
+package dtd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pschou/go-xmltree"
+	"github.com/pschou/go-xmltree/internal/validation"
+)
+
+// An Error describes an element which is not valid.
+type Error = validation.Error
+
+// Errors is the list of errors returned by Validate, in document order.
+type Errors = validation.Errors
+
+// Validate checks that root is valid according to the DTD: that it is
+// the element named by the DOCTYPE declaration, that every element and
+// attribute is declared, that the children of each element match its
+// content model, and that attribute values match their types. ID values
+// must be unique, and IDREF values must refer to them.
+//
+// If root is not valid, the error returned is of type Errors, listing
+// every problem found.
+func (d *DTD) Validate(root *xmltree.Element) error {
+	v := validator{d: d, ids: make(map[string]bool)}
+	name := qname(root)
+	path := "/" + name
+	if d.Name != "" && name != d.Name {
+		v.errorf(root, path, "root element is %s, not %s", name, d.Name)
+	}
+	v.element(root, path)
+	for _, ref := range v.idrefs {
+		if !v.ids[ref.id] {
+			v.errorf(ref.el, ref.path, "no element has the ID %q", ref.id)
+		}
+	}
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+// qname returns the name of an element or attribute as written.
+func qname(el *xmltree.Element) string {
+	return attrName(el, el.Name)
+}
+
+func attrName(el *xmltree.Element, name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	if q := el.Prefix(name); q != "" {
+		return q
+	}
+	// An undeclared prefix.
+	return name.Space + ":" + name.Local
+}
+
+type validator struct {
+	d      *DTD
+	errs   Errors
+	ids    map[string]bool
+	idrefs []idref
+}
+
+// An idref is a reference to an ID, checked once the whole document has
+// been seen.
+type idref struct {
+	id   string
+	el   *xmltree.Element
+	path string
+}
+
+func (v *validator) errorf(el *xmltree.Element, path, format string, args ...interface{}) {
+	v.errs = append(v.errs, &Error{Path: path, Element: el, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) element(el *xmltree.Element, path string) {
+	name := qname(el)
+	v.attributes(el, name, path)
+	if decl, ok := v.d.Elements[name]; ok {
+		v.content(el, decl, path)
+	} else {
+		v.errorf(el, path, "element %s is not declared", name)
+	}
+
+	count := make(map[string]int)
+	for i := range el.Children {
+		child := &el.Children[i]
+		if child.Type != xmltree.XML_Tag {
+			continue
+		}
+		name := qname(child)
+		count[name]++
+		v.element(child, path+"/"+name+"["+strconv.Itoa(count[name])+"]")
+	}
+}
+
+func (v *validator) content(el *xmltree.Element, decl *ElementDecl, path string) {
+	switch decl.Content {
+	case EmptyContent:
+		if len(el.Children) > 0 || el.Content != "" {
+			v.errorf(el, path, "element declared EMPTY has content")
+		}
+		return
+	case AnyContent:
+		return
+	}
+	var names, children []string
+	text := strings.TrimSpace(el.Content) != ""
+	for i := range el.Children {
+		child := &el.Children[i]
+		switch child.Type {
+		case xmltree.XML_Tag:
+			name := qname(child)
+			names = append(names, "<"+name+">")
+			children = append(children, name)
+		case xmltree.XML_CharData, xmltree.XML_CDATA:
+			if strings.TrimSpace(child.Content) != "" {
+				text = true
+			}
+		}
+	}
+	if decl.Content == ElementContent && text {
+		v.errorf(el, path, "text is not allowed in element content")
+	}
+	if decl.re.MatchString(strings.Join(names, "")) {
+		return
+	}
+	if decl.Content == MixedContent {
+		allowed := make(map[string]bool)
+		if decl.Model != nil {
+			for _, item := range decl.Model.Items {
+				allowed[item.Name] = true
+			}
+		}
+		for _, name := range children {
+			if !allowed[name] {
+				v.errorf(el, path, "element %s is not allowed in mixed content", name)
+				return
+			}
+		}
+	}
+	v.errorf(el, path, "content (%s) does not match %s", strings.Join(children, ","), decl.Model)
+}
+
+func (v *validator) attributes(el *xmltree.Element, name, path string) {
+	decls := v.d.Attlists[name]
+	seen := make(map[string]bool)
+	for _, a := range el.StartElement.Attr {
+		aname := attrName(el, a.Name)
+		apath := path + "/@" + aname
+		var decl *AttDecl
+		for _, d := range decls {
+			if d.Name == aname {
+				decl = d
+				break
+			}
+		}
+		if decl == nil {
+			v.errorf(el, apath, "attribute is not declared")
+			continue
+		}
+		seen[aname] = true
+		v.attrValue(el, decl, a.Value, apath)
+	}
+	for _, d := range decls {
+		if d.Default == DefaultRequired && !seen[d.Name] {
+			v.errorf(el, path, "missing required attribute %s", d.Name)
+		}
+	}
+}
+
+func (v *validator) attrValue(el *xmltree.Element, decl *AttDecl, value, path string) {
+	if decl.Type != "CDATA" {
+		value = strings.Join(strings.Fields(value), " ")
+	}
+	if decl.Default == DefaultFixed && value != decl.Value {
+		v.errorf(el, path, "value %q does not match the fixed value %q", value, decl.Value)
+		return
+	}
+	tokens := strings.Fields(value)
+	switch decl.Type {
+	case "ID", "IDREF", "ENTITY", "NMTOKEN":
+		if len(tokens) != 1 {
+			v.errorf(el, path, "value %q is not a single %s", value, decl.Type)
+			return
+		}
+	case "IDREFS", "ENTITIES", "NMTOKENS":
+		if len(tokens) == 0 {
+			v.errorf(el, path, "value of type %s is empty", decl.Type)
+			return
+		}
+	}
+	switch decl.Type {
+	case "ID":
+		if !isName(value) {
+			v.errorf(el, path, "value %q is not a name", value)
+		} else if v.ids[value] {
+			v.errorf(el, path, "duplicate ID %q", value)
+		}
+		v.ids[value] = true
+	case "IDREF", "IDREFS":
+		for _, id := range tokens {
+			if !isName(id) {
+				v.errorf(el, path, "value %q is not a name", id)
+				continue
+			}
+			v.idrefs = append(v.idrefs, idref{id, el, path})
+		}
+	case "ENTITY", "ENTITIES":
+		for _, name := range tokens {
+			if e, ok := v.d.Entities[name]; !ok || e.Notation == "" {
+				v.errorf(el, path, "%s is not an unparsed entity", name)
+			}
+		}
+	case "NMTOKEN", "NMTOKENS":
+		for _, tok := range tokens {
+			if !isNmtoken(tok) {
+				v.errorf(el, path, "value %q is not a name token", tok)
+			}
+		}
+	case "", "NOTATION":
+		for _, allowed := range decl.Values {
+			if value == allowed {
+				return
+			}
+		}
+		v.errorf(el, path, "value %q is not one of (%s)", value, strings.Join(decl.Values, "|"))
+	}
+}
+
+func isName(s string) bool {
+	return s != "" && isNameStart(s[0]) && isNmtoken(s)
+}
+
+func isNmtoken(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isNameChar(s[i]) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// AddDefaults adds the attributes with default or fixed values in the
+// DTD to each element of root which does not specify them. Defaults for
+// namespace declarations are not added.
+func (d *DTD) AddDefaults(root *xmltree.Element) {
+	add := func(el *xmltree.Element) error {
+		for _, decl := range d.Attlists[qname(el)] {
+			if decl.Default != DefaultValue && decl.Default != DefaultFixed {
+				continue
+			}
+			if decl.Name == "xmlns" || strings.HasPrefix(decl.Name, "xmlns:") {
+				continue
+			}
+			name := xml.Name{Local: decl.Name}
+			if i := strings.IndexByte(decl.Name, ':'); i >= 0 {
+				if resolved, ok := el.ResolveNS(decl.Name); ok {
+					name = resolved
+				} else {
+					name = xml.Name{Space: decl.Name[:i], Local: decl.Name[i+1:]}
+				}
+			}
+			found := false
+			for _, a := range el.StartElement.Attr {
+				if attrName(el, a.Name) == decl.Name {
+					found = true
+					break
+				}
+			}
+			if !found {
+				el.StartElement.Attr = append(el.StartElement.Attr, xml.Attr{Name: name, Value: decl.Value})
+			}
+		}
+		return nil
+	}
+	add(root)
+	root.WalkFunc(add)
+}
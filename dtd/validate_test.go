@@ -0,0 +1,169 @@
+package dtd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-xmltree"
+)
+
+const catalog = `<?xml version="1.0"?>
+<!DOCTYPE catalog [
+  <!ELEMENT catalog (product+, related*)>
+  <!ELEMENT product (name, price?, (image | note)*)>
+  <!ELEMENT name (#PCDATA)>
+  <!ELEMENT price (#PCDATA)>
+  <!ELEMENT note (#PCDATA | b)*>
+  <!ELEMENT b (#PCDATA)>
+  <!ELEMENT image EMPTY>
+  <!ELEMENT related EMPTY>
+  <!ATTLIST catalog version CDATA #FIXED "2">
+  <!ATTLIST product
+      id       ID            #REQUIRED
+      currency (USD|EUR)     "USD"
+      tags     NMTOKENS      #IMPLIED
+      xlink:type CDATA       "simple">
+  <!ATTLIST image src ENTITY #REQUIRED format NOTATION (png) "png">
+  <!ATTLIST related refs IDREFS #REQUIRED>
+  <!NOTATION png SYSTEM "image/png">
+  <!ENTITY photo SYSTEM "photo.png" NDATA png>
+  <!ENTITY co "ACME">
+  <!ENTITY brand "&co; Widgets">
+]>
+`
+
+func TestParseDocument(t *testing.T) {
+	root, d, err := ParseDocument(strings.NewReader(catalog+`<catalog xmlns:xlink="http://www.w3.org/1999/xlink">
+  <product id="p1" tags=" a  b "><name>&brand;</name><image src="photo"/></product>
+</catalog>`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d == nil || d.Name != "catalog" {
+		t.Fatalf("got DTD %+v", d)
+	}
+	product := &root.Children[0]
+	if got := product.Children[0].Content; got != "ACME Widgets" {
+		t.Errorf("got name %q", got)
+	}
+	if got := root.Attr("", "version"); got != "2" {
+		t.Errorf("got version %q", got)
+	}
+	if got := product.Attr("", "currency"); got != "USD" {
+		t.Errorf("got currency %q", got)
+	}
+	if got := product.Attr("http://www.w3.org/1999/xlink", "type"); got != "simple" {
+		t.Errorf("got xlink:type %q", got)
+	}
+	if got := product.Children[1].Attr("", "format"); got != "png" {
+		t.Errorf("got format %q", got)
+	}
+	if err := d.Validate(root); err != nil {
+		t.Error(err)
+	}
+
+	root, d, err = ParseDocument(strings.NewReader(`<a>&amp;</a>`), nil)
+	if err != nil || d != nil || root.Content != "&" {
+		t.Errorf("got %v, %v, %v", root, d, err)
+	}
+	if _, _, err := ParseDocument(strings.NewReader(catalog+`<catalog>&unknown;</catalog>`), nil); err == nil {
+		t.Error("undeclared entity accepted")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	_, d, err := ParseDocument(strings.NewReader(catalog+`<catalog/>`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		doc string
+		err string
+	}{
+		{doc: `<catalog><product id="a"><name/><price>1</price><note>x <b>y</b></note><image src="photo"/></product></catalog>`},
+		{doc: `<catalog version="2"><product id="a"><name/></product><product id="b"><name/></product><related refs="a b"/></catalog>`},
+		{
+			doc: `<list/>`,
+			err: "/list: root element is list, not catalog",
+		},
+		{
+			doc: `<catalog/>`,
+			err: "/catalog: content () does not match (product+,related*)",
+		},
+		{
+			doc: `<catalog><product id="a"><price/><name/></product></catalog>`,
+			err: "/catalog/product[1]: content (price,name) does not match (name,price?,(image|note)*)",
+		},
+		{
+			doc: `<catalog><product id="a"><name/>text</product></catalog>`,
+			err: "/catalog/product[1]: text is not allowed in element content",
+		},
+		{
+			doc: `<catalog><product id="a"><name/><note><i/></note></product></catalog>`,
+			err: "/catalog/product[1]/note[1]: element i is not allowed in mixed content",
+		},
+		{
+			doc: `<catalog><product id="a"><name><b/></name></product></catalog>`,
+			err: "/catalog/product[1]/name[1]: element b is not allowed in mixed content",
+		},
+		{
+			doc: `<catalog><product id="a"><name/><image src="photo">x</image></product></catalog>`,
+			err: "/catalog/product[1]/image[1]: element declared EMPTY has content",
+		},
+		{
+			doc: `<catalog><product><name/></product></catalog>`,
+			err: "/catalog/product[1]: missing required attribute id",
+		},
+		{
+			doc: `<catalog><product id="a" size="2"><name/></product></catalog>`,
+			err: "/catalog/product[1]/@size: attribute is not declared",
+		},
+		{
+			doc: `<catalog version="3"><product id="a"><name/></product></catalog>`,
+			err: `/catalog/@version: value "3" does not match the fixed value "2"`,
+		},
+		{
+			doc: `<catalog><product id="a" currency="GBP"><name/></product></catalog>`,
+			err: `/catalog/product[1]/@currency: value "GBP" is not one of (USD|EUR)`,
+		},
+		{
+			doc: `<catalog><product id="a" tags="a,b"><name/></product></catalog>`,
+			err: `/catalog/product[1]/@tags: value "a,b" is not a name token`,
+		},
+		{
+			doc: `<catalog><product id="1"><name/></product></catalog>`,
+			err: `/catalog/product[1]/@id: value "1" is not a name`,
+		},
+		{
+			doc: `<catalog><product id="a"><name/></product><product id="a"><name/></product></catalog>`,
+			err: `/catalog/product[2]/@id: duplicate ID "a"`,
+		},
+		{
+			doc: `<catalog><product id="a"><name/></product><related refs="a c"/></catalog>`,
+			err: `/catalog/related[1]/@refs: no element has the ID "c"`,
+		},
+		{
+			doc: `<catalog><product id="a"><name/><image src="co"/></product></catalog>`,
+			err: `/catalog/product[1]/image[1]/@src: co is not an unparsed entity`,
+		},
+		{
+			doc: `<catalog><product id="a"><name/><video/></product></catalog>`,
+			err: `/catalog/product[1]: content (name,video) does not match`,
+		},
+	}
+	for _, tt := range tests {
+		root, err := xmltree.Parse(strings.NewReader(tt.doc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = d.Validate(root)
+		switch {
+		case err == nil && tt.err != "":
+			t.Errorf("%s: valid, want error %q", tt.doc, tt.err)
+		case err != nil && tt.err == "":
+			t.Errorf("%s: %v", tt.doc, err)
+		case err != nil && !strings.HasPrefix(err.(Errors)[0].Error(), tt.err):
+			t.Errorf("%s: got %q, want %q", tt.doc, err.(Errors)[0], tt.err)
+		}
+	}
+}
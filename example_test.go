@@ -10,7 +10,7 @@ import (
 	"github.com/pschou/go-xmltree"
 )
 
-func ExampleElement_Search() {
+func ExampleElement_Find() {
 	data := `
 	  <Staff>
         <Person>
@@ -227,7 +227,7 @@ func ExampleMarshal() {
 	// </toc>
 }
 
-func ExampleMarshalNested() {
+func ExampleMarshalIndent() {
 	var input = []byte(`<?xml version="1.0" encoding="UTF-8"?>
 	<toc>
 	  <level1>
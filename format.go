@@ -0,0 +1,271 @@
+package xmltree
+
+import (
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// FormatOptions controls the layout of the document written by Format.
+type FormatOptions struct {
+	// EncodeOptions controls the order of attributes, quoting and the
+	// way empty elements are written.
+	EncodeOptions
+	// Prefix begins every line.
+	Prefix string
+	// Indent is written once for each level of nesting. The default
+	// is two spaces.
+	Indent string
+	// Width is the length of line, in characters, beyond which the
+	// attributes of a start tag are wrapped. Text is never broken, as
+	// that would change it, so lines holding long text may exceed
+	// Width. Zero means no limit.
+	Width int
+	// MaxAttrs wraps the attributes of start tags with more than
+	// MaxAttrs attributes and namespace declarations. Zero means no
+	// limit.
+	MaxAttrs int
+	// IndentComments re-indents the continuation lines of comments
+	// which span several lines, to one level deeper than the comment
+	// itself. By default comments are written as they are.
+	IndentComments bool
+}
+
+// Format writes el to w as an indented document, laid out according to
+// opts. A nil opts is the same as a zero FormatOptions. Unlike
+// EncodeIndent, Format only adds white space where it cannot change the
+// meaning of the document:
+//
+//   - Elements with only child elements, comments and other markup have
+//     each child on its own line. Character data consisting only of
+//     white space between them is replaced by the indentation.
+//   - Elements with mixed content, that is character data or CDATA
+//     sections along with other children, are written inline, exactly
+//     as they are, as are elements within the scope of
+//     xml:space="preserve".
+//   - Elements with only text are written on one line.
+//
+// When wrapped, each attribute and namespace declaration of a start tag
+// is written on its own line, indented one level deeper than the tag.
+// The output ends with a line break. Format is idempotent: formatting
+// the document Format produces, once parsed, gives the same document,
+// so its output is suitable for keeping XML under version control.
+func Format(w io.Writer, el *Element, opts *FormatOptions) error {
+	if opts == nil {
+		opts = new(FormatOptions)
+	}
+	f := formatter{w: w, opts: opts, indent: opts.Indent, first: true}
+	if f.indent == "" {
+		f.indent = "  "
+	}
+	f.block(el, nil, 0)
+	f.writeString("\n")
+	return f.err
+}
+
+type formatter struct {
+	w        io.Writer
+	opts     *FormatOptions
+	indent   string
+	preserve bool // within xml:space="preserve"
+	first    bool // nothing has been written yet
+	err      error
+}
+
+func (f *formatter) writeString(s string) {
+	if f.err == nil {
+		_, f.err = io.WriteString(f.w, s)
+	}
+}
+
+// line starts a new line at the given depth.
+func (f *formatter) line(depth int) {
+	if !f.first {
+		f.writeString("\n")
+	}
+	f.first = false
+	f.writeString(f.opts.Prefix)
+	for i := 0; i < depth; i++ {
+		f.writeString(f.indent)
+	}
+}
+
+// column returns the width of the indentation at the given depth.
+func (f *formatter) column(depth int) int {
+	return utf8.RuneCountInString(f.opts.Prefix) + depth*utf8.RuneCountInString(f.indent)
+}
+
+// mixed reports whether el has text among its children, so that its
+// children cannot be placed on separate lines.
+func mixed(el *Element) bool {
+	for i := range el.Children {
+		child := &el.Children[i]
+		if child.Type == XML_CDATA || child.Type == XML_CharData && strings.TrimSpace(child.Content) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// block writes a node on its own line.
+func (f *formatter) block(el, parent *Element, depth int) {
+	if depth > recursionLimit {
+		if f.err == nil {
+			f.err = errDeepXML
+		}
+		return
+	}
+	switch el.Type {
+	case XML_CharData:
+		if strings.TrimSpace(el.Content) == "" {
+			// Replaced by indentation
+			return
+		}
+		f.line(depth)
+		f.writeString(escape(el.Content))
+	case XML_Comment:
+		f.line(depth)
+		if f.opts.IndentComments {
+			f.comment(el.Content, depth)
+		} else {
+			f.inline(el, parent, depth)
+		}
+	case XML_Tag:
+		f.line(depth)
+		outer := f.preserve
+		f.preserve = preserveSpace(el, outer)
+		if f.preserve || mixed(el) {
+			f.inline(el, parent, depth)
+			f.preserve = outer
+			return
+		}
+		empty := len(el.Children) == 0 && el.Content == ""
+		f.startTag(el, parent, depth, empty, true)
+		if empty {
+			break
+		}
+		if len(el.Children) == 0 {
+			f.writeString(escape(el.Content))
+		} else {
+			for i := range el.Children {
+				f.block(&el.Children[i], el, depth+1)
+			}
+			f.line(depth)
+		}
+		f.writeString("</" + qualify(&el.Scope, el.Name) + ">")
+		f.preserve = outer
+	default:
+		f.line(depth)
+		f.inline(el, parent, depth)
+	}
+}
+
+// inline writes a node and its descendants as they are, without
+// adding any white space.
+func (f *formatter) inline(el, parent *Element, depth int) {
+	if depth > recursionLimit {
+		if f.err == nil {
+			f.err = errDeepXML
+		}
+		return
+	}
+	switch el.Type {
+	case XML_CharData:
+		f.writeString(escape(el.Content))
+	case XML_CDATA:
+		var b strings.Builder
+		writeCDATA(&b, el.Content)
+		f.writeString(b.String())
+	case XML_Comment:
+		f.writeString("<!--" + strings.ReplaceAll(el.Content, "-->", "--&gt;") + "-->")
+	case XML_ProcInst:
+		f.writeString("<?" + el.Name.Local)
+		if el.Content != "" {
+			f.writeString(" " + el.Content)
+		}
+		f.writeString("?>")
+	case XML_Directive:
+		f.writeString("<!" + el.Content + ">")
+	case XML_Tag:
+		empty := len(el.Children) == 0 && el.Content == ""
+		f.startTag(el, parent, depth, empty, false)
+		if empty {
+			return
+		}
+		if len(el.Children) == 0 {
+			f.writeString(escape(el.Content))
+		}
+		for i := range el.Children {
+			f.inline(&el.Children[i], el, depth+1)
+		}
+		f.writeString("</" + qualify(&el.Scope, el.Name) + ">")
+	}
+}
+
+// startTag writes the start tag of el, wrapping its attributes if
+// wrap is set and the tag is too long or has too many attributes.
+func (f *formatter) startTag(el, parent *Element, depth int, empty, wrap bool) {
+	name := qualify(&el.Scope, el.Name)
+	items := f.opts.attrs(el, diffScope(parent, el).ns)
+	end := ">"
+	if empty {
+		end = f.opts.emptyTag(name)
+	}
+	tag := "<" + name
+	if wrap && len(items) > 1 {
+		long := f.opts.MaxAttrs > 0 && len(items) > f.opts.MaxAttrs
+		if !long && f.opts.Width > 0 {
+			n := f.column(depth) + utf8.RuneCountInString(tag+end)
+			for _, item := range items {
+				n += 1 + utf8.RuneCountInString(item)
+			}
+			long = n > f.opts.Width
+		}
+		if long {
+			f.writeString(tag)
+			for _, item := range items {
+				f.line(depth + 1)
+				f.writeString(item)
+			}
+			f.writeString(end)
+			return
+		}
+	}
+	for _, item := range items {
+		tag += " " + item
+	}
+	f.writeString(tag + end)
+}
+
+// comment writes a comment, indenting its continuation lines one
+// level deeper than the comment, and its last line, if blank, at the
+// level of the comment.
+func (f *formatter) comment(text string, depth int) {
+	text = strings.ReplaceAll(text, "-->", "--&gt;")
+	lines := strings.Split(text, "\n")
+	if len(lines) == 1 {
+		f.writeString("<!--" + text + "-->")
+		return
+	}
+	margin := -1
+	for _, s := range lines[1:] {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		n := len(s) - len(strings.TrimLeft(s, " \t"))
+		if margin < 0 || n < margin {
+			margin = n
+		}
+	}
+	f.writeString("<!--" + lines[0])
+	for i, s := range lines[1:] {
+		f.writeString("\n")
+		switch {
+		case strings.TrimSpace(s) != "":
+			f.writeString(f.opts.Prefix + strings.Repeat(f.indent, depth+1) + s[margin:])
+		case i == len(lines)-2:
+			f.writeString(f.opts.Prefix + strings.Repeat(f.indent, depth))
+		}
+	}
+	f.writeString("-->")
+}
@@ -0,0 +1,125 @@
+package xmltree
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		opts *FormatOptions
+		in   string
+		want string
+	}{
+		{
+			name: "element content",
+			in:   `<a><b>text</b>  <c/><!--note--><?pi x?></a>`,
+			want: `<a>
+  <b>text</b>
+  <c />
+  <!--note-->
+  <?pi x?>
+</a>
+`,
+		},
+		{
+			name: "mixed content",
+			in:   `<doc><p>Some <b>bold</b> and <i>italic <u>text</u></i>.</p><p><![CDATA[x < y]]></p></doc>`,
+			want: `<doc>
+  <p>Some <b>bold</b> and <i>italic <u>text</u></i>.</p>
+  <p><![CDATA[x < y]]></p>
+</doc>
+`,
+		},
+		{
+			name: "preserve",
+			opts: &FormatOptions{Indent: "\t"},
+			in:   `<a><pre xml:space="preserve"> <b/>  <c/> </pre><d><e/></d></a>`,
+			want: "<a>\n\t<pre xml:space=\"preserve\"> <b />  <c /> </pre>\n\t<d>\n\t\t<e />\n\t</d>\n</a>\n",
+		},
+		{
+			name: "max attrs",
+			opts: &FormatOptions{MaxAttrs: 2},
+			in:   `<a xmlns="urn:a"><b x="1" y="2"/><c x="1" y="2" z="3">text</c></a>`,
+			want: `<a xmlns="urn:a">
+  <b x="1" y="2" />
+  <c
+    x="1"
+    y="2"
+    z="3">text</c>
+</a>
+`,
+		},
+		{
+			name: "encode options",
+			opts: &FormatOptions{EncodeOptions: EncodeOptions{AttrOrder: AttrsNSFirst, SelfClose: SelfCloseNever}},
+			in:   `<a z="1" y="2" xmlns="urn:a"><b/></a>`,
+			want: `<a xmlns="urn:a" y="2" z="1">
+  <b></b>
+</a>
+`,
+		},
+		{
+			name: "width",
+			opts: &FormatOptions{Width: 30},
+			in:   `<a><b id="first" class="second"/><c id="1" n="2"/></a>`,
+			want: `<a>
+  <b
+    id="first"
+    class="second" />
+  <c id="1" n="2" />
+</a>
+`,
+		},
+		{
+			name: "comments",
+			opts: &FormatOptions{IndentComments: true},
+			in: `<a><b><!-- first
+        second
+
+          third
+      --></b></a>`,
+			want: `<a>
+  <b>
+    <!-- first
+      second
+
+        third
+    -->
+  </b>
+</a>
+`,
+		},
+	}
+	for _, tt := range tests {
+		root, err := ParseWithOptions(strings.NewReader(tt.in), &ParseOptions{Whitespace: WhitespacePreserve})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var buf bytes.Buffer
+		if err := Format(&buf, root, tt.opts); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, buf.String(), tt.want)
+		}
+
+		// Formatting the output again must not change it.
+		again, err := ParseWithOptions(bytes.NewReader(buf.Bytes()), &ParseOptions{Whitespace: WhitespacePreserve})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var buf2 bytes.Buffer
+		if err := Format(&buf2, again, tt.opts); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if buf2.String() != buf.String() {
+			t.Errorf("%s: not idempotent, got\n%s\nthen\n%s", tt.name, buf.String(), buf2.String())
+		}
+		if !EqualWithOptions(root, again, &EqualOptions{Ordered: true}) {
+			t.Errorf("%s: output does not match input:\n%s", tt.name, buf.String())
+		}
+	}
+}
@@ -2,6 +2,11 @@ module github.com/pschou/go-xmltree
 
 go 1.18
 
-require golang.org/x/net v0.8.0
+require (
+	golang.org/x/net v0.8.0
+	// Only the yamlconv package and the xmltree command import
+	// yaml.v3; the xmltree package itself does not.
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require golang.org/x/text v0.8.0 // indirect
@@ -0,0 +1,116 @@
+package xmltree
+
+import (
+	"bytes"
+	"hash"
+	"sort"
+)
+
+// Hash returns a digest of el and its descendants, computed with h.
+// The digest is consistent with EqualWithOptions: elements which compare
+// equal under opts have the same digest, so in particular namespace
+// prefixes are ignored unless opts.Prefixes is set, and the order of
+// children is ignored unless opts.Ordered is set. A nil opts is the same
+// as a zero EqualOptions. The digest is a Merkle tree: each node is
+// hashed from its own name, attributes and text and the digests of its
+// children. h is reset before use.
+//
+// To hash many elements of the same document, use a Hasher, which
+// reuses the digests of subtrees it has already seen.
+func (el *Element) Hash(h hash.Hash, opts *EqualOptions) []byte {
+	return NewHasher(h, opts).Sum(el)
+}
+
+// A Hasher computes the digests returned by Element.Hash, caching the
+// digest of every node it visits. The cache is keyed by the address of
+// each Element, so Reset must be called if a hashed tree is modified.
+type Hasher struct {
+	h    hash.Hash
+	c    comparer
+	sums map[*Element][]byte
+}
+
+// NewHasher returns a Hasher computing digests with h, consistent with
+// EqualWithOptions given opts.
+func NewHasher(h hash.Hash, opts *EqualOptions) *Hasher {
+	if opts == nil {
+		opts = new(EqualOptions)
+	}
+	return &Hasher{h: h, c: comparer{opts: opts}, sums: make(map[*Element][]byte)}
+}
+
+// Reset discards the cached digests.
+func (hs *Hasher) Reset() {
+	hs.sums = make(map[*Element][]byte)
+}
+
+// Sum returns the digest of el and its descendants.
+func (hs *Hasher) Sum(el *Element) []byte {
+	return hs.sum(el, 0)
+}
+
+func (hs *Hasher) sum(el *Element, depth int) []byte {
+	if s, ok := hs.sums[el]; ok {
+		return s
+	}
+	var sums [][]byte
+	if depth < maxCompareDepth {
+		for _, child := range hs.c.children(el) {
+			sums = append(sums, hs.sum(child, depth+1))
+		}
+		if !hs.c.opts.Ordered {
+			sort.Slice(sums, func(i, j int) bool {
+				return bytes.Compare(sums[i], sums[j]) < 0
+			})
+		}
+	}
+	hs.h.Reset()
+	hs.c.writeNode(hs.h, el)
+	for _, s := range sums {
+		hs.h.Write(s)
+	}
+	s := hs.h.Sum(nil)
+	hs.sums[el] = s
+	return s
+}
+
+// Duplicates returns the groups of equal elements found in the tree
+// rooted at root, as compared by EqualWithOptions with opts. Each group
+// lists its elements in document order, and the groups are ordered by
+// their first element. Only the outermost duplicates are reported: the
+// descendants of a duplicated element are not searched further. Digests
+// are computed with h, but elements are only grouped if they are equal,
+// so collisions in h do not produce false duplicates.
+func Duplicates(root *Element, h hash.Hash, opts *EqualOptions) [][]*Element {
+	hs := NewHasher(h, opts)
+	count := make(map[string]int)
+	for _, el := range root.Flatten() {
+		count[string(hs.Sum(el))]++
+	}
+
+	var groups [][]*Element
+	byDigest := make(map[string][]int) // indices into groups
+	root.WalkDepthFunc(func(el *Element) bool {
+		digest := string(hs.Sum(el))
+		if count[digest] < 2 {
+			return true
+		}
+		for _, g := range byDigest[digest] {
+			if EqualWithOptions(groups[g][0], el, hs.c.opts) {
+				groups[g] = append(groups[g], el)
+				return false
+			}
+		}
+		byDigest[digest] = append(byDigest[digest], len(groups))
+		groups = append(groups, []*Element{el})
+		return false
+	})
+
+	dups := groups[:0]
+	for _, g := range groups {
+		if len(g) > 1 {
+			dups = append(dups, g)
+		}
+	}
+	return dups
+}
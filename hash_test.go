@@ -0,0 +1,64 @@
+package xmltree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"hash/fnv"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		opts EqualOptions
+	}{
+		{`<a xmlns:p="urn:x"><p:b/></a>`, `<a xmlns:q="urn:x"><q:b/></a>`, EqualOptions{}},
+		{`<a xmlns:p="urn:x"><p:b/></a>`, `<a xmlns:q="urn:x"><q:b/></a>`, EqualOptions{Prefixes: true}},
+		{`<list><b>1</b><a/></list>`, `<list><a/><b>1</b></list>`, EqualOptions{}},
+		{`<list><b>1</b><a/></list>`, `<list><a/><b>1</b></list>`, EqualOptions{Ordered: true}},
+		{`<a>  one   two </a>`, `<a>one two</a>`, EqualOptions{}},
+		{`<a>  one   two </a>`, `<a>one two</a>`, EqualOptions{Whitespace: true}},
+		{`<a>x<!-- note -->y</a>`, `<a>xy</a>`, EqualOptions{}},
+		{`<a>x<!-- note -->y</a>`, `<a>xy</a>`, EqualOptions{IgnoreComments: true}},
+		{`<a x="1" y="2"/>`, `<a y="2" x="1"/>`, EqualOptions{}},
+		{`<a x="1"/>`, `<a x="2"/>`, EqualOptions{}},
+	} {
+		a := parseFullDoc(t, []byte(tt.a))
+		b := parseFullDoc(t, []byte(tt.b))
+		equal := EqualWithOptions(a, b, &tt.opts)
+		same := bytes.Equal(a.Hash(sha256.New(), &tt.opts), b.Hash(sha256.New(), &tt.opts))
+		if equal != same {
+			t.Errorf("%s, %s, %+v: equal is %t, but equal digests is %t", tt.a, tt.b, tt.opts, equal, same)
+		}
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	doc := parseFullDoc(t, []byte(`<oval_definitions xmlns="http://oval.mitre.org/XMLSchema/oval-definitions-5">
+	  <tests>
+	    <test id="tst:1"><object object_ref="obj:1"/></test>
+	    <test id="tst:2"><object object_ref="obj:2"/></test>
+	  </tests>
+	  <objects>
+	    <object id="obj:1"><path>/etc</path><filename>passwd</filename></object>
+	    <object id="obj:2"><path>/etc</path><filename>shadow</filename></object>
+	    <object id="obj:3"><filename>passwd</filename><path>/etc</path></object>
+	  </objects>
+	</oval_definitions>`))
+	groups := Duplicates(doc, fnv.New32a(), &EqualOptions{IgnoreAttrs: []xml.Name{{Local: "id"}}})
+	var got []string
+	for _, g := range groups {
+		var ids []string
+		for _, el := range g {
+			ids = append(ids, el.Attr("", "id"))
+		}
+		got = append(got, strings.Join(ids, ","))
+	}
+	want := []string{"obj:1,obj:3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got duplicates %q, wanted %q", got, want)
+	}
+}
@@ -0,0 +1,303 @@
+package xmltree
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	xhtmlNS = "http://www.w3.org/1999/xhtml"
+	svgNS   = "http://www.w3.org/2000/svg"
+	mathNS  = "http://www.w3.org/1998/Math/MathML"
+	xlinkNS = "http://www.w3.org/1999/xlink"
+)
+
+var errNoHTML = errors.New("xmltree: no html element in document")
+
+// ParseHTML builds a tree of Elements by reading an HTML document. The
+// document is parsed as a web browser would, following the HTML5
+// parsing algorithm, so it need not be well-formed XML. The root of the
+// tree is the html element. HTML elements are placed in the XHTML
+// namespace, and embedded SVG and MathML in their own namespaces, so
+// the tree can be searched and written like any other XML document.
+//
+// White space in character data is handled as by Parse, except within
+// pre, textarea, script and style elements, where it is preserved.
+// Attributes whose names are not valid in XML are dropped, and the
+// document type declaration is not kept.
+func ParseHTML(r io.Reader) (*Element, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	var root *html.Node
+	for n := doc.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type == html.ElementNode {
+			root = n
+			break
+		}
+	}
+	if root == nil {
+		return nil, errNoHTML
+	}
+	var el Element
+	if err := el.fromHTML(root, Scope{}, WhitespaceTrim, 0); err != nil {
+		return nil, err
+	}
+	return &el, nil
+}
+
+// fromHTML fills in el from an HTML element node.
+func (el *Element) fromHTML(n *html.Node, parent Scope, space Whitespace, depth int) error {
+	if depth > recursionLimit {
+		return errDeepXML
+	}
+	ns := xhtmlNS
+	switch n.Namespace {
+	case "svg":
+		ns = svgNS
+	case "math":
+		ns = mathNS
+	}
+	*el = Element{Type: XML_Tag, Scope: parent}
+	el.Name = xml.Name{Space: ns, Local: n.Data}
+	if uri, ok := el.Scope.lookupPrefix(""); !ok || uri != ns {
+		el.declareNS(xml.Name{Space: ns})
+	}
+	for _, a := range n.Attr {
+		var name xml.Name
+		switch a.Namespace {
+		case "":
+			if !isNCName(a.Key) {
+				continue
+			}
+			name.Local = a.Key
+		case "xlink":
+			name = xml.Name{Space: xlinkNS, Local: a.Key}
+			if uri, ok := el.Scope.lookupPrefix("xlink"); !ok || uri != xlinkNS {
+				el.declareNS(xml.Name{Space: xlinkNS, Local: "xlink"})
+			}
+		case "xml":
+			name = xml.Name{Space: xmlLangURI, Local: a.Key}
+		default:
+			// Namespace declarations are made as needed.
+			continue
+		}
+		el.StartElement.Attr = append(el.StartElement.Attr, xml.Attr{Name: name, Value: a.Val})
+	}
+
+	switch n.DataAtom {
+	case atom.Pre, atom.Textarea, atom.Script, atom.Style:
+		space = WhitespacePreserve
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if err := el.appendHTML(c, space, depth+1); err != nil {
+			return err
+		}
+	}
+	if len(el.Children) == 1 && el.Children[0].Type == XML_CharData {
+		el.Content = el.Children[0].Content
+		if space != WhitespacePreserve {
+			el.Content = strings.TrimSpace(el.Content)
+		}
+		el.Children = nil
+	}
+	return nil
+}
+
+// appendHTML adds an HTML node to the children of el. An element whose
+// name is not valid in XML is replaced by its children.
+func (el *Element) appendHTML(n *html.Node, space Whitespace, depth int) error {
+	switch n.Type {
+	case html.ElementNode:
+		if !isNCName(n.Data) {
+			if depth > recursionLimit {
+				return errDeepXML
+			}
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if err := el.appendHTML(c, space, depth+1); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		var child Element
+		if err := child.fromHTML(n, el.Scope, space, depth); err != nil {
+			return err
+		}
+		el.Children = append(el.Children, child)
+	case html.TextNode:
+		if text := space.apply(n.Data); text != "" {
+			el.Children = append(el.Children, Element{Type: XML_CharData, Content: text, Scope: el.Scope})
+		}
+	case html.CommentNode:
+		el.Children = append(el.Children, Element{Type: XML_Comment, Content: n.Data, Scope: el.Scope})
+	}
+	return nil
+}
+
+// lookupPrefix returns the namespace bound to a prefix, or the default
+// namespace for an empty prefix.
+func (scope *Scope) lookupPrefix(prefix string) (string, bool) {
+	for i := len(scope.ns) - 1; i >= 0; i-- {
+		if scope.ns[i].Local == prefix {
+			return scope.ns[i].Space, true
+		}
+	}
+	return "", false
+}
+
+// isNCName reports whether s is a valid XML name without a colon.
+func isNCName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if !isNameStart(r) && (i == 0 || !isNameChar(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Elements which have no end tag in HTML.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"param": true, "source": true, "track": true, "wbr": true,
+}
+
+// Elements whose text is not escaped in HTML.
+var rawTextElements = map[string]bool{
+	"script": true, "style": true, "xmp": true, "iframe": true,
+	"noembed": true, "noframes": true, "plaintext": true,
+}
+
+// Attributes which are true if present, regardless of their value.
+var booleanAttrs = map[string]bool{
+	"allowfullscreen": true, "async": true, "autofocus": true, "autoplay": true,
+	"checked": true, "controls": true, "default": true, "defer": true,
+	"disabled": true, "formnovalidate": true, "hidden": true, "inert": true,
+	"ismap": true, "itemscope": true, "loop": true, "multiple": true,
+	"muted": true, "nomodule": true, "novalidate": true, "open": true,
+	"playsinline": true, "readonly": true, "required": true, "reversed": true,
+	"selected": true,
+}
+
+var htmlTextEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	"\u00a0", "&nbsp;",
+)
+
+var htmlAttrEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	`"`, "&quot;",
+	"\u00a0", "&nbsp;",
+)
+
+// EncodeHTML writes el to w as HTML5. It is intended for trees built by
+// ParseHTML, or XHTML documents such as the descriptions in an XCCDF
+// benchmark. Elements in the XHTML, SVG and MathML namespaces are
+// written without prefixes or namespace declarations, which HTML
+// parsers infer. Void elements such as br are written without an end
+// tag, and other empty elements with one. The text of script and style
+// elements is written without escaping. Boolean attributes such as
+// checked are written as a bare name when their value is empty or
+// their own name. Processing instructions and directives are omitted.
+// If el is an html element, the document starts with <!DOCTYPE html>.
+func EncodeHTML(w io.Writer, el *Element) error {
+	hw := htmlWriter{w: w}
+	if el.Type == XML_Tag && el.Name.Local == "html" && htmlNamespace(el.Name.Space) {
+		hw.writeString("<!DOCTYPE html>")
+	}
+	hw.element(el, false, 0)
+	return hw.err
+}
+
+// htmlNamespace reports whether elements in the namespace are written
+// without a prefix.
+func htmlNamespace(space string) bool {
+	switch space {
+	case "", xhtmlNS, svgNS, mathNS:
+		return true
+	}
+	return false
+}
+
+type htmlWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (hw *htmlWriter) writeString(s string) {
+	if hw.err == nil {
+		_, hw.err = io.WriteString(hw.w, s)
+	}
+}
+
+func (hw *htmlWriter) element(el *Element, raw bool, depth int) {
+	switch el.Type {
+	case XML_CharData, XML_CDATA:
+		if raw {
+			hw.writeString(el.Content)
+		} else {
+			hw.writeString(htmlTextEscaper.Replace(el.Content))
+		}
+		return
+	case XML_Comment:
+		hw.writeString("<!--" + strings.ReplaceAll(el.Content, "-->", "--&gt;") + "-->")
+		return
+	case XML_Tag:
+	default:
+		return
+	}
+	if depth > recursionLimit {
+		hw.err = errDeepXML
+		return
+	}
+	name := el.Name.Local
+	if !htmlNamespace(el.Name.Space) {
+		name = qualify(&el.Scope, el.Name)
+	}
+	foreign := el.Name.Space == svgNS || el.Name.Space == mathNS
+	hw.writeString("<" + name)
+	for _, a := range el.StartElement.Attr {
+		attr := a.Name.Local
+		switch a.Name.Space {
+		case "":
+			if !foreign && booleanAttrs[attr] && (a.Value == "" || strings.EqualFold(a.Value, attr)) {
+				hw.writeString(" " + attr)
+				continue
+			}
+		case xlinkNS:
+			attr = "xlink:" + attr
+		default:
+			attr = qualify(&el.Scope, a.Name)
+		}
+		hw.writeString(" " + attr + `="` + htmlAttrEscaper.Replace(a.Value) + `"`)
+	}
+	if !foreign && htmlNamespace(el.Name.Space) && voidElements[name] {
+		hw.writeString(">")
+		return
+	}
+	if foreign && len(el.Children) == 0 && el.Content == "" {
+		hw.writeString("/>")
+		return
+	}
+	hw.writeString(">")
+	raw = !foreign && htmlNamespace(el.Name.Space) && rawTextElements[name]
+	if len(el.Children) == 0 {
+		hw.element(&Element{Type: XML_CharData, Content: el.Content}, raw, depth+1)
+	}
+	for i := range el.Children {
+		hw.element(&el.Children[i], raw, depth+1)
+	}
+	hw.writeString("</" + name + ">")
+}
@@ -0,0 +1,89 @@
+package xmltree
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestParseHTML(t *testing.T) {
+	const doc = `<!DOCTYPE html>
+<title>Advisory RHSA-2023:1234</title>
+<p class=lead>Affects <b>bash</b><p>Fixed in <a href="/errata?id=1&amp;v=2" data-x=1 @click=go>5.2</a>
+<pre>  rpm -q bash
+  yum update</pre>
+<svg viewBox="0 0 10 10"><use xlink:href="#icon"/></svg>
+<!-- generated -->`
+
+	root, err := ParseHTML(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Name != (xml.Name{Space: xhtmlNS, Local: "html"}) {
+		t.Fatalf("root is %v", root.Name)
+	}
+	paras := root.Find(&Selector{Name: xml.Name{Space: xhtmlNS, Local: "p"}})
+	if len(paras) != 2 {
+		t.Fatalf("found %d paragraphs, wanted 2", len(paras))
+	}
+	if got := paras[0].InnerText(" "); got != "Affects bash" {
+		t.Errorf("first paragraph is %q", got)
+	}
+	links := root.Find(&Selector{Name: xml.Name{Local: "a"}})
+	if len(links) != 1 || links[0].Attr("", "href") != "/errata?id=1&v=2" {
+		t.Fatalf("link not found: %v", links)
+	}
+	if len(links[0].StartElement.Attr) != 2 {
+		t.Errorf("attribute with invalid XML name kept: %v", links[0].StartElement.Attr)
+	}
+	links[0].AddClass("external")
+
+	pre := root.Find(&Selector{Name: xml.Name{Local: "pre"}})
+	if len(pre) != 1 || pre[0].Content != "  rpm -q bash\n  yum update" {
+		t.Errorf("white space in pre not preserved: %q", pre[0].Content)
+	}
+	use := root.Find(&Selector{Name: xml.Name{Space: svgNS, Local: "use"}})
+	if len(use) != 1 || use[0].Attr(xlinkNS, "href") != "#icon" {
+		t.Errorf("svg content not namespaced: %v", use)
+	}
+
+	// The tree is well-formed XML.
+	again, err := Parse(strings.NewReader(root.String()))
+	if err != nil {
+		t.Fatalf("%s\n%s", err, root)
+	}
+	if !Equal(root, again) {
+		t.Errorf("tree did not round trip through XML:\n%s\n%s", root, again)
+	}
+	if links := again.Find(&Selector{Name: xml.Name{Local: "a"}}); links[0].Attr("", "class") != "external" {
+		t.Errorf("class not added: %s", again)
+	}
+}
+
+func TestEncodeHTML(t *testing.T) {
+	const doc = `<html xmlns="http://www.w3.org/1999/xhtml"><head><style>p > b { color: red }</style>` +
+		`<script>if (a < b && c) { run() }</script></head>` +
+		`<body><div/><p>x&lt;y&amp;z<br/>a&#160;b</p><input type="checkbox" checked="checked" disabled=""/>` +
+		`<svg xmlns="http://www.w3.org/2000/svg"><circle r="1"/></svg><!--note--></body></html>`
+	root := parseFullDoc(t, []byte(strings.ReplaceAll(doc, "if (a < b && c)", "if (a &lt; b &amp;&amp; c)")))
+	var sb strings.Builder
+	if err := EncodeHTML(&sb, root); err != nil {
+		t.Fatal(err)
+	}
+	want := `<!DOCTYPE html><html><head><style>p > b { color: red }</style>` +
+		`<script>if (a < b && c) { run() }</script></head>` +
+		`<body><div></div><p>x&lt;y&amp;z<br>a&nbsp;b</p><input type="checkbox" checked disabled>` +
+		`<svg><circle r="1"/></svg><!--note--></body></html>`
+	if got := sb.String(); got != want {
+		t.Errorf("got\n%s\nwanted\n%s", got, want)
+	}
+
+	// The output parses back into the same tree.
+	again, err := ParseHTML(strings.NewReader(sb.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !EqualWithOptions(root, again, &EqualOptions{IgnoreAttrs: []xml.Name{{Local: "checked"}}}) {
+		t.Errorf("EncodeHTML output did not round trip:\n%s\n%s", root, again)
+	}
+}
@@ -0,0 +1,60 @@
+// Package validation holds the error types and helpers shared by the
+// schema languages: the xsd, dtd and relaxng packages.
+package validation
+
+import (
+	"encoding/xml"
+	"fmt"
+
+	"github.com/pschou/go-xmltree"
+)
+
+// An Error describes an element which is not valid.
+type Error struct {
+	// Path is the location of the element, written in an XPath-like
+	// syntax using the namespace prefixes in scope at the element.
+	Path    string
+	Element *xmltree.Element
+	Msg     string
+}
+
+func (e *Error) Error() string {
+	return e.Path + ": " + e.Msg
+}
+
+// Errors is the list of errors returned by Validate, in document order.
+type Errors []*Error
+
+func (e Errors) Error() string {
+	switch len(e) {
+	case 0:
+		return "no validation errors"
+	case 1:
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", e[0], len(e)-1)
+}
+
+// QName returns the name of el, or of one of its attributes, as
+// written, using the namespace prefixes in scope at el. Names in a
+// namespace without a prefix in scope are written without one.
+func QName(el *xmltree.Element, name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	if q := el.Prefix(name); q != "" {
+		return q
+	}
+	return name.Local
+}
+
+// AttrValue returns the value of the unqualified attribute local of el,
+// and whether it is present.
+func AttrValue(el *xmltree.Element, local string) (string, bool) {
+	for _, a := range el.StartElement.Attr {
+		if a.Name.Space == "" && a.Name.Local == local {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,708 @@
+package xmltree
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A JSONStyle is a convention for mapping XML to JSON, and to YAML.
+type JSONStyle uint8
+
+const (
+	// JSONLossless keeps everything needed to rebuild the document.
+	// Attributes are written as "@name" members, namespace
+	// declarations as "@xmlns" and "@xmlns:prefix", and text as a
+	// "#text" member, or as the value itself for an element with
+	// nothing but text. An empty element is null. When the order of
+	// children cannot be kept by grouping them by name, as with mixed
+	// content, they are listed in order in a "#content" array, where
+	// text is a string, and comments, CDATA sections, directives and
+	// processing instructions are objects with a "#comment", "#cdata",
+	// "#directive" or "?target" member.
+	JSONLossless JSONStyle = iota
+	// JSONBadgerFish follows the BadgerFish convention: every element
+	// is an object, attributes are "@name" members, text is a "$"
+	// member, and namespace declarations are an "@xmlns" object
+	// mapping each prefix, or "$" for the default namespace, to its
+	// URI.
+	JSONBadgerFish
+	// JSONParker follows the Parker convention: attributes and
+	// namespaces are dropped, an element with nothing but text is its
+	// text, converted to a number or boolean where possible, and the
+	// root element is not named.
+	JSONParker
+	// JSONGData follows the convention of the Google Data APIs:
+	// attributes are plain members, text is a "$t" member, and the
+	// colon in a prefixed name is written as "$". Namespace
+	// declarations are "xmlns" and "xmlns$prefix" members.
+	JSONGData
+	// JSONFriendly is like JSONLossless, but is meant to be easy to
+	// read and write by hand rather than to keep every detail. Mixed
+	// content is not listed in order: the text within an element is
+	// joined into its "#text" member, and comments, processing
+	// instructions and directives are dropped.
+	JSONFriendly
+)
+
+// JSONConvention controls the mapping made by ToJSON and FromJSON.
+type JSONConvention struct {
+	Style JSONStyle
+	// Arrays lists elements which are always written as arrays, even
+	// when they occur once, as for elements a schema allows to repeat.
+	// If the Space of a name is empty, elements with that local name
+	// in any namespace are matched.
+	Arrays []xml.Name
+	// Root is the name of the root element built by FromJSON with the
+	// Parker style, which does not record it. The default is "root".
+	Root xml.Name
+	// Namespaces maps prefixes, or "" for the default namespace, to
+	// namespace URIs which are taken as declared outside the JSON. The
+	// declarations are left out by ToJSON, and FromJSON makes them on
+	// the root element, so that fragments can be written without
+	// declaring the namespaces they use.
+	Namespaces map[string]string
+}
+
+var errJSONRoot = errors.New("xmltree: JSON document must be an object with a single member")
+
+// ToJSON converts el to JSON following a convention. A nil conv is the
+// same as a zero JSONConvention, which uses the JSONLossless style.
+// Except with the JSONParker style, the result is an object with a
+// single member, named for el. Element and attribute names are written
+// with the prefixes in scope where they are used. Elements which occur
+// more than once among their siblings become arrays.
+func ToJSON(el *Element, conv *JSONConvention) ([]byte, error) {
+	v, err := toJSONValue(el, conv)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	writeJSON(&buf, v)
+	return buf.Bytes(), nil
+}
+
+// FromJSON builds a tree of Elements from JSON written in a convention,
+// as by ToJSON. A nil conv is the same as a zero JSONConvention. Only
+// the JSONLossless style rebuilds a document exactly; the others lose
+// details such as the order of differently named children. Names are
+// resolved using the namespace declarations in the JSON, and an error
+// is returned if a prefix is not declared.
+func FromJSON(r io.Reader, conv *JSONConvention) (*Element, error) {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	v, err := readJSON(d, 0)
+	if err != nil {
+		return nil, err
+	}
+	return fromJSONValue(v, conv)
+}
+
+// toJSONValue converts el to a JSON value: nil, a string, a
+// json.Number, a bool, a jsonObject or a []interface{}.
+func toJSONValue(el *Element, conv *JSONConvention) (interface{}, error) {
+	if conv == nil {
+		conv = new(JSONConvention)
+	}
+	c := jsonConverter{conv: conv}
+	v, err := c.value(el, nil, 0)
+	if err != nil || conv.Style == JSONParker {
+		return v, err
+	}
+	return jsonObject{{c.name(el, el.Name), v}}, nil
+}
+
+// fromJSONValue builds a tree of Elements from a JSON value.
+func fromJSONValue(v interface{}, conv *JSONConvention) (*Element, error) {
+	if conv == nil {
+		conv = new(JSONConvention)
+	}
+	c := jsonConverter{conv: conv}
+	root := new(Element)
+	if conv.Style == JSONParker {
+		root.Name = conv.Root
+		if root.Name.Local == "" {
+			root.Name.Local = "root"
+		}
+		if root.Name.Space != "" {
+			root.declareNS(xml.Name{Space: root.Name.Space})
+		}
+		return root, c.parker(root, v, 0)
+	}
+	obj, ok := v.(jsonObject)
+	if !ok || len(obj) != 1 {
+		return nil, errJSONRoot
+	}
+	return root, c.element(root, obj[0].key, obj[0].value, Scope{ns: c.external()}, 0)
+}
+
+// A jsonObject is a JSON object which keeps the order of its members.
+type jsonObject []jsonMember
+
+type jsonMember struct {
+	key   string
+	value interface{}
+}
+
+type jsonConverter struct {
+	conv *JSONConvention
+}
+
+// style returns the convention followed, where JSONFriendly differs
+// from JSONLossless only in how children are listed.
+func (c *jsonConverter) style() JSONStyle {
+	if c.conv.Style == JSONFriendly {
+		return JSONLossless
+	}
+	return c.conv.Style
+}
+
+// external returns the namespaces of conv.Namespaces, ordered by
+// prefix.
+func (c *jsonConverter) external() []xml.Name {
+	var ns []xml.Name
+	for prefix, uri := range c.conv.Namespaces {
+		ns = append(ns, xml.Name{Space: uri, Local: prefix})
+	}
+	sort.Slice(ns, func(i, j int) bool { return ns[i].Local < ns[j].Local })
+	return ns
+}
+
+// decls returns the namespace declarations made by el, except those
+// of conv.Namespaces made by the root.
+func (c *jsonConverter) decls(el, parent *Element) []xml.Name {
+	ns := diffScope(parent, el).ns
+	if parent != nil || len(c.conv.Namespaces) == 0 {
+		return ns
+	}
+	var decls []xml.Name
+	for _, d := range ns {
+		if uri, ok := c.conv.Namespaces[d.Local]; !ok || uri != d.Space {
+			decls = append(decls, d)
+		}
+	}
+	return decls
+}
+
+func (c *jsonConverter) forceArray(name xml.Name) bool {
+	for _, a := range c.conv.Arrays {
+		if a.Local == name.Local && (a.Space == "" || a.Space == name.Space) {
+			return true
+		}
+	}
+	return false
+}
+
+// name returns the key used for an element or attribute name.
+func (c *jsonConverter) name(el *Element, name xml.Name) string {
+	switch c.conv.Style {
+	case JSONParker:
+		return name.Local
+	case JSONGData:
+		return strings.Replace(qualify(&el.Scope, name), ":", "$", 1)
+	}
+	return qualify(&el.Scope, name)
+}
+
+// text returns the character data directly within el.
+func (c *jsonConverter) text(el *Element) string {
+	if len(el.Children) == 0 {
+		return el.Content
+	}
+	var sb strings.Builder
+	for i := range el.Children {
+		if isText(&el.Children[i]) {
+			sb.WriteString(el.Children[i].Content)
+		}
+	}
+	return sb.String()
+}
+
+// ordered reports whether the children of el must be listed in order
+// to keep the document intact.
+func (c *jsonConverter) ordered(el *Element) bool {
+	var text, tags bool
+	done := make(map[xml.Name]bool)
+	var last xml.Name
+	for i := range el.Children {
+		switch child := &el.Children[i]; child.Type {
+		case XML_CharData:
+			text = true
+		case XML_Tag:
+			tags = true
+			if child.Name != last && done[child.Name] {
+				return true
+			}
+			done[child.Name] = true
+			last = child.Name
+		default:
+			return true
+		}
+	}
+	return text && tags
+}
+
+func (c *jsonConverter) value(el, parent *Element, depth int) (interface{}, error) {
+	if depth > recursionLimit {
+		return nil, errDeepXML
+	}
+	style := c.style()
+	var obj jsonObject
+	switch style {
+	case JSONLossless:
+		for _, ns := range c.decls(el, parent) {
+			key := "@xmlns"
+			if ns.Local != "" {
+				key += ":" + ns.Local
+			}
+			obj = append(obj, jsonMember{key, ns.Space})
+		}
+	case JSONBadgerFish:
+		var decls jsonObject
+		for _, ns := range c.decls(el, parent) {
+			key := ns.Local
+			if key == "" {
+				key = "$"
+			}
+			decls = append(decls, jsonMember{key, ns.Space})
+		}
+		if decls != nil {
+			obj = append(obj, jsonMember{"@xmlns", decls})
+		}
+	case JSONGData:
+		for _, ns := range c.decls(el, parent) {
+			key := "xmlns"
+			if ns.Local != "" {
+				key += "$" + ns.Local
+			}
+			obj = append(obj, jsonMember{key, ns.Space})
+		}
+	}
+	if style != JSONParker {
+		for _, a := range el.StartElement.Attr {
+			key := c.name(el, a.Name)
+			if style != JSONGData {
+				key = "@" + key
+			}
+			obj = append(obj, jsonMember{key, a.Value})
+		}
+	}
+
+	if c.conv.Style == JSONLossless && c.ordered(el) {
+		var content []interface{}
+		for i := range el.Children {
+			child := &el.Children[i]
+			var item interface{}
+			switch child.Type {
+			case XML_CharData:
+				item = child.Content
+			case XML_CDATA:
+				item = jsonObject{{"#cdata", child.Content}}
+			case XML_Comment:
+				item = jsonObject{{"#comment", child.Content}}
+			case XML_Directive:
+				item = jsonObject{{"#directive", child.Content}}
+			case XML_ProcInst:
+				item = jsonObject{{"?" + child.Name.Local, child.Content}}
+			case XML_Tag:
+				v, err := c.value(child, el, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				item = jsonObject{{c.name(child, child.Name), v}}
+			}
+			content = append(content, item)
+		}
+		return append(obj, jsonMember{"#content", content}), nil
+	}
+
+	text := c.text(el)
+	hasTags := false
+	for i := range el.Children {
+		if el.Children[i].Type == XML_Tag {
+			hasTags = true
+			break
+		}
+	}
+	switch style {
+	case JSONLossless:
+		if obj == nil && !hasTags {
+			if text == "" {
+				return nil, nil
+			}
+			return text, nil
+		}
+		if text != "" {
+			obj = append(obj, jsonMember{"#text", text})
+		}
+	case JSONBadgerFish:
+		if text != "" {
+			obj = append(obj, jsonMember{"$", text})
+		}
+	case JSONGData:
+		if text != "" {
+			obj = append(obj, jsonMember{"$t", text})
+		}
+	case JSONParker:
+		if !hasTags {
+			if text == "" {
+				return nil, nil
+			}
+			return parkerScalar(text), nil
+		}
+	}
+
+	// Group children by name, in order of first occurrence.
+	index := make(map[string]int)
+	for i := range el.Children {
+		child := &el.Children[i]
+		if child.Type != XML_Tag {
+			continue
+		}
+		v, err := c.value(child, el, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		key := c.name(child, child.Name)
+		j, ok := index[key]
+		if !ok {
+			index[key] = len(obj)
+			if c.forceArray(child.Name) {
+				v = []interface{}{v}
+			}
+			obj = append(obj, jsonMember{key, v})
+			continue
+		}
+		if list, ok := obj[j].value.([]interface{}); ok {
+			obj[j].value = append(list, v)
+		} else {
+			obj[j].value = []interface{}{obj[j].value, v}
+		}
+	}
+	if obj == nil {
+		obj = jsonObject{}
+	}
+	return obj, nil
+}
+
+// parkerScalar converts text to a number or boolean if it is written
+// as one.
+func parkerScalar(s string) interface{} {
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil && json.Valid([]byte(s)) {
+		return json.Number(s)
+	}
+	return s
+}
+
+// scalar returns the text of a JSON string, number or boolean.
+func scalar(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+// declarations returns the namespace declarations among the members of
+// an object, for the lossless, BadgerFish and GData styles.
+func (c *jsonConverter) declarations(obj jsonObject) ([]xml.Name, error) {
+	var decls []xml.Name
+	for _, m := range obj {
+		switch c.style() {
+		case JSONLossless:
+			if m.key == "@xmlns" || strings.HasPrefix(m.key, "@xmlns:") {
+				uri, _ := scalar(m.value)
+				decls = append(decls, xml.Name{Space: uri, Local: strings.TrimPrefix(strings.TrimPrefix(m.key, "@xmlns"), ":")})
+			}
+		case JSONGData:
+			if m.key == "xmlns" || strings.HasPrefix(m.key, "xmlns$") {
+				uri, _ := scalar(m.value)
+				decls = append(decls, xml.Name{Space: uri, Local: strings.TrimPrefix(strings.TrimPrefix(m.key, "xmlns"), "$")})
+			}
+		case JSONBadgerFish:
+			if m.key != "@xmlns" {
+				continue
+			}
+			ns, ok := m.value.(jsonObject)
+			if !ok {
+				return nil, fmt.Errorf("xmltree: @xmlns must be an object")
+			}
+			for _, d := range ns {
+				uri, _ := scalar(d.value)
+				prefix := d.key
+				if prefix == "$" {
+					prefix = ""
+				}
+				decls = append(decls, xml.Name{Space: uri, Local: prefix})
+			}
+		}
+	}
+	return decls, nil
+}
+
+func (c *jsonConverter) resolve(scope *Scope, qname string, attr bool) (xml.Name, error) {
+	if c.style() == JSONGData {
+		qname = strings.Replace(qname, "$", ":", 1)
+	}
+	if attr && !strings.Contains(qname, ":") {
+		return xml.Name{Local: qname}, nil
+	}
+	name, ok := scope.ResolveNS(qname)
+	if !ok {
+		if strings.Contains(qname, ":") {
+			return name, fmt.Errorf("xmltree: undeclared namespace prefix in %q", qname)
+		}
+		name.Space = ""
+	}
+	return name, nil
+}
+
+// element fills in el, named qname, from its JSON value.
+func (c *jsonConverter) element(el *Element, qname string, v interface{}, parent Scope, depth int) error {
+	if depth > recursionLimit {
+		return errDeepXML
+	}
+	el.Type = XML_Tag
+	el.Scope = parent
+	obj, _ := v.(jsonObject)
+	decls, err := c.declarations(obj)
+	if err != nil {
+		return err
+	}
+	if len(decls) > 0 {
+		el.Scope.ns = append(parent.ns[:len(parent.ns):len(parent.ns)], decls...)
+	}
+	if el.Name, err = c.resolve(&el.Scope, qname, false); err != nil {
+		return err
+	}
+	if obj == nil {
+		if v == nil {
+			return nil
+		}
+		text, ok := scalar(v)
+		if !ok {
+			return fmt.Errorf("xmltree: unexpected JSON array for element %s", qname)
+		}
+		el.Content = text
+		return nil
+	}
+
+	style := c.style()
+	var text string
+	for _, m := range obj {
+		switch {
+		case style == JSONLossless && (m.key == "@xmlns" || strings.HasPrefix(m.key, "@xmlns:")),
+			style == JSONGData && (m.key == "xmlns" || strings.HasPrefix(m.key, "xmlns$")),
+			style == JSONBadgerFish && m.key == "@xmlns":
+			// Declarations were handled above.
+		case style == JSONLossless && m.key == "#text", style == JSONBadgerFish && m.key == "$",
+			style == JSONGData && m.key == "$t":
+			text, _ = scalar(m.value)
+		case style == JSONLossless && m.key == "#content":
+			items, ok := m.value.([]interface{})
+			if !ok {
+				return fmt.Errorf("xmltree: #content of %s must be an array", qname)
+			}
+			for _, item := range items {
+				if err := c.contentItem(el, item, depth); err != nil {
+					return err
+				}
+			}
+		case style != JSONGData && strings.HasPrefix(m.key, "@"),
+			style == JSONGData && !isContainer(m.value):
+			name, err := c.resolve(&el.Scope, strings.TrimPrefix(m.key, "@"), true)
+			if err != nil {
+				return err
+			}
+			value, _ := scalar(m.value)
+			el.StartElement.Attr = append(el.StartElement.Attr, xml.Attr{Name: name, Value: value})
+		default:
+			values, ok := m.value.([]interface{})
+			if !ok {
+				values = []interface{}{m.value}
+			}
+			for _, v := range values {
+				var child Element
+				if err := c.element(&child, m.key, v, el.Scope, depth+1); err != nil {
+					return err
+				}
+				el.Children = append(el.Children, child)
+			}
+		}
+	}
+	if text != "" {
+		if len(el.Children) == 0 {
+			el.Content = text
+		} else {
+			el.Children = append([]Element{{Type: XML_CharData, Content: text, Scope: el.Scope}}, el.Children...)
+		}
+	}
+	return nil
+}
+
+func isContainer(v interface{}) bool {
+	switch v.(type) {
+	case jsonObject, []interface{}:
+		return true
+	}
+	return false
+}
+
+// contentItem adds an item of a lossless #content array to el.
+func (c *jsonConverter) contentItem(el *Element, item interface{}, depth int) error {
+	if text, ok := scalar(item); ok {
+		el.Children = append(el.Children, Element{Type: XML_CharData, Content: text, Scope: el.Scope})
+		return nil
+	}
+	obj, ok := item.(jsonObject)
+	if !ok || len(obj) != 1 {
+		return fmt.Errorf("xmltree: #content items must be strings or objects with a single member")
+	}
+	key, v := obj[0].key, obj[0].value
+	node := Element{Scope: el.Scope}
+	node.Content, _ = scalar(v)
+	switch {
+	case key == "#cdata":
+		node.Type = XML_CDATA
+	case key == "#comment":
+		node.Type = XML_Comment
+	case key == "#directive":
+		node.Type = XML_Directive
+	case strings.HasPrefix(key, "?"):
+		node.Type = XML_ProcInst
+		node.Name.Local = key[1:]
+	default:
+		node = Element{}
+		if err := c.element(&node, key, v, el.Scope, depth+1); err != nil {
+			return err
+		}
+	}
+	el.Children = append(el.Children, node)
+	return nil
+}
+
+// parker fills in the content of el from a JSON value in the Parker
+// style.
+func (c *jsonConverter) parker(el *Element, v interface{}, depth int) error {
+	if depth > recursionLimit {
+		return errDeepXML
+	}
+	el.Type = XML_Tag
+	obj, ok := v.(jsonObject)
+	if !ok {
+		if _, isArray := v.([]interface{}); isArray {
+			return fmt.Errorf("xmltree: unexpected JSON array for element %s", el.Name.Local)
+		}
+		el.Content, _ = scalar(v)
+		return nil
+	}
+	for _, m := range obj {
+		values, ok := m.value.([]interface{})
+		if !ok {
+			values = []interface{}{m.value}
+		}
+		for _, v := range values {
+			child := Element{Scope: el.Scope}
+			child.Name = xml.Name{Space: el.Name.Space, Local: m.key}
+			if err := c.parker(&child, v, depth+1); err != nil {
+				return err
+			}
+			el.Children = append(el.Children, child)
+		}
+	}
+	return nil
+}
+
+// readJSON reads a JSON value, keeping the order of object members.
+func readJSON(d *json.Decoder, depth int) (interface{}, error) {
+	if depth > recursionLimit {
+		return nil, errDeepXML
+	}
+	tok, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := jsonObject{}
+		for d.More() {
+			key, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := readJSON(d, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, jsonMember{key.(string), v})
+		}
+		_, err := d.Token()
+		return obj, err
+	case json.Delim('['):
+		list := []interface{}{}
+		for d.More() {
+			v, err := readJSON(d, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		_, err := d.Token()
+		return list, err
+	}
+	return tok, nil
+}
+
+// writeJSON writes a JSON value compactly.
+func writeJSON(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		buf.WriteString(v.String())
+	case string:
+		enc := json.NewEncoder(buf)
+		enc.SetEscapeHTML(false)
+		enc.Encode(v)
+		// Drop the newline written by Encode
+		buf.Truncate(buf.Len() - 1)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSON(buf, item)
+		}
+		buf.WriteByte(']')
+	case jsonObject:
+		buf.WriteByte('{')
+		for i, m := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSON(buf, m.key)
+			buf.WriteByte(':')
+			writeJSON(buf, m.value)
+		}
+		buf.WriteByte('}')
+	}
+}
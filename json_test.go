@@ -0,0 +1,98 @@
+package xmltree
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+)
+
+const jsonSample = `<oval_definitions xmlns="http://oval.mitre.org/XMLSchema/oval-definitions-5" xmlns:oval="http://oval.mitre.org/XMLSchema/oval-common-5">
+  <generator><oval:schema_version>5.11</oval:schema_version></generator>
+  <definitions>
+    <definition id="def:1" version="2"><title>CVE-1</title><reference source="CVE" ref_id="CVE-1"/></definition>
+  </definitions>
+  <tests>
+    <test id="tst:1" check="all"/>
+    <test id="tst:2" check="all"/>
+  </tests>
+</oval_definitions>`
+
+func TestToJSON(t *testing.T) {
+	root := parseFullDoc(t, []byte(jsonSample))
+	arrays := []xml.Name{{Local: "definition"}}
+	for _, tt := range []struct {
+		style JSONStyle
+		want  string
+	}{
+		{JSONLossless, `{"oval_definitions":{"@xmlns:oval":"http://oval.mitre.org/XMLSchema/oval-common-5","@xmlns":"http://oval.mitre.org/XMLSchema/oval-definitions-5",` +
+			`"generator":{"oval:schema_version":"5.11"},` +
+			`"definitions":{"definition":[{"@id":"def:1","@version":"2","title":"CVE-1","reference":{"@source":"CVE","@ref_id":"CVE-1"}}]},` +
+			`"tests":{"test":[{"@id":"tst:1","@check":"all"},{"@id":"tst:2","@check":"all"}]}}}`},
+		{JSONBadgerFish, `{"oval_definitions":{"@xmlns":{"oval":"http://oval.mitre.org/XMLSchema/oval-common-5","$":"http://oval.mitre.org/XMLSchema/oval-definitions-5"},` +
+			`"generator":{"oval:schema_version":{"$":"5.11"}},` +
+			`"definitions":{"definition":[{"@id":"def:1","@version":"2","title":{"$":"CVE-1"},"reference":{"@source":"CVE","@ref_id":"CVE-1"}}]},` +
+			`"tests":{"test":[{"@id":"tst:1","@check":"all"},{"@id":"tst:2","@check":"all"}]}}}`},
+		{JSONParker, `{"generator":{"schema_version":5.11},"definitions":{"definition":[{"title":"CVE-1","reference":null}]},"tests":{"test":[null,null]}}`},
+		{JSONGData, `{"oval_definitions":{"xmlns$oval":"http://oval.mitre.org/XMLSchema/oval-common-5","xmlns":"http://oval.mitre.org/XMLSchema/oval-definitions-5",` +
+			`"generator":{"oval$schema_version":{"$t":"5.11"}},` +
+			`"definitions":{"definition":[{"id":"def:1","version":"2","title":{"$t":"CVE-1"},"reference":{"source":"CVE","ref_id":"CVE-1"}}]},` +
+			`"tests":{"test":[{"id":"tst:1","check":"all"},{"id":"tst:2","check":"all"}]}}}`},
+	} {
+		conv := &JSONConvention{Style: tt.style, Arrays: arrays}
+		got, err := ToJSON(root, conv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("style %d:\n got %s\nwant %s", tt.style, got, tt.want)
+		}
+		if tt.style == JSONParker {
+			continue
+		}
+		back, err := FromJSON(bytes.NewReader(got), conv)
+		if err != nil {
+			t.Fatalf("style %d: %v", tt.style, err)
+		}
+		if !Equal(root, back) {
+			t.Errorf("style %d: did not round trip:\n%s\n%s", tt.style, root, back)
+		}
+	}
+}
+
+func TestJSONLossless(t *testing.T) {
+	const doc = `<description xmlns:h="http://www.w3.org/1999/xhtml">Run <h:code>umask</h:code> first.` +
+		`<!-- note --><?pi data?><![CDATA[<raw>]]><h:br/><h:p>a</h:p><h:br/></description>`
+	root := parseFullDoc(t, []byte(doc))
+	data, err := ToJSON(root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := FromJSON(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := back.String(); got != root.String() {
+		t.Errorf("lossless JSON did not round trip:\n%s\n%s\n%s", data, root, got)
+	}
+}
+
+func TestFromJSONErrors(t *testing.T) {
+	for _, doc := range []string{
+		`[1, 2]`,
+		`{"a": 1, "b": 2}`,
+		`{"p:a": null}`,
+		`{"a": {"@x": 1, "#content": 3}}`,
+		`{"a": `,
+	} {
+		if _, err := FromJSON(bytes.NewReader([]byte(doc)), nil); err == nil {
+			t.Errorf("FromJSON(%s) did not fail", doc)
+		}
+	}
+	root, err := FromJSON(bytes.NewReader([]byte(`{"item": [1, {"b": true}]}`)), &JSONConvention{Style: JSONParker})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := root.String(), `<root><item>1</item><item><b>true</b></item></root>`; got != want {
+		t.Errorf("got %s, wanted %s", got, want)
+	}
+}
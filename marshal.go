@@ -1,27 +1,129 @@
 package xmltree
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/xml"
+	"errors"
 	"io"
+	"sort"
 	"strings"
-	"text/template"
 )
 
-// NOTE(droyo) As of go1.5.1, the encoding/xml package does not resolve
-// prefixes in attribute names. Therefore we add .Name.Space verbatim
-// instead of trying to resolve it. One consequence is this is that we cannot
-// rename prefixes without some work.
-var tagTmpl = template.Must(template.New("Marshal XML tags").Parse(
-	`{{define "start" -}}
-	<{{.Scope.Prefix .Name -}}
-	{{range .StartElement.Attr}} {{$.Scope.Prefix .Name -}}="{{.Value}}"{{end -}}
-	{{range .NS }} xmlns{{ if .Local }}:{{ .Local }}{{end}}="{{ .Space }}"{{end -}}
-	{{if or .Children .Content}}>{{else}} />{{end}}
-	{{- end}}
-
-	{{define "end" -}}
-	</{{.Prefix .Name}}>{{end}}`))
+// An AttrOrder is the order in which attributes and namespace
+// declarations are written in a start tag.
+type AttrOrder uint8
+
+const (
+	// AttrsAsIs writes attributes in the order of the Element,
+	// followed by namespace declarations.
+	AttrsAsIs AttrOrder = iota
+	// AttrsByName sorts attributes and namespace declarations together
+	// by their names as written.
+	AttrsByName
+	// AttrsNSFirst writes namespace declarations before attributes,
+	// each sorted by their names as written.
+	AttrsNSFirst
+)
+
+// A SelfClose is the way an empty element is written.
+type SelfClose uint8
+
+const (
+	// SelfCloseSpace writes empty elements as <a />.
+	SelfCloseSpace SelfClose = iota
+	// SelfCloseTight writes empty elements as <a/>.
+	SelfCloseTight
+	// SelfCloseNever writes empty elements as <a></a>.
+	SelfCloseNever
+)
+
+// EncodeOptions controls the way start tags are written by
+// EncodeWithOptions and Format. The zero value gives the output of
+// Encode.
+type EncodeOptions struct {
+	// AttrOrder is the order of attributes and namespace declarations.
+	AttrOrder AttrOrder
+	// LessAttr, if not nil, orders attributes and namespace
+	// declarations in place of AttrOrder. Attributes are given with
+	// their expanded names, and declarations as attributes named
+	// xmlns, or with a Space of xmlns and the prefix as Local, as in
+	// the tokens read by an xml.Decoder. The sort is stable, starting
+	// from the order of AttrsAsIs.
+	LessAttr func(a, b xml.Attr) bool
+	// SingleQuote writes attribute values within single quotes rather
+	// than double quotes.
+	SingleQuote bool
+	// SelfClose is the way empty elements are written.
+	SelfClose SelfClose
+}
+
+// A tagAttr is an attribute or namespace declaration in a start tag.
+type tagAttr struct {
+	attr  xml.Attr // as passed to EncodeOptions.LessAttr
+	qname string   // as written
+	decl  bool
+}
+
+// attrs returns the attributes of el followed by the namespace
+// declarations decls, in the order given by opts, written as
+// name="value".
+func (opts *EncodeOptions) attrs(el *Element, decls []xml.Name) []string {
+	list := make([]tagAttr, 0, len(el.StartElement.Attr)+len(decls))
+	for _, a := range el.StartElement.Attr {
+		list = append(list, tagAttr{attr: a, qname: qualify(&el.Scope, a.Name)})
+	}
+	for _, ns := range decls {
+		t := tagAttr{attr: xml.Attr{Name: xml.Name{Local: "xmlns"}, Value: ns.Space}, qname: "xmlns", decl: true}
+		if ns.Local != "" {
+			t.attr.Name = xml.Name{Space: "xmlns", Local: ns.Local}
+			t.qname += ":" + ns.Local
+		}
+		list = append(list, t)
+	}
+	switch {
+	case opts.LessAttr != nil:
+		sort.SliceStable(list, func(i, j int) bool {
+			return opts.LessAttr(list[i].attr, list[j].attr)
+		})
+	case opts.AttrOrder == AttrsByName:
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].qname < list[j].qname
+		})
+	case opts.AttrOrder == AttrsNSFirst:
+		sort.SliceStable(list, func(i, j int) bool {
+			if list[i].decl != list[j].decl {
+				return list[i].decl
+			}
+			return list[i].qname < list[j].qname
+		})
+	}
+	items := make([]string, len(list))
+	for i, t := range list {
+		items[i] = t.qname + "=" + opts.quote(t.attr.Value)
+	}
+	return items
+}
+
+// quote escapes and quotes an attribute value.
+func (opts *EncodeOptions) quote(s string) string {
+	if opts.SingleQuote {
+		return "'" + attrEscaperSingle.Replace(s) + "'"
+	}
+	return `"` + escapeAttr(s) + `"`
+}
+
+// emptyTag returns the end of the start tag of an empty element named
+// name, including its end tag if it is not self-closing.
+func (opts *EncodeOptions) emptyTag(name string) string {
+	switch opts.SelfClose {
+	case SelfCloseTight:
+		return "/>"
+	case SelfCloseNever:
+		return "></" + name + ">"
+	}
+	return " />"
+}
 
 // Marshal produces the XML encoding of an Element as a self-contained
 // document. The xmltree package may adjust the declarations of XML
@@ -32,7 +134,7 @@ var tagTmpl = template.Must(template.New("Marshal XML tags").Parse(
 // the original encoding of the source document.
 func Marshal(el *Element) []byte {
 	var buf bytes.Buffer
-	if err := Encode(&buf, el); err != nil {
+	if err := NewEncoder(&buf).Encode(el); err != nil {
 		// bytes.Buffer.Write should never return an error
 		panic(err)
 	}
@@ -45,13 +147,7 @@ func Marshal(el *Element) []byte {
 // nesting depth.
 func MarshalIndent(el *Element, prefix, indent string) []byte {
 	var buf bytes.Buffer
-	enc := encoder{
-		w:      &buf,
-		prefix: prefix,
-		indent: indent,
-		pretty: true,
-	}
-	if err := enc.encode(el, nil, make(map[*Element]struct{})); err != nil {
+	if err := NewEncoder(&buf).SetIndent(prefix, indent).Encode(el); err != nil {
 		panic(err)
 	}
 	return buf.Bytes()
@@ -60,22 +156,24 @@ func MarshalIndent(el *Element, prefix, indent string) []byte {
 // Encode writes the XML encoding of the Element to w.
 // Encode returns any errors encountered writing to w.
 func Encode(w io.Writer, el *Element) error {
-	enc := encoder{w: w}
-	return enc.encode(el, nil, make(map[*Element]struct{}))
+	return NewEncoder(w).Encode(el)
+}
+
+// EncodeWithOptions is like Encode, but start tags are written as
+// described by opts. A nil opts is the same as a zero EncodeOptions,
+// and gives the same output as Encode.
+func EncodeWithOptions(w io.Writer, el *Element, opts *EncodeOptions) error {
+	return NewEncoder(w).SetOptions(opts).Encode(el)
 }
 
 // EncodeIndent is like Encode, but adds line breaks for each
 // successive element. Each line begins with prefix and is
 // followed by zero or more copies of indent according to the
-// nesting depth.
+// nesting depth. Character data is placed on lines of its own,
+// which changes the text of elements with mixed content; Format
+// leaves such elements as they are.
 func EncodeIndent(w io.Writer, el *Element, prefix, indent string) error {
-	enc := encoder{
-		w:      w,
-		prefix: prefix,
-		indent: indent,
-		pretty: true,
-	}
-	return enc.encode(el, nil, make(map[*Element]struct{}))
+	return NewEncoder(w).SetIndent(prefix, indent).Encode(el)
 }
 
 // String returns the XML encoding of an Element
@@ -84,10 +182,158 @@ func (el *Element) String() string {
 	return string(Marshal(el))
 }
 
-type encoder struct {
-	w              io.Writer
+var (
+	errNotStarted = errors.New("xmltree: no element started by EncodeStart")
+	errNotTag     = errors.New("xmltree: EncodeStart requires an XML_Tag element")
+)
+
+// An Encoder writes Elements to an output stream. Output is buffered,
+// so Flush must be called after the last element is written with
+// EncodeStart, EncodeChild or EncodeEnd; Encode flushes the output
+// itself.
+//
+// Besides writing whole trees with Encode, an Encoder can write a
+// document a piece at a time, so that large documents need not be held
+// in memory: EncodeStart writes the start tag of an element, EncodeChild
+// writes a complete child of it, and EncodeEnd writes its end tag.
+// Namespace declarations are only written where they differ from those
+// of the enclosing element, as with Encode.
+type Encoder struct {
+	w              *bufio.Writer
+	opts           *EncodeOptions
 	prefix, indent string
 	pretty         bool
+	preserve       bool          // within xml:space="preserve"
+	open           []openElement // elements started by EncodeStart
+}
+
+// An openElement is an element whose end tag has not been written.
+type openElement struct {
+	el    *Element
+	outer bool // the xml:space state of its parent
+}
+
+// NewEncoder returns a new Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: bufio.NewWriter(w), opts: new(EncodeOptions)}
+}
+
+// SetIndent makes the Encoder add line breaks for each successive
+// element, as EncodeIndent does. Each line begins with prefix and is
+// followed by zero or more copies of indent according to the nesting
+// depth. SetIndent returns the Encoder.
+func (e *Encoder) SetIndent(prefix, indent string) *Encoder {
+	e.prefix, e.indent, e.pretty = prefix, indent, true
+	return e
+}
+
+// SetOptions sets the way start tags are written. A nil opts is the same
+// as a zero EncodeOptions. SetOptions returns the Encoder.
+func (e *Encoder) SetOptions(opts *EncodeOptions) *Encoder {
+	if opts == nil {
+		opts = new(EncodeOptions)
+	}
+	e.opts = opts
+	return e
+}
+
+// Encode writes el and its descendants, and flushes the output. If an
+// element has been started by EncodeStart, el is written as its child.
+func (e *Encoder) Encode(el *Element) error {
+	e.encode(el, e.parent(), len(e.open), make(map[*Element]struct{}))
+	return e.Flush()
+}
+
+// EncodeStart writes the start tag of el, which must be an element.
+// Its Content and Children are not written; the content of the element
+// is written by later calls, up to the matching EncodeEnd.
+func (e *Encoder) EncodeStart(el *Element) error {
+	if el.Type != XML_Tag {
+		return errNotTag
+	}
+	if len(e.open) > recursionLimit {
+		return errDeepXML
+	}
+	e.line(len(e.open))
+	e.startTag(el, diffScope(e.parent(), el), false)
+	e.open = append(e.open, openElement{el: el, outer: e.preserve})
+	e.preserve = preserveSpace(el, e.preserve)
+	if e.indenting() {
+		e.w.WriteByte('\n')
+	}
+	return e.error()
+}
+
+// EncodeChild writes el and its descendants as a child of the element
+// started by the last call to EncodeStart.
+func (e *Encoder) EncodeChild(el *Element) error {
+	if len(e.open) == 0 {
+		return errNotStarted
+	}
+	e.encode(el, e.parent(), len(e.open), make(map[*Element]struct{}))
+	return e.error()
+}
+
+// EncodeEnd writes the end tag of the element started by the last call
+// to EncodeStart.
+func (e *Encoder) EncodeEnd() error {
+	if len(e.open) == 0 {
+		return errNotStarted
+	}
+	top := e.open[len(e.open)-1]
+	e.open = e.open[:len(e.open)-1]
+	e.line(len(e.open))
+	e.endTag(top.el, top.outer)
+	return e.error()
+}
+
+// Flush writes any buffered output to the underlying writer.
+func (e *Encoder) Flush() error {
+	return e.w.Flush()
+}
+
+// error returns the first error encountered writing to the output.
+func (e *Encoder) error() error {
+	_, err := e.w.Write(nil)
+	return err
+}
+
+// parent returns the innermost element started by EncodeStart, or nil.
+func (e *Encoder) parent() *Element {
+	if len(e.open) == 0 {
+		return nil
+	}
+	return e.open[len(e.open)-1].el
+}
+
+// indenting reports whether line breaks and indentation are added at
+// the current position.
+func (e *Encoder) indenting() bool {
+	return e.pretty && !e.preserve
+}
+
+// line writes the indentation for a line at the given depth, if
+// indenting.
+func (e *Encoder) line(depth int) {
+	if !e.indenting() {
+		return
+	}
+	e.w.WriteString(e.prefix)
+	for i := 0; i < depth; i++ {
+		e.w.WriteString(e.indent)
+	}
+}
+
+// preserveSpace reports whether white space is significant within el,
+// given whether it was significant in its parent.
+func preserveSpace(el *Element, inherited bool) bool {
+	switch el.Attr(xmlLangURI, "space") {
+	case "preserve":
+		return true
+	case "default":
+		return false
+	}
+	return inherited
 }
 
 var htmlEscaper = strings.NewReplacer(
@@ -99,6 +345,31 @@ var htmlEscaperMin = strings.NewReplacer(
 	`&`, "&amp;",
 )
 
+var attrEscaper = strings.NewReplacer(
+	`<`, "&lt;",
+	`&`, "&amp;",
+	`"`, "&quot;",
+	"\t", "&#x9;",
+	"\n", "&#xA;",
+	"\r", "&#xD;",
+)
+
+var attrEscaperSingle = strings.NewReplacer(
+	`<`, "&lt;",
+	`&`, "&amp;",
+	`'`, "&apos;",
+	"\t", "&#x9;",
+	"\n", "&#xA;",
+	"\r", "&#xD;",
+)
+
+// escapeAttr escapes an attribute value for writing within double
+// quotes. White space other than spaces is written as character
+// references, so it survives attribute value normalization.
+func escapeAttr(s string) string {
+	return attrEscaper.Replace(s)
+}
+
 func escape(s string) string {
 	if strings.Index(s, "<") == -1 {
 		return htmlEscaperMin.Replace(s)
@@ -111,63 +382,73 @@ func escape(s string) string {
 // be "pulled" in, so they can be resolved properly. This is trickier than
 // just defining everything at the top level because there may be conflicts
 // introduced by the modifications.
-func (e *encoder) encode(el, parent *Element, visited map[*Element]struct{}) error {
+func (e *Encoder) encode(el, parent *Element, depth int, visited map[*Element]struct{}) {
 	switch el.Type {
 	case XML_CharData:
-		if e.pretty {
-			for i := 0; i < len(visited); i++ {
-				io.WriteString(e.w, e.indent)
-			}
-		}
-		io.WriteString(e.w, escape(el.Content))
-		if e.pretty {
-			e.w.Write([]byte{'\n'})
+		if e.indenting() && strings.TrimSpace(el.Content) == "" {
+			// Replaced by indentation
+			return
 		}
+		e.line(depth)
+		e.w.WriteString(escape(el.Content))
+	case XML_CDATA:
+		e.line(depth)
+		writeCDATA(e.w, el.Content)
 	case XML_Comment:
-		if e.pretty {
-			for i := 0; i < len(visited); i++ {
-				io.WriteString(e.w, e.indent)
-			}
-		}
-		e.w.Write([]byte("<!--"))
-		io.WriteString(e.w, strings.ReplaceAll(el.Content, "-->", "--&gt;"))
-		e.w.Write([]byte("-->"))
-		if e.pretty {
-			e.w.Write([]byte{'\n'})
+		e.line(depth)
+		e.w.WriteString("<!--")
+		e.w.WriteString(strings.ReplaceAll(el.Content, "-->", "--&gt;"))
+		e.w.WriteString("-->")
+	case XML_ProcInst:
+		e.line(depth)
+		e.w.WriteString("<?" + el.Name.Local)
+		if el.Content != "" {
+			e.w.WriteString(" " + el.Content)
 		}
+		e.w.WriteString("?>")
+	case XML_Directive:
+		e.line(depth)
+		e.w.WriteString("<!" + el.Content + ">")
 	case XML_Tag:
-		if len(visited) > recursionLimit {
+		if depth > recursionLimit {
 			// We only return I/O errors
-			return nil
+			return
 		}
 		if _, ok := visited[el]; ok {
 			// We have a cycle. Leave a comment, but no error
-			e.w.Write([]byte("<!-- cycle detected -->"))
-			return nil
+			e.w.WriteString("<!-- cycle detected -->")
+			return
 		}
-		scope := diffScope(parent, el)
-		if err := e.encodeOpenTag(el, scope, len(visited)); err != nil {
-			return err
+		e.line(depth)
+		empty := len(el.Children) == 0 && len(el.Content) == 0
+		e.startTag(el, diffScope(parent, el), empty)
+		if empty {
+			break
 		}
 		if len(el.Children) == 0 {
-			if len(el.Content) > 0 {
-				io.WriteString(e.w, escape(el.Content))
-			} else {
-				return nil
-			}
+			e.w.WriteString(escape(el.Content))
+			e.endTag(el, e.preserve)
+			return
+		}
+		outer := e.preserve
+		e.preserve = preserveSpace(el, outer)
+		if e.indenting() {
+			e.w.WriteByte('\n')
 		}
 		visited[el] = struct{}{}
 		for i := range el.Children {
-			if err := e.encode(&el.Children[i], el, visited); err != nil {
-				return err
-			}
+			e.encode(&el.Children[i], el, depth+1, visited)
 		}
 		delete(visited, el)
-		if err := e.encodeCloseTag(el, len(visited)); err != nil {
-			return err
-		}
+		e.line(depth)
+		e.endTag(el, outer)
+		return
+	default:
+		return
+	}
+	if e.indenting() {
+		e.w.WriteByte('\n')
 	}
-	return nil
 }
 
 // diffScope returns the Scope of the child element, minus any
@@ -189,40 +470,100 @@ func diffScope(parent, child *Element) Scope {
 	return childScope
 }
 
-func (e *encoder) encodeOpenTag(el *Element, scope Scope, depth int) error {
-	if e.pretty {
-		for i := 0; i < depth; i++ {
-			io.WriteString(e.w, e.indent)
-		}
+// startTag writes the start tag of el, declaring the namespaces in
+// scope. An empty element is closed as set by the EncodeOptions.
+func (e *Encoder) startTag(el *Element, scope Scope, empty bool) {
+	name := qualify(&el.Scope, el.Name)
+	e.w.WriteString("<" + name)
+	for _, attr := range e.opts.attrs(el, scope.ns) {
+		e.w.WriteString(" " + attr)
 	}
-	var tag = struct {
-		*Element
-		NS []xml.Name
-	}{Element: el, NS: scope.ns}
-	if err := tagTmpl.ExecuteTemplate(e.w, "start", tag); err != nil {
-		return err
+	if empty {
+		e.w.WriteString(e.opts.emptyTag(name))
+	} else {
+		e.w.WriteByte('>')
 	}
-	if e.pretty {
-		if len(el.Children) > 0 || len(el.Content) == 0 {
-			io.WriteString(e.w, "\n")
-		}
+}
+
+// endTag writes the end tag of el, and returns to the xml:space scope
+// of its parent.
+func (e *Encoder) endTag(el *Element, outer bool) {
+	e.w.WriteString("</" + qualify(&el.Scope, el.Name) + ">")
+	e.preserve = outer
+	if e.indenting() {
+		e.w.WriteByte('\n')
 	}
-	return nil
 }
 
-func (e *encoder) encodeCloseTag(el *Element, depth int) error {
-	if e.pretty {
-		for i := 0; i < depth; i++ {
-			if len(el.Children) > 0 {
-				io.WriteString(e.w, e.indent)
+// MarshalXML implements the xml.Marshaler interface, allowing an *Element
+// to be used as an "any XML" field within an ordinary tagged struct. The
+// Element is written with its own name and namespace declarations; the
+// name of the enclosing struct field is only used if the Element has no
+// name of its own.
+func (el *Element) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if el.Type == XML_Tag && el.Name.Local == "" {
+		return e.EncodeElement(el.Content, start)
+	}
+	return el.encodeTokens(e, nil, 0)
+}
+
+// encodeTokens writes the Element to an xml.Encoder as a stream of
+// tokens. Names are written in their prefixed form along with explicit
+// namespace declarations, so the xml.Encoder does not invent prefixes of
+// its own.
+func (el *Element) encodeTokens(e *xml.Encoder, parent *Element, depth int) error {
+	switch el.Type {
+	case XML_CharData, XML_CDATA:
+		// The xml.Encoder cannot write CDATA sections.
+		return e.EncodeToken(xml.CharData(el.Content))
+	case XML_Comment:
+		return e.EncodeToken(xml.Comment(el.Content))
+	case XML_ProcInst:
+		return e.EncodeToken(xml.ProcInst{Target: el.Name.Local, Inst: []byte(el.Content)})
+	case XML_Directive:
+		return e.EncodeToken(xml.Directive(el.Content))
+	case XML_Tag:
+		if depth > recursionLimit {
+			return errDeepXML
+		}
+		name := xml.Name{Local: qualify(&el.Scope, el.Name)}
+		start := xml.StartElement{Name: name}
+		for _, a := range el.StartElement.Attr {
+			start.Attr = append(start.Attr, xml.Attr{
+				Name:  xml.Name{Local: qualify(&el.Scope, a.Name)},
+				Value: a.Value,
+			})
+		}
+		for _, ns := range diffScope(parent, el).ns {
+			attr := xml.Attr{Name: xml.Name{Local: "xmlns"}, Value: ns.Space}
+			if ns.Local != "" {
+				attr.Name.Local += ":" + ns.Local
 			}
+			start.Attr = append(start.Attr, attr)
 		}
-	}
-	if err := tagTmpl.ExecuteTemplate(e.w, "end", el); err != nil {
-		return err
-	}
-	if e.pretty {
-		io.WriteString(e.w, "\n")
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		if len(el.Children) == 0 && len(el.Content) > 0 {
+			if err := e.EncodeToken(xml.CharData(el.Content)); err != nil {
+				return err
+			}
+		}
+		for i := range el.Children {
+			if err := el.Children[i].encodeTokens(e, el, depth+1); err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(xml.EndElement{Name: name})
 	}
 	return nil
 }
+
+// qualify is like Scope.Prefix, but falls back to the local name when the
+// namespace has no prefix in scope.
+func qualify(scope *Scope, name xml.Name) string {
+	if qname := scope.Prefix(name); qname != "" {
+		return qname
+	}
+	return name.Local
+}
@@ -0,0 +1,158 @@
+package xmltree
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestEncodeOptions(t *testing.T) {
+	const doc = `<r xmlns:b="urn:b" xmlns="urn:a" z="1" b:y="it's" a="&quot;"><e/><f b:x="2"></f></r>`
+	root, err := Parse(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		opts *EncodeOptions
+		want string
+	}{
+		{nil, `<r z="1" b:y="it's" a="&quot;" xmlns="urn:a" xmlns:b="urn:b"><e /><f b:x="2" /></r>`},
+		{
+			&EncodeOptions{AttrOrder: AttrsByName, SelfClose: SelfCloseTight},
+			`<r a="&quot;" b:y="it's" xmlns="urn:a" xmlns:b="urn:b" z="1"><e/><f b:x="2"/></r>`,
+		},
+		{
+			&EncodeOptions{AttrOrder: AttrsNSFirst, SingleQuote: true, SelfClose: SelfCloseNever},
+			`<r xmlns='urn:a' xmlns:b='urn:b' a='"' b:y='it&apos;s' z='1'><e></e><f b:x='2'></f></r>`,
+		},
+		{
+			&EncodeOptions{
+				AttrOrder: AttrsByName, // ignored
+				LessAttr: func(a, b xml.Attr) bool {
+					return a.Name.Space == "xmlns" && b.Name.Space != "xmlns"
+				},
+			},
+			`<r xmlns:b="urn:b" z="1" b:y="it's" a="&quot;" xmlns="urn:a"><e /><f b:x="2" /></r>`,
+		},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := EncodeWithOptions(&buf, root, tt.opts); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%+v:\ngot  %s\nwant %s", tt.opts, buf.String(), tt.want)
+		}
+		again, err := Parse(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !Equal(root, again) {
+			t.Errorf("%+v: output does not round trip", tt.opts)
+		}
+	}
+}
+
+func TestEncoderStream(t *testing.T) {
+	root, err := Parse(strings.NewReader(`<feed xmlns="urn:feed" xmlns:x="urn:x"><entry x:id="1">one</entry></feed>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := &root.Children[0]
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf).SetIndent("", "  ").SetOptions(&EncodeOptions{SelfClose: SelfCloseTight})
+	if err := enc.EncodeStart(root); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := enc.EncodeChild(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.EncodeStart(&Element{StartElement: xml.StartElement{Name: xml.Name{Space: "urn:other", Local: "more"}}, Scope: Scope{ns: []xml.Name{{Space: "urn:other"}}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.EncodeChild(&Element{Type: XML_Comment, Content: " empty "}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := enc.EncodeEnd(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if buf.Len() != 0 {
+		t.Errorf("output written before Flush: %q", buf.String())
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := `<feed xmlns="urn:feed" xmlns:x="urn:x">
+  <entry x:id="1">one</entry>
+  <entry x:id="1">one</entry>
+  <more xmlns="urn:other">
+    <!-- empty -->
+  </more>
+</feed>
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+	if err := enc.EncodeEnd(); err != errNotStarted {
+		t.Errorf("EncodeEnd without EncodeStart: got %v", err)
+	}
+	if err := enc.EncodeChild(entry); err != errNotStarted {
+		t.Errorf("EncodeChild without EncodeStart: got %v", err)
+	}
+}
+
+func TestEncoderDirective(t *testing.T) {
+	const doctype = `DOCTYPE note SYSTEM "note.dtd"`
+	root, err := Parse(strings.NewReader(`<note><to>Tove</to></note>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.Encode(&Element{Type: XML_Directive, Content: doctype}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(root); err != nil {
+		t.Fatal(err)
+	}
+	if want := "<!" + doctype + "><note><to>Tove</to></note>"; buf.String() != want {
+		t.Errorf("got %s, want %s", buf.String(), want)
+	}
+
+	// The directive is read back ahead of the same document.
+	d := xml.NewDecoder(bytes.NewReader(buf.Bytes()))
+	tok, err := d.Token()
+	if dir, ok := tok.(xml.Directive); err != nil || !ok || string(dir) != doctype {
+		t.Errorf("first token %#v, %v", tok, err)
+	}
+	again, err := Parse(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Equal(again, root) {
+		t.Errorf("round trip gave %s", again)
+	}
+}
+
+func TestEscapeAttr(t *testing.T) {
+	const value = "a < b & \"c\"\tline\nnext\r"
+	el := &Element{Type: XML_Tag}
+	el.Name.Local = "e"
+	el.SetAttr("", "v", value)
+	got := el.String()
+	if want := `<e v="a &lt; b &amp; &quot;c&quot;&#x9;line&#xA;next&#xD;" />`; got != want {
+		t.Errorf("got %s, wanted %s", got, want)
+	}
+	// White space written as references survives attribute value
+	// normalization.
+	again := parseDoc(t, []byte(got))
+	if v := again.Attr("", "v"); v != value {
+		t.Errorf("value read back as %q, wanted %q", v, value)
+	}
+}
@@ -0,0 +1,351 @@
+package xmltree
+
+import (
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+)
+
+// A ConflictKind describes the type of a Conflict reported by Merge3.
+type ConflictKind uint8
+
+const (
+	// Both sides changed the text of an element, or the content of
+	// some other node, in different ways.
+	ConflictText ConflictKind = iota
+	// Both sides changed the same attribute in different ways.
+	ConflictAttr
+	// One side deleted a node which the other side modified.
+	ConflictDelete
+	// Both sides inserted different nodes with the same identity.
+	ConflictInsert
+)
+
+var conflictKindNames = [...]string{
+	ConflictText:   "text",
+	ConflictAttr:   "attribute",
+	ConflictDelete: "delete/modify",
+	ConflictInsert: "insert/insert",
+}
+
+func (k ConflictKind) String() string {
+	if int(k) < len(conflictKindNames) {
+		return conflictKindNames[k]
+	}
+	return "ConflictKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// A Conflict is a change made by both sides of a three-way merge which
+// could not be reconciled.
+type Conflict struct {
+	Kind ConflictKind
+	// Path is the location of the conflict, in the XPath-like syntax
+	// used by Diff.
+	Path string
+	// The conflicting nodes of each version. A nil node is absent from
+	// that version. For attribute conflicts these are the elements
+	// owning the attribute.
+	Base, Ours, Theirs *Element
+	// For attribute conflicts, the name of the attribute.
+	Attr xml.Name
+}
+
+func (c Conflict) String() string {
+	if c.Kind == ConflictAttr {
+		return fmt.Sprintf("%s conflict at %s/@%s", c.Kind, c.Path, c.Attr.Local)
+	}
+	return fmt.Sprintf("%s conflict at %s", c.Kind, c.Path)
+}
+
+// MergeOptions controls how Merge3 matches and merges elements.
+type MergeOptions struct {
+	// Identity lists the local names of attributes, such as "id", which
+	// identify an element among its siblings. Elements without an
+	// identity attribute are matched by name and position among
+	// siblings of the same name.
+	Identity []string
+	// Whitespace makes differences in white space significant.
+	Whitespace bool
+	// Theirs resolves conflicts in favor of theirs. By default, the
+	// merged document keeps our version of a conflicting node.
+	Theirs bool
+}
+
+// Merge3 merges the changes made to base by ours and theirs, returning
+// the merged tree and a list of the conflicts found. Changes are merged
+// element by element: attributes and text are merged individually, and
+// children are matched by name and identity. The merged tree keeps the
+// order of children in ours, with nodes inserted by theirs placed after
+// the node preceding them in theirs. Conflicts are resolved in favor of
+// one side, according to opts, which may be nil. None of the arguments
+// are modified.
+func Merge3(base, ours, theirs *Element, opts *MergeOptions) (*Element, []Conflict) {
+	if opts == nil {
+		opts = new(MergeOptions)
+	}
+	m := merger{
+		opts: opts,
+		diff: differ{opts: &DiffOptions{Identity: opts.Identity, Whitespace: opts.Whitespace},
+			sums: make(map[*Element][sha256.Size]byte)},
+	}
+	path := "/" + qualify(&ours.Scope, ours.Name)
+	if base != nil && (base.Name != ours.Name || base.Name != theirs.Name) || ours.Name != theirs.Name {
+		winner := ours
+		if opts.Theirs {
+			winner = theirs
+		}
+		m.conflict(Conflict{Kind: ConflictText, Path: path, Base: base, Ours: ours, Theirs: theirs})
+		return winner.Clone(), m.conflicts
+	}
+	merged := m.element(base, ours, theirs, path, 0)
+	return &merged, m.conflicts
+}
+
+type merger struct {
+	opts      *MergeOptions
+	diff      differ
+	conflicts []Conflict
+}
+
+func (m *merger) conflict(c Conflict) {
+	m.conflicts = append(m.conflicts, c)
+}
+
+// same reports whether two versions of a node are equal. Absent nodes
+// are only equal to each other.
+func (m *merger) same(a, b *Element) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return m.diff.sum(a, 0) == m.diff.sum(b, 0)
+}
+
+// pick returns the version of a node which wins a conflict.
+func (m *merger) pick(ours, theirs *Element) *Element {
+	if m.opts.Theirs {
+		return theirs
+	}
+	return ours
+}
+
+// element merges three versions of an element; base may be nil if the
+// element was added by both sides.
+func (m *merger) element(base, ours, theirs *Element, path string, depth int) Element {
+	merged := *ours
+	merged.Children = nil
+	merged = merged.clone(0)
+	if base == nil {
+		base = &Element{Type: ours.Type, StartElement: xml.StartElement{Name: ours.Name}}
+	}
+	if ours.Type != XML_Tag {
+		merged.Content = m.text(base, ours, theirs, path)
+		return merged
+	}
+	merged.StartElement.Attr = m.attrs(base, ours, theirs, path)
+	if len(ours.Children) == 0 && len(theirs.Children) == 0 && len(base.Children) == 0 {
+		merged.Content = m.text(base, ours, theirs, path)
+		return merged
+	}
+	if depth >= recursionLimit {
+		return m.pick(ours, theirs).clone(0)
+	}
+	merged.Content = ""
+	merged.Children = m.children(split(base), split(ours), split(theirs), &merged, path, depth)
+	return merged
+}
+
+// split returns el with the character data held in its Content moved
+// to a child, so that it can be merged with the children of another
+// version of el.
+func split(el *Element) *Element {
+	if len(el.Children) > 0 || el.Content == "" {
+		return el
+	}
+	c := *el
+	splitContent(&c)
+	return &c
+}
+
+func (m *merger) text(base, ours, theirs *Element, path string) string {
+	b, o, t := m.diff.text(base.Content), m.diff.text(ours.Content), m.diff.text(theirs.Content)
+	switch {
+	case o == t || t == b:
+		return ours.Content
+	case o == b:
+		return theirs.Content
+	}
+	m.conflict(Conflict{Kind: ConflictText, Path: path, Base: base, Ours: ours, Theirs: theirs})
+	return m.pick(ours, theirs).Content
+}
+
+func (m *merger) attrs(base, ours, theirs *Element, path string) []xml.Attr {
+	var merged []xml.Attr
+	var names []xml.Name
+	seen := make(map[xml.Name]bool)
+	for _, el := range []*Element{ours, theirs, base} {
+		for _, a := range el.StartElement.Attr {
+			if !seen[a.Name] {
+				seen[a.Name] = true
+				names = append(names, a.Name)
+			}
+		}
+	}
+	for _, name := range names {
+		b, inBase := attrValue(base, name)
+		o, inOurs := attrValue(ours, name)
+		t, inTheirs := attrValue(theirs, name)
+		value, present := o, inOurs
+		switch {
+		case inOurs == inTheirs && o == t, inTheirs == inBase && t == b:
+		case inOurs == inBase && o == b:
+			value, present = t, inTheirs
+		default:
+			m.conflict(Conflict{Kind: ConflictAttr, Path: path, Attr: name,
+				Base: base, Ours: ours, Theirs: theirs})
+			if m.opts.Theirs {
+				value, present = t, inTheirs
+			}
+		}
+		if present {
+			merged = append(merged, xml.Attr{Name: name, Value: value})
+		}
+	}
+	return merged
+}
+
+// keyed indexes the children of an element which take part in the
+// merge by their key: name and identity, or name and position among
+// siblings of the same name.
+type keyed struct {
+	keys  []string            // per child, "" if skipped
+	nodes map[string]*Element // by key
+	segs  map[string]string   // path segment by key
+}
+
+func (m *merger) index(el *Element) keyed {
+	k := keyed{
+		keys:  make([]string, len(el.Children)),
+		nodes: make(map[string]*Element),
+		segs:  make(map[string]string),
+	}
+	count := make(map[string]int)
+	for _, i := range m.diff.children(el) {
+		c := &el.Children[i]
+		key := m.diff.key(c)
+		seg := ""
+		if _, _, ok := m.diff.identity(c); ok && c.Type == XML_Tag {
+			seg = m.diff.segment(c, 0)
+		} else {
+			name := strconv.Itoa(int(c.Type)) + " " + c.Name.Space + " " + c.Name.Local
+			count[name]++
+			key = name + " #" + strconv.Itoa(count[name])
+			seg = m.diff.segment(c, count[name])
+		}
+		k.keys[i] = key
+		k.nodes[key] = c
+		k.segs[key] = seg
+	}
+	return k
+}
+
+func (m *merger) children(base, ours, theirs, parent *Element, path string, depth int) []Element {
+	kb, ko, kt := m.index(base), m.index(ours), m.index(theirs)
+
+	// take returns the merged form of the node with the given key, or
+	// false if it should be left out.
+	take := func(key string) (Element, bool) {
+		b, o, t := kb.nodes[key], ko.nodes[key], kt.nodes[key]
+		seg := ko.segs[key]
+		if seg == "" {
+			seg = kt.segs[key]
+		}
+		p := path + "/" + seg
+		switch {
+		case o != nil && t != nil:
+			if b == nil && o.Type != XML_Tag && !m.same(o, t) {
+				m.conflict(Conflict{Kind: ConflictInsert, Path: p, Ours: o, Theirs: t})
+				return m.adopt(m.pick(o, t), parent), true
+			}
+			return m.element(b, o, t, p, depth+1), true
+		case o != nil:
+			// Deleted by theirs, or inserted by ours
+			if b == nil || m.same(b, o) {
+				if b == nil {
+					return o.clone(0), true
+				}
+				return Element{}, false
+			}
+			m.conflict(Conflict{Kind: ConflictDelete, Path: p, Base: b, Ours: o})
+			if m.opts.Theirs {
+				return Element{}, false
+			}
+			return o.clone(0), true
+		case t != nil:
+			// Deleted by ours, or inserted by theirs
+			if b == nil {
+				return m.adopt(t, parent), true
+			}
+			if !m.same(b, t) {
+				m.conflict(Conflict{Kind: ConflictDelete, Path: p, Base: b, Theirs: t})
+				if m.opts.Theirs {
+					return m.adopt(t, parent), true
+				}
+			}
+		}
+		return Element{}, false
+	}
+
+	// Nodes inserted by theirs, and nodes theirs modified but ours
+	// deleted, are placed after the node which precedes them in theirs.
+	after := make(map[string][]string)
+	var leading []string
+	prev := ""
+	for _, key := range kt.keys {
+		if key == "" {
+			continue
+		}
+		if ko.nodes[key] == nil {
+			if prev == "" {
+				leading = append(leading, key)
+			} else {
+				after[prev] = append(after[prev], key)
+			}
+			continue
+		}
+		prev = key
+	}
+
+	var merged []Element
+	appendKey := func(key string) {
+		if el, ok := take(key); ok {
+			merged = append(merged, el)
+		}
+		for _, k := range after[key] {
+			if el, ok := take(k); ok {
+				merged = append(merged, el)
+			}
+		}
+	}
+	for _, key := range leading {
+		if el, ok := take(key); ok {
+			merged = append(merged, el)
+		}
+	}
+	for i, key := range ko.keys {
+		if key == "" {
+			// Skipped white space
+			merged = append(merged, ours.Children[i].clone(0))
+			continue
+		}
+		appendKey(key)
+	}
+	return merged
+}
+
+// adopt copies a node from theirs into the scope of a merged parent.
+func (m *merger) adopt(el, parent *Element) Element {
+	c := el.clone(0)
+	c.rescope(parent.Scope)
+	return c
+}
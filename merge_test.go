@@ -0,0 +1,100 @@
+package xmltree
+
+import (
+	"testing"
+)
+
+func TestMerge3(t *testing.T) {
+	base := parseFullDoc(t, []byte(`<config>
+	  <server id="a" port="80"><name>alpha</name></server>
+	  <server id="b" port="80"><name>beta</name></server>
+	  <server id="c" port="80"><name>gamma</name></server>
+	  <note>shared</note>
+	</config>`))
+	ours := parseFullDoc(t, []byte(`<config>
+	  <server id="a" port="8080"><name>alpha</name></server>
+	  <server id="b" port="80"><name>beta</name></server>
+	  <server id="c" port="80"><name>gamma (ours)</name></server>
+	  <note>shared</note>
+	</config>`))
+	theirs := parseFullDoc(t, []byte(`<config>
+	  <server id="a" port="80" tls="on"><name>alpha</name></server>
+	  <server id="d" port="80"><name>delta</name></server>
+	  <server id="c" port="80"><name>gamma (theirs)</name></server>
+	  <note>changed</note>
+	</config>`))
+	before := [3]string{base.String(), ours.String(), theirs.String()}
+
+	merged, conflicts := Merge3(base, ours, theirs, &MergeOptions{Identity: []string{"id"}})
+	if after := [3]string{base.String(), ours.String(), theirs.String()}; after != before {
+		t.Error("Merge3 modified its arguments")
+	}
+	want := parseFullDoc(t, []byte(`<config>
+	  <server id="a" port="8080" tls="on"><name>alpha</name></server>
+	  <server id="d" port="80"><name>delta</name></server>
+	  <server id="c" port="80"><name>gamma (ours)</name></server>
+	  <note>changed</note>
+	</config>`))
+	if !EqualWithOptions(merged, want, &EqualOptions{Ordered: true}) {
+		t.Errorf("merged document:\n%s\nwanted:\n%s", merged, want)
+	}
+	if len(conflicts) != 1 {
+		t.Fatalf("got conflicts %v, wanted 1", conflicts)
+	}
+	c := conflicts[0]
+	if c.Kind != ConflictText || c.Path != `/config/server[@id="c"]/name[1]` {
+		t.Errorf("got conflict %s", c)
+	}
+
+	merged, _ = Merge3(base, ours, theirs, &MergeOptions{Identity: []string{"id"}, Theirs: true})
+	names, err := merged.Query(`server[@id="c"]/name`)
+	if err != nil || len(names) != 1 {
+		t.Fatalf("merged document lost server c: %s", merged)
+	}
+	if name := names[0].Content; name != "gamma (theirs)" {
+		t.Errorf("conflict resolved to %q, wanted theirs", name)
+	}
+}
+
+func TestMerge3Conflicts(t *testing.T) {
+	for _, tt := range []struct {
+		base, ours, theirs string
+		kind               ConflictKind
+		path               string
+	}{
+		{`<a x="1"/>`, `<a x="2"/>`, `<a x="3"/>`, ConflictAttr, `/a`},
+		{`<a><b>1</b></a>`, `<a/>`, `<a><b>2</b></a>`, ConflictDelete, `/a/b[1]`},
+		{`<a><b>1</b></a>`, `<a><b>2</b></a>`, `<a/>`, ConflictDelete, `/a/b[1]`},
+		{`<a/>`, `<a><!--x--></a>`, `<a><!--y--></a>`, ConflictInsert, `/a/comment()[1]`},
+	} {
+		base := parseFullDoc(t, []byte(tt.base))
+		ours := parseFullDoc(t, []byte(tt.ours))
+		theirs := parseFullDoc(t, []byte(tt.theirs))
+		merged, conflicts := Merge3(base, ours, theirs, nil)
+		if len(conflicts) != 1 || conflicts[0].Kind != tt.kind || conflicts[0].Path != tt.path {
+			t.Errorf("Merge3(%s, %s, %s) conflicts = %v, wanted %s at %s",
+				tt.base, tt.ours, tt.theirs, conflicts, tt.kind, tt.path)
+		}
+		if !Equal(merged, ours) {
+			t.Errorf("Merge3(%s, %s, %s) = %s, wanted ours", tt.base, tt.ours, tt.theirs, merged)
+		}
+	}
+}
+
+func TestMerge3Namespaces(t *testing.T) {
+	base := parseFullDoc(t, []byte(`<a xmlns="urn:a"><b/></a>`))
+	ours := parseFullDoc(t, []byte(`<p:a xmlns:p="urn:a"><p:b/></p:a>`))
+	theirs := parseFullDoc(t, []byte(`<a xmlns="urn:a" xmlns:x="urn:x"><b/><x:c/></a>`))
+	merged, conflicts := Merge3(base, ours, theirs, nil)
+	if len(conflicts) != 0 {
+		t.Errorf("unexpected conflicts %v", conflicts)
+	}
+	want := parseFullDoc(t, []byte(`<a xmlns="urn:a"><b/><c xmlns="urn:x"/></a>`))
+	if !Equal(merged, want) {
+		t.Errorf("merged document %s, wanted %s", merged, want)
+	}
+	reparsed := parseFullDoc(t, []byte(merged.String()))
+	if !Equal(reparsed, want) {
+		t.Errorf("merged document does not round trip: %s", merged)
+	}
+}
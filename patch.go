@@ -0,0 +1,586 @@
+package xmltree
+
+import (
+	"crypto/sha256"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A PatchError describes an operation of an XML patch document which
+// could not be applied.
+type PatchError struct {
+	// The operation, one of add, replace or remove.
+	Op string
+	// The selector of the operation.
+	Sel string
+	Err error
+}
+
+func (e *PatchError) Error() string {
+	return fmt.Sprintf("xmltree: patch <%s sel=%q>: %v", e.Op, e.Sel, e.Err)
+}
+
+func (e *PatchError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	ErrPatchNoMatch       = errors.New("selector does not match a node")
+	ErrPatchMultipleMatch = errors.New("selector matches more than one node")
+	ErrPatchInvalid       = errors.New("invalid patch operation")
+)
+
+// ApplyPatch applies the operations of an XML patch document, as
+// described by RFC 5261, to the tree rooted at doc, which is modified in
+// place. The children of patch are <add>, <replace> and <remove>
+// elements, in any namespace, which are applied in order. The sel
+// attribute of each operation is an XPath expression which must select
+// exactly one node; namespace prefixes in it are resolved using the
+// scope of the operation, and unprefixed element names are in the
+// default namespace of the patch document, if there is one.
+//
+// If an operation fails, ApplyPatch returns a *PatchError and doc
+// reflects the operations applied before it.
+func ApplyPatch(doc, patch *Element) error {
+	for i := range patch.Children {
+		op := &patch.Children[i]
+		if op.Type != XML_Tag {
+			continue
+		}
+		if err := applyPatchOp(doc, op); err != nil {
+			return &PatchError{Op: op.Name.Local, Sel: op.Attr("", "sel"), Err: err}
+		}
+	}
+	return nil
+}
+
+func applyPatchOp(doc, op *Element) error {
+	x, err := CompileXPath(op.Attr("", "sel"))
+	if err != nil {
+		return err
+	}
+	c := NewXPathContext(doc)
+	c.Namespaces = op.Scope.namespaces()
+	nodes, err := c.Select(x, c.Root())
+	if err != nil {
+		return err
+	}
+	switch len(nodes) {
+	case 0:
+		return ErrPatchNoMatch
+	case 1:
+	default:
+		return ErrPatchMultipleMatch
+	}
+	target := nodes[0]
+	switch op.Name.Local {
+	case "add":
+		return patchAdd(c, target, op)
+	case "replace":
+		return patchReplace(c, target, op)
+	case "remove":
+		return patchRemove(c, target, op.Attr("", "ws"))
+	}
+	return fmt.Errorf("%w: unknown operation <%s>", ErrPatchInvalid, op.Name.Local)
+}
+
+// patchContent returns copies of the nodes an operation carries.
+func patchContent(op *Element) []Element {
+	if len(op.Children) == 0 {
+		if op.Content == "" {
+			return nil
+		}
+		return []Element{{Type: XML_CharData, Content: op.Content, Scope: op.Scope}}
+	}
+	nodes := make([]Element, len(op.Children))
+	for i := range op.Children {
+		nodes[i] = op.Children[i].clone(0)
+	}
+	return nodes
+}
+
+// childPosition returns the parent of a node which is one of the
+// Children of an element, and its index within them.
+func childPosition(c *XPathContext, n Node) (*Element, int, bool) {
+	if n.Type == DocumentNode || n.Type == AttributeNode || n.Type == NamespaceNode ||
+		n.Type == TextNode && n.Element.Type == XML_Tag {
+		return nil, 0, false
+	}
+	parent, ok := c.parent[n.Element]
+	if !ok {
+		return nil, 0, false
+	}
+	return parent, c.index[n.Element], true
+}
+
+// splitContent moves the Content of an element without children into a
+// character data child, so that other children can be added next to it.
+func splitContent(el *Element) {
+	if len(el.Children) == 0 && el.Content != "" {
+		el.Children = []Element{{Type: XML_CharData, Content: el.Content, Scope: el.Scope}}
+	}
+	el.Content = ""
+}
+
+// insertChildren inserts nodes into the children of parent at index i.
+func insertChildren(parent *Element, i int, nodes []Element) {
+	splitContent(parent)
+	for j := range nodes {
+		nodes[j].rescope(parent.Scope)
+	}
+	children := make([]Element, 0, len(parent.Children)+len(nodes))
+	children = append(children, parent.Children[:i]...)
+	children = append(children, nodes...)
+	parent.Children = append(children, parent.Children[i:]...)
+}
+
+func patchAdd(c *XPathContext, target Node, op *Element) error {
+	if typ := op.Attr("", "type"); typ != "" {
+		if target.Type != ElementNode {
+			return fmt.Errorf("%w: type=%q requires an element", ErrPatchInvalid, typ)
+		}
+		el := target.Element
+		value := op.Text()
+		switch {
+		case strings.HasPrefix(typ, "@"):
+			name := xml.Name{Local: typ[1:]}
+			if strings.Contains(name.Local, ":") {
+				name = op.Resolve(name.Local)
+			}
+			for _, a := range el.StartElement.Attr {
+				if a.Name == name {
+					return fmt.Errorf("%w: attribute %s already exists", ErrPatchInvalid, typ[1:])
+				}
+			}
+			el.StartElement.Attr = append(el.StartElement.Attr, xml.Attr{Name: name, Value: value})
+			return nil
+		case strings.HasPrefix(typ, "namespace::"):
+			prefix := strings.TrimPrefix(typ, "namespace::")
+			for _, n := range c.namespaceNodes(el) {
+				if n.Name().Local == prefix {
+					return fmt.Errorf("%w: namespace prefix %s already declared", ErrPatchInvalid, prefix)
+				}
+			}
+			el.declareNS(xml.Name{Space: value, Local: prefix})
+			return nil
+		}
+		return fmt.Errorf("%w: type=%q", ErrPatchInvalid, typ)
+	}
+
+	nodes := patchContent(op)
+	switch pos := op.Attr("", "pos"); pos {
+	case "", "prepend":
+		if target.Type != ElementNode {
+			return fmt.Errorf("%w: can only add children to an element", ErrPatchInvalid)
+		}
+		splitContent(target.Element)
+		i := len(target.Element.Children)
+		if pos == "prepend" {
+			i = 0
+		}
+		insertChildren(target.Element, i, nodes)
+	case "before", "after":
+		parent, i, ok := childPosition(c, target)
+		if !ok {
+			return fmt.Errorf("%w: cannot add siblings to the document element", ErrPatchInvalid)
+		}
+		if pos == "after" {
+			i++
+		}
+		insertChildren(parent, i, nodes)
+	default:
+		return fmt.Errorf("%w: pos=%q", ErrPatchInvalid, pos)
+	}
+	return nil
+}
+
+// declareNS adds a namespace declaration to an element, which is seen
+// by all of its descendants.
+func (el *Element) declareNS(decl xml.Name) {
+	old := el.Scope.ns
+	ns := append(append(make([]xml.Name, 0, len(old)+1), old...), decl)
+	el.WalkFunc(func(e *Element) error {
+		if len(e.Scope.ns) >= len(old) && equalNames(e.Scope.ns[:len(old)], old) {
+			e.Scope.ns = append(ns[:len(ns):len(ns)], e.Scope.ns[len(old):]...)
+		}
+		return nil
+	})
+	el.Scope.ns = ns[:len(ns):len(ns)]
+}
+
+func equalNames(a, b []xml.Name) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func patchReplace(c *XPathContext, target Node, op *Element) error {
+	switch target.Type {
+	case AttributeNode:
+		target.Attr().Value = op.Text()
+		return nil
+	case NamespaceNode:
+		el, i := target.Element, target.Index
+		old := el.Scope.ns[i]
+		replaced := xml.Name{Space: op.Text(), Local: old.Local}
+		replace := func(e *Element) {
+			if i < len(e.Scope.ns) && e.Scope.ns[i] == old {
+				ns := append([]xml.Name(nil), e.Scope.ns...)
+				ns[i] = replaced
+				e.Scope.ns = ns
+			}
+		}
+		el.WalkFunc(func(e *Element) error {
+			replace(e)
+			return nil
+		})
+		replace(el)
+		return nil
+	case TextNode:
+		target.Element.Content = op.Text()
+		return nil
+	}
+
+	// The replacement is a single node of the same type, ignoring any
+	// white space around it.
+	var repl *Element
+	nodes := patchContent(op)
+	for i := range nodes {
+		if nodes[i].Type == XML_CharData && strings.TrimSpace(nodes[i].Content) == "" {
+			continue
+		}
+		if repl != nil {
+			return fmt.Errorf("%w: replacement must be a single node", ErrPatchInvalid)
+		}
+		repl = &nodes[i]
+	}
+	if repl == nil || c.Node(repl).Type != target.Type {
+		return fmt.Errorf("%w: replacement is not of the same type as the target", ErrPatchInvalid)
+	}
+	parent, i, ok := childPosition(c, target)
+	if !ok {
+		// The document element
+		repl.rescope(Scope{})
+		*target.Element = *repl
+		return nil
+	}
+	repl.rescope(parent.Scope)
+	parent.Children[i] = *repl
+	return nil
+}
+
+func patchRemove(c *XPathContext, target Node, ws string) error {
+	switch target.Type {
+	case AttributeNode:
+		attrs := target.Element.StartElement.Attr
+		target.Element.StartElement.Attr = append(attrs[:target.Index:target.Index], attrs[target.Index+1:]...)
+		return nil
+	case NamespaceNode:
+		el, i := target.Element, target.Index
+		old := el.Scope.ns[i]
+		remove := func(e *Element) {
+			if i < len(e.Scope.ns) && e.Scope.ns[i] == old {
+				ns := append([]xml.Name(nil), e.Scope.ns[:i]...)
+				e.Scope.ns = append(ns, e.Scope.ns[i+1:]...)
+			}
+		}
+		el.WalkFunc(func(e *Element) error {
+			remove(e)
+			return nil
+		})
+		remove(el)
+		return nil
+	case TextNode:
+		if target.Element.Type == XML_Tag {
+			target.Element.Content = ""
+			return nil
+		}
+	}
+	parent, i, ok := childPosition(c, target)
+	if !ok {
+		return fmt.Errorf("%w: cannot remove the document element", ErrPatchInvalid)
+	}
+	first, last := i, i+1
+	isSpace := func(j int) bool {
+		return j >= 0 && j < len(parent.Children) && parent.Children[j].Type == XML_CharData &&
+			strings.TrimSpace(parent.Children[j].Content) == ""
+	}
+	switch ws {
+	case "":
+	case "before":
+		if !isSpace(i - 1) {
+			return fmt.Errorf("%w: no white space before the target", ErrPatchInvalid)
+		}
+		first--
+	case "after":
+		if !isSpace(i + 1) {
+			return fmt.Errorf("%w: no white space after the target", ErrPatchInvalid)
+		}
+		last++
+	case "both":
+		if !isSpace(i-1) || !isSpace(i+1) {
+			return fmt.Errorf("%w: no white space around the target", ErrPatchInvalid)
+		}
+		first--
+		last++
+	default:
+		return fmt.Errorf("%w: ws=%q", ErrPatchInvalid, ws)
+	}
+	children := append([]Element(nil), parent.Children[:first]...)
+	parent.Children = append(children, parent.Children[last:]...)
+	return nil
+}
+
+// GeneratePatch returns an XML patch document, as described by RFC 5261,
+// which transforms a into b when applied with ApplyPatch. Neither tree
+// is modified. The root of the patch is a <diff> element declaring the
+// namespace prefixes used in its selectors. Children are matched as by
+// Diff; opts may be nil. Each operation is checked by applying it to a
+// copy of a, and an error is returned if one cannot be applied.
+func GeneratePatch(a, b *Element, opts *DiffOptions) (*Element, error) {
+	if opts == nil {
+		opts = new(DiffOptions)
+	}
+	g := patchGen{
+		differ: differ{opts: opts, sums: make(map[*Element][sha256.Size]byte)},
+		work:   a.Clone(),
+		prefix: make(map[string]string),
+		used:   make(map[string]bool),
+	}
+	g.diff = &Element{Type: XML_Tag}
+	g.diff.Name.Local = "diff"
+	if a.Name != b.Name {
+		g.emit("replace", "/"+g.qname(a, a.Name), nil, b)
+	} else {
+		g.element(g.work, b, "/"+g.qname(a, a.Name), 0)
+	}
+	if g.err != nil {
+		return nil, g.err
+	}
+
+	g.diff.Scope.ns = g.selectorScope()
+	for i := range g.diff.Children {
+		g.diff.Children[i].rescope(g.diff.Scope)
+	}
+	return g.diff, nil
+}
+
+type patchGen struct {
+	differ
+	diff   *Element
+	work   *Element          // a copy of a, with the patch applied so far
+	prefix map[string]string // namespace to selector prefix
+	used   map[string]bool   // prefixes in use
+	err    error             // the first operation which failed
+}
+
+// qname returns a name used by el as written in a selector. Every
+// namespace is given a prefix, reusing the prefix of the document where
+// possible.
+func (g *patchGen) qname(el *Element, name xml.Name) string {
+	switch name.Space {
+	case "":
+		return name.Local
+	case xmlLangURI:
+		return "xml:" + name.Local
+	}
+	prefix, ok := g.prefix[name.Space]
+	if !ok {
+		if qname := el.Prefix(name); strings.Contains(qname, ":") {
+			prefix = qname[:strings.IndexByte(qname, ':')]
+		}
+		for i := 0; prefix == "" || g.used[prefix]; i++ {
+			prefix = fmt.Sprintf("ns%d", i)
+		}
+		g.prefix[name.Space] = prefix
+		g.used[prefix] = true
+	}
+	return prefix + ":" + name.Local
+}
+
+// childPath returns the selector of the i'th child of el.
+func (g *patchGen) childPath(el *Element, path string, i int) string {
+	child := &el.Children[i]
+	pos := 0
+	for j := 0; j <= i; j++ {
+		c := &el.Children[j]
+		if c.Type == child.Type && c.Name == child.Name || isText(c) && isText(child) {
+			pos++
+		}
+	}
+	switch child.Type {
+	case XML_CharData, XML_CDATA:
+		return fmt.Sprintf("%s/text()[%d]", path, pos)
+	case XML_Comment:
+		return fmt.Sprintf("%s/comment()[%d]", path, pos)
+	case XML_ProcInst:
+		return fmt.Sprintf("%s/processing-instruction('%s')[%d]", path, child.Name.Local, pos)
+	}
+	return fmt.Sprintf("%s/%s[%d]", path, g.qname(child, child.Name), pos)
+}
+
+// emit adds an operation to the patch, and applies it to the working
+// copy so that later selectors see the document as it will be.
+func (g *patchGen) emit(op, sel string, attrs []xml.Attr, content ...*Element) {
+	if g.err != nil {
+		return
+	}
+	el := Element{Type: XML_Tag}
+	el.Name.Local = op
+	el.StartElement.Attr = append([]xml.Attr{{Name: xml.Name{Local: "sel"}, Value: sel}}, attrs...)
+	for _, c := range content {
+		el.Children = append(el.Children, c.clone(0))
+	}
+	if len(el.Children) == 1 && el.Children[0].Type == XML_CharData {
+		el.Content = el.Children[0].Content
+		el.Children = nil
+	}
+	el.Scope.ns = g.selectorScope()
+	// The working copy is about to change, so forget what is known
+	// about its content.
+	g.sums = make(map[*Element][sha256.Size]byte)
+	if err := applyPatchOp(g.work, &el); err != nil {
+		g.err = &PatchError{Op: op, Sel: sel, Err: err}
+		return
+	}
+	g.diff.Children = append(g.diff.Children, el)
+}
+
+func (g *patchGen) selectorScope() []xml.Name {
+	var ns []xml.Name
+	for space, prefix := range g.prefix {
+		ns = append(ns, xml.Name{Space: space, Local: prefix})
+	}
+	sort.Sort(byXMLName(ns))
+	return ns
+}
+
+func textNode(s string) *Element {
+	return &Element{Type: XML_CharData, Content: s}
+}
+
+// element generates the operations which turn the working element a
+// into b.
+func (g *patchGen) element(a, b *Element, path string, depth int) {
+	g.attrs(a, b, path)
+	if len(a.Children) == 0 && len(b.Children) == 0 {
+		if g.text(a.Content) != g.text(b.Content) {
+			switch {
+			case a.Content == "":
+				g.emit("add", path, nil, textNode(b.Content))
+			case b.Content == "":
+				g.emit("remove", path+"/text()", nil)
+			default:
+				g.emit("replace", path+"/text()", nil, textNode(b.Content))
+			}
+		}
+		return
+	}
+	if len(a.Children) == 0 && g.text(a.Content) != "" || len(b.Children) == 0 && g.text(b.Content) != "" ||
+		depth >= recursionLimit {
+		// Text on one side and children on the other
+		g.emit("replace", path, nil, b)
+		return
+	}
+	g.childNodes(a, b, path, depth)
+}
+
+func (g *patchGen) attrs(a, b *Element, path string) {
+	for _, attr := range a.StartElement.Attr {
+		if _, ok := attrValue(b, attr.Name); !ok {
+			g.emit("remove", path+"/@"+g.qname(a, attr.Name), nil)
+		}
+	}
+	for _, attr := range b.StartElement.Attr {
+		v, ok := attrValue(a, attr.Name)
+		switch {
+		case !ok:
+			g.emit("add", path, []xml.Attr{{Name: xml.Name{Local: "type"}, Value: "@" + g.qname(b, attr.Name)}},
+				textNode(attr.Value))
+		case v != attr.Value:
+			g.emit("replace", path+"/@"+g.qname(a, attr.Name), nil, textNode(attr.Value))
+		}
+	}
+}
+
+// attrValue returns the value of the attribute with exactly the given
+// name.
+func attrValue(el *Element, name xml.Name) (string, bool) {
+	for _, a := range el.StartElement.Attr {
+		if a.Name == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+func (g *patchGen) childNodes(a, b *Element, path string, depth int) {
+	m := g.match(a, b, depth)
+
+	// Remove deleted and moved nodes, last first so that the
+	// positions of the remaining nodes do not change.
+	for i := len(m.ca) - 1; i >= 0; i-- {
+		if m.matchA[i] < 0 || m.moved[i] {
+			g.emit("remove", g.childPath(a, path, m.ca[i]), nil)
+		}
+	}
+
+	// What is left of a is in the same order as in b. Walk b, adding
+	// whatever is missing after the previous node.
+	var kept []*Element // b's children which have a counterpart left in a
+	for i := range m.ca {
+		if m.matchA[i] >= 0 && !m.moved[i] {
+			kept = append(kept, &b.Children[m.cb[m.matchA[i]]])
+		}
+	}
+	pos := -1 // index in a.Children of the counterpart of the previous node
+	next := 0 // index in kept of the next counterpart
+	for _, n := range m.cb {
+		y := &b.Children[n]
+		if next < len(kept) && kept[next] == y {
+			pos = g.nextCompared(a, pos)
+			next++
+			continue
+		}
+		if pos < 0 {
+			g.emit("add", path, []xml.Attr{{Name: xml.Name{Local: "pos"}, Value: "prepend"}}, y)
+		} else {
+			g.emit("add", g.childPath(a, path, pos), []xml.Attr{{Name: xml.Name{Local: "pos"}, Value: "after"}}, y)
+		}
+		pos++
+	}
+
+	// With the children lined up, descend into each pair.
+	m = g.match(a, b, depth)
+	for i, n := range m.ca {
+		if k := m.matchA[i]; k >= 0 {
+			x, y := &a.Children[n], &b.Children[m.cb[k]]
+			if x.Type == XML_Tag {
+				g.element(x, y, g.childPath(a, path, n), depth+1)
+			} else if g.text(x.Content) != g.text(y.Content) {
+				g.emit("replace", g.childPath(a, path, n), nil, y)
+			}
+		}
+	}
+}
+
+// nextCompared returns the index of the first child of el after i which
+// takes part in comparisons.
+func (g *patchGen) nextCompared(el *Element, i int) int {
+	for i++; i < len(el.Children); i++ {
+		c := &el.Children[i]
+		if c.Type != XML_CharData || g.text(c.Content) != "" {
+			return i
+		}
+	}
+	return i
+}
@@ -0,0 +1,109 @@
+package xmltree
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestApplyPatch(t *testing.T) {
+	doc := parseFullDoc(t, []byte(`<doc xmlns:x="urn:x">
+	  <note>This is a sample document</note>
+	  <elem a="foo">
+	    <child1/>
+	    <!-- comment -->
+	    <x:child2 id="c2"/>
+	  </elem>
+	</doc>`))
+	patch := parseFullDoc(t, []byte(`<diff xmlns:p="urn:x">
+	  <add sel="doc/elem[@a='foo']"><new id="ert4773">This is a new child</new></add>
+	  <add sel="doc/elem/child1" pos="before"><first/></add>
+	  <add sel="doc/elem" type="@b">bar</add>
+	  <replace sel="doc/elem/@a">baz</replace>
+	  <replace sel="doc/note/text()">This is a patched document</replace>
+	  <replace sel="doc/elem/comment()"><!-- replaced --></replace>
+	  <remove sel="doc/elem/p:child2"/>
+	  <add sel="doc" type="namespace::y">urn:y</add>
+	</diff>`))
+	if err := ApplyPatch(doc, patch); err != nil {
+		t.Fatal(err)
+	}
+	want := parseFullDoc(t, []byte(`<doc xmlns:x="urn:x" xmlns:y="urn:y">
+	  <note>This is a patched document</note>
+	  <elem a="baz" b="bar">
+	    <first/>
+	    <child1/>
+	    <!-- replaced -->
+	    <new id="ert4773">This is a new child</new>
+	  </elem>
+	</doc>`))
+	if !EqualWithOptions(doc, want, &EqualOptions{Ordered: true}) {
+		t.Errorf("got %s", doc)
+	}
+	if name, ok := doc.ResolveNS("y:foo"); !ok || name.Space != "urn:y" {
+		t.Errorf("namespace declaration was not added")
+	}
+
+	for _, tt := range []struct {
+		op  string
+		err error
+	}{
+		{`<remove sel="doc/nothing"/>`, ErrPatchNoMatch},
+		{`<remove sel="doc/elem/*"/>`, ErrPatchMultipleMatch},
+		{`<remove sel="doc"/>`, ErrPatchInvalid},
+		{`<add sel="doc/elem" type="@a">x</add>`, ErrPatchInvalid},
+	} {
+		patch := parseFullDoc(t, []byte(`<diff>`+tt.op+`</diff>`))
+		err := ApplyPatch(doc, patch)
+		var perr *PatchError
+		if !errors.As(err, &perr) || !errors.Is(err, tt.err) {
+			t.Errorf("%s: got error %v, wanted %v", tt.op, err, tt.err)
+		}
+	}
+}
+
+func TestGeneratePatch(t *testing.T) {
+	a := parseFullDoc(t, []byte(ovalSample))
+	b := parseFullDoc(t, []byte(`<oval_definitions xmlns="http://oval.mitre.org/XMLSchema/oval-definitions-5">
+  <definitions>
+    <definition id="def:2" version="2" class="patch"><title>CVE-2</title></definition>
+    <definition id="def:1" version="1">
+      <title>CVE-1 (updated)</title>
+      <criteria operator="OR">
+        <criterion test_ref="tst:3"/>
+        <criterion test_ref="tst:1"/>
+      </criteria>
+    </definition>
+  </definitions>
+  <tests>
+    <test id="tst:1"/>
+    <test id="tst:3"/>
+  </tests>
+</oval_definitions>`))
+	before := a.String()
+	for _, opts := range []*DiffOptions{nil, {Identity: []string{"id"}}} {
+		patch, err := GeneratePatch(a, b, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("%s", MarshalIndent(patch, "", "  "))
+		if a.String() != before {
+			t.Fatal("GeneratePatch modified its argument")
+		}
+
+		// The patch must survive serialization
+		patch = parseFullDoc(t, Marshal(patch))
+		doc := a.Clone()
+		if err := ApplyPatch(doc, patch); err != nil {
+			t.Fatal(err)
+		}
+		if !EqualWithOptions(doc, b, &EqualOptions{Ordered: true}) {
+			t.Errorf("patched document differs:\n%s\nwanted\n%s", doc, b)
+		}
+	}
+
+	if patch, err := GeneratePatch(a, a, nil); err != nil {
+		t.Error(err)
+	} else if len(patch.Children) != 0 {
+		t.Errorf("patch between identical documents is not empty: %s", patch)
+	}
+}
@@ -0,0 +1,662 @@
+package relaxng
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pschou/go-xmltree"
+)
+
+// ParseCompact converts a schema in the RELAX NG compact syntax into the
+// equivalent schema in the XML syntax. Annotations are discarded.
+func ParseCompact(text []byte) (*xmltree.Element, error) {
+	toks, err := lex(string(text))
+	if err != nil {
+		return nil, err
+	}
+	p := &compactParser{
+		toks:     toks,
+		prefixes: map[string]string{"xml": "http://www.w3.org/XML/1998/namespace"},
+		libs:     map[string]string{"xsd": xsdLib},
+	}
+	return p.topLevel()
+}
+
+type tokKind uint8
+
+const (
+	tEOF     tokKind = iota
+	tIdent           // an identifier or keyword
+	tQuoted          // an identifier escaped with \, never a keyword
+	tCName           // prefix:local
+	tNsName          // prefix:*
+	tLiteral         // a string literal
+	tOp              // punctuation
+)
+
+type token struct {
+	kind tokKind
+	text string
+	line int
+}
+
+var escapes = regexp.MustCompile(`\\x+\{([0-9a-fA-F]+)\}`)
+
+func isIdentStart(r rune) bool {
+	return unicode.IsLetter(r) || r == '_'
+}
+
+func isIdentChar(r rune) bool {
+	return isIdentStart(r) || unicode.IsDigit(r) || r == '.' || r == '-' || unicode.Is(unicode.Mn, r) || r == '·'
+}
+
+// lex splits a schema into tokens, dropping comments.
+func lex(s string) ([]token, error) {
+	var err error
+	s = escapes.ReplaceAllStringFunc(s, func(esc string) string {
+		hex := esc[strings.IndexByte(esc, '{')+1 : len(esc)-1]
+		n, e := strconv.ParseUint(hex, 16, 32)
+		if e != nil || !utf8.ValidRune(rune(n)) {
+			err = fmt.Errorf("relaxng: bad escape %s", esc)
+		}
+		return string(rune(n))
+	})
+	if err != nil {
+		return nil, err
+	}
+	var toks []token
+	line := 1
+	ident := func(i int) int {
+		for i < len(s) {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if !isIdentChar(r) {
+				break
+			}
+			i += size
+		}
+		return i
+	}
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '\n':
+			line++
+			i++
+		case unicode.IsSpace(r):
+			i += size
+		case r == '#':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case r == '"' || r == '\'':
+			quote := s[i : i+1]
+			if strings.HasPrefix(s[i:], quote+quote+quote) {
+				quote += quote + quote
+			}
+			end := strings.Index(s[i+len(quote):], quote)
+			if end < 0 || len(quote) == 1 && strings.ContainsAny(s[i+1:i+1+end], "\r\n") {
+				return nil, fmt.Errorf("relaxng: line %d: unterminated literal", line)
+			}
+			value := s[i+len(quote) : i+len(quote)+end]
+			toks = append(toks, token{tLiteral, value, line})
+			line += strings.Count(value, "\n")
+			i += 2*len(quote) + end
+		case r == '\\' || isIdentStart(r):
+			kind, start := tIdent, i
+			if r == '\\' {
+				kind, start = tQuoted, i+1
+			}
+			end := ident(start)
+			if first, _ := utf8.DecodeRuneInString(s[start:]); end == start || !isIdentStart(first) {
+				return nil, fmt.Errorf("relaxng: line %d: unexpected %q", line, r)
+			}
+			tok := token{kind, s[start:end], line}
+			if end < len(s) && s[end] == ':' {
+				if strings.HasPrefix(s[end:], ":*") {
+					tok = token{tNsName, s[start:end], line}
+					end += 2
+				} else if local := ident(end + 1); local > end+1 {
+					tok = token{tCName, s[start:local], line}
+					end = local
+				}
+			}
+			toks = append(toks, tok)
+			i = end
+		default:
+			op := string(r)
+			for _, two := range []string{"|=", "&=", ">>"} {
+				if strings.HasPrefix(s[i:], two) {
+					op = two
+				}
+			}
+			if !strings.Contains("=|&{}()[],?*+-~", op) && len(op) == 1 {
+				return nil, fmt.Errorf("relaxng: line %d: unexpected %q", line, op)
+			}
+			toks = append(toks, token{tOp, op, line})
+			i += len(op)
+		}
+	}
+	return append(toks, token{tEOF, "", line}), nil
+}
+
+type compactParser struct {
+	toks      []token
+	pos       int
+	prefixes  map[string]string
+	libs      map[string]string
+	defaultNS *string
+}
+
+func (p *compactParser) peek() token { return p.toks[p.pos] }
+
+func (p *compactParser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *compactParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("relaxng: line %d: %s", p.peek().line, fmt.Sprintf(format, args...))
+}
+
+// is reports whether the next token is the operator or keyword s.
+func (p *compactParser) is(s string) bool {
+	t := p.peek()
+	return (t.kind == tOp || t.kind == tIdent) && t.text == s
+}
+
+func (p *compactParser) accept(s string) bool {
+	if p.is(s) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *compactParser) expect(s string) error {
+	if !p.accept(s) {
+		return p.errorf("expected %q, found %q", s, p.peek().text)
+	}
+	return nil
+}
+
+func (p *compactParser) literal() (string, error) {
+	t := p.next()
+	if t.kind != tLiteral {
+		return "", p.errorf("expected a literal, found %q", t.text)
+	}
+	s := t.text
+	for p.accept("~") {
+		t := p.next()
+		if t.kind != tLiteral {
+			return "", p.errorf("expected a literal after ~")
+		}
+		s += t.text
+	}
+	return s, nil
+}
+
+// skipAnnotations skips annotations in brackets, and documentation.
+func (p *compactParser) skipAnnotations() {
+	for p.is("[") {
+		depth := 0
+		for {
+			t := p.next()
+			if t.kind == tEOF {
+				return
+			}
+			if t.kind == tOp && t.text == "[" {
+				depth++
+			} else if t.kind == tOp && t.text == "]" {
+				if depth--; depth == 0 {
+					break
+				}
+			}
+		}
+	}
+}
+
+// skipFollowing skips annotations after a pattern, written with >>.
+func (p *compactParser) skipFollowing() {
+	for p.accept(">>") {
+		p.next() // the name of the annotation element
+		p.skipAnnotations()
+	}
+}
+
+func node(local string, attrs ...string) *xmltree.Element {
+	el := &xmltree.Element{Type: xmltree.XML_Tag}
+	el.Name = xml.Name{Space: rngNS, Local: local}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		el.StartElement.Attr = append(el.StartElement.Attr, xml.Attr{Name: xml.Name{Local: attrs[i]}, Value: attrs[i+1]})
+	}
+	return el
+}
+
+func appendChild(parent, child *xmltree.Element) *xmltree.Element {
+	parent.Children = append(parent.Children, *child)
+	return parent
+}
+
+var keywords = map[string]bool{
+	"attribute": true, "default": true, "datatypes": true, "div": true,
+	"element": true, "empty": true, "external": true, "grammar": true,
+	"include": true, "inherit": true, "list": true, "mixed": true,
+	"namespace": true, "notAllowed": true, "parent": true, "start": true,
+	"string": true, "text": true, "token": true,
+}
+
+func (p *compactParser) topLevel() (*xmltree.Element, error) {
+	for {
+		p.skipAnnotations()
+		switch {
+		case p.accept("namespace"):
+			if err := p.namespaceDecl(false); err != nil {
+				return nil, err
+			}
+		case p.is("default") && p.toks[p.pos+1].text == "namespace":
+			p.next()
+			p.next()
+			if err := p.namespaceDecl(true); err != nil {
+				return nil, err
+			}
+		case p.accept("datatypes"):
+			prefix := p.next().text
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			uri, err := p.literal()
+			if err != nil {
+				return nil, err
+			}
+			p.libs[prefix] = uri
+		default:
+			var root *xmltree.Element
+			var err error
+			if p.grammarContentNext() {
+				root = node("grammar")
+				err = p.grammarContent(root, "")
+			} else if root, err = p.pattern(); err == nil && p.peek().kind != tEOF {
+				err = p.errorf("unexpected %q after the pattern", p.peek().text)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if p.defaultNS != nil {
+				root.StartElement.Attr = append(root.StartElement.Attr, xml.Attr{Name: xml.Name{Local: "ns"}, Value: *p.defaultNS})
+			}
+			return root, nil
+		}
+	}
+}
+
+func (p *compactParser) namespaceDecl(isDefault bool) error {
+	prefix := ""
+	if t := p.peek(); t.kind == tIdent || t.kind == tQuoted {
+		prefix = p.next().text
+	}
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	if p.is("inherit") {
+		return p.errorf("namespace inherit is not supported")
+	}
+	uri, err := p.literal()
+	if err != nil {
+		return err
+	}
+	if prefix != "" {
+		p.prefixes[prefix] = uri
+	}
+	if isDefault {
+		p.defaultNS = &uri
+	}
+	return nil
+}
+
+// grammarContentNext reports whether definitions follow, rather than a
+// pattern.
+func (p *compactParser) grammarContentNext() bool {
+	t := p.peek()
+	switch {
+	case t.kind == tEOF:
+		return true
+	case t.kind == tIdent && (t.text == "start" || t.text == "div" || t.text == "include"):
+		return true
+	case t.kind == tIdent && keywords[t.text]:
+		return false
+	case t.kind == tIdent || t.kind == tQuoted:
+		next := p.toks[p.pos+1]
+		return next.kind == tOp && (next.text == "=" || next.text == "|=" || next.text == "&=")
+	}
+	return false
+}
+
+// grammarContent reads definitions into parent until the given closing
+// operator, or the end of the schema.
+func (p *compactParser) grammarContent(parent *xmltree.Element, end string) error {
+	for {
+		p.skipAnnotations()
+		if end != "" && p.accept(end) {
+			return nil
+		}
+		t := p.peek()
+		switch {
+		case t.kind == tEOF:
+			if end != "" {
+				return p.errorf("expected %q", end)
+			}
+			return nil
+		case p.accept("div"):
+			div := node("div")
+			if err := p.expect("{"); err != nil {
+				return err
+			}
+			if err := p.grammarContent(div, "}"); err != nil {
+				return err
+			}
+			appendChild(parent, div)
+		case p.accept("include"):
+			href, err := p.literal()
+			if err != nil {
+				return err
+			}
+			if p.is("inherit") {
+				return p.errorf("inherit is not supported")
+			}
+			include := node("include", "href", href)
+			if p.accept("{") {
+				if err := p.grammarContent(include, "}"); err != nil {
+					return err
+				}
+			}
+			appendChild(parent, include)
+		case t.kind == tIdent && t.text == "start" || t.kind == tIdent && !keywords[t.text] || t.kind == tQuoted:
+			p.next()
+			def := node("define", "name", t.text)
+			if t.kind == tIdent && t.text == "start" {
+				def = node("start")
+			}
+			switch op := p.next(); op.text {
+			case "=":
+			case "|=":
+				def.StartElement.Attr = append(def.StartElement.Attr, xml.Attr{Name: xml.Name{Local: "combine"}, Value: "choice"})
+			case "&=":
+				def.StartElement.Attr = append(def.StartElement.Attr, xml.Attr{Name: xml.Name{Local: "combine"}, Value: "interleave"})
+			default:
+				return p.errorf("expected =, |= or &= after %s", t.text)
+			}
+			body, err := p.pattern()
+			if err != nil {
+				return err
+			}
+			appendChild(parent, appendChild(def, body))
+		default:
+			return p.errorf("unexpected %q in grammar", t.text)
+		}
+	}
+}
+
+// pattern reads a pattern, with its binary operators.
+func (p *compactParser) pattern() (*xmltree.Element, error) {
+	first, err := p.particle()
+	if err != nil {
+		return nil, err
+	}
+	var op string
+	for _, o := range []string{",", "&", "|"} {
+		if p.is(o) {
+			op = o
+		}
+	}
+	if op == "" {
+		return first, nil
+	}
+	container := node(map[string]string{",": "group", "&": "interleave", "|": "choice"}[op])
+	appendChild(container, first)
+	for p.accept(op) {
+		q, err := p.particle()
+		if err != nil {
+			return nil, err
+		}
+		appendChild(container, q)
+	}
+	for _, o := range []string{",", "&", "|"} {
+		if p.is(o) {
+			return nil, p.errorf("mixed operators %s and %s need parentheses", op, o)
+		}
+	}
+	return container, nil
+}
+
+func (p *compactParser) particle() (*xmltree.Element, error) {
+	p.skipAnnotations()
+	q, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range []string{"?", "*", "+"} {
+		if p.accept(o) {
+			q = appendChild(node(map[string]string{"?": "optional", "*": "zeroOrMore", "+": "oneOrMore"}[o]), q)
+			break
+		}
+	}
+	p.skipFollowing()
+	return q, nil
+}
+
+// block reads a pattern in braces.
+func (p *compactParser) block(parent *xmltree.Element) (*xmltree.Element, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	q, err := p.pattern()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+	return appendChild(parent, q), nil
+}
+
+func (p *compactParser) primary() (*xmltree.Element, error) {
+	t := p.peek()
+	if t.kind == tIdent {
+		switch t.text {
+		case "element", "attribute":
+			p.next()
+			el := node(t.text)
+			nc, err := p.nameClass(t.text == "attribute")
+			if err != nil {
+				return nil, err
+			}
+			appendChild(el, nc)
+			return p.block(el)
+		case "list", "mixed":
+			p.next()
+			return p.block(node(t.text))
+		case "parent":
+			p.next()
+			name := p.next()
+			if name.kind != tIdent && name.kind != tQuoted {
+				return nil, p.errorf("expected a name after parent")
+			}
+			return node("parentRef", "name", name.text), nil
+		case "empty", "text", "notAllowed":
+			p.next()
+			return node(t.text), nil
+		case "external":
+			p.next()
+			href, err := p.literal()
+			if err != nil {
+				return nil, err
+			}
+			return node("externalRef", "href", href), nil
+		case "grammar":
+			p.next()
+			g := node("grammar")
+			if err := p.expect("{"); err != nil {
+				return nil, err
+			}
+			return g, p.grammarContent(g, "}")
+		case "string", "token":
+			p.next()
+			return p.datatype(t.text, "")
+		}
+	}
+	switch t.kind {
+	case tOp:
+		if p.accept("(") {
+			q, err := p.pattern()
+			if err != nil {
+				return nil, err
+			}
+			return q, p.expect(")")
+		}
+	case tIdent, tQuoted:
+		if !keywords[t.text] || t.kind == tQuoted {
+			p.next()
+			return node("ref", "name", t.text), nil
+		}
+	case tCName:
+		p.next()
+		i := strings.IndexByte(t.text, ':')
+		lib, ok := p.libs[t.text[:i]]
+		if !ok {
+			return nil, p.errorf("undeclared datatypes prefix %s", t.text[:i])
+		}
+		return p.datatype(t.text[i+1:], lib)
+	case tLiteral:
+		s, err := p.literal()
+		if err != nil {
+			return nil, err
+		}
+		v := node("value")
+		v.Content = s
+		return v, nil
+	}
+	return nil, p.errorf("unexpected %q", t.text)
+}
+
+// datatype reads the rest of a data or value pattern, after the name of
+// the datatype.
+func (p *compactParser) datatype(name, lib string) (*xmltree.Element, error) {
+	if p.peek().kind == tLiteral {
+		s, err := p.literal()
+		if err != nil {
+			return nil, err
+		}
+		v := node("value", "type", name, "datatypeLibrary", lib)
+		v.Content = s
+		return v, nil
+	}
+	data := node("data", "type", name, "datatypeLibrary", lib)
+	if p.accept("{") {
+		for !p.accept("}") {
+			p.skipAnnotations()
+			t := p.next()
+			if t.kind != tIdent && t.kind != tQuoted {
+				return nil, p.errorf("expected a parameter name")
+			}
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			s, err := p.literal()
+			if err != nil {
+				return nil, err
+			}
+			param := node("param", "name", t.text)
+			param.Content = s
+			appendChild(data, param)
+		}
+	}
+	if p.accept("-") {
+		except, err := p.primary()
+		if err != nil {
+			return nil, err
+		}
+		appendChild(data, appendChild(node("except"), except))
+	}
+	return data, nil
+}
+
+// nameClass reads a name class. Names without a prefix are in the
+// default namespace for elements, and in no namespace for attributes.
+func (p *compactParser) nameClass(attribute bool) (*xmltree.Element, error) {
+	first, err := p.nameClassPrimary(attribute)
+	if err != nil {
+		return nil, err
+	}
+	if !p.is("|") {
+		return first, nil
+	}
+	choice := appendChild(node("choice"), first)
+	for p.accept("|") {
+		q, err := p.nameClassPrimary(attribute)
+		if err != nil {
+			return nil, err
+		}
+		appendChild(choice, q)
+	}
+	return choice, nil
+}
+
+func (p *compactParser) nameClassPrimary(attribute bool) (*xmltree.Element, error) {
+	p.skipAnnotations()
+	t := p.next()
+	switch t.kind {
+	case tIdent, tQuoted:
+		name := node("name")
+		if attribute {
+			name = node("name", "ns", "")
+		}
+		name.Content = t.text
+		return name, nil
+	case tCName:
+		i := strings.IndexByte(t.text, ':')
+		uri, ok := p.prefixes[t.text[:i]]
+		if !ok {
+			return nil, p.errorf("undeclared namespace prefix %s", t.text[:i])
+		}
+		name := node("name", "ns", uri)
+		name.Content = t.text[i+1:]
+		return name, nil
+	case tNsName:
+		uri, ok := p.prefixes[t.text]
+		if !ok {
+			return nil, p.errorf("undeclared namespace prefix %s", t.text)
+		}
+		return p.except(node("nsName", "ns", uri), attribute)
+	case tOp:
+		switch t.text {
+		case "*":
+			return p.except(node("anyName"), attribute)
+		case "(":
+			nc, err := p.nameClass(attribute)
+			if err != nil {
+				return nil, err
+			}
+			return nc, p.expect(")")
+		}
+	}
+	return nil, p.errorf("unexpected %q in name class", t.text)
+}
+
+func (p *compactParser) except(nc *xmltree.Element, attribute bool) (*xmltree.Element, error) {
+	if !p.accept("-") {
+		return nc, nil
+	}
+	except, err := p.nameClassPrimary(attribute)
+	if err != nil {
+		return nil, err
+	}
+	return appendChild(nc, appendChild(node("except"), except)), nil
+}
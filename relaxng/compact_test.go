@@ -0,0 +1,61 @@
+package relaxng
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCompact(t *testing.T) {
+	tests := []struct {
+		schema, doc string
+		valid       bool
+	}{
+		{`element a { empty }`, `<a/>`, true},
+		{`element \element { text }`, `<element>x</element>`, true},
+		{`element a { "x\x{79}z" }`, `<a>xyz</a>`, true},
+		{`element a { '''one
+two''' ~ "!" }`, "<a>one\ntwo!</a>", true},
+		{`namespace p = "urn:p" element p:a { attribute p:b { string } }`, `<a xmlns="urn:p" xmlns:q="urn:p" q:b=""/>`, true},
+		{`namespace p = "urn:p" element p:a { attribute p:b { string } }`, `<a xmlns="urn:p" b=""/>`, false},
+		{`element a { element (b | c) { empty }+ }`, `<a><c/><b/></a>`, true},
+		{`element a { xsd:int - ("1" | "2") }`, `<a>2</a>`, false},
+		{`element a { xsd:int - ("1" | "2") }`, `<a>3</a>`, true},
+		{`grammar { start = element a { parent x } } x = empty`, `<a/>`, false},
+		{`start = grammar { start = element a { parent x } } x = text`, `<a>t</a>`, true},
+		{`start = element a { x } x &= element b { empty } x &= element c { empty }`, `<a><c/><b/></a>`, true},
+		{`start = a div { a = element a { empty } }`, `<a/>`, true},
+	}
+	for _, tt := range tests {
+		s, err := CompileCompact([]byte(tt.schema), nil)
+		if err != nil {
+			if tt.valid {
+				t.Errorf("%s: %v", tt.schema, err)
+			}
+			continue
+		}
+		err = s.Validate(mustParse(t, tt.doc))
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: %s: valid is %v, want %v (%v)", tt.schema, tt.doc, err == nil, tt.valid, err)
+		}
+	}
+}
+
+func TestParseCompactErrors(t *testing.T) {
+	tests := []struct {
+		schema, err string
+	}{
+		{`element a { empty, text | empty }`, "line 1: mixed operators , and | need parentheses"},
+		{`element a {
+			"unterminated }`, "line 2: unterminated literal"},
+		{`element p:a { empty }`, "undeclared namespace prefix p"},
+		{`element a { y:int }`, "undeclared datatypes prefix y"},
+		{`start = element a { empty`, `expected "}"`},
+		{`element a { empty } extra`, `unexpected "extra" after the pattern`},
+	}
+	for _, tt := range tests {
+		_, err := ParseCompact([]byte(tt.schema))
+		if err == nil || !strings.HasPrefix(err.Error(), "relaxng: ") || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.schema, err, tt.err)
+		}
+	}
+}
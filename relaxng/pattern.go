@@ -0,0 +1,382 @@
+package relaxng
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"github.com/pschou/go-xmltree"
+	"github.com/pschou/go-xmltree/xsd"
+)
+
+// The kinds of pattern, after simplification.
+type kind uint8
+
+const (
+	pNotAllowed kind = iota
+	pEmpty
+	pText
+	pChoice
+	pInterleave
+	pGroup
+	pOneOrMore
+	pList
+	pData
+	pValue
+	pAttribute
+	pElement
+	pAfter
+)
+
+// A pattern is a simplified RELAX NG pattern. Validation follows the
+// algorithm of James Clark, taking the derivative of a pattern with
+// respect to each part of the document in turn.
+type pattern struct {
+	kind     kind
+	p1, p2   *pattern // p1 is the content of an element, attribute or list, and the except of data
+	nc       *nameClass
+	dt       *datatype
+	value    string
+	scope    *xmltree.Scope // the context of a value
+	nullable bool
+}
+
+var (
+	notAllowed = &pattern{kind: pNotAllowed}
+	empty      = &pattern{kind: pEmpty, nullable: true}
+	text       = &pattern{kind: pText, nullable: true}
+)
+
+// A builder makes patterns, sharing those built from the same parts so
+// that choices between equal patterns collapse.
+type builder struct {
+	shared map[patternKey]*pattern
+}
+
+type patternKey struct {
+	kind   kind
+	p1, p2 *pattern
+}
+
+func newBuilder() *builder {
+	return &builder{shared: make(map[patternKey]*pattern)}
+}
+
+func (b *builder) make(k kind, p1, p2 *pattern, nullable bool) *pattern {
+	key := patternKey{k, p1, p2}
+	if p, ok := b.shared[key]; ok {
+		return p
+	}
+	p := &pattern{kind: k, p1: p1, p2: p2, nullable: nullable}
+	b.shared[key] = p
+	return p
+}
+
+// inChoice reports whether p is one of the alternatives of choice c.
+func inChoice(c, p *pattern) bool {
+	for c.kind == pChoice {
+		if c.p2 == p || inChoice(c.p2, p) {
+			return true
+		}
+		c = c.p1
+	}
+	return c == p
+}
+
+func (b *builder) choice(p1, p2 *pattern) *pattern {
+	switch {
+	case p1 == notAllowed:
+		return p2
+	case p2 == notAllowed, inChoice(p1, p2):
+		return p1
+	case inChoice(p2, p1):
+		return p2
+	}
+	return b.make(pChoice, p1, p2, p1.nullable || p2.nullable)
+}
+
+func (b *builder) group(p1, p2 *pattern) *pattern {
+	switch {
+	case p1 == notAllowed || p2 == notAllowed:
+		return notAllowed
+	case p1 == empty:
+		return p2
+	case p2 == empty:
+		return p1
+	}
+	return b.make(pGroup, p1, p2, p1.nullable && p2.nullable)
+}
+
+func (b *builder) interleave(p1, p2 *pattern) *pattern {
+	switch {
+	case p1 == notAllowed || p2 == notAllowed:
+		return notAllowed
+	case p1 == empty:
+		return p2
+	case p2 == empty:
+		return p1
+	}
+	return b.make(pInterleave, p1, p2, p1.nullable && p2.nullable)
+}
+
+func (b *builder) after(p1, p2 *pattern) *pattern {
+	if p1 == notAllowed || p2 == notAllowed {
+		return notAllowed
+	}
+	return b.make(pAfter, p1, p2, false)
+}
+
+func (b *builder) oneOrMore(p *pattern) *pattern {
+	if p == notAllowed || p == empty {
+		return p
+	}
+	return b.make(pOneOrMore, p, nil, p.nullable)
+}
+
+func (b *builder) list(p *pattern) *pattern {
+	if p == notAllowed {
+		return p
+	}
+	return b.make(pList, p, nil, false)
+}
+
+// A nameClass is a set of names of elements or attributes.
+type nameClass struct {
+	kind ncKind
+	name xml.Name   // the name, or only the namespace for nsName
+	a, b *nameClass // the alternatives of a choice; a is the except of anyName and nsName
+}
+
+type ncKind uint8
+
+const (
+	ncName ncKind = iota
+	ncNsName
+	ncAnyName
+	ncChoice
+)
+
+func (nc *nameClass) contains(n xml.Name) bool {
+	switch nc.kind {
+	case ncName:
+		return nc.name == n
+	case ncNsName:
+		return nc.name.Space == n.Space && (nc.a == nil || !nc.a.contains(n))
+	case ncAnyName:
+		return nc.a == nil || !nc.a.contains(n)
+	}
+	return nc.a.contains(n) || nc.b.contains(n)
+}
+
+// names appends a description of the names in nc to list.
+func (nc *nameClass) names(list []string) []string {
+	switch nc.kind {
+	case ncName:
+		return append(list, nc.name.Local)
+	case ncNsName:
+		return append(list, "{"+nc.name.Space+"}*")
+	case ncAnyName:
+		return append(list, "*")
+	}
+	return nc.b.names(nc.a.names(list))
+}
+
+// A datatype is a datatype from a datatype library: the built-in one,
+// which has the types string and token, or that of XML Schema.
+type datatype struct {
+	name string
+	xsd  *xsd.Datatype // nil for the built-in library
+}
+
+func (dt *datatype) allows(s string, scope *xmltree.Scope) bool {
+	return dt.xsd == nil || dt.xsd.Validate(s, scope) == nil
+}
+
+func (dt *datatype) equal(a string, ascope *xmltree.Scope, b string, bscope *xmltree.Scope) bool {
+	switch {
+	case dt.xsd != nil:
+		return dt.xsd.Validate(b, bscope) == nil && dt.xsd.Equal(a, ascope, b, bscope)
+	case dt.name == "token":
+		return strings.Join(strings.Fields(a), " ") == strings.Join(strings.Fields(b), " ")
+	}
+	return a == b
+}
+
+// An attr is an attribute of the element being validated.
+type attr struct {
+	name  xml.Name
+	value string
+	scope *xmltree.Scope
+}
+
+func (b *builder) applyAfter(p *pattern, f func(*pattern) *pattern) *pattern {
+	switch p.kind {
+	case pAfter:
+		return b.after(p.p1, f(p.p2))
+	case pChoice:
+		return b.choice(b.applyAfter(p.p1, f), b.applyAfter(p.p2, f))
+	}
+	return notAllowed
+}
+
+func (b *builder) startTagOpenDeriv(p *pattern, name xml.Name) *pattern {
+	switch p.kind {
+	case pChoice:
+		return b.choice(b.startTagOpenDeriv(p.p1, name), b.startTagOpenDeriv(p.p2, name))
+	case pElement:
+		if p.nc.contains(name) {
+			return b.after(p.p1, empty)
+		}
+	case pInterleave:
+		return b.choice(
+			b.applyAfter(b.startTagOpenDeriv(p.p1, name), func(q *pattern) *pattern { return b.interleave(q, p.p2) }),
+			b.applyAfter(b.startTagOpenDeriv(p.p2, name), func(q *pattern) *pattern { return b.interleave(p.p1, q) }))
+	case pOneOrMore:
+		return b.applyAfter(b.startTagOpenDeriv(p.p1, name), func(q *pattern) *pattern {
+			return b.group(q, b.choice(p, empty))
+		})
+	case pGroup:
+		x := b.applyAfter(b.startTagOpenDeriv(p.p1, name), func(q *pattern) *pattern { return b.group(q, p.p2) })
+		if p.p1.nullable {
+			return b.choice(x, b.startTagOpenDeriv(p.p2, name))
+		}
+		return x
+	case pAfter:
+		return b.applyAfter(b.startTagOpenDeriv(p.p1, name), func(q *pattern) *pattern { return b.after(q, p.p2) })
+	}
+	return notAllowed
+}
+
+func (b *builder) attDeriv(p *pattern, a *attr) *pattern {
+	switch p.kind {
+	case pAfter:
+		return b.after(b.attDeriv(p.p1, a), p.p2)
+	case pChoice:
+		return b.choice(b.attDeriv(p.p1, a), b.attDeriv(p.p2, a))
+	case pGroup:
+		return b.choice(b.group(b.attDeriv(p.p1, a), p.p2), b.group(p.p1, b.attDeriv(p.p2, a)))
+	case pInterleave:
+		return b.choice(b.interleave(b.attDeriv(p.p1, a), p.p2), b.interleave(p.p1, b.attDeriv(p.p2, a)))
+	case pOneOrMore:
+		return b.group(b.attDeriv(p.p1, a), b.choice(p, empty))
+	case pAttribute:
+		if p.nc.contains(a.name) && b.valueMatch(p.p1, a.value, a.scope) {
+			return empty
+		}
+	}
+	return notAllowed
+}
+
+func (b *builder) valueMatch(p *pattern, s string, scope *xmltree.Scope) bool {
+	return p.nullable && strings.TrimSpace(s) == "" || b.textDeriv(p, s, scope).nullable
+}
+
+// startTagCloseDeriv removes the attributes remaining in p. If recover
+// is set, they are treated as optional.
+func (b *builder) startTagCloseDeriv(p *pattern, recover bool) *pattern {
+	switch p.kind {
+	case pAfter:
+		return b.after(b.startTagCloseDeriv(p.p1, recover), p.p2)
+	case pChoice:
+		return b.choice(b.startTagCloseDeriv(p.p1, recover), b.startTagCloseDeriv(p.p2, recover))
+	case pGroup:
+		return b.group(b.startTagCloseDeriv(p.p1, recover), b.startTagCloseDeriv(p.p2, recover))
+	case pInterleave:
+		return b.interleave(b.startTagCloseDeriv(p.p1, recover), b.startTagCloseDeriv(p.p2, recover))
+	case pOneOrMore:
+		return b.oneOrMore(b.startTagCloseDeriv(p.p1, recover))
+	case pAttribute:
+		if recover {
+			return empty
+		}
+		return notAllowed
+	}
+	return p
+}
+
+func (b *builder) textDeriv(p *pattern, s string, scope *xmltree.Scope) *pattern {
+	switch p.kind {
+	case pChoice:
+		return b.choice(b.textDeriv(p.p1, s, scope), b.textDeriv(p.p2, s, scope))
+	case pInterleave:
+		return b.choice(b.interleave(b.textDeriv(p.p1, s, scope), p.p2), b.interleave(p.p1, b.textDeriv(p.p2, s, scope)))
+	case pGroup:
+		q := b.group(b.textDeriv(p.p1, s, scope), p.p2)
+		if p.p1.nullable {
+			return b.choice(q, b.textDeriv(p.p2, s, scope))
+		}
+		return q
+	case pAfter:
+		return b.after(b.textDeriv(p.p1, s, scope), p.p2)
+	case pOneOrMore:
+		return b.group(b.textDeriv(p.p1, s, scope), b.choice(p, empty))
+	case pText:
+		return text
+	case pValue:
+		if p.dt.equal(p.value, p.scope, s, scope) {
+			return empty
+		}
+	case pData:
+		if p.dt.allows(s, scope) && (p.p1 == nil || !b.textDeriv(p.p1, s, scope).nullable) {
+			return empty
+		}
+	case pList:
+		q := p.p1
+		for _, word := range strings.Fields(s) {
+			q = b.textDeriv(q, word, scope)
+		}
+		if q.nullable {
+			return empty
+		}
+	}
+	return notAllowed
+}
+
+// endTagDeriv ends the content of an element. If recover is set, the
+// content is treated as complete.
+func (b *builder) endTagDeriv(p *pattern, recover bool) *pattern {
+	switch p.kind {
+	case pChoice:
+		return b.choice(b.endTagDeriv(p.p1, recover), b.endTagDeriv(p.p2, recover))
+	case pAfter:
+		if recover || p.p1.nullable {
+			return p.p2
+		}
+	}
+	return notAllowed
+}
+
+// front calls fn for each pattern which could match the next part of
+// the content of an element.
+func front(p *pattern, fn func(*pattern)) {
+	switch p.kind {
+	case pChoice, pInterleave:
+		front(p.p1, fn)
+		front(p.p2, fn)
+	case pGroup:
+		front(p.p1, fn)
+		if p.p1.nullable {
+			front(p.p2, fn)
+		}
+	case pOneOrMore, pAfter:
+		front(p.p1, fn)
+	default:
+		fn(p)
+	}
+}
+
+// attributes calls fn for each attribute pattern which could match an
+// attribute of the element, and reports whether it is required.
+func attributes(p *pattern, required bool, fn func(p *pattern, required bool)) {
+	switch p.kind {
+	case pChoice:
+		attributes(p.p1, required && !p.p2.nullable, fn)
+		attributes(p.p2, required && !p.p1.nullable, fn)
+	case pGroup, pInterleave:
+		attributes(p.p1, required, fn)
+		attributes(p.p2, required, fn)
+	case pOneOrMore, pAfter:
+		attributes(p.p1, required, fn)
+	case pAttribute:
+		fn(p, required)
+	}
+}
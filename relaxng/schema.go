@@ -0,0 +1,567 @@
+// Package relaxng validates trees of xmltree Elements against RELAX NG
+// schemas, written in either the XML syntax or the compact syntax.
+//
+// Schemas are simplified as described by the RELAX NG specification,
+// including grammars, combined definitions, include and externalRef,
+// and then validated by taking derivatives of patterns, which handles
+// interleave and ambiguous choices without backtracking. Datatypes are
+// those of the built-in library, string and token, and those of XML
+// Schema, as implemented by the xsd package.
+package relaxng
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/pschou/go-xmltree"
+	"github.com/pschou/go-xmltree/internal/validation"
+	"github.com/pschou/go-xmltree/xsd"
+)
+
+const (
+	rngNS  = "http://relaxng.org/ns/structure/1.0"
+	xsdLib = "http://www.w3.org/2001/XMLSchema-datatypes"
+)
+
+// Options controls how schemas are found.
+type Options struct {
+	// Load returns the schema named by the href attribute of an
+	// include or externalRef element, as written. Schemas in the
+	// compact syntax may be converted with ParseCompact. If Load is
+	// nil, include and externalRef are errors.
+	Load func(href string) (*xmltree.Element, error)
+}
+
+// A Schema is a compiled RELAX NG schema. A Schema may be used by
+// several goroutines at once.
+type Schema struct {
+	start *pattern
+}
+
+// Compile compiles a RELAX NG schema in the XML syntax, along with the
+// schemas it includes and refers to. A nil opts is the same as a zero
+// Options.
+func Compile(doc *xmltree.Element, opts *Options) (*Schema, error) {
+	c := &compiler{b: newBuilder(), loading: make(map[string]bool), external: make(map[[2]string]*pattern)}
+	if opts != nil {
+		c.opts = *opts
+	}
+	start, err := c.pattern(doc, context{})
+	if err != nil {
+		return nil, err
+	}
+	// Compile the content of elements, which may refer to themselves.
+	for len(c.pending) > 0 {
+		e := c.pending[0]
+		c.pending = c.pending[1:]
+		content, err := c.group(e.content, e.ctx)
+		if err != nil {
+			return nil, err
+		}
+		e.p.p1 = content
+	}
+	return &Schema{start: start}, nil
+}
+
+// CompileCompact compiles a RELAX NG schema in the compact syntax.
+func CompileCompact(text []byte, opts *Options) (*Schema, error) {
+	doc, err := ParseCompact(text)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(doc, opts)
+}
+
+type compiler struct {
+	opts     Options
+	b        *builder
+	pending  []pendingElement
+	loading  map[string]bool        // schemas being loaded, to detect loops
+	external map[[2]string]*pattern // schemas referred to, by href and ns
+}
+
+// A pendingElement is an element pattern whose content is yet to be
+// compiled.
+type pendingElement struct {
+	p       *pattern
+	content []*xmltree.Element
+	ctx     context
+}
+
+// A context holds the values inherited by a pattern from its ancestors.
+type context struct {
+	ns  string   // the ns attribute
+	lib string   // the datatypeLibrary attribute
+	g   *grammar // the innermost grammar
+}
+
+// in returns the context within el.
+func (ctx context) in(el *xmltree.Element) context {
+	if ns, ok := validation.AttrValue(el, "ns"); ok {
+		ctx.ns = ns
+	}
+	if lib, ok := validation.AttrValue(el, "datatypeLibrary"); ok {
+		ctx.lib = lib
+	}
+	return ctx
+}
+
+// A grammar holds the definitions of a grammar element.
+type grammar struct {
+	parent  *grammar
+	defines map[string][]component // "" is the start
+	done    map[string]*pattern
+	active  map[string]bool // definitions being compiled
+}
+
+// A component is a start or define element, and the context it is in.
+type component struct {
+	el  *xmltree.Element
+	ctx context
+}
+
+// children returns the child elements of el in the RELAX NG namespace.
+func children(el *xmltree.Element) []*xmltree.Element {
+	var list []*xmltree.Element
+	for i := range el.Children {
+		c := &el.Children[i]
+		if c.Type == xmltree.XML_Tag && c.Name.Space == rngNS {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// content returns the text within el, with surrounding white space
+// removed.
+func content(el *xmltree.Element) string {
+	var b strings.Builder
+	b.WriteString(el.Content)
+	for i := range el.Children {
+		if c := &el.Children[i]; c.Type == xmltree.XML_CharData || c.Type == xmltree.XML_CDATA {
+			b.WriteString(c.Content)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// value returns the text within a value element, which is significant.
+func value(el *xmltree.Element) string {
+	if len(el.Children) == 0 {
+		return el.Content
+	}
+	var b strings.Builder
+	for i := range el.Children {
+		if c := &el.Children[i]; c.Type == xmltree.XML_CharData || c.Type == xmltree.XML_CDATA {
+			b.WriteString(c.Content)
+		}
+	}
+	return b.String()
+}
+
+func (c *compiler) load(href string) (*xmltree.Element, error) {
+	if c.opts.Load == nil {
+		return nil, fmt.Errorf("relaxng: cannot load %q without Options.Load", href)
+	}
+	if c.loading[href] {
+		return nil, fmt.Errorf("relaxng: %q includes itself", href)
+	}
+	doc, err := c.opts.Load(href)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Name.Space != rngNS {
+		return nil, fmt.Errorf("relaxng: %q is not a RELAX NG schema", href)
+	}
+	return doc, nil
+}
+
+// group compiles a list of patterns, which match in sequence.
+func (c *compiler) group(list []*xmltree.Element, ctx context) (*pattern, error) {
+	p := empty
+	for _, el := range list {
+		q, err := c.pattern(el, ctx)
+		if err != nil {
+			return nil, err
+		}
+		p = c.b.group(p, q)
+	}
+	return p, nil
+}
+
+func (c *compiler) pattern(el *xmltree.Element, ctx context) (*pattern, error) {
+	if el.Name.Space != rngNS {
+		return nil, fmt.Errorf("relaxng: %s is not a RELAX NG pattern", el.Name.Local)
+	}
+	ctx = ctx.in(el)
+	kids := children(el)
+	switch el.Name.Local {
+	case "element":
+		nc, kids, err := c.nameClassOf(el, kids, ctx, false)
+		if err != nil {
+			return nil, err
+		}
+		p := &pattern{kind: pElement, nc: nc}
+		c.pending = append(c.pending, pendingElement{p, kids, ctx})
+		return p, nil
+	case "attribute":
+		nc, kids, err := c.nameClassOf(el, kids, ctx, true)
+		if err != nil {
+			return nil, err
+		}
+		content := text
+		if len(kids) > 0 {
+			if content, err = c.group(kids, ctx); err != nil {
+				return nil, err
+			}
+		}
+		return &pattern{kind: pAttribute, nc: nc, p1: content}, nil
+	case "group":
+		return c.group(kids, ctx)
+	case "interleave", "choice":
+		var p *pattern
+		for _, k := range kids {
+			q, err := c.pattern(k, ctx)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case p == nil:
+				p = q
+			case el.Name.Local == "choice":
+				p = c.b.choice(p, q)
+			default:
+				p = c.b.interleave(p, q)
+			}
+		}
+		if p == nil {
+			return nil, fmt.Errorf("relaxng: empty %s", el.Name.Local)
+		}
+		return p, nil
+	case "optional", "zeroOrMore", "oneOrMore", "mixed", "list":
+		p, err := c.group(kids, ctx)
+		if err != nil {
+			return nil, err
+		}
+		switch el.Name.Local {
+		case "optional":
+			return c.b.choice(p, empty), nil
+		case "zeroOrMore":
+			return c.b.choice(c.b.oneOrMore(p), empty), nil
+		case "oneOrMore":
+			return c.b.oneOrMore(p), nil
+		case "mixed":
+			return c.b.interleave(p, text), nil
+		}
+		return c.b.list(p), nil
+	case "empty":
+		return empty, nil
+	case "text":
+		return text, nil
+	case "notAllowed":
+		return notAllowed, nil
+	case "ref", "parentRef":
+		g := ctx.g
+		if el.Name.Local == "parentRef" && g != nil {
+			g = g.parent
+		}
+		name, _ := validation.AttrValue(el, "name")
+		if g == nil {
+			return nil, fmt.Errorf("relaxng: %s to %q outside of a grammar", el.Name.Local, name)
+		}
+		return c.define(g, strings.TrimSpace(name))
+	case "data":
+		return c.data(el, kids, ctx)
+	case "value":
+		dt, err := c.datatype(el, ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &pattern{kind: pValue, dt: dt, value: value(el), scope: &el.Scope}, nil
+	case "grammar":
+		g := &grammar{parent: ctx.g, defines: make(map[string][]component), done: make(map[string]*pattern), active: make(map[string]bool)}
+		ctx.g = g
+		if err := c.collect(el, ctx, nil); err != nil {
+			return nil, err
+		}
+		return c.define(g, "")
+	case "externalRef":
+		href, _ := validation.AttrValue(el, "href")
+		key := [2]string{href, ctx.ns}
+		if p, ok := c.external[key]; ok {
+			return p, nil
+		}
+		doc, err := c.load(href)
+		if err != nil {
+			return nil, err
+		}
+		c.loading[href] = true
+		p, err := c.pattern(doc, context{ns: ctx.ns})
+		delete(c.loading, href)
+		c.external[key] = p
+		return p, err
+	}
+	return nil, fmt.Errorf("relaxng: unknown pattern %s", el.Name.Local)
+}
+
+// collect adds the definitions in the content of a grammar to the
+// grammar in ctx. Those named in override are skipped, as they are
+// replaced by the definitions in an include element.
+func (c *compiler) collect(el *xmltree.Element, ctx context, override map[string]bool) error {
+	g := ctx.g
+	for _, k := range children(el) {
+		kctx := ctx.in(k)
+		switch k.Name.Local {
+		case "start":
+			if !override[""] {
+				g.defines[""] = append(g.defines[""], component{k, kctx})
+			}
+		case "define":
+			name, _ := validation.AttrValue(k, "name")
+			name = strings.TrimSpace(name)
+			if !override[name] {
+				g.defines[name] = append(g.defines[name], component{k, kctx})
+			}
+		case "div":
+			if err := c.collect(k, kctx, override); err != nil {
+				return err
+			}
+		case "include":
+			href, _ := validation.AttrValue(k, "href")
+			doc, err := c.load(href)
+			if err != nil {
+				return err
+			}
+			if doc.Name.Local != "grammar" {
+				return fmt.Errorf("relaxng: included schema %q is not a grammar", href)
+			}
+			// Definitions within the include element replace those
+			// in the schema included.
+			replaced := make(map[string]bool)
+			for name := range override {
+				replaced[name] = true
+			}
+			var find func(el *xmltree.Element)
+			find = func(el *xmltree.Element) {
+				for _, d := range children(el) {
+					switch d.Name.Local {
+					case "start":
+						replaced[""] = true
+					case "define":
+						name, _ := validation.AttrValue(d, "name")
+						replaced[strings.TrimSpace(name)] = true
+					case "div":
+						find(d)
+					}
+				}
+			}
+			find(k)
+			c.loading[href] = true
+			err = c.collect(doc, kctx.in(doc), replaced)
+			delete(c.loading, href)
+			if err != nil {
+				return err
+			}
+			if err := c.collect(k, kctx, override); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("relaxng: unexpected %s in grammar", k.Name.Local)
+		}
+	}
+	return nil
+}
+
+// define compiles the definition with the given name in a grammar, or
+// its start if name is empty, combining multiple definitions.
+func (c *compiler) define(g *grammar, name string) (*pattern, error) {
+	if p, ok := g.done[name]; ok {
+		return p, nil
+	}
+	what := "definition of " + name
+	if name == "" {
+		what = "start"
+	}
+	defs := g.defines[name]
+	if len(defs) == 0 {
+		return nil, fmt.Errorf("relaxng: no %s", what)
+	}
+	if g.active[name] {
+		return nil, fmt.Errorf("relaxng: %s refers to itself outside of an element", what)
+	}
+	g.active[name] = true
+	defer delete(g.active, name)
+
+	combine, plain := "", 0
+	for _, d := range defs {
+		how, ok := validation.AttrValue(d.el, "combine")
+		switch {
+		case !ok:
+			if plain++; plain > 1 {
+				return nil, fmt.Errorf("relaxng: more than one %s without combine", what)
+			}
+		case how != "choice" && how != "interleave":
+			return nil, fmt.Errorf("relaxng: bad combine %q in %s", how, what)
+		case combine != "" && how != combine:
+			return nil, fmt.Errorf("relaxng: conflicting combine in %s", what)
+		default:
+			combine = how
+		}
+	}
+	var p *pattern
+	for _, d := range defs {
+		q, err := c.group(children(d.el), d.ctx)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case p == nil:
+			p = q
+		case combine == "interleave":
+			p = c.b.interleave(p, q)
+		default:
+			p = c.b.choice(p, q)
+		}
+	}
+	g.done[name] = p
+	return p, nil
+}
+
+// nameClassOf returns the name class of an element or attribute
+// pattern, and the rest of its children.
+func (c *compiler) nameClassOf(el *xmltree.Element, kids []*xmltree.Element, ctx context, attribute bool) (*nameClass, []*xmltree.Element, error) {
+	if qname, ok := validation.AttrValue(el, "name"); ok {
+		ns := ctx.ns
+		if _, ok := validation.AttrValue(el, "ns"); attribute && !ok {
+			ns = ""
+		}
+		name, err := resolve(el, qname, ns)
+		return &nameClass{kind: ncName, name: name}, kids, err
+	}
+	if len(kids) == 0 {
+		return nil, nil, fmt.Errorf("relaxng: %s without a name", el.Name.Local)
+	}
+	nc, err := c.nameClass(kids[0], ctx)
+	return nc, kids[1:], err
+}
+
+// resolve resolves a QName in a schema, using ns for names without a
+// prefix.
+func resolve(el *xmltree.Element, qname, ns string) (xml.Name, error) {
+	qname = strings.TrimSpace(qname)
+	if !strings.Contains(qname, ":") {
+		return xml.Name{Space: ns, Local: qname}, nil
+	}
+	name, ok := el.ResolveNS(qname)
+	if !ok {
+		return name, fmt.Errorf("relaxng: undeclared namespace prefix in %q", qname)
+	}
+	return name, nil
+}
+
+func (c *compiler) nameClass(el *xmltree.Element, ctx context) (*nameClass, error) {
+	ctx = ctx.in(el)
+	kids := children(el)
+	switch el.Name.Local {
+	case "name":
+		name, err := resolve(el, content(el), ctx.ns)
+		return &nameClass{kind: ncName, name: name}, err
+	case "anyName", "nsName":
+		nc := &nameClass{kind: ncAnyName}
+		if el.Name.Local == "nsName" {
+			nc = &nameClass{kind: ncNsName, name: xml.Name{Space: ctx.ns}}
+		}
+		for _, k := range kids {
+			if k.Name.Local != "except" {
+				return nil, fmt.Errorf("relaxng: unexpected %s in %s", k.Name.Local, el.Name.Local)
+			}
+			except, err := c.nameClassChoice(children(k), ctx.in(k))
+			if err != nil {
+				return nil, err
+			}
+			nc.a = except
+		}
+		return nc, nil
+	case "choice":
+		return c.nameClassChoice(kids, ctx)
+	}
+	return nil, fmt.Errorf("relaxng: unknown name class %s", el.Name.Local)
+}
+
+func (c *compiler) nameClassChoice(list []*xmltree.Element, ctx context) (*nameClass, error) {
+	var nc *nameClass
+	for _, el := range list {
+		n, err := c.nameClass(el, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if nc == nil {
+			nc = n
+		} else {
+			nc = &nameClass{kind: ncChoice, a: nc, b: n}
+		}
+	}
+	if nc == nil {
+		return nil, fmt.Errorf("relaxng: empty name class")
+	}
+	return nc, nil
+}
+
+func (c *compiler) data(el *xmltree.Element, kids []*xmltree.Element, ctx context) (*pattern, error) {
+	var params [][2]string
+	var except *pattern
+	for _, k := range kids {
+		switch k.Name.Local {
+		case "param":
+			name, _ := validation.AttrValue(k, "name")
+			params = append(params, [2]string{strings.TrimSpace(name), value(k)})
+		case "except":
+			for _, e := range children(k) {
+				q, err := c.pattern(e, ctx.in(k))
+				if err != nil {
+					return nil, err
+				}
+				if except == nil {
+					except = q
+				} else {
+					except = c.b.choice(except, q)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("relaxng: unexpected %s in data", k.Name.Local)
+		}
+	}
+	dt, err := c.datatype(el, ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return &pattern{kind: pData, dt: dt, p1: except}, nil
+}
+
+// datatype returns the datatype of a data or value element.
+func (c *compiler) datatype(el *xmltree.Element, ctx context, params [][2]string) (*datatype, error) {
+	name, ok := validation.AttrValue(el, "type")
+	if !ok {
+		// A value without a type is a token.
+		return &datatype{name: "token"}, nil
+	}
+	name = strings.TrimSpace(name)
+	switch ctx.lib {
+	case "":
+		if name != "string" && name != "token" {
+			return nil, fmt.Errorf("relaxng: unknown datatype %s", name)
+		}
+		if len(params) > 0 {
+			return nil, fmt.Errorf("relaxng: datatype %s has no parameters", name)
+		}
+		return &datatype{name: name}, nil
+	case xsdLib:
+		d, err := xsd.NewDatatype(name, params)
+		if err != nil {
+			return nil, fmt.Errorf("relaxng: %v", strings.TrimPrefix(err.Error(), "xsd: "))
+		}
+		return &datatype{name: name, xsd: d}, nil
+	}
+	return nil, fmt.Errorf("relaxng: unsupported datatype library %q", ctx.lib)
+}
@@ -0,0 +1,200 @@
+package relaxng
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pschou/go-xmltree"
+	"github.com/pschou/go-xmltree/internal/validation"
+)
+
+// An Error describes an element which is not valid.
+type Error = validation.Error
+
+// Errors is the list of errors returned by Validate, in document order.
+type Errors = validation.Errors
+
+// Validate checks that root is valid according to the Schema. After an
+// error, validation continues as if the element or attribute in error
+// were absent, or the content missing were present, so that later
+// problems are also reported.
+//
+// If root is not valid, the error returned is of type Errors, listing
+// every problem found.
+func (s *Schema) Validate(root *xmltree.Element) error {
+	v := validator{b: newBuilder()}
+	p := v.element(s.start, root, "/"+validation.QName(root, root.Name))
+	if !p.nullable && len(v.errs) == 0 {
+		v.errorf(root, "/"+validation.QName(root, root.Name), "document is incomplete")
+	}
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+type validator struct {
+	b    *builder
+	errs Errors
+}
+
+func (v *validator) errorf(el *xmltree.Element, path, format string, args ...interface{}) {
+	v.errs = append(v.errs, &Error{Path: path, Element: el, Msg: fmt.Sprintf(format, args...)})
+}
+
+// element validates el, which must match p, and returns the derivative
+// of p with respect to it.
+func (v *validator) element(p *pattern, el *xmltree.Element, path string) *pattern {
+	b := v.b
+	q := b.startTagOpenDeriv(p, el.Name)
+	if q == notAllowed {
+		v.errorf(el, path, "element %s is not allowed here%s", validation.QName(el, el.Name), expecting(p))
+		return p
+	}
+
+	for _, a := range el.StartElement.Attr {
+		at := &attr{name: a.Name, value: a.Value, scope: &el.Scope}
+		r := b.attDeriv(q, at)
+		if r != notAllowed {
+			q = r
+			continue
+		}
+		apath := path + "/@" + validation.QName(el, a.Name)
+		declared := false
+		attributes(q, false, func(p *pattern, _ bool) {
+			declared = declared || p.nc.contains(a.Name)
+		})
+		if declared {
+			v.errorf(el, apath, "value %q is not valid", a.Value)
+		} else {
+			v.errorf(el, apath, "attribute is not allowed")
+		}
+	}
+	if r := b.startTagCloseDeriv(q, false); r != notAllowed {
+		q = r
+	} else {
+		var missing []string
+		attributes(q, true, func(p *pattern, required bool) {
+			if required {
+				missing = p.nc.names(missing)
+			}
+		})
+		if len(missing) == 0 {
+			v.errorf(el, path, "missing attributes")
+		} else {
+			v.errorf(el, path, "missing required attribute %s", strings.Join(missing, ", "))
+		}
+		q = b.startTagCloseDeriv(q, true)
+	}
+
+	// The content of the element: runs of text, and child elements.
+	hasElements := false
+	for i := range el.Children {
+		if el.Children[i].Type == xmltree.XML_Tag {
+			hasElements = true
+		}
+	}
+	var texts strings.Builder
+	reported := false // whether the text of el was in error
+	texts.WriteString(el.Content)
+	flush := func() {
+		s := texts.String()
+		texts.Reset()
+		if strings.TrimSpace(s) == "" {
+			if !hasElements {
+				// White space alone may be a value.
+				q = b.choice(q, b.textDeriv(q, s, &el.Scope))
+			}
+			return
+		}
+		if r := b.textDeriv(q, s, &el.Scope); r != notAllowed {
+			q = r
+			return
+		}
+		reported = true
+		if acceptsText(q) {
+			v.errorf(el, path, "value %q is not valid", strings.TrimSpace(s))
+		} else {
+			v.errorf(el, path, "text is not allowed here%s", expecting(q))
+		}
+	}
+	count := make(map[xml.Name]int)
+	for i := range el.Children {
+		child := &el.Children[i]
+		switch child.Type {
+		case xmltree.XML_CharData, xmltree.XML_CDATA:
+			texts.WriteString(child.Content)
+		case xmltree.XML_Tag:
+			flush()
+			count[child.Name]++
+			q = v.element(q, child, path+"/"+validation.QName(child, child.Name)+"["+strconv.Itoa(count[child.Name])+"]")
+		}
+	}
+	flush()
+
+	if r := b.endTagDeriv(q, false); r != notAllowed {
+		return r
+	}
+	switch {
+	case reported && expecting(q) == "":
+	case expecting(q) != "":
+		v.errorf(el, path, "content is incomplete%s", expecting(q))
+	case acceptsText(q) && !hasElements:
+		v.errorf(el, path, "value %q is not valid", strings.TrimSpace(textOf(el)))
+	default:
+		v.errorf(el, path, "content is incomplete")
+	}
+	return b.endTagDeriv(q, true)
+}
+
+// textOf returns the text directly within an element.
+func textOf(el *xmltree.Element) string {
+	var b strings.Builder
+	b.WriteString(el.Content)
+	for i := range el.Children {
+		if c := &el.Children[i]; c.Type == xmltree.XML_CharData || c.Type == xmltree.XML_CDATA {
+			b.WriteString(c.Content)
+		}
+	}
+	return b.String()
+}
+
+// acceptsText reports whether p could match text next, other than white
+// space.
+func acceptsText(p *pattern) bool {
+	found := false
+	front(p, func(p *pattern) {
+		switch p.kind {
+		case pText, pData, pValue, pList:
+			found = true
+		}
+	})
+	return found
+}
+
+// expecting describes the elements which p could match next.
+func expecting(p *pattern) string {
+	var names []string
+	front(p, func(p *pattern) {
+		if p.kind == pElement {
+			names = p.nc.names(names)
+		}
+	})
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	uniq := names[:1]
+	for _, n := range names[1:] {
+		if n != uniq[len(uniq)-1] {
+			uniq = append(uniq, n)
+		}
+	}
+	if len(uniq) == 1 {
+		return "; expected " + uniq[0]
+	}
+	return "; expected one of " + strings.Join(uniq, ", ")
+}
@@ -0,0 +1,264 @@
+package relaxng
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-xmltree"
+)
+
+const addressBook = `
+<grammar xmlns="http://relaxng.org/ns/structure/1.0" ns="urn:book"
+    datatypeLibrary="http://www.w3.org/2001/XMLSchema-datatypes">
+  <start>
+    <element name="book">
+      <optional><attribute name="version"><value>1.0</value></attribute></optional>
+      <zeroOrMore><ref name="card"/></zeroOrMore>
+    </element>
+  </start>
+  <define name="card">
+    <element name="card">
+      <attribute name="id"><data type="ID"/></attribute>
+      <interleave>
+        <element name="name"><text/></element>
+        <oneOrMore><ref name="contact"/></oneOrMore>
+        <optional><element name="tags"><list><zeroOrMore><data type="NCName"/></zeroOrMore></list></element></optional>
+      </interleave>
+      <optional><element name="note"><mixed><zeroOrMore><element name="b"><text/></element></zeroOrMore></mixed></element></optional>
+      <zeroOrMore><element><anyName><except><nsName/></except></anyName><ref name="any"/></element></zeroOrMore>
+    </element>
+  </define>
+  <define name="contact">
+    <choice>
+      <element name="email"><data type="string"><param name="pattern">[^@]+@[^@]+</param></data></element>
+      <element name="age"><data type="integer"><param name="minInclusive">0</param><param name="maxInclusive">150</param></data></element>
+    </choice>
+  </define>
+  <define name="contact" combine="choice">
+    <element name="kind"><choice><value type="token" datatypeLibrary="">home</value><value>work</value></choice></element>
+  </define>
+  <define name="any">
+    <zeroOrMore><choice><attribute><anyName/></attribute><text/><element><anyName/><ref name="any"/></element></choice></zeroOrMore>
+  </define>
+</grammar>`
+
+const addressBookCompact = `
+# The same schema as addressBook.
+default namespace = "urn:book"
+datatypes x = "http://www.w3.org/2001/XMLSchema-datatypes"
+namespace local = "urn:book"
+
+start = element book {
+  attribute version { "1.0" }?,
+  card*
+}
+## A card.
+card = element card {
+  attribute id { x:ID },
+  (element name { text }
+   & contact+
+   & element tags { list { x:NCName* } }?),
+  element note { mixed { element b { text }* } }?,
+  element * - local:* { any }*
+}
+contact = element email { x:string { pattern = "[^@]+@[^@]+" } }
+  | element age { xsd:integer { minInclusive = "0" maxInclusive = '150' } }
+contact |= element kind { token "home" | "work" }
+[ a:doc [ "annotations are skipped" ] ]
+any = (attribute * { text } | text | element * { any })*
+`
+
+func mustParse(t *testing.T, doc string) *xmltree.Element {
+	t.Helper()
+	root, err := xmltree.Parse(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestValidate(t *testing.T) {
+	xmlSchema, err := Compile(mustParse(t, addressBook), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compactSchema, err := CompileCompact([]byte(addressBookCompact), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		doc string
+		err string // a substring of the first error, if any
+	}{
+		{doc: `<book xmlns="urn:book"/>`},
+		{doc: `<book xmlns="urn:book" version=" 1.0 "><card id="c1"><email>a@b</email><name>Ann</name>
+			<tags> friend  work </tags><kind> home </kind><age>40</age><note>Has <b>two</b> cats</note>
+			<x:extra xmlns:x="urn:other" x:a="1"><y/>text</x:extra></card></book>`},
+		{doc: `<b:book xmlns:b="urn:book"><b:card id="c1"><b:name/><b:age>0</b:age></b:card></b:book>`},
+		{
+			doc: `<list xmlns="urn:book"/>`,
+			err: "/list: element list is not allowed here; expected book",
+		},
+		{
+			doc: `<book xmlns="urn:book" version="2.0"/>`,
+			err: `/book/@version: value "2.0" is not valid`,
+		},
+		{
+			doc: `<book xmlns="urn:book" lang="en"/>`,
+			err: "/book/@lang: attribute is not allowed",
+		},
+		{
+			doc: `<book xmlns="urn:book"><card><name/><age>1</age></card></book>`,
+			err: "/book/card[1]: missing required attribute id",
+		},
+		{
+			doc: `<book xmlns="urn:book"><card id="c1"><name/></card></book>`,
+			err: "/book/card[1]: content is incomplete; expected one of age, email, kind, tags",
+		},
+		{
+			doc: `<book xmlns="urn:book"><card id="c1"><age>1</age><note/><name/></card></book>`,
+			err: "/book/card[1]/note[1]: element note is not allowed here; expected one of age, email, kind, name, tags",
+		},
+		{
+			doc: `<book xmlns="urn:book"><card id="c1"><name/><age>200</age></card></book>`,
+			err: `/book/card[1]/age[1]: value "200" is not valid`,
+		},
+		{
+			doc: `<book xmlns="urn:book"><card id="c1"><name/><email>nobody</email></card></book>`,
+			err: `/book/card[1]/email[1]: value "nobody" is not valid`,
+		},
+		{
+			doc: `<book xmlns="urn:book"><card id="c1"><name/><kind>other</kind></card></book>`,
+			err: `/book/card[1]/kind[1]: value "other" is not valid`,
+		},
+		{
+			doc: `<book xmlns="urn:book"><card id="c1"><name/><age>1</age><tags>a 1b</tags></card></book>`,
+			err: `/book/card[1]/tags[1]: value "a 1b" is not valid`,
+		},
+		{
+			doc: `<book xmlns="urn:book"><card id="c1"><name/><age>1</age><note>a<i/></note></card></book>`,
+			err: "/book/card[1]/note[1]/i[1]: element i is not allowed here; expected b",
+		},
+		{
+			doc: `<book xmlns="urn:book"><card id="c1">text<name/><age>1</age></card></book>`,
+			err: "/book/card[1]: text is not allowed here",
+		},
+		{
+			doc: `<book xmlns="urn:book"><card id="c1"><name/><age>1</age><extra/></card></book>`,
+			err: "/book/card[1]/extra[1]: element extra is not allowed here",
+		},
+	}
+	for name, s := range map[string]*Schema{"xml": xmlSchema, "compact": compactSchema} {
+		for _, tt := range tests {
+			err := s.Validate(mustParse(t, tt.doc))
+			switch {
+			case err == nil && tt.err != "":
+				t.Errorf("%s: %s: valid, want error %q", name, tt.doc, tt.err)
+			case err != nil && tt.err == "":
+				t.Errorf("%s: %s: %v", name, tt.doc, err)
+			case err != nil:
+				errs, ok := err.(Errors)
+				if !ok {
+					t.Errorf("%s: %s: error of type %T", name, tt.doc, err)
+				} else if !strings.Contains(errs[0].Error(), tt.err) {
+					t.Errorf("%s: %s: error %q, want %q", name, tt.doc, errs[0], tt.err)
+				}
+			}
+		}
+	}
+}
+
+func TestValidateRecovers(t *testing.T) {
+	s, err := Compile(mustParse(t, addressBook), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := mustParse(t, `<book xmlns="urn:book"><card id="c1" bad="1"><name/></card><card><name/><age>x</age></card></book>`)
+	err = s.Validate(doc)
+	errs, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("error %v, want Errors", err)
+	}
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.Error())
+	}
+	want := []string{
+		"/book/card[1]/@bad: attribute is not allowed",
+		"/book/card[1]: content is incomplete; expected one of age, email, kind, tags",
+		"/book/card[2]: missing required attribute id",
+		`/book/card[2]/age[1]: value "x" is not valid`,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("errors\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestInclude(t *testing.T) {
+	files := map[string]string{
+		"base.rng": `<grammar xmlns="http://relaxng.org/ns/structure/1.0">
+			<start><element name="doc"><ref name="body"/></element></start>
+			<define name="body"><element name="p"><text/></element></define>
+		</grammar>`,
+		"main.rng": `<grammar xmlns="http://relaxng.org/ns/structure/1.0">
+			<include href="base.rng">
+				<define name="body"><oneOrMore><ref name="para"/></oneOrMore></define>
+			</include>
+			<define name="para"><externalRef href="para.rnc"/></define>
+		</grammar>`,
+		"para.rnc": `element para { attribute n { xsd:positiveInteger }, text }`,
+		"loop.rng": `<grammar xmlns="http://relaxng.org/ns/structure/1.0"><include href="loop.rng"/></grammar>`,
+	}
+	opts := &Options{Load: func(href string) (*xmltree.Element, error) {
+		text, ok := files[href]
+		if !ok {
+			return nil, fmt.Errorf("no file %s", href)
+		}
+		if strings.HasSuffix(href, ".rnc") {
+			return ParseCompact([]byte(text))
+		}
+		return xmltree.Parse(strings.NewReader(text))
+	}}
+	s, err := Compile(mustParse(t, files["main.rng"]), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Validate(mustParse(t, `<doc><para n="1">a</para><para n="2"/></doc>`)); err != nil {
+		t.Error(err)
+	}
+	err = s.Validate(mustParse(t, `<doc><p/></doc>`))
+	if err == nil || err.(Errors)[0].Error() != "/doc/p[1]: element p is not allowed here; expected para" {
+		t.Errorf("overridden definition: %v", err)
+	}
+	err = s.Validate(mustParse(t, `<doc><para n="0"/></doc>`))
+	if err == nil || err.(Errors)[0].Error() != `/doc/para[1]/@n: value "0" is not valid` {
+		t.Errorf("external pattern: %v", err)
+	}
+
+	if _, err := Compile(mustParse(t, files["loop.rng"]), opts); err == nil {
+		t.Error("recursive include compiled")
+	}
+	if _, err := Compile(mustParse(t, files["main.rng"]), nil); err == nil {
+		t.Error("include compiled without Load")
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		schema string
+		err    string
+	}{
+		{`<grammar xmlns="http://relaxng.org/ns/structure/1.0"><start><ref name="x"/></start></grammar>`, "x"},
+		{`<grammar xmlns="http://relaxng.org/ns/structure/1.0"><define name="x"><empty/></define></grammar>`, "start"},
+		{`<element xmlns="http://relaxng.org/ns/structure/1.0" name="a"><data type="int"/></element>`, "int"},
+		{`<element xmlns="http://relaxng.org/ns/structure/1.0" name="a"><bogus/></element>`, "bogus"},
+		{`<grammar xmlns="http://relaxng.org/ns/structure/1.0"><start><empty/></start><start><text/></start></grammar>`, "start"},
+	}
+	for _, tt := range tests {
+		_, err := Compile(mustParse(t, tt.schema), nil)
+		if err == nil || !strings.HasPrefix(err.Error(), "relaxng: ") || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want one mentioning %s", tt.schema, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,514 @@
+// Package schematron evaluates ISO Schematron schemas against trees of
+// xmltree Elements, reporting the results in the Schematron Validation
+// Report Language (SVRL).
+//
+// Expressions are evaluated as XPath 1.0 by the xmltree XPath engine,
+// whatever the queryBinding of the schema, so schemas written for XSLT 2
+// work as long as their expressions are also valid XPath 1.0. The
+// function current() returns the context node of the rule being
+// evaluated. Phases, variables (let), abstract patterns and rules,
+// diagnostics and include are supported. Schemas in the older ASCC
+// namespace are accepted as well.
+package schematron
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pschou/go-xmltree"
+)
+
+const (
+	isoNS  = "http://purl.oclc.org/dsdl/schematron"
+	asccNS = "http://www.ascc.net/xml/schematron"
+	svrlNS = "http://purl.oclc.org/dsdl/svrl"
+)
+
+// Options controls how a schema is compiled.
+type Options struct {
+	// Phase is the id of the phase to evaluate, or "#ALL" for every
+	// pattern. If Phase is empty, the defaultPhase of the schema is
+	// used, or every pattern if it has none.
+	Phase string
+
+	// Load returns the document named by the href attribute of an
+	// include element, as written. If Load is nil, include elements
+	// are an error.
+	Load func(href string) (*xmltree.Element, error)
+
+	// Functions holds extension functions available to expressions,
+	// as for XPathContext.Functions.
+	Functions map[string]xmltree.XPathFunc
+}
+
+// A Schema is a compiled Schematron schema. A Schema may be used by
+// several goroutines at once.
+type Schema struct {
+	title, version, phase string
+	ns                    [][2]string // prefixes and URIs, in order of declaration
+	namespaces            map[string]string
+	lets                  []let // of the schema and the phase
+	patterns              []*pattern
+	diagnostics           map[string]message
+	functions             map[string]xmltree.XPathFunc
+}
+
+type let struct {
+	name  string
+	value *xmltree.XPath
+}
+
+type pattern struct {
+	id, title string
+	lets      []let
+	rules     []*rule
+}
+
+type rule struct {
+	id, role, flag string
+	context        string
+	match          *xmltree.XPath // selects the nodes which match the context
+	lets           []let
+	checks         []*check
+}
+
+// A check is an assert, or a report if report is set.
+type check struct {
+	report         bool
+	id, role, flag string
+	test           string
+	x              *xmltree.XPath
+	msg            message
+	diagnostics    []string
+}
+
+// A message is the text of an assertion or diagnostic, which may include
+// the values of expressions.
+type message []part
+
+type part struct {
+	text string
+	x    *xmltree.XPath // for value-of, and name with a path
+	name bool           // the name of the context node, or of the node selected by x
+}
+
+type compiler struct {
+	opts     Options
+	ns       string // the namespace of the schema elements
+	abstract map[string]*xmltree.Element
+	rules    map[string]*xmltree.Element // abstract rules, by id
+	loading  map[string]bool
+}
+
+// Compile compiles a Schematron schema. A nil opts is the same as a zero
+// Options.
+func Compile(doc *xmltree.Element, opts *Options) (*Schema, error) {
+	c := &compiler{abstract: make(map[string]*xmltree.Element), rules: make(map[string]*xmltree.Element), loading: make(map[string]bool)}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if doc.Name.Local != "schema" || doc.Name.Space != isoNS && doc.Name.Space != asccNS {
+		return nil, fmt.Errorf("schematron: %s is not a Schematron schema element", doc.Name.Local)
+	}
+	c.ns = doc.Name.Space
+	doc = doc.Clone()
+	if err := c.include(doc); err != nil {
+		return nil, err
+	}
+
+	s := &Schema{
+		title:       strings.Join(strings.Fields(textOf(c.child(doc, "title"))), " "),
+		version:     doc.Attr("", "schemaVersion"),
+		namespaces:  make(map[string]string),
+		diagnostics: make(map[string]message),
+		functions:   c.opts.Functions,
+	}
+	for _, el := range c.children(doc, "ns") {
+		prefix, uri := el.Attr("", "prefix"), el.Attr("", "uri")
+		s.ns = append(s.ns, [2]string{prefix, uri})
+		s.namespaces[prefix] = uri
+	}
+
+	// Abstract patterns and rules may be used before they are defined.
+	var patterns []*xmltree.Element
+	for _, el := range c.children(doc, "pattern") {
+		if el.Attr("", "abstract") == "true" {
+			c.abstract[el.Attr("", "id")] = el
+		} else {
+			patterns = append(patterns, el)
+		}
+	}
+	for _, el := range doc.FindFunc(func(el *xmltree.Element) bool { return c.is(el, "rule") }) {
+		if el.Attr("", "abstract") == "true" {
+			c.rules[el.Attr("", "id")] = el
+		}
+	}
+
+	var err error
+	if s.lets, err = c.lets(doc); err != nil {
+		return nil, err
+	}
+	for _, group := range c.children(doc, "diagnostics") {
+		for _, el := range c.children(group, "diagnostic") {
+			if s.diagnostics[el.Attr("", "id")], err = c.message(el); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// The phase decides which patterns are active.
+	s.phase = c.opts.Phase
+	if s.phase == "" {
+		s.phase = doc.Attr("", "defaultPhase")
+	}
+	var active map[string]bool
+	if s.phase != "" && s.phase != "#ALL" {
+		var phase *xmltree.Element
+		for _, el := range c.children(doc, "phase") {
+			if el.Attr("", "id") == s.phase {
+				phase = el
+			}
+		}
+		if phase == nil {
+			return nil, fmt.Errorf("schematron: no phase %q", s.phase)
+		}
+		active = make(map[string]bool)
+		for _, el := range c.children(phase, "active") {
+			active[el.Attr("", "pattern")] = true
+		}
+		lets, err := c.lets(phase)
+		if err != nil {
+			return nil, err
+		}
+		s.lets = append(s.lets, lets...)
+	}
+
+	for _, el := range patterns {
+		id := el.Attr("", "id")
+		if active != nil && !active[id] {
+			continue
+		}
+		if isa := el.Attr("", "is-a"); isa != "" {
+			if el, err = c.instantiate(el, isa); err != nil {
+				return nil, err
+			}
+		}
+		p, err := c.pattern(el, id)
+		if err != nil {
+			return nil, err
+		}
+		s.patterns = append(s.patterns, p)
+	}
+	for _, p := range s.patterns {
+		for _, r := range p.rules {
+			for _, chk := range r.checks {
+				for _, id := range chk.diagnostics {
+					if _, ok := s.diagnostics[id]; !ok {
+						return nil, fmt.Errorf("schematron: no diagnostic %q, referred to by %s", id, chk.test)
+					}
+				}
+			}
+		}
+	}
+	return s, nil
+}
+
+// is reports whether el is the Schematron element named local.
+func (c *compiler) is(el *xmltree.Element, local string) bool {
+	return el.Type == xmltree.XML_Tag && el.Name.Space == c.ns && el.Name.Local == local
+}
+
+// children returns the Schematron children of el named local.
+func (c *compiler) children(el *xmltree.Element, local string) []*xmltree.Element {
+	var list []*xmltree.Element
+	for i := range el.Children {
+		if c.is(&el.Children[i], local) {
+			list = append(list, &el.Children[i])
+		}
+	}
+	return list
+}
+
+func (c *compiler) child(el *xmltree.Element, local string) *xmltree.Element {
+	if list := c.children(el, local); len(list) > 0 {
+		return list[0]
+	}
+	return nil
+}
+
+// textOf returns the text content of el, which may be nil.
+func textOf(el *xmltree.Element) string {
+	if el == nil {
+		return ""
+	}
+	return el.Text()
+}
+
+// include replaces include elements within el with the documents they
+// name.
+func (c *compiler) include(el *xmltree.Element) error {
+	for i := range el.Children {
+		child := &el.Children[i]
+		if !c.is(child, "include") {
+			if err := c.include(child); err != nil {
+				return err
+			}
+			continue
+		}
+		href := child.Attr("", "href")
+		switch {
+		case c.opts.Load == nil:
+			return fmt.Errorf("schematron: cannot include %q without Options.Load", href)
+		case c.loading[href]:
+			return fmt.Errorf("schematron: %q includes itself", href)
+		}
+		doc, err := c.opts.Load(href)
+		if err != nil {
+			return fmt.Errorf("schematron: include %q: %w", href, err)
+		}
+		c.loading[href] = true
+		doc = doc.Clone()
+		if err := c.include(doc); err != nil {
+			return err
+		}
+		delete(c.loading, href)
+		el.Children[i] = *doc
+	}
+	return nil
+}
+
+func (c *compiler) lets(el *xmltree.Element) ([]let, error) {
+	var list []let
+	for _, l := range c.children(el, "let") {
+		x, err := compileXPath(l.Attr("", "value"), "let "+l.Attr("", "name"))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, let{l.Attr("", "name"), x})
+	}
+	return list, nil
+}
+
+func compileXPath(expr, where string) (*xmltree.XPath, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, fmt.Errorf("schematron: %s: missing expression", where)
+	}
+	x, err := xmltree.CompileXPath(expr)
+	if err != nil {
+		return nil, fmt.Errorf("schematron: %s: %w", where, err)
+	}
+	return x, nil
+}
+
+// instantiate returns a copy of the abstract pattern named isa, with the
+// parameters given by the pattern el substituted in its expressions.
+func (c *compiler) instantiate(el *xmltree.Element, isa string) (*xmltree.Element, error) {
+	abstract, ok := c.abstract[isa]
+	if !ok {
+		return nil, fmt.Errorf("schematron: pattern %q: no abstract pattern %q", el.Attr("", "id"), isa)
+	}
+	var params [][2]string
+	for _, p := range c.children(el, "param") {
+		params = append(params, [2]string{"$" + p.Attr("", "name"), p.Attr("", "value")})
+	}
+	// Longer names first, so that $ab is not taken for $a.
+	sort.Slice(params, func(i, j int) bool { return len(params[i][0]) > len(params[j][0]) })
+	inst := abstract.Clone()
+	var subst func(e *xmltree.Element)
+	subst = func(e *xmltree.Element) {
+		for i, a := range e.StartElement.Attr {
+			switch a.Name.Local {
+			case "context", "test", "select", "value", "path":
+				e.StartElement.Attr[i].Value = substitute(a.Value, params)
+			}
+		}
+		for i := range e.Children {
+			subst(&e.Children[i])
+		}
+	}
+	subst(inst)
+	inst.RemoveAttr("", "abstract")
+	inst.SetAttr("", "id", el.Attr("", "id"))
+	return inst, nil
+}
+
+func substitute(s string, params [][2]string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		replaced := false
+		if s[i] == '$' {
+			for _, p := range params {
+				if strings.HasPrefix(s[i:], p[0]) && !isNameByte(s, i+len(p[0])) {
+					b.WriteString(p[1])
+					i += len(p[0])
+					replaced = true
+					break
+				}
+			}
+		}
+		if !replaced {
+			b.WriteByte(s[i])
+			i++
+		}
+	}
+	return b.String()
+}
+
+// isNameByte reports whether s[i] could continue a name.
+func isNameByte(s string, i int) bool {
+	if i >= len(s) {
+		return false
+	}
+	ch := s[i]
+	return ch == '_' || ch == '-' || ch == '.' || ch >= '0' && ch <= '9' ||
+		ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= 0x80
+}
+
+func (c *compiler) pattern(el *xmltree.Element, id string) (*pattern, error) {
+	p := &pattern{id: id, title: strings.Join(strings.Fields(textOf(c.child(el, "title"))), " ")}
+	var err error
+	if p.lets, err = c.lets(el); err != nil {
+		return nil, err
+	}
+	for _, r := range c.children(el, "rule") {
+		if r.Attr("", "abstract") == "true" {
+			continue
+		}
+		context := r.Attr("", "context")
+		if strings.TrimSpace(context) == "" {
+			return nil, fmt.Errorf("schematron: pattern %q: rule without a context", id)
+		}
+		x, err := compileXPath(matchExpr(context), "rule "+context)
+		if err != nil {
+			return nil, err
+		}
+		rule := &rule{id: r.Attr("", "id"), role: r.Attr("", "role"), flag: r.Attr("", "flag"), context: context, match: x}
+		if err := c.ruleBody(rule, r, 0); err != nil {
+			return nil, err
+		}
+		p.rules = append(p.rules, rule)
+	}
+	return p, nil
+}
+
+// ruleBody adds the variables and checks of el to r, including those of
+// the abstract rules it extends.
+func (c *compiler) ruleBody(r *rule, el *xmltree.Element, depth int) error {
+	if depth > len(c.rules) {
+		return fmt.Errorf("schematron: rule %s: abstract rules extend each other in a loop", r.context)
+	}
+	for i := range el.Children {
+		child := &el.Children[i]
+		switch {
+		case c.is(child, "let"):
+			name := child.Attr("", "name")
+			x, err := compileXPath(child.Attr("", "value"), "let "+name)
+			if err != nil {
+				return err
+			}
+			r.lets = append(r.lets, let{name, x})
+		case c.is(child, "extends"):
+			base, ok := c.rules[child.Attr("", "rule")]
+			if !ok {
+				return fmt.Errorf("schematron: rule %s: no abstract rule %q", r.context, child.Attr("", "rule"))
+			}
+			if err := c.ruleBody(r, base, depth+1); err != nil {
+				return err
+			}
+		case c.is(child, "assert"), c.is(child, "report"):
+			test := child.Attr("", "test")
+			x, err := compileXPath(test, child.Name.Local+" "+test)
+			if err != nil {
+				return err
+			}
+			msg, err := c.message(child)
+			if err != nil {
+				return err
+			}
+			r.checks = append(r.checks, &check{
+				report:      child.Name.Local == "report",
+				id:          child.Attr("", "id"),
+				role:        child.Attr("", "role"),
+				flag:        child.Attr("", "flag"),
+				test:        test,
+				x:           x,
+				msg:         msg,
+				diagnostics: strings.Fields(child.Attr("", "diagnostics")),
+			})
+		}
+	}
+	return nil
+}
+
+// message compiles the mixed content of an assertion or diagnostic.
+func (c *compiler) message(el *xmltree.Element) (message, error) {
+	var msg message
+	var walk func(el *xmltree.Element) error
+	walk = func(el *xmltree.Element) error {
+		if len(el.Children) == 0 {
+			msg = append(msg, part{text: el.Content})
+		}
+		for i := range el.Children {
+			child := &el.Children[i]
+			switch {
+			case child.Type == xmltree.XML_CharData || child.Type == xmltree.XML_CDATA:
+				msg = append(msg, part{text: child.Content})
+			case c.is(child, "value-of"):
+				x, err := compileXPath(child.Attr("", "select"), "value-of")
+				if err != nil {
+					return err
+				}
+				msg = append(msg, part{x: x})
+			case c.is(child, "name"):
+				p := part{name: true}
+				if path := child.Attr("", "path"); path != "" {
+					x, err := compileXPath(path, "name")
+					if err != nil {
+						return err
+					}
+					p.x = x
+				}
+				msg = append(msg, p)
+			case child.Type == xmltree.XML_Tag:
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return msg, walk(el)
+}
+
+// matchExpr returns an expression selecting the nodes matched by an XSLT
+// pattern, by searching for each alternative from the root.
+func matchExpr(pattern string) string {
+	var alts []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i <= len(pattern); i++ {
+		if i == len(pattern) || quote == 0 && depth == 0 && pattern[i] == '|' {
+			alt := strings.TrimSpace(pattern[start:i])
+			if !strings.HasPrefix(alt, "/") {
+				alt = "//" + alt
+			}
+			alts = append(alts, alt)
+			start = i + 1
+			continue
+		}
+		switch ch := pattern[i]; {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '(' || ch == '[':
+			depth++
+		case ch == ')' || ch == ']':
+			depth--
+		}
+	}
+	return strings.Join(alts, " | ")
+}
@@ -0,0 +1,270 @@
+package schematron
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-xmltree"
+)
+
+const benchmarkSchema = `
+<sch:schema xmlns:sch="http://purl.oclc.org/dsdl/schematron" queryBinding="xslt2" schemaVersion="1.2">
+  <sch:title>Benchmark checks</sch:title>
+  <sch:ns prefix="x" uri="urn:xccdf"/>
+  <sch:let name="maxScore" value="10"/>
+  <sch:phase id="ids">
+    <sch:active pattern="unique-ids"/>
+  </sch:phase>
+  <sch:pattern id="unique-ids">
+    <sch:rule context="x:Rule | x:Group">
+      <sch:assert test="count(//x:*[@id = current()/@id]) = 1" diagnostics="dup">Duplicate id <sch:value-of select="@id"/>.</sch:assert>
+    </sch:rule>
+  </sch:pattern>
+  <sch:pattern id="rules">
+    <sch:title>Rule content</sch:title>
+    <sch:rule context="x:Rule[@selected = 'false']">
+      <sch:report test="true()" role="info">Rule <sch:value-of select="@id"/> is not selected.</sch:report>
+    </sch:rule>
+    <sch:rule context="x:Rule">
+      <sch:let name="weight" value="number(@weight)"/>
+      <sch:extends rule="titled"/>
+      <sch:assert test="$weight &lt;= $maxScore" flag="weight">The <sch:name/> weight
+        <sch:emph><sch:value-of select="$weight"/></sch:emph> exceeds <sch:value-of select="$maxScore"/>.</sch:assert>
+    </sch:rule>
+    <sch:rule abstract="true" id="titled">
+      <sch:assert test="x:title" id="title">A <sch:name/> in a <sch:name path=".."/> needs a title.</sch:assert>
+    </sch:rule>
+  </sch:pattern>
+  <sch:pattern id="values" is-a="non-empty">
+    <sch:param name="element" value="x:value"/>
+  </sch:pattern>
+  <sch:pattern abstract="true" id="non-empty">
+    <sch:rule context="$element">
+      <sch:assert test="normalize-space(.) != ''">Empty <sch:name/>.</sch:assert>
+    </sch:rule>
+  </sch:pattern>
+  <sch:diagnostics>
+    <sch:diagnostic id="dup">Used by <sch:value-of select="count(//x:*[@id = current()/@id])"/> elements.</sch:diagnostic>
+  </sch:diagnostics>
+</sch:schema>`
+
+const benchmark = `<Benchmark xmlns="urn:xccdf">
+  <Group id="g1">
+    <title>Group</title>
+    <Rule id="r1" weight="5"><title>One</title></Rule>
+    <Rule id="r2" weight="11"/>
+    <Rule id="r1" weight="1" selected="false"/>
+  </Group>
+  <value>1</value>
+  <value> </value>
+</Benchmark>`
+
+func mustParse(t *testing.T, doc string) *xmltree.Element {
+	t.Helper()
+	root, err := xmltree.Parse(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// summary lists the results of a report, one per line.
+func summary(report *xmltree.Element) []string {
+	var lines []string
+	for i := range report.Children {
+		el := &report.Children[i]
+		line := el.Name.Local
+		for _, a := range el.StartElement.Attr {
+			if a.Name.Local != "test" {
+				line += fmt.Sprintf(" %s=%s", a.Name.Local, a.Value)
+			}
+		}
+		for j := range el.Children {
+			line += fmt.Sprintf(" [%s: %s]", el.Children[j].Name.Local, el.Children[j].Content)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestValidate(t *testing.T) {
+	s, err := Compile(mustParse(t, benchmarkSchema), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := s.Validate(mustParse(t, benchmark))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"ns-prefix-in-attribute-values uri=urn:xccdf prefix=x",
+		"active-pattern id=unique-ids",
+		"fired-rule context=x:Rule | x:Group",
+		"fired-rule context=x:Rule | x:Group",
+		"failed-assert location=/x:Benchmark/x:Group[1]/x:Rule[1] [diagnostic-reference: Used by 2 elements.] [text: Duplicate id r1.]",
+		"fired-rule context=x:Rule | x:Group",
+		"fired-rule context=x:Rule | x:Group",
+		"failed-assert location=/x:Benchmark/x:Group[1]/x:Rule[3] [diagnostic-reference: Used by 2 elements.] [text: Duplicate id r1.]",
+		"active-pattern id=rules name=Rule content",
+		"fired-rule context=x:Rule",
+		"fired-rule context=x:Rule",
+		"failed-assert id=title location=/x:Benchmark/x:Group[1]/x:Rule[2] [text: A Rule in a Group needs a title.]",
+		"failed-assert flag=weight location=/x:Benchmark/x:Group[1]/x:Rule[2] [text: The Rule weight 11 exceeds 10.]",
+		"fired-rule context=x:Rule[@selected = 'false']",
+		"successful-report role=info location=/x:Benchmark/x:Group[1]/x:Rule[3] [text: Rule r1 is not selected.]",
+		"active-pattern id=values",
+		"fired-rule context=x:value",
+		"fired-rule context=x:value",
+		"failed-assert location=/x:Benchmark/x:value[2] [text: Empty value.]",
+	}
+	got := summary(report)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("report\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if report.Name.Local != "schematron-output" || report.Attr("", "title") != "Benchmark checks" || report.Attr("", "schemaVersion") != "1.2" {
+		t.Errorf("report element %s %v", report.Name.Local, report.StartElement.Attr)
+	}
+	if n := len(FailedAsserts(report)); n != 5 {
+		t.Errorf("%d failed asserts, want 5", n)
+	}
+	doc := mustParse(t, benchmark)
+	c := xmltree.NewXPathContext(doc)
+	c.Namespaces = map[string]string{"x": "urn:xccdf"}
+	for _, el := range FailedAsserts(report) {
+		loc := el.Attr("", "location")
+		if nodes, err := c.Select(xmltree.MustCompileXPath(loc), c.Root()); err != nil || len(nodes) != 1 || nodes[0].Name().Local != "Rule" && nodes[0].Name().Local != "value" {
+			t.Errorf("location %s selects %v, %v", loc, nodes, err)
+		}
+	}
+	if out := report.String(); !strings.Contains(out, `xmlns:svrl="http://purl.oclc.org/dsdl/svrl"`) ||
+		!strings.Contains(out, "<svrl:failed-assert ") {
+		t.Errorf("encoded report %s", out)
+	}
+}
+
+func TestPhase(t *testing.T) {
+	s, err := Compile(mustParse(t, benchmarkSchema), &Options{Phase: "ids"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := s.Validate(mustParse(t, `<Benchmark xmlns="urn:xccdf"><Rule id="a"/><Rule id="b"/></Benchmark>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"ns-prefix-in-attribute-values uri=urn:xccdf prefix=x",
+		"active-pattern id=unique-ids",
+		"fired-rule context=x:Rule | x:Group",
+		"fired-rule context=x:Rule | x:Group",
+	}
+	if got := summary(report); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("report\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if report.Attr("", "phase") != "ids" {
+		t.Errorf("phase %q", report.Attr("", "phase"))
+	}
+	if _, err := Compile(mustParse(t, benchmarkSchema), &Options{Phase: "none"}); err == nil {
+		t.Error("compiled with an unknown phase")
+	}
+}
+
+func TestInclude(t *testing.T) {
+	files := map[string]string{
+		"attrs.sch": `<sch:rule xmlns:sch="http://purl.oclc.org/dsdl/schematron" context="@*">
+			<sch:assert test="string-length(.) &lt; 4"><sch:name/> is too long</sch:assert>
+		</sch:rule>`,
+		"loop.sch": `<sch:pattern xmlns:sch="http://purl.oclc.org/dsdl/schematron"><sch:include href="loop.sch"/></sch:pattern>`,
+	}
+	opts := &Options{Load: func(href string) (*xmltree.Element, error) {
+		text, ok := files[href]
+		if !ok {
+			return nil, fmt.Errorf("no file %s", href)
+		}
+		return xmltree.Parse(strings.NewReader(text))
+	}}
+	schema := `<schema xmlns="http://purl.oclc.org/dsdl/schematron"><pattern><include href="%s"/></pattern></schema>`
+	s, err := Compile(mustParse(t, fmt.Sprintf(schema, "attrs.sch")), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := s.Validate(mustParse(t, `<a xmlns:p="urn:p" b="1"><c p:d="long"/></a>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := FailedAsserts(report)
+	if len(failed) != 1 || failed[0].Attr("", "location") != "/a/c[1]/@*[namespace-uri()='urn:p'][local-name()='d']" || failed[0].Children[0].Content != "p:d is too long" {
+		t.Errorf("failed asserts %v", summary(report))
+	}
+
+	for _, a := range report.StartElement.Attr {
+		if a.Name.Local == "title" {
+			t.Errorf("report of an untitled schema has title %q", a.Value)
+		}
+	}
+
+	for _, href := range []string{"loop.sch", "missing.sch"} {
+		if _, err := Compile(mustParse(t, fmt.Sprintf(schema, href)), opts); err == nil {
+			t.Errorf("include of %s compiled", href)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		schema string
+		err    string
+	}{
+		{`<schema/>`, "schematron: schema is not a Schematron schema element"},
+		{`<schema xmlns="http://purl.oclc.org/dsdl/schematron"><pattern id="p"><rule/></pattern></schema>`, `schematron: pattern "p": rule without a context`},
+		{`<schema xmlns="http://purl.oclc.org/dsdl/schematron"><pattern><rule context="a"><assert test="b[">x</assert></rule></pattern></schema>`, "schematron: assert b[: "},
+		{`<schema xmlns="http://purl.oclc.org/dsdl/schematron"><pattern><rule context="a"><extends rule="r"/></rule></pattern></schema>`, `schematron: rule a: no abstract rule "r"`},
+		{`<schema xmlns="http://purl.oclc.org/dsdl/schematron"><pattern id="p" is-a="q"/></schema>`, `schematron: pattern "p": no abstract pattern "q"`},
+		{`<schema xmlns="http://purl.oclc.org/dsdl/schematron"><pattern><rule context="a"><assert test="b" diagnostics="d"/></rule></pattern></schema>`, `schematron: no diagnostic "d"`},
+		{`<schema xmlns="http://purl.oclc.org/dsdl/schematron"><pattern><include href="a.sch"/></pattern></schema>`, "without Options.Load"},
+	}
+	for _, tt := range tests {
+		_, err := Compile(mustParse(t, tt.schema), nil)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.schema, err, tt.err)
+		}
+	}
+}
+
+func TestValidateError(t *testing.T) {
+	s, err := Compile(mustParse(t, `<schema xmlns="http://www.ascc.net/xml/schematron">
+		<pattern name="p"><rule context="a"><assert test="$undefined">x</assert></rule></pattern></schema>`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Validate(mustParse(t, `<a/>`)); err == nil {
+		t.Error("undefined variable evaluated")
+	}
+}
+
+func TestValidateDeep(t *testing.T) {
+	s, err := Compile(mustParse(t, `<schema xmlns="http://purl.oclc.org/dsdl/schematron">
+		<pattern><rule context="a"><assert test="true()">x</assert></rule></pattern></schema>`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := &xmltree.Element{Type: xmltree.XML_Tag, StartElement: xml.StartElement{Name: xml.Name{Local: "a"}}}
+	for el, i := root, 0; i <= maxDepth; i++ {
+		el.Children = []xmltree.Element{{Type: xmltree.XML_Tag, StartElement: xml.StartElement{Name: xml.Name{Local: "a"}}}}
+		el = &el.Children[0]
+	}
+	if _, err := s.Validate(root); err != errDeep {
+		t.Errorf("got %v, want %v", err, errDeep)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	c := xmltree.NewXPathContext(mustParse(t, `<a/>`))
+	for _, s := range []string{``, `plain`, `it's`, `say "hi"`, `it's "x"`, `"''"`, `a'b"c'd`} {
+		got, err := c.EvalString(xmltree.MustCompileXPath(quote(s)), c.Root())
+		if err != nil || got != s {
+			t.Errorf("quote(%q) = %s, which gives %q, %v", s, quote(s), got, err)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package schematron
+
+import (
+	"encoding/xml"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pschou/go-xmltree"
+)
+
+// maxDepth limits the depth of documents validated.
+const maxDepth = 3000
+
+var errDeep = errors.New("schematron: document too deeply nested")
+
+// Validate evaluates the active patterns of the schema against the
+// document rooted at root, and returns the report as an
+// svrl:schematron-output element. Each pattern is listed as an
+// svrl:active-pattern, followed by an svrl:fired-rule for each node
+// matched by one of its rules, in document order. A node is only
+// matched by the first rule of a pattern whose context matches it.
+// Each fired rule is followed by an svrl:failed-assert for each assert
+// which failed, and an svrl:successful-report for each report whose
+// test held.
+//
+// The error returned is for expressions which cannot be evaluated, such
+// as those referring to undefined variables, and for documents nested
+// too deeply to check. A document which does not
+// meet the assertions of the schema is not an error; see FailedAsserts.
+func (s *Schema) Validate(root *xmltree.Element) (*xmltree.Element, error) {
+	e := &evaluator{s: s, c: xmltree.NewXPathContext(root), order: make(map[*xmltree.Element]int)}
+	e.c.Namespaces = s.namespaces
+	e.c.Functions = map[string]xmltree.XPathFunc{
+		"current": func(*xmltree.XPathContext, xmltree.Node, []interface{}) (interface{}, error) {
+			return []xmltree.Node{e.current}, nil
+		},
+	}
+	for name, fn := range s.functions {
+		e.c.Functions[name] = fn
+	}
+	var number func(el *xmltree.Element, depth int) error
+	number = func(el *xmltree.Element, depth int) error {
+		if depth > maxDepth {
+			return errDeep
+		}
+		e.order[el] = len(e.order)
+		for i := range el.Children {
+			if err := number(&el.Children[i], depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := number(root, 0); err != nil {
+		return nil, err
+	}
+
+	e.out = newReport()
+	if s.title != "" {
+		e.out.SetAttr("", "title", s.title)
+	}
+	if s.phase != "" {
+		e.out.SetAttr("", "phase", s.phase)
+	}
+	if s.version != "" {
+		e.out.SetAttr("", "schemaVersion", s.version)
+	}
+	for _, ns := range s.ns {
+		e.emit(e.svrl("ns-prefix-in-attribute-values", "uri", ns[1], "prefix", ns[0]))
+	}
+
+	doc := e.c.Root()
+	global, err := e.lets(s.lets, make(map[string]interface{}), doc)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range s.patterns {
+		if err := e.pattern(p, global); err != nil {
+			return nil, err
+		}
+	}
+	return e.out, nil
+}
+
+// FailedAsserts returns the svrl:failed-assert elements of a report
+// returned by Validate, which is empty if the document met every
+// assertion of the schema.
+func FailedAsserts(report *xmltree.Element) []*xmltree.Element {
+	return report.FindFunc(func(el *xmltree.Element) bool {
+		return el.Name.Space == svrlNS && el.Name.Local == "failed-assert"
+	})
+}
+
+// newReport returns an empty svrl:schematron-output element, declaring
+// the svrl prefix.
+func newReport() *xmltree.Element {
+	el, err := xmltree.Parse(strings.NewReader(`<svrl:schematron-output xmlns:svrl="` + svrlNS + `"/>`))
+	if err != nil {
+		panic(err)
+	}
+	return el
+}
+
+type evaluator struct {
+	s       *Schema
+	c       *xmltree.XPathContext
+	out     *xmltree.Element
+	current xmltree.Node
+	order   map[*xmltree.Element]int // document order
+}
+
+// svrl returns an SVRL element with the given attributes, given as pairs
+// of names and values. Attributes with empty values are left out.
+func (e *evaluator) svrl(local string, attrs ...string) *xmltree.Element {
+	el := &xmltree.Element{Type: xmltree.XML_Tag, Scope: e.out.Scope}
+	el.Name = xml.Name{Space: svrlNS, Local: local}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		if attrs[i+1] != "" {
+			el.SetAttr("", attrs[i], attrs[i+1])
+		}
+	}
+	return el
+}
+
+func (e *evaluator) emit(el *xmltree.Element) {
+	e.out.Children = append(e.out.Children, *el)
+}
+
+// lets evaluates variables with n as the context node, adding them to a
+// copy of vars.
+func (e *evaluator) lets(lets []let, vars map[string]interface{}, n xmltree.Node) (map[string]interface{}, error) {
+	if len(lets) == 0 {
+		return vars, nil
+	}
+	scope := make(map[string]interface{}, len(vars)+len(lets))
+	for name, v := range vars {
+		scope[name] = v
+	}
+	for _, l := range lets {
+		e.c.Variables = scope
+		e.current = n
+		v, err := e.c.Eval(l.value, n)
+		if err != nil {
+			return nil, err
+		}
+		scope[l.name] = v
+	}
+	return scope, nil
+}
+
+func (e *evaluator) pattern(p *pattern, global map[string]interface{}) error {
+	e.emit(e.svrl("active-pattern", "id", p.id, "name", p.title))
+	vars, err := e.lets(p.lets, global, e.c.Root())
+	if err != nil {
+		return err
+	}
+
+	type hit struct {
+		node xmltree.Node
+		rule *rule
+	}
+	var hits []hit
+	matched := make(map[xmltree.Node]bool)
+	for _, r := range p.rules {
+		e.c.Variables = vars
+		e.current = e.c.Root()
+		nodes, err := e.c.Select(r.match, e.c.Root())
+		if err != nil {
+			return err
+		}
+		for _, n := range nodes {
+			if !matched[n] {
+				matched[n] = true
+				hits = append(hits, hit{n, r})
+			}
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		a1, a2 := e.key(hits[i].node)
+		b1, b2 := e.key(hits[j].node)
+		return a1 < b1 || a1 == b1 && a2 < b2
+	})
+
+	for _, h := range hits {
+		r := h.rule
+		e.emit(e.svrl("fired-rule", "context", r.context, "id", r.id, "role", r.role, "flag", r.flag))
+		scope, err := e.lets(r.lets, vars, h.node)
+		if err != nil {
+			return err
+		}
+		for _, chk := range r.checks {
+			e.c.Variables = scope
+			e.current = h.node
+			ok, err := e.c.EvalBool(chk.x, h.node)
+			if err != nil {
+				return err
+			}
+			if ok != chk.report {
+				continue
+			}
+			local := "failed-assert"
+			if chk.report {
+				local = "successful-report"
+			}
+			el := e.svrl(local, "test", chk.test, "id", chk.id, "role", chk.role, "flag", chk.flag, "location", e.location(h.node))
+			for _, id := range chk.diagnostics {
+				text, err := e.text(e.s.diagnostics[id], h.node)
+				if err != nil {
+					return err
+				}
+				ref := e.svrl("diagnostic-reference", "diagnostic", id)
+				ref.Content = text
+				el.Children = append(el.Children, *ref)
+			}
+			text, err := e.text(chk.msg, h.node)
+			if err != nil {
+				return err
+			}
+			t := e.svrl("text")
+			t.Content = text
+			el.Children = append(el.Children, *t)
+			e.emit(el)
+		}
+	}
+	return nil
+}
+
+// text evaluates a message for the node n, collapsing white space.
+func (e *evaluator) text(msg message, n xmltree.Node) (string, error) {
+	var b strings.Builder
+	for _, p := range msg {
+		switch {
+		case p.name && p.x == nil:
+			b.WriteString(qname(n))
+		case p.name:
+			nodes, err := e.c.Select(p.x, n)
+			if err != nil {
+				return "", err
+			}
+			if len(nodes) > 0 {
+				b.WriteString(qname(nodes[0]))
+			}
+		case p.x != nil:
+			s, err := e.c.EvalString(p.x, n)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(s)
+		default:
+			b.WriteString(p.text)
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " "), nil
+}
+
+// qname returns the name of a node as written in the document.
+func qname(n xmltree.Node) string {
+	name := n.Name()
+	if n.Type != xmltree.ElementNode && n.Type != xmltree.AttributeNode || name.Space == "" {
+		return name.Local
+	}
+	if q := n.Element.Prefix(name); q != "" {
+		return q
+	}
+	return name.Local
+}
+
+// key returns the position of a node in document order.
+func (e *evaluator) key(n xmltree.Node) (int, int) {
+	switch {
+	case n.Type == xmltree.DocumentNode:
+		return -1, 0
+	case n.Type == xmltree.AttributeNode || n.Type == xmltree.NamespaceNode:
+		return e.order[n.Element], 1 + n.Index
+	case n.Type == xmltree.TextNode && n.Element.Type == xmltree.XML_Tag:
+		return e.order[n.Element], len(n.Element.StartElement.Attr) + 1
+	}
+	return e.order[n.Element], 0
+}
+
+// location returns a path to the node, written in XPath using the
+// namespace prefixes declared in the schema, so that it may be evaluated
+// with them.
+func (e *evaluator) location(n xmltree.Node) string {
+	parent, ok := e.c.Parent(n)
+	switch {
+	case n.Type == xmltree.DocumentNode:
+		return "/"
+	case n.Type == xmltree.AttributeNode:
+		return e.location(parent) + "/@" + e.nameTest(n.Name())
+	case n.Type == xmltree.NamespaceNode:
+		return e.location(parent) + "/namespace::" + n.Name().Local
+	case n.Type == xmltree.TextNode && n.Element.Type == xmltree.XML_Tag:
+		return e.location(parent) + "/text()[1]"
+	case !ok || parent.Type == xmltree.DocumentNode:
+		return "/" + e.nameTest(n.Name())
+	}
+	test := func(el *xmltree.Element) bool { return el.Type == n.Element.Type && el.Name == n.Element.Name }
+	step := e.nameTest(n.Name())
+	switch n.Type {
+	case xmltree.TextNode:
+		step = "text()"
+		test = func(el *xmltree.Element) bool {
+			return el.Type == xmltree.XML_CharData || el.Type == xmltree.XML_CDATA
+		}
+	case xmltree.CommentNode:
+		step = "comment()"
+	case xmltree.ProcInstNode:
+		step = "processing-instruction()"
+		test = func(el *xmltree.Element) bool { return el.Type == xmltree.XML_ProcInst }
+	}
+	pos := 0
+	for i := range parent.Element.Children {
+		child := &parent.Element.Children[i]
+		if test(child) {
+			pos++
+		}
+		if child == n.Element {
+			break
+		}
+	}
+	return e.location(parent) + "/" + step + "[" + strconv.Itoa(pos) + "]"
+}
+
+// nameTest returns a name test for an element or attribute name, using
+// the first prefix the schema declares for its namespace. For a
+// namespace without one, the test is written with namespace-uri() and
+// local-name().
+func (e *evaluator) nameTest(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	for _, ns := range e.s.ns {
+		if ns[1] == name.Space && ns[0] != "" {
+			return ns[0] + ":" + name.Local
+		}
+	}
+	return "*[namespace-uri()=" + quote(name.Space) + "][local-name()=" + quote(name.Local) + "]"
+}
+
+// quote returns s as an XPath string literal, or as a call of concat
+// if s holds both kinds of quote.
+func quote(s string) string {
+	switch {
+	case !strings.Contains(s, "'"):
+		return "'" + s + "'"
+	case !strings.Contains(s, `"`):
+		return `"` + s + `"`
+	}
+	var parts []string
+	for s != "" {
+		i := strings.IndexAny(s, `'"`)
+		if i < 0 {
+			i = len(s)
+		} else if i == 0 {
+			// A run of the same quote, within the other.
+			i = len(s) - len(strings.TrimLeft(s, s[:1]))
+		}
+		parts = append(parts, quote(s[:i]))
+		s = s[i:]
+	}
+	return "concat(" + strings.Join(parts, ", ") + ")"
+}
@@ -0,0 +1,65 @@
+package xmltree
+
+import (
+	"strings"
+)
+
+// Text returns the character data of el and all of its descendants,
+// concatenated in document order. Both the Content of elements without
+// children and the XML_CharData and XML_CDATA children built by Parse
+// are included; comments and processing instructions are not. For a
+// node other than a tag, Text returns its Content.
+//
+// ParseXML does not record where character data falls between child
+// elements, so for trees built by it the Content of an element precedes
+// the text of its children.
+func (el *Element) Text() string {
+	if el.Type != XML_Tag || len(el.Children) == 0 {
+		return el.Content
+	}
+	var sb strings.Builder
+	el.eachText(func(s string) { sb.WriteString(s) }, 0)
+	return sb.String()
+}
+
+// InnerText is like Text, but each run of character data is trimmed of
+// surrounding white space, empty runs are dropped, and the rest are
+// joined with sep. This gives readable text for mixed content, where
+// the words of a paragraph are split among child elements:
+//
+//	<p>Run <code>make</code> first.</p>
+//
+// has an InnerText(" ") of "Run make first.".
+func (el *Element) InnerText(sep string) string {
+	var parts []string
+	el.eachText(func(s string) {
+		if s = strings.TrimSpace(s); s != "" {
+			parts = append(parts, s)
+		}
+	}, 0)
+	return strings.Join(parts, sep)
+}
+
+// eachText calls fn for each run of character data within el, in
+// document order.
+func (el *Element) eachText(fn func(string), depth int) {
+	switch {
+	case el.Type == XML_CharData, el.Type == XML_CDATA:
+		fn(el.Content)
+		return
+	case el.Type != XML_Tag:
+		return
+	case len(el.Children) == 0:
+		fn(el.Content)
+		return
+	case el.Content != "":
+		// Built by ParseXML
+		fn(el.Content)
+	}
+	if depth >= recursionLimit {
+		return
+	}
+	for i := range el.Children {
+		el.Children[i].eachText(fn, depth+1)
+	}
+}
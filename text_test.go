@@ -0,0 +1,31 @@
+package xmltree
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	const doc = `<description xmlns:xhtml="http://www.w3.org/1999/xhtml">Set the
+	<xhtml:code>umask</xhtml:code> to <!-- not 022 --><xhtml:b>027</xhtml:b>:
+	<xhtml:pre>umask 027</xhtml:pre></description>`
+
+	el := parseFullDoc(t, []byte(doc))
+	if got, want := el.Text(), "Set the umask to 027: umask 027"; got != want {
+		t.Errorf("Text() = %q, wanted %q", got, want)
+	}
+	if got, want := el.InnerText("|"), "Set the|umask|to|027|:|umask 027"; got != want {
+		t.Errorf("InnerText(%q) = %q, wanted %q", "|", got, want)
+	}
+
+	el, err := ParseXML(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := el.InnerText(" "), "Set the\n\t to : umask 027 umask 027"; got != want {
+		t.Errorf("InnerText(%q) of ParseXML tree = %q, wanted %q", " ", got, want)
+	}
+	if got := el.Children[0].Text(); got != "umask" {
+		t.Errorf("Text() of leaf = %q, wanted %q", got, "umask")
+	}
+}
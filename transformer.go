@@ -0,0 +1,462 @@
+package xmltree
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// An Action is the edit made by a TransformRule to the elements it
+// selects.
+type Action uint8
+
+const (
+	// Rename the element to Name.
+	ActionRename Action = iota
+	// Set the attribute Name of the element to Value, adding it if it
+	// is missing.
+	ActionSetAttr
+	// Remove the attribute Name of the element.
+	ActionRemoveAttr
+	// Replace the element and its descendants with a copy of Content.
+	ActionReplace
+	// Delete the element and its descendants.
+	ActionDelete
+	// Wrap the element in a copy of Content, as its last child.
+	ActionWrap
+	// Move the element to the end of the children of the element
+	// selected by To.
+	ActionMove
+)
+
+var actionNames = [...]string{
+	ActionRename:     "rename",
+	ActionSetAttr:    "set attribute",
+	ActionRemoveAttr: "remove attribute",
+	ActionReplace:    "replace",
+	ActionDelete:     "delete",
+	ActionWrap:       "wrap",
+	ActionMove:       "move",
+}
+
+func (a Action) String() string {
+	if int(a) < len(actionNames) {
+		return actionNames[a]
+	}
+	return "Action(" + strconv.Itoa(int(a)) + ")"
+}
+
+// A TransformRule pairs a selector with the Action to take on each
+// element it selects.
+type TransformRule struct {
+	// Select selects the elements to edit. It is evaluated from the
+	// root of the tree, and must select only elements.
+	Select *XPath
+	Action Action
+	// Name is the new name of the element for ActionRename, and the
+	// name of the attribute for ActionSetAttr and ActionRemoveAttr.
+	Name xml.Name
+	// Value is the value of the attribute for ActionSetAttr.
+	Value string
+	// Content is the replacement for ActionReplace, and the wrapper
+	// for ActionWrap, which must be an XML_Tag. It is copied for each
+	// element edited, and is not modified.
+	Content *Element
+	// To selects the destination for ActionMove. It is evaluated from
+	// the root of the tree, and must select a single element.
+	To *XPath
+}
+
+// A Transformer applies an ordered list of TransformRules to a tree.
+//
+// Every selector is evaluated against the tree as it was before any
+// edits, and the tree is then rebuilt in a single walk. Unlike edits
+// made through the pointers handed out by WalkFunc, rules may freely
+// delete, move and wrap elements without disturbing one another.
+//
+// An element selected by several rules is edited by each of them in
+// the order of the rules. Once an element is deleted or replaced, later
+// rules selecting it are ignored, as are the rules selecting its
+// descendants, unless they are moved out of it. An element is moved by
+// the first rule moving it.
+type Transformer struct {
+	Rules []TransformRule
+	// Namespaces maps the namespace prefixes used in selectors to
+	// namespace URIs, as for XPathContext.
+	Namespaces map[string]string
+}
+
+// An Edit is a change made by a Transformer.
+type Edit struct {
+	// Rule is the index in Rules of the rule which made the change.
+	Rule   int
+	Action Action
+	// Path is the location of the edited element in the tree before
+	// the transformation, in an XPath-like syntax, and NewPath the
+	// location of the destination of a move.
+	Path, NewPath string
+	// For attribute changes, the name of the attribute.
+	Attr xml.Name
+	// The old and new values of an attribute, or the old and new names
+	// of a renamed element.
+	OldValue, NewValue string
+}
+
+func (e Edit) String() string {
+	switch e.Action {
+	case ActionRename:
+		return fmt.Sprintf("%s %s -> %s", e.Action, e.Path, e.NewValue)
+	case ActionSetAttr:
+		return fmt.Sprintf("%s %s/@%s %q -> %q", e.Action, e.Path, e.Attr.Local, e.OldValue, e.NewValue)
+	case ActionRemoveAttr:
+		return fmt.Sprintf("%s %s/@%s=%q", e.Action, e.Path, e.Attr.Local, e.OldValue)
+	case ActionMove:
+		return fmt.Sprintf("%s %s -> %s", e.Action, e.Path, e.NewPath)
+	}
+	return fmt.Sprintf("%s %s", e.Action, e.Path)
+}
+
+// Apply applies the rules of the Transformer to the tree rooted at
+// root, and returns the changes made, in document order of the
+// resulting tree. If a rule cannot be applied, Apply returns an error
+// and root is not modified.
+func (t *Transformer) Apply(root *Element) ([]Edit, error) {
+	tr := transformer{
+		t:     t,
+		c:     NewXPathContext(root),
+		plans: make(map[*Element]*transformPlan),
+		moves: make(map[*Element][]*Element),
+	}
+	tr.c.Namespaces = t.Namespaces
+	if err := tr.plan(root); err != nil {
+		return nil, err
+	}
+	result, _ := tr.process(root, Scope{}, 0)
+	if tr.err != nil {
+		return nil, tr.err
+	}
+	for _, el := range tr.moved {
+		if p := tr.plans[el]; !p.deleted && !tr.placed[el] {
+			return nil, fmt.Errorf("xmltree: transform rule %d: destination of %s is removed", p.moveRule, tr.path(el))
+		}
+	}
+	*root = result
+	return tr.edits, nil
+}
+
+// A transformPlan holds the rules selecting an element.
+type transformPlan struct {
+	rules    []int
+	moveTo   *Element
+	moveRule int
+	deleted  bool
+	done     bool // deleted or replaced
+}
+
+type transformer struct {
+	t      *Transformer
+	c      *XPathContext
+	plans  map[*Element]*transformPlan
+	moves  map[*Element][]*Element // moved elements by destination
+	moved  []*Element              // in document order
+	placed map[*Element]bool
+	edits  []Edit
+	err    error
+}
+
+// plan evaluates the selectors of the rules and records the rules
+// selecting each element.
+func (tr *transformer) plan(root *Element) error {
+	for i := range tr.t.Rules {
+		r := &tr.t.Rules[i]
+		if err := checkRule(r); err != nil {
+			return fmt.Errorf("xmltree: transform rule %d: %v", i, err)
+		}
+		els, err := tr.elements(r.Select)
+		if err != nil {
+			return fmt.Errorf("xmltree: transform rule %d: %v", i, err)
+		}
+		var dest *Element
+		if r.Action == ActionMove {
+			to, err := tr.elements(r.To)
+			if err != nil {
+				return fmt.Errorf("xmltree: transform rule %d: %v", i, err)
+			}
+			if len(to) != 1 {
+				return fmt.Errorf("xmltree: transform rule %d: destination selects %d elements", i, len(to))
+			}
+			dest = to[0]
+		}
+		for _, el := range els {
+			p := tr.plans[el]
+			if p == nil {
+				p = new(transformPlan)
+				tr.plans[el] = p
+			}
+			if p.done {
+				continue
+			}
+			switch r.Action {
+			case ActionDelete:
+				if el == root {
+					return fmt.Errorf("xmltree: transform rule %d: cannot delete the document element", i)
+				}
+				p.deleted, p.done = true, true
+			case ActionReplace:
+				if el == root && r.Content.Type != XML_Tag {
+					return fmt.Errorf("xmltree: transform rule %d: document element must be replaced by an element", i)
+				}
+				p.done = true
+			case ActionMove:
+				if p.moveTo != nil {
+					continue
+				}
+				for n := dest; n != nil; n = tr.c.parent[n] {
+					if n == el {
+						return fmt.Errorf("xmltree: transform rule %d: cannot move %s into itself", i, tr.path(el))
+					}
+				}
+				p.moveTo, p.moveRule = dest, i
+				tr.moved = append(tr.moved, el)
+			}
+			p.rules = append(p.rules, i)
+		}
+	}
+
+	moved := tr.moved
+	sort.Slice(moved, func(i, j int) bool { return tr.c.order[moved[i]] < tr.c.order[moved[j]] })
+	tr.placed = make(map[*Element]bool)
+	for _, el := range moved {
+		if p := tr.plans[el]; !p.deleted {
+			tr.moves[p.moveTo] = append(tr.moves[p.moveTo], el)
+		}
+	}
+	for _, el := range moved {
+		if p := tr.plans[el]; !p.deleted && tr.inside(p.moveTo, el) {
+			return fmt.Errorf("xmltree: transform rule %d: moving %s into %s, which is moved into it, makes a cycle", p.moveRule, tr.path(el), tr.path(p.moveTo))
+		}
+	}
+	return nil
+}
+
+// inside reports whether el would be within the subtree of ancestor
+// once the moves are made.
+func (tr *transformer) inside(el, ancestor *Element) bool {
+	seen := make(map[*Element]bool)
+	for n := el; n != nil && !seen[n]; {
+		if n == ancestor {
+			return true
+		}
+		seen[n] = true
+		if p := tr.plans[n]; p != nil && p.moveTo != nil && !p.deleted {
+			n = p.moveTo
+		} else {
+			n = tr.c.parent[n]
+		}
+	}
+	return false
+}
+
+func checkRule(r *TransformRule) error {
+	if r.Select == nil {
+		return fmt.Errorf("no selector")
+	}
+	switch r.Action {
+	case ActionRename, ActionSetAttr, ActionRemoveAttr:
+		if r.Name.Local == "" {
+			return fmt.Errorf("%s without a name", r.Action)
+		}
+	case ActionReplace:
+		if r.Content == nil {
+			return fmt.Errorf("replace without content")
+		}
+	case ActionWrap:
+		if r.Content == nil || r.Content.Type != XML_Tag {
+			return fmt.Errorf("wrap without an element")
+		}
+	case ActionMove:
+		if r.To == nil {
+			return fmt.Errorf("move without a destination")
+		}
+	case ActionDelete:
+	default:
+		return fmt.Errorf("unknown %s", r.Action)
+	}
+	return nil
+}
+
+// elements returns the elements selected by x.
+func (tr *transformer) elements(x *XPath) ([]*Element, error) {
+	nodes, err := tr.c.Select(x, tr.c.Root())
+	if err != nil {
+		return nil, err
+	}
+	els := make([]*Element, len(nodes))
+	for i, n := range nodes {
+		if n.Type != ElementNode {
+			return nil, fmt.Errorf("%q selects a node which is not an element", x.src)
+		}
+		els[i] = n.Element
+	}
+	return els, nil
+}
+
+// path returns the location of an element in the original tree.
+func (tr *transformer) path(el *Element) string {
+	name := qualify(&el.Scope, el.Name)
+	parent, ok := tr.c.parent[el]
+	if !ok {
+		return "/" + name
+	}
+	pos := 0
+	for i := 0; i <= tr.c.index[el]; i++ {
+		if c := &parent.Children[i]; c.Type == XML_Tag && c.Name == el.Name {
+			pos++
+		}
+	}
+	return fmt.Sprintf("%s/%s[%d]", tr.path(parent), name, pos)
+}
+
+// process returns the transformed copy of el, to be placed in an
+// element with the given scope, or false if el is deleted.
+func (tr *transformer) process(el *Element, parent Scope, depth int) (Element, bool) {
+	if depth > recursionLimit {
+		tr.err = errDeepXML
+		return Element{}, false
+	}
+	out := *el
+	out.StartElement.Attr = append([]xml.Attr(nil), el.StartElement.Attr...)
+	out.Children = nil
+	changed := false
+	rename := -1
+	var repl *Element
+	var wraps []*Element
+
+	p := tr.plans[el]
+	if p == nil {
+		p = new(transformPlan)
+	}
+	for _, i := range p.rules {
+		r := &tr.t.Rules[i]
+		edit := Edit{Rule: i, Action: r.Action, Path: tr.path(el)}
+		switch r.Action {
+		case ActionRename:
+			if out.Name == r.Name {
+				continue
+			}
+			edit.OldValue = qualify(&out.Scope, out.Name)
+			out.Name = r.Name
+			rename = len(tr.edits)
+		case ActionSetAttr:
+			edit.Attr, edit.NewValue = r.Name, r.Value
+			if i := attrIndex(&out, r.Name); i >= 0 {
+				if out.StartElement.Attr[i].Value == r.Value {
+					continue
+				}
+				edit.OldValue = out.StartElement.Attr[i].Value
+				out.StartElement.Attr[i].Value = r.Value
+			} else {
+				out.StartElement.Attr = append(out.StartElement.Attr, xml.Attr{Name: r.Name, Value: r.Value})
+			}
+		case ActionRemoveAttr:
+			i := attrIndex(&out, r.Name)
+			if i < 0 {
+				continue
+			}
+			edit.Attr, edit.OldValue = r.Name, out.StartElement.Attr[i].Value
+			out.StartElement.Attr = append(out.StartElement.Attr[:i], out.StartElement.Attr[i+1:]...)
+		case ActionReplace:
+			repl = r.Content
+		case ActionDelete:
+			if rename >= 0 {
+				tr.edits[rename].NewValue = qualify(&out.Scope, out.Name)
+			}
+			tr.edits = append(tr.edits, edit)
+			return Element{}, false
+		case ActionWrap:
+			wraps = append(wraps, r.Content)
+		case ActionMove:
+			edit.NewPath = tr.path(p.moveTo)
+		}
+		changed = true
+		tr.edits = append(tr.edits, edit)
+	}
+
+	// The wrappers are built from the outside in, so that each is
+	// scoped within the next.
+	wrappers := make([]Element, len(wraps))
+	for i := len(wraps) - 1; i >= 0; i-- {
+		wrappers[i] = wraps[i].clone(0)
+		wrappers[i].rescope(parent)
+		parent = wrappers[i].Scope
+	}
+
+	if repl != nil {
+		out = repl.clone(0)
+		out.rescope(parent)
+	} else {
+		orig := Scope{}
+		if p, ok := tr.c.parent[el]; ok {
+			orig = p.Scope
+		}
+		if changed || !equalNames(parent.ns, orig.ns) {
+			out.rescope(parent)
+		}
+		rescoped := !equalNames(out.Scope.ns, el.Scope.ns)
+		for i := range el.Children {
+			c := &el.Children[i]
+			if c.Type != XML_Tag {
+				n := *c
+				if rescoped {
+					n.Scope = out.Scope
+				}
+				out.Children = append(out.Children, n)
+				continue
+			}
+			if p := tr.plans[c]; p != nil && p.moveTo != nil && !p.deleted {
+				continue // placed at its destination
+			}
+			if n, ok := tr.process(c, out.Scope, depth+1); ok {
+				out.Children = append(out.Children, n)
+			}
+		}
+		if moved := tr.moves[el]; len(moved) > 0 {
+			if len(out.Children) == 0 && out.Content != "" {
+				out.Children = []Element{{Type: XML_CharData, Content: out.Content, Scope: out.Scope}}
+				out.Content = ""
+			}
+			for _, m := range moved {
+				tr.placed[m] = true
+				if n, ok := tr.process(m, out.Scope, depth+1); ok {
+					out.Children = append(out.Children, n)
+				}
+			}
+		}
+	}
+	if rename >= 0 {
+		tr.edits[rename].NewValue = qualify(&out.Scope, out.Name)
+	}
+
+	for i := range wrappers {
+		w := &wrappers[i]
+		if len(w.Children) == 0 && w.Content != "" {
+			w.Children = []Element{{Type: XML_CharData, Content: w.Content, Scope: w.Scope}}
+			w.Content = ""
+		}
+		w.Children = append(w.Children, out)
+		out = *w
+	}
+	return out, true
+}
+
+// attrIndex returns the index of the attribute of el with the given
+// name, or -1.
+func attrIndex(el *Element, name xml.Name) int {
+	for i, a := range el.StartElement.Attr {
+		if a.Name == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,138 @@
+package xmltree
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestTransformer(t *testing.T) {
+	root := parseDoc(t, []byte(`<Benchmark xmlns="urn:xccdf" xmlns:x="urn:extra">`+
+		`<Group id="g1"><Rule id="r1" severity="low"><title>One</title></Rule><Rule id="r2" x:draft="1"><title>Two</title></Rule></Group>`+
+		`<Group id="g2"><Rule id="r3" severity="high"/></Group>`+
+		`<Profile id="p1"/><notes>text</notes></Benchmark>`))
+	wrapper := parseDoc(t, []byte(`<section xmlns="urn:html" class="rules"/>`))
+	replacement := parseDoc(t, []byte(`<removed xmlns="urn:xccdf"/>`))
+
+	tf := &Transformer{
+		Namespaces: map[string]string{"c": "urn:xccdf", "x": "urn:extra"},
+		Rules: []TransformRule{
+			{Select: MustCompileXPath(`//c:Rule[@severity = 'low']`), Action: ActionSetAttr, Name: xml.Name{Local: "severity"}, Value: "medium"},
+			{Select: MustCompileXPath(`//c:Rule`), Action: ActionRemoveAttr, Name: xml.Name{Space: "urn:extra", Local: "draft"}},
+			{Select: MustCompileXPath(`//c:Group`), Action: ActionRename, Name: xml.Name{Space: "urn:xccdf", Local: "Section"}},
+			{Select: MustCompileXPath(`//c:Rule[@id = 'r3']`), Action: ActionMove, To: MustCompileXPath(`//c:Group[@id = 'g1']`)},
+			{Select: MustCompileXPath(`//c:Group[@id = 'g2']`), Action: ActionDelete},
+			{Select: MustCompileXPath(`//c:Profile`), Action: ActionReplace, Content: replacement},
+			{Select: MustCompileXPath(`//c:Group`), Action: ActionWrap, Content: wrapper},
+			{Select: MustCompileXPath(`//c:notes`), Action: ActionMove, To: MustCompileXPath(`//c:Rule[@id = 'r1']`)},
+			{Select: MustCompileXPath(`//c:Rule`), Action: ActionSetAttr, Name: xml.Name{Space: "urn:new", Local: "seen"}, Value: "yes"},
+		},
+	}
+	edits, err := tf.Apply(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `<Benchmark xmlns:x="urn:extra" xmlns="urn:xccdf">` +
+		`<section class="rules" xmlns="urn:html"><Section id="g1" xmlns="urn:xccdf">` +
+		`<Rule id="r1" severity="medium" ns:seen="yes" xmlns:ns="urn:new"><title>One</title><notes>text</notes></Rule>` +
+		`<Rule id="r2" ns:seen="yes" xmlns:ns="urn:new"><title>Two</title></Rule>` +
+		`<Rule id="r3" severity="high" ns:seen="yes" xmlns:ns="urn:new" />` +
+		`</Section></section>` +
+		`<removed /></Benchmark>`
+	if got := root.String(); got != want {
+		t.Errorf("got\n%s\nwanted\n%s", got, want)
+	}
+	again := parseDoc(t, []byte(root.String()))
+	if s := again.Children[0].Children[0]; s.Name != (xml.Name{Space: "urn:xccdf", Local: "Section"}) {
+		t.Errorf("wrapped element reparsed as %v", s.Name)
+	}
+
+	var report []string
+	for _, e := range edits {
+		report = append(report, e.String())
+	}
+	wantReport := []string{
+		`rename /Benchmark/Group[1] -> Section`,
+		`wrap /Benchmark/Group[1]`,
+		`set attribute /Benchmark/Group[1]/Rule[1]/@severity "low" -> "medium"`,
+		`set attribute /Benchmark/Group[1]/Rule[1]/@seen "" -> "yes"`,
+		`move /Benchmark/notes[1] -> /Benchmark/Group[1]/Rule[1]`,
+		`remove attribute /Benchmark/Group[1]/Rule[2]/@draft="1"`,
+		`set attribute /Benchmark/Group[1]/Rule[2]/@seen "" -> "yes"`,
+		`move /Benchmark/Group[2]/Rule[1] -> /Benchmark/Group[1]`,
+		`set attribute /Benchmark/Group[2]/Rule[1]/@seen "" -> "yes"`,
+		`rename /Benchmark/Group[2] -> Section`,
+		`delete /Benchmark/Group[2]`,
+		`replace /Benchmark/Profile[1]`,
+	}
+	if strings.Join(report, "\n") != strings.Join(wantReport, "\n") {
+		t.Errorf("got edits\n%s\nwanted\n%s", strings.Join(report, "\n"), strings.Join(wantReport, "\n"))
+	}
+	if wrapper.Children != nil || replacement.Name.Local != "removed" {
+		t.Error("rule content was modified")
+	}
+}
+
+func TestTransformerErrors(t *testing.T) {
+	doc := []byte(`<a><b><c/></b><d/></a>`)
+	for _, tt := range []struct {
+		rule TransformRule
+		want string
+	}{
+		{TransformRule{Select: MustCompileXPath(`/a`), Action: ActionDelete}, "cannot delete the document element"},
+		{TransformRule{Select: MustCompileXPath(`//b`), Action: ActionMove, To: MustCompileXPath(`//c`)}, "into itself"},
+		{TransformRule{Select: MustCompileXPath(`//b`), Action: ActionMove, To: MustCompileXPath(`//*`)}, "destination selects 4 elements"},
+		{TransformRule{Select: MustCompileXPath(`/`), Action: ActionDelete}, "not an element"},
+		{TransformRule{Select: MustCompileXPath(`//b`), Action: ActionRename}, "rename without a name"},
+		{TransformRule{Select: MustCompileXPath(`//b`), Action: ActionWrap}, "wrap without an element"},
+	} {
+		root := parseDoc(t, doc)
+		if _, err := (&Transformer{Rules: []TransformRule{tt.rule}}).Apply(root); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("got %v, wanted an error containing %q", err, tt.want)
+		}
+	}
+
+	// A move into a deleted element fails, leaving the tree unchanged.
+	root := parseDoc(t, doc)
+	_, err := (&Transformer{Rules: []TransformRule{
+		{Select: MustCompileXPath(`//d`), Action: ActionMove, To: MustCompileXPath(`//c`)},
+		{Select: MustCompileXPath(`//b`), Action: ActionDelete},
+	}}).Apply(root)
+	if err == nil || !strings.Contains(err.Error(), "destination of /a/d[1] is removed") {
+		t.Errorf("got %v, wanted an error moving into a deleted element", err)
+	}
+	if got := root.String(); got != `<a><b><c /></b><d /></a>` {
+		t.Errorf("tree modified to %s", got)
+	}
+
+	// Elements moved into each other's subtrees make a cycle, which
+	// is reported for the first of them in document order.
+	for i := 0; i < 10; i++ {
+		root = parseDoc(t, []byte(`<r><a><ad/></a><b><bd/></b></r>`))
+		_, err = (&Transformer{Rules: []TransformRule{
+			{Select: MustCompileXPath(`//b`), Action: ActionMove, To: MustCompileXPath(`//ad`)},
+			{Select: MustCompileXPath(`//a`), Action: ActionMove, To: MustCompileXPath(`//bd`)},
+		}}).Apply(root)
+		if want := "transform rule 1: moving /r/a[1] into /r/b[1]/bd[1], which is moved into it, makes a cycle"; err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("got %v, wanted %q", err, want)
+		}
+	}
+}
+
+func TestTransformerDeep(t *testing.T) {
+	root := &Element{Type: XML_Tag, StartElement: xml.StartElement{Name: xml.Name{Local: "e"}}}
+	for el, i := root, 0; i <= recursionLimit; i++ {
+		el.Children = []Element{{Type: XML_Tag, StartElement: xml.StartElement{Name: xml.Name{Local: "e"}}}}
+		el = &el.Children[0]
+	}
+	_, err := (&Transformer{Rules: []TransformRule{
+		{Select: MustCompileXPath(`/e`), Action: ActionRename, Name: xml.Name{Local: "f"}},
+	}}).Apply(root)
+	if err != errDeepXML {
+		t.Errorf("got %v, wanted %v", err, errDeepXML)
+	}
+	if root.Name.Local != "e" {
+		t.Errorf("tree modified")
+	}
+}
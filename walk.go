@@ -18,6 +18,8 @@ func (el *Element) Each(fn func(*Element) error) (err error) {
 // The WalkDepthFunc method calls Func for each of the Element's children in a
 // depth-first order.  If the Func returns true the children will
 // continue to be considered, otherwise the depth is no longer searched.
+// The Func must not add or remove children of the elements it is
+// given; a Transformer can make such edits instead.
 func (el *Element) WalkDepthFunc(fn func(*Element) bool) {
 	el.walkDepthDeep(fn, recursionLimit)
 }
@@ -35,7 +37,8 @@ func (el *Element) walkDepthDeep(fn func(*Element) bool, n int) {
 
 // The WalkFunc method calls Func for each of the Element's children in a
 // depth-first order.  If the Func returns a non-nil error, WalkFunc will
-// return it immediately.
+// return it immediately. As with WalkDepthFunc, the Func must not add
+// or remove children of the elements it is given.
 func (el *Element) WalkFunc(fn func(*Element) error) (err error) {
 	return el.walkFuncDeep(fn, recursionLimit)
 }
@@ -0,0 +1,67 @@
+package xmltree
+
+import (
+	"strings"
+	"unicode"
+)
+
+// A Whitespace is a policy for the white space in character data, used
+// when parsing a document with ParseWithOptions. Within an element marked
+// xml:space="preserve", or a descendant not marked xml:space="default",
+// white space is always preserved.
+type Whitespace uint8
+
+const (
+	// WhitespaceTrim is the policy used by Parse. White space at
+	// either end of a run of character data is reduced to a single
+	// space, runs of nothing but white space are dropped, and the text
+	// of an element with no other children is trimmed.
+	WhitespaceTrim Whitespace = iota
+	// WhitespacePreserve keeps all character data as written,
+	// including runs of nothing but white space between elements.
+	WhitespacePreserve
+	// WhitespaceNormalize is like WhitespaceTrim, but also reduces
+	// every run of white space within the text to a single space.
+	WhitespaceNormalize
+	// WhitespaceIgnorable drops runs of nothing but white space, such
+	// as the indentation between elements, and keeps all other
+	// character data as written.
+	WhitespaceIgnorable
+)
+
+// apply returns a run of character data after applying the policy. An
+// empty result means the run is dropped.
+func (ws Whitespace) apply(s string) string {
+	switch ws {
+	case WhitespacePreserve:
+		return s
+	case WhitespaceIgnorable:
+		if strings.TrimSpace(s) == "" {
+			return ""
+		}
+		return s
+	case WhitespaceNormalize:
+		fields := strings.Fields(s)
+		if len(fields) == 0 {
+			return ""
+		}
+		return padSpace(s, strings.Join(fields, " "))
+	}
+	text := strings.TrimFunc(s, unicode.IsSpace)
+	if text == "" {
+		return ""
+	}
+	return padSpace(s, text)
+}
+
+// padSpace adds a single space to either end of text where s begins or
+// ends with white space.
+func padSpace(s, text string) string {
+	if len(strings.TrimLeftFunc(s, unicode.IsSpace)) < len(s) {
+		text = " " + text
+	}
+	if len(strings.TrimRightFunc(s, unicode.IsSpace)) < len(s) {
+		text += " "
+	}
+	return text
+}
@@ -0,0 +1,90 @@
+package xmltree
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWhitespace(t *testing.T) {
+	const doc = "<doc>\n  <p>  one\n  <b>two</b>  three  </p>\n  <t>  x  y  </t>\n</doc>"
+	for _, tt := range []struct {
+		ws       Whitespace
+		children int      // of doc
+		p        []string // children of p
+		text     string   // content of t
+	}{
+		{WhitespaceTrim, 2, []string{" one ", "two", " three "}, "x  y"},
+		{WhitespacePreserve, 5, []string{"  one\n  ", "two", "  three  "}, "  x  y  "},
+		{WhitespaceNormalize, 2, []string{" one ", "two", " three "}, "x y"},
+		{WhitespaceIgnorable, 2, []string{"  one\n  ", "two", "  three  "}, "  x  y  "},
+	} {
+		root, err := ParseWithOptions(strings.NewReader(doc), &ParseOptions{Whitespace: tt.ws})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(root.Children) != tt.children {
+			t.Errorf("policy %d: root has %d children, wanted %d", tt.ws, len(root.Children), tt.children)
+			continue
+		}
+		p, _ := root.Query("p")
+		var got []string
+		for _, c := range p[0].Children {
+			got = append(got, c.Content)
+		}
+		if strings.Join(got, "|") != strings.Join(tt.p, "|") {
+			t.Errorf("policy %d: got mixed content %q, wanted %q", tt.ws, got, tt.p)
+		}
+		if tl, _ := root.Query("t"); tl[0].Content != tt.text {
+			t.Errorf("policy %d: got text %q, wanted %q", tt.ws, tl[0].Content, tt.text)
+		}
+	}
+}
+
+func TestXMLSpace(t *testing.T) {
+	const doc = `<doc><pre xml:space="preserve">  a
+  <b>  b  </b>
+<c xml:space="default">  c  </c></pre><d>  d  </d></doc>`
+	root := parseFullDoc(t, []byte(doc))
+	pre := root.Children[0]
+	if got := pre.Children[0].Content; got != "  a\n  " {
+		t.Errorf("preserved text trimmed to %q", got)
+	}
+	if got := pre.Children[1].Content; got != "  b  " {
+		t.Errorf("inherited xml:space ignored: %q", got)
+	}
+	if got := pre.Children[3].Content; got != "c" {
+		t.Errorf("xml:space=\"default\" ignored: %q", got)
+	}
+	if got := root.Children[1].Content; got != "d" {
+		t.Errorf("text outside xml:space trimmed to %q", got)
+	}
+
+	// The indenting encoder must not add white space within pre.
+	out := string(MarshalIndent(root, "", "  "))
+	if !strings.Contains(out, "<pre xml:space=\"preserve\">  a\n  <b>  b  </b>\n<c xml:space=\"default\">c</c></pre>\n") {
+		t.Errorf("MarshalIndent changed preserved white space:\n%s", out)
+	}
+	again := parseFullDoc(t, []byte(out))
+	if !EqualWithOptions(root, again, &EqualOptions{Ordered: true, Whitespace: true}) {
+		t.Errorf("MarshalIndent output does not round trip:\n%s\n%s", root, again)
+	}
+}
+
+func TestWhitespaceMultibyte(t *testing.T) {
+	for _, tt := range []struct {
+		s, trim, normalize string
+	}{
+		{"voilà", "voilà", "voilà"},
+		{"Å", "Å", "Å"},
+		{"Été  à", "Été  à", "Été à"},
+		{"\u00a0Été\u00a0", " Été ", " Été "},
+		{"\u3000日本\u3000", " 日本 ", " 日本 "},
+	} {
+		if got := WhitespaceTrim.apply(tt.s); got != tt.trim {
+			t.Errorf("trim %q: got %q, wanted %q", tt.s, got, tt.trim)
+		}
+		if got := WhitespaceNormalize.apply(tt.s); got != tt.normalize {
+			t.Errorf("normalize %q: got %q, wanted %q", tt.s, got, tt.normalize)
+		}
+	}
+}
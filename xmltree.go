@@ -14,7 +14,6 @@ import (
 	"io"
 	"sort"
 	"strings"
-	"unicode"
 
 	"golang.org/x/net/html/charset"
 )
@@ -43,6 +42,7 @@ const (
 	XML_Comment
 	XML_ProcInst
 	XML_Directive
+	XML_CDATA
 )
 
 // An Element represents a single element in an XML document. Elements
@@ -80,6 +80,240 @@ func Unmarshal(el *Element, v interface{}) error {
 	return xml.Unmarshal(Marshal(el), v)
 }
 
+// UnmarshalXML implements the xml.Unmarshaler interface, allowing an
+// *Element to be used as an "any XML" field within an ordinary tagged
+// struct. The element and all of its content are captured as they would
+// be by Parse.
+//
+// The xml.Decoder does not report the namespaces declared outside of
+// the element. A caller which knows them may set the Scope of the
+// Element before unmarshaling, such as to the Scope of an element of a
+// parsed tree, and those declarations are kept, so that QNames in
+// attribute values such as xsi:type resolve. Otherwise, any namespace
+// used in the names of the subtree which is not declared within it is
+// declared on the element itself, with a generated prefix of the form
+// ns0, ns1 and so on, and QNames in attribute values using outer
+// prefixes do not resolve.
+func (el *Element) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	*el = Element{Type: XML_Tag, StartElement: start.Copy(), Scope: outerScope(el.Scope, start)}
+	scanner := scanner{Decoder: d}
+	if err := el.parse(&scanner, Kind(0xff), WhitespaceTrim, 0); err != nil {
+		return err
+	}
+	el.declareMissingNS()
+	return nil
+}
+
+// outerScope returns the declarations of scope, other than those of
+// prefixes which the start element declares itself.
+func outerScope(scope Scope, start xml.StartElement) Scope {
+	own := make(map[string]bool)
+	for _, a := range start.Attr {
+		switch {
+		case a.Name.Space == "xmlns":
+			own[a.Name.Local] = true
+		case a.Name.Space == "" && a.Name.Local == "xmlns":
+			own[""] = true
+		}
+	}
+	var ns []xml.Name
+	for _, d := range scope.ns {
+		if !own[d.Local] {
+			ns = append(ns, d)
+		}
+	}
+	return Scope{ns: ns}
+}
+
+// declareMissingNS adds namespace declarations to the Element for every
+// namespace used in the subtree that cannot be resolved from the scope
+// where it is used.
+func (el *Element) declareMissingNS() {
+	var (
+		missing   []string
+		seen      = make(map[string]bool)
+		unqualTag bool
+	)
+	need := func(scope *Scope, space string) {
+		switch space {
+		case "", xmlLangURI, xmlNamespaceURI, "xmlns":
+			return
+		}
+		if seen[space] || scope.Prefix(xml.Name{Space: space, Local: "x"}) != "" {
+			return
+		}
+		seen[space] = true
+		missing = append(missing, space)
+	}
+	check := func(e *Element) {
+		if e.Name.Space == "" {
+			unqualTag = true
+		}
+		need(&e.Scope, e.Name.Space)
+		for _, a := range e.StartElement.Attr {
+			need(&e.Scope, a.Name.Space)
+		}
+	}
+	check(el)
+	el.WalkFunc(func(e *Element) error {
+		check(e)
+		return nil
+	})
+	if len(missing) == 0 {
+		return
+	}
+
+	prefixes := make(map[string]bool)
+	for _, ns := range el.Scope.ns {
+		prefixes[ns.Local] = true
+	}
+	el.WalkFunc(func(e *Element) error {
+		for _, ns := range e.Scope.ns {
+			prefixes[ns.Local] = true
+		}
+		return nil
+	})
+	var decls []xml.Name
+	for _, space := range missing {
+		if space == el.Name.Space && !unqualTag && !prefixes[""] {
+			decls = append(decls, xml.Name{Space: space})
+			prefixes[""] = true
+			continue
+		}
+		for i := 0; ; i++ {
+			if p := fmt.Sprintf("ns%d", i); !prefixes[p] {
+				decls = append(decls, xml.Name{Space: space, Local: p})
+				prefixes[p] = true
+				break
+			}
+		}
+	}
+	sort.Sort(byXMLName(decls))
+	el.inheritNS(decls)
+}
+
+// inheritNS prepends namespace declarations to the scope of the Element
+// and all of its descendants, as if they had been declared by an
+// ancestor.
+func (el *Element) inheritNS(decls []xml.Name) {
+	ns := make([]xml.Name, 0, len(decls)+len(el.Scope.ns))
+	el.Scope.ns = append(append(ns, decls...), el.Scope.ns...)
+	for i := range el.Children {
+		el.Children[i].inheritNS(decls)
+	}
+}
+
+// Clone returns a deep copy of the Element, which may be modified
+// without affecting the original.
+func (el *Element) Clone() *Element {
+	c := el.clone(0)
+	return &c
+}
+
+func (el *Element) clone(depth int) Element {
+	c := *el
+	if el.StartElement.Attr != nil {
+		c.StartElement.Attr = append([]xml.Attr(nil), el.StartElement.Attr...)
+	}
+	if el.Scope.ns != nil {
+		c.Scope.ns = append([]xml.Name(nil), el.Scope.ns...)
+	}
+	if el.Children != nil && depth < recursionLimit {
+		c.Children = make([]Element, len(el.Children))
+		for i := range el.Children {
+			c.Children[i] = el.Children[i].clone(depth + 1)
+		}
+	}
+	return c
+}
+
+// rescope moves an Element, which may have been taken from another
+// document, into the namespace scope of a new parent. The Scope of the
+// Element and its descendants is rebuilt on top of parent, declaring
+// only those namespaces which the subtree uses and which are not
+// already in scope. Prefixes are kept where possible.
+func (el *Element) rescope(parent Scope) {
+	el.rescopeDeep(parent, 0)
+}
+
+func (el *Element) rescopeDeep(parent Scope, depth int) {
+	scope := Scope{ns: parent.ns[:len(parent.ns):len(parent.ns)]}
+	if el.Type != XML_Tag {
+		el.Scope = scope
+		return
+	}
+	old := el.Scope
+	var decls []xml.Name
+	bound := func(prefix string) (string, bool) {
+		for i := len(decls) - 1; i >= 0; i-- {
+			if decls[i].Local == prefix {
+				return decls[i].Space, true
+			}
+		}
+		for i := len(scope.ns) - 1; i >= 0; i-- {
+			if scope.ns[i].Local == prefix {
+				return scope.ns[i].Space, true
+			}
+		}
+		return "", false
+	}
+	declare := func(name xml.Name, isAttr bool) {
+		switch name.Space {
+		case xmlLangURI, xmlNamespaceURI, "xmlns":
+			return
+		case "":
+			if isAttr {
+				return
+			}
+			// An unqualified element must not pick up a default
+			// namespace from its new parent.
+			if space, _ := bound(""); space != "" {
+				decls = append(decls, xml.Name{})
+			}
+			return
+		}
+		tmp := Scope{ns: append(scope.ns[:len(scope.ns):len(scope.ns)], decls...)}
+		if qname := tmp.Prefix(name); qname != "" && (!isAttr || strings.Contains(qname, ":")) {
+			return
+		}
+		prefix := ""
+		if qname := old.Prefix(name); strings.Contains(qname, ":") {
+			prefix = qname[:strings.IndexByte(qname, ':')]
+		}
+		if prefix == "" && isAttr {
+			prefix = "ns"
+		}
+		if space, ok := bound(prefix); ok && space != name.Space && (prefix != "" || isAttr) {
+			prefix = ""
+		}
+		if prefix == "" && isAttr {
+			for i := 0; ; i++ {
+				p := fmt.Sprintf("ns%d", i)
+				if _, ok := bound(p); !ok {
+					prefix = p
+					break
+				}
+			}
+		}
+		decls = append(decls, xml.Name{Space: name.Space, Local: prefix})
+	}
+	declare(el.Name, false)
+	for _, a := range el.StartElement.Attr {
+		declare(a.Name, true)
+	}
+	if len(decls) > 0 {
+		sort.Sort(byXMLName(decls))
+		scope.ns = append(scope.ns, decls...)
+		scope.ns = scope.ns[:len(scope.ns):len(scope.ns)]
+	}
+	el.Scope = scope
+	if depth < recursionLimit {
+		for i := range el.Children {
+			el.Children[i].rescopeDeep(scope, depth+1)
+		}
+	}
+}
+
 // A Scope represents the xml namespace scope at a given position in
 // the document.
 type Scope struct {
@@ -129,7 +363,7 @@ func (scope *Scope) ResolveDefault(qname, defaultns string) xml.Name {
 	if defaultns == "" || strings.Contains(qname, ":") {
 		return scope.Resolve(qname)
 	}
-	return xml.Name{defaultns, qname}
+	return xml.Name{Space: defaultns, Local: qname}
 }
 
 // SimplifyNS will try to find a namespace which is already declared and is
@@ -222,7 +456,7 @@ func (scope *Scope) Prefix(name xml.Name) (qname string) {
 		return "xmlns:" + name.Local
 	}
 	for i := len(scope.ns) - 1; i >= 0; i-- {
-		if scope.ns[i].Space == name.Space {
+		if scope.ns[i].Space == name.Space && !scope.shadowed(i) {
 			if scope.ns[i].Local == "" {
 				// Favor default NS if there is an extra
 				// qualified NS declaration
@@ -235,14 +469,27 @@ func (scope *Scope) Prefix(name xml.Name) (qname string) {
 	return qname
 }
 
+// shadowed reports whether the i'th declaration of the scope is hidden
+// by a later declaration of the same prefix. It is only called for
+// declarations of the namespace sought, which are few, so Prefix does
+// not allocate.
+func (scope *Scope) shadowed(i int) bool {
+	for _, d := range scope.ns[i+1:] {
+		if d.Local == scope.ns[i].Local {
+			return true
+		}
+	}
+	return false
+}
+
 func (scope *Scope) pushNS(tag xml.StartElement) []xml.Attr {
 	var ns []xml.Name
 	var newAttrs []xml.Attr
 	for _, attr := range tag.Attr {
 		if attr.Name.Space == "xmlns" {
-			ns = append(ns, xml.Name{attr.Value, attr.Name.Local})
+			ns = append(ns, xml.Name{Space: attr.Value, Local: attr.Name.Local})
 		} else if attr.Name.Local == "xmlns" {
-			ns = append(ns, xml.Name{attr.Value, ""})
+			ns = append(ns, xml.Name{Space: attr.Value})
 		} else {
 			newAttrs = append(newAttrs, attr)
 		}
@@ -264,15 +511,22 @@ func (scope *Scope) pushNS(tag xml.StartElement) []xml.Attr {
 // Save some typing when scanning xml
 type scanner struct {
 	*xml.Decoder
-	tok xml.Token
-	err error
+	tok   xml.Token
+	err   error
+	space Whitespace // policy outside of xml:space="preserve"
+	raw   *recorder  // source of the document, if known
+	cdata bool       // tok is a CDATA section
 }
 
 func (s *scanner) scan() bool {
 	if s.err != nil {
 		return false
 	}
+	start := s.InputOffset()
 	s.tok, s.err = s.Token()
+	if s.raw != nil {
+		s.cdata = s.raw.cdata(start, s.tok)
+	}
 	return s.err == nil
 }
 
@@ -281,10 +535,35 @@ func (s *scanner) scan() bool {
 // document with a single root element.  All non XML Tag elements and Tagged
 // content will be omitted from the tree (such as comments).
 func Parse(doc io.Reader) (*Element, error) {
-	d := xml.NewDecoder(doc)
+	return ParseWithOptions(doc, nil)
+}
+
+// ParseOptions controls the tree built by ParseWithOptions.
+type ParseOptions struct {
+	// Whitespace is the policy applied to character data, except
+	// within elements marked xml:space="preserve", where white space
+	// is always preserved.
+	Whitespace Whitespace
+
+	// Entity maps the names of entities, beyond the five predefined by
+	// XML, to their replacement text, as for xml.Decoder.Entity. A
+	// reference to any other entity is an error.
+	Entity map[string]string
+}
+
+// ParseWithOptions is like Parse, but the tree built is controlled by
+// opts. A nil opts is the same as a zero ParseOptions, and gives the
+// same tree as Parse.
+func ParseWithOptions(doc io.Reader, opts *ParseOptions) (*Element, error) {
+	if opts == nil {
+		opts = new(ParseOptions)
+	}
+	raw := &recorder{r: doc}
+	d := xml.NewDecoder(raw)
 	d.CharsetReader = charset.NewReaderLabel
+	d.Entity = opts.Entity
 
-	scanner := scanner{Decoder: d}
+	scanner := scanner{Decoder: d, space: opts.Whitespace, raw: raw}
 	root := new(Element)
 
 	for scanner.scan() {
@@ -296,7 +575,7 @@ func Parse(doc io.Reader) (*Element, error) {
 	if scanner.err != nil {
 		return nil, scanner.err
 	}
-	if err := root.parse(&scanner, Kind(0xff), 0); err != nil {
+	if err := root.parse(&scanner, Kind(0xff), opts.Whitespace, 0); err != nil {
 		return nil, err
 	}
 	return root, nil
@@ -322,17 +601,23 @@ func ParseXML(doc io.Reader) (*Element, error) {
 	if scanner.err != nil {
 		return nil, scanner.err
 	}
-	if err := root.parse(&scanner, XML_Tag, 0); err != nil {
+	if err := root.parse(&scanner, XML_Tag, WhitespacePreserve, 0); err != nil {
 		return nil, err
 	}
 	return root, nil
 }
 
-func (el *Element) parse(scanner *scanner, keepKinds Kind, depth int) error {
+func (el *Element) parse(scanner *scanner, keepKinds Kind, space Whitespace, depth int) error {
 	if depth > recursionLimit {
 		return errDeepXML
 	}
 	el.StartElement.Attr = el.pushNS(el.StartElement)
+	switch el.Attr(xmlLangURI, "space") {
+	case "preserve":
+		space = WhitespacePreserve
+	case "default":
+		space = scanner.space
+	}
 
 	//scanner.InputOffset()
 	var charDat bytes.Buffer
@@ -342,7 +627,7 @@ walk:
 		switch tok := scanner.tok.(type) {
 		case xml.StartElement:
 			child := Element{Type: XML_Tag, StartElement: tok.Copy(), Scope: el.Scope}
-			if err := child.parse(scanner, keepKinds, depth+1); err != nil {
+			if err := child.parse(scanner, keepKinds, space, depth+1); err != nil {
 				return err
 			}
 			el.Children = append(el.Children, child)
@@ -351,24 +636,22 @@ walk:
 				return fmt.Errorf("Expecting </%s>, got </%s>", el.Prefix(el.Name), el.Prefix(tok.Name))
 			}
 			if keepKinds&XML_CharData == XML_CharData && len(el.Children) == 1 && el.Children[0].Type == XML_CharData {
-				el.Content = strings.TrimSpace(el.Children[0].Content)
+				el.Content = el.Children[0].Content
+				if space != WhitespacePreserve && space != WhitespaceIgnorable {
+					el.Content = strings.TrimSpace(el.Content)
+				}
 				el.Children = nil
 			} else {
 				el.Content = string(charDat.Bytes())
 			}
 			break walk
 		case xml.CharData:
-			if keepKinds&XML_CharData == XML_CharData {
-				trimTok := strings.TrimRightFunc(string(tok), unicode.IsSpace)
-				if len(trimTok) < len(tok) && len(trimTok) > 0 {
-					trimTok = trimTok + " "
-				}
-				test := trimTok
-				trimTok = strings.TrimLeftFunc(trimTok, unicode.IsSpace)
-				if len(trimTok) < len(test) {
-					trimTok = " " + trimTok
-				}
-				child := Element{Type: XML_CharData, Content: trimTok}
+			if keepKinds&XML_CDATA == XML_CDATA && scanner.cdata {
+				child := Element{Type: XML_CDATA, Content: string(tok)}
+				el.Children = append(el.Children, child)
+			} else if keepKinds&XML_CharData == XML_CharData {
+				text := space.apply(string(tok))
+				child := Element{Type: XML_CharData, Content: text}
 				if len(child.Content) > 0 {
 					el.Children = append(el.Children, child)
 				}
@@ -383,6 +666,7 @@ walk:
 		case xml.ProcInst:
 			if keepKinds&XML_ProcInst == XML_ProcInst {
 				child := Element{Type: XML_ProcInst, Content: string(tok.Copy().Inst)}
+				child.Name.Local = tok.Target
 				el.Children = append(el.Children, child)
 			}
 		case xml.Directive:
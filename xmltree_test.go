@@ -207,7 +207,7 @@ func TestNSResolution(t *testing.T) {
 	}
 
 	defaultns := root.FindFunc(func(el *Element) bool {
-		if (el.Name != xml.Name{"http://schemas.xmlsoap.org/wsdl/", "binding"}) {
+		if (el.Name != xml.Name{Space: "http://schemas.xmlsoap.org/wsdl/", Local: "binding"}) {
 			return false
 		}
 		return el.Attr("", "name") == "wseDocReciboSoap12"
@@ -221,6 +221,33 @@ func TestNSResolution(t *testing.T) {
 	}
 }
 
+func TestPrefixShadowed(t *testing.T) {
+	root := parseDoc(t, []byte(`<a xmlns:p="urn:one"><b xmlns:p="urn:two" xmlns:q="urn:one"><c xmlns="urn:one"/><d xmlns:q="urn:two"/></b></a>`))
+	b := &root.Children[0]
+	c, d := &b.Children[0], &b.Children[1]
+	tests := []struct {
+		el   *Element
+		name xml.Name
+		want string
+	}{
+		{root, xml.Name{Space: "urn:one", Local: "x"}, "p:x"},
+		{b, xml.Name{Space: "urn:one", Local: "x"}, "q:x"},
+		{b, xml.Name{Space: "urn:two", Local: "x"}, "p:x"},
+		{c, xml.Name{Space: "urn:one", Local: "x"}, "x"},
+		{d, xml.Name{Space: "urn:one", Local: "x"}, ""},
+		{d, xml.Name{Space: "urn:two", Local: "x"}, "q:x"},
+	}
+	for _, tt := range tests {
+		if got := tt.el.Prefix(tt.name); got != tt.want {
+			t.Errorf("Prefix(%v) at <%s>: got %q, want %q", tt.name, tt.el.Name.Local, got, tt.want)
+		}
+	}
+	// Prefix is called for every name encoded.
+	if n := testing.AllocsPerRun(100, func() { c.Prefix(xml.Name{Space: "urn:one", Local: "x"}) }); n != 0 {
+		t.Errorf("Prefix allocated %v times", n)
+	}
+}
+
 func TestString(t *testing.T) {
 	root := parseDoc(t, exampleDoc)
 	s := root.String()
@@ -352,3 +379,103 @@ func TestExistingNSAttrs(t *testing.T) {
 		found[attr.Name] = true
 	}
 }
+
+func TestElementUnmarshalXML(t *testing.T) {
+	const doc = `<Rule xmlns="http://checklists.nist.gov/xccdf/1.1" xmlns:oval="http://oval.mitre.org/XMLSchema/oval-definitions-5" id="r1">
+	  <check system="http://oval.mitre.org/XMLSchema/oval-definitions-5">
+	    <check-content>
+	      <oval:definition id="oval:x:def:1"><oval:title>kernel</oval:title></oval:definition>
+	    </check-content>
+	  </check>
+	</Rule>`
+	type rule struct {
+		XMLName xml.Name `xml:"http://checklists.nist.gov/xccdf/1.1 Rule"`
+		ID      string   `xml:"id,attr"`
+		Content *Element `xml:"check>check-content"`
+	}
+	var r rule
+	if err := xml.Unmarshal([]byte(doc), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Content == nil {
+		t.Fatal("check-content was not captured")
+	}
+	title := r.Content.FindOne(&Selector{Name: xml.Name{
+		Space: "http://oval.mitre.org/XMLSchema/oval-definitions-5", Local: "title"}})
+	if title == nil || title.Content != "kernel" {
+		t.Fatalf("could not find oval:title in %s", r.Content)
+	}
+
+	// The captured subtree must stand on its own
+	out := r.Content.String()
+	sub := parseFullDoc(t, []byte(out))
+	if !Equal(sub, r.Content) {
+		t.Errorf("captured subtree did not survive a round trip: %s", out)
+	}
+
+	// And the enclosing struct must marshal back to an equivalent document
+	data, err := xml.Marshal(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r2 rule
+	if err := xml.Unmarshal(data, &r2); err != nil {
+		t.Fatalf("%s: %v", data, err)
+	}
+	if r2.ID != "r1" || r2.Content == nil || !Equal(r.Content, r2.Content) {
+		t.Errorf("struct did not survive a round trip: %s", data)
+	}
+	t.Logf("%s", data)
+}
+
+func TestElementMarshalXMLNodes(t *testing.T) {
+	root := parseFullDoc(t, []byte(`<content><!-- note --><?render inline?><p>x</p></content>`))
+	type wrapper struct {
+		XMLName xml.Name `xml:"wrapper"`
+		Content *Element
+	}
+	data, err := xml.Marshal(&wrapper{Content: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<wrapper><content><!-- note --><?render inline?><p>x</p></content></wrapper>`
+	if string(data) != want {
+		t.Errorf("got %s, wanted %s", data, want)
+	}
+}
+
+func TestElementUnmarshalXMLScope(t *testing.T) {
+	const doc = `<Rule xmlns="urn:xccdf" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:oval="urn:oval">
+	  <check><check-content><object xsi:type="oval:file_object"/></check-content></check>
+	</Rule>`
+	type rule struct {
+		Content *Element `xml:"check>check-content"`
+	}
+
+	// Without the outer scope, namespaces used in names are declared
+	// on the element with generated prefixes.
+	var r rule
+	if err := xml.Unmarshal([]byte(doc), &r); err != nil {
+		t.Fatal(err)
+	}
+	want := `<check-content xmlns:ns0="http://www.w3.org/2001/XMLSchema-instance" xmlns="urn:xccdf"><object ns0:type="oval:file_object" /></check-content>`
+	if got := r.Content.String(); got != want {
+		t.Errorf("got %s, wanted %s", got, want)
+	}
+
+	// With the scope of the enclosing element supplied by the caller.
+	outer := parseDoc(t, []byte(doc))
+	r = rule{Content: &Element{Scope: outer.Scope}}
+	if err := xml.Unmarshal([]byte(doc), &r); err != nil {
+		t.Fatal(err)
+	}
+	obj := &r.Content.Children[0]
+	if got := obj.Resolve(obj.Attr("", "type")); got != (xml.Name{Space: "urn:oval", Local: "file_object"}) {
+		t.Errorf("xsi:type resolved to %v", got)
+	}
+	want = `<check-content xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:oval="urn:oval" xmlns="urn:xccdf">` +
+		`<object xsi:type="oval:file_object" /></check-content>`
+	if got := r.Content.String(); got != want {
+		t.Errorf("got %s, wanted %s", got, want)
+	}
+}
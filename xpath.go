@@ -0,0 +1,868 @@
+package xmltree
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An XPath is a compiled XPath 1.0 expression. It may be evaluated
+// concurrently against any number of trees.
+type XPath struct {
+	src string
+	e   xexpr
+}
+
+// CompileXPath parses an XPath 1.0 expression.
+func CompileXPath(expr string) (*XPath, error) {
+	e, err := parseXPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &XPath{src: expr, e: e}, nil
+}
+
+// MustCompileXPath is like CompileXPath but panics if the expression
+// cannot be parsed.
+func MustCompileXPath(expr string) *XPath {
+	x, err := CompileXPath(expr)
+	if err != nil {
+		panic(err)
+	}
+	return x
+}
+
+// String returns the source text of the expression.
+func (x *XPath) String() string {
+	return x.src
+}
+
+// Query evaluates an XPath 1.0 expression with el as the context node
+// and the root of the document, and returns the selected elements in
+// document order. Namespace prefixes in the expression are resolved
+// using the namespace scope of el; if el has a default namespace,
+// unprefixed element names in the expression are in that namespace.
+// Selected nodes which are not elements are omitted.
+func (el *Element) Query(expr string) ([]*Element, error) {
+	x, err := CompileXPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	c := NewXPathContext(el)
+	c.Namespaces = el.Scope.namespaces()
+	nodes, err := c.Select(x, c.Node(el))
+	if err != nil {
+		return nil, err
+	}
+	var result []*Element
+	for _, n := range nodes {
+		if n.Type == ElementNode {
+			result = append(result, n.Element)
+		}
+	}
+	return result, nil
+}
+
+// namespaces returns the prefix to namespace mapping in effect for the
+// Scope.
+func (scope *Scope) namespaces() map[string]string {
+	m := make(map[string]string, len(scope.ns))
+	for _, ns := range scope.ns {
+		m[ns.Local] = ns.Space
+	}
+	return m
+}
+
+// A NodeType is the type of a node in the XPath data model.
+type NodeType uint8
+
+const (
+	DocumentNode NodeType = iota
+	ElementNode
+	AttributeNode
+	TextNode
+	CommentNode
+	ProcInstNode
+	NamespaceNode
+)
+
+// A Node is a node of an Element tree, as seen by XPath. Nodes are
+// comparable, and two Nodes are equal if they refer to the same node.
+type Node struct {
+	Type NodeType
+	// Element is the node itself for element, comment and processing
+	// instruction nodes, and for text nodes which are character data
+	// children. For the document node it is the document element. For
+	// attribute and namespace nodes, and text nodes held in the Content
+	// of an element, it is the owning element.
+	Element *Element
+	// Index is the position of an attribute node in the owner's
+	// attributes, or of a namespace node in the owner's Scope.
+	Index int
+}
+
+// Attr returns the attribute of an attribute node, or nil.
+func (n Node) Attr() *xml.Attr {
+	if n.Type != AttributeNode {
+		return nil
+	}
+	return &n.Element.StartElement.Attr[n.Index]
+}
+
+// Name returns the expanded name of a node. The name of a processing
+// instruction is its target, and the name of a namespace node is its
+// prefix. Other nodes have no name.
+func (n Node) Name() xml.Name {
+	switch n.Type {
+	case ElementNode:
+		return n.Element.Name
+	case AttributeNode:
+		return n.Attr().Name
+	case ProcInstNode:
+		return xml.Name{Local: n.Element.Name.Local}
+	case NamespaceNode:
+		return xml.Name{Local: n.Element.Scope.ns[n.Index].Local}
+	}
+	return xml.Name{}
+}
+
+// String returns the string-value of a node.
+func (n Node) String() string {
+	switch n.Type {
+	case DocumentNode, ElementNode:
+		return n.Element.Text()
+	case AttributeNode:
+		return n.Attr().Value
+	case NamespaceNode:
+		return n.Element.Scope.ns[n.Index].Space
+	}
+	return n.Element.Content
+}
+
+// An XPathFunc implements an extension function. It is called with the
+// context node and the evaluated arguments, which are values of the
+// types described by XPathContext.Eval.
+type XPathFunc func(c *XPathContext, context Node, args []interface{}) (interface{}, error)
+
+// An XPathContext evaluates XPath expressions against a single tree.
+// The tree must not be modified while the XPathContext is in use.
+type XPathContext struct {
+	// Namespaces maps namespace prefixes used in expressions to
+	// namespace URIs. The entry for the empty prefix, if any, is used
+	// for unprefixed element names.
+	Namespaces map[string]string
+	// Variables holds the values of variables, keyed by name as written
+	// in expressions, without the leading $.
+	Variables map[string]interface{}
+	// Functions holds extension functions, keyed by name as written in
+	// expressions. They take precedence over the core function library.
+	Functions map[string]XPathFunc
+
+	root   *Element
+	parent map[*Element]*Element
+	index  map[*Element]int // position among siblings
+	order  map[*Element]int // document order
+	all    []Node           // lazily built list of nodes in document order
+}
+
+// NewXPathContext returns an XPathContext for the tree rooted at root,
+// which is treated as the document element.
+func NewXPathContext(root *Element) *XPathContext {
+	c := &XPathContext{root: root}
+	c.reindex()
+	return c
+}
+
+// reindex rebuilds the parent and document order indices. It must be
+// called after the tree is modified.
+func (c *XPathContext) reindex() {
+	c.parent = make(map[*Element]*Element)
+	c.index = make(map[*Element]int)
+	c.order = make(map[*Element]int)
+	c.all = nil
+	n := 0
+	var walk func(el *Element, depth int)
+	walk = func(el *Element, depth int) {
+		c.order[el] = n
+		n++
+		if depth >= recursionLimit {
+			return
+		}
+		for i := range el.Children {
+			child := &el.Children[i]
+			c.parent[child] = el
+			c.index[child] = i
+			walk(child, depth+1)
+		}
+	}
+	walk(c.root, 0)
+}
+
+// Root returns the document node.
+func (c *XPathContext) Root() Node {
+	return Node{Type: DocumentNode, Element: c.root}
+}
+
+// Node returns the node for an Element in the tree.
+func (c *XPathContext) Node(el *Element) Node {
+	switch el.Type {
+	case XML_CharData, XML_CDATA:
+		return Node{Type: TextNode, Element: el}
+	case XML_Comment:
+		return Node{Type: CommentNode, Element: el}
+	case XML_ProcInst:
+		return Node{Type: ProcInstNode, Element: el}
+	}
+	return Node{Type: ElementNode, Element: el}
+}
+
+// Parent returns the parent of a node, and false if it has none.
+func (c *XPathContext) Parent(n Node) (Node, bool) {
+	switch {
+	case n.Type == DocumentNode:
+		return Node{}, false
+	case n.Type == AttributeNode || n.Type == NamespaceNode ||
+		n.Type == TextNode && n.Element.Type == XML_Tag:
+		return Node{Type: ElementNode, Element: n.Element}, true
+	case n.Element == c.root:
+		return c.Root(), true
+	}
+	if p, ok := c.parent[n.Element]; ok {
+		return Node{Type: ElementNode, Element: p}, true
+	}
+	return Node{}, false
+}
+
+// Eval evaluates an expression with n as the context node. The result
+// is a []Node, in document order, for node-sets, a string, a float64 or
+// a bool.
+func (c *XPathContext) Eval(x *XPath, n Node) (interface{}, error) {
+	return c.eval(x.e, xframe{node: n, pos: 1, size: 1})
+}
+
+// EvalAt is like Eval, but with pos and size as the context position
+// and size returned by the position() and last() functions, as when n
+// is one of a list of nodes being processed in turn.
+func (c *XPathContext) EvalAt(x *XPath, n Node, pos, size int) (interface{}, error) {
+	return c.eval(x.e, xframe{node: n, pos: pos, size: size})
+}
+
+// Select is like Eval, but returns an error if the expression does not
+// evaluate to a node-set.
+func (c *XPathContext) Select(x *XPath, n Node) ([]Node, error) {
+	v, err := c.Eval(x, n)
+	if err != nil {
+		return nil, err
+	}
+	nodes, ok := v.([]Node)
+	if !ok {
+		return nil, fmt.Errorf("xmltree: XPath %q does not select nodes", x.src)
+	}
+	return nodes, nil
+}
+
+// EvalString evaluates an expression and converts the result to a
+// string, as by the XPath string() function.
+func (c *XPathContext) EvalString(x *XPath, n Node) (string, error) {
+	v, err := c.Eval(x, n)
+	return XPathString(v), err
+}
+
+// EvalBool evaluates an expression and converts the result to a
+// boolean, as by the XPath boolean() function.
+func (c *XPathContext) EvalBool(x *XPath, n Node) (bool, error) {
+	v, err := c.Eval(x, n)
+	return XPathBool(v), err
+}
+
+// EvalNumber evaluates an expression and converts the result to a
+// number, as by the XPath number() function.
+func (c *XPathContext) EvalNumber(x *XPath, n Node) (float64, error) {
+	v, err := c.Eval(x, n)
+	return XPathNumber(v), err
+}
+
+// XPathString converts an XPath value to a string.
+func XPathString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NaN"
+		case math.IsInf(v, 1):
+			return "Infinity"
+		case math.IsInf(v, -1):
+			return "-Infinity"
+		case v == 0:
+			return "0"
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []Node:
+		if len(v) == 0 {
+			return ""
+		}
+		return v[0].String()
+	}
+	return ""
+}
+
+// XPathNumber converts an XPath value to a number.
+func XPathNumber(v interface{}) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	}
+	s := strings.TrimSpace(XPathString(v))
+	if s == "" || strings.ContainsAny(s, "eExXpP_+") {
+		return math.NaN()
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || strings.HasPrefix(s, "Inf") || strings.HasPrefix(s, "-Inf") ||
+		strings.EqualFold(s, "nan") {
+		return math.NaN()
+	}
+	return f
+}
+
+// XPathBool converts an XPath value to a boolean.
+func XPathBool(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case string:
+		return v != ""
+	case []Node:
+		return len(v) > 0
+	}
+	return false
+}
+
+// An xframe is the dynamic context of an evaluation.
+type xframe struct {
+	node      Node
+	pos, size int
+}
+
+var errXPathDepth = errors.New("xmltree: XPath expression too deeply nested")
+
+func (c *XPathContext) eval(e xexpr, f xframe) (interface{}, error) {
+	switch e := e.(type) {
+	case xliteral:
+		return string(e), nil
+	case xnumber:
+		return float64(e), nil
+	case xvarRef:
+		v, ok := c.Variables[string(e)]
+		if !ok {
+			return nil, fmt.Errorf("xmltree: undefined XPath variable $%s", string(e))
+		}
+		return v, nil
+	case xnegate:
+		v, err := c.eval(e.e, f)
+		if err != nil {
+			return nil, err
+		}
+		return -XPathNumber(v), nil
+	case xbinary:
+		return c.evalBinary(e, f)
+	case xcall:
+		return c.call(e, f)
+	case xfilter:
+		v, err := c.eval(e.e, f)
+		if err != nil {
+			return nil, err
+		}
+		nodes, ok := v.([]Node)
+		if !ok {
+			return nil, errors.New("xmltree: XPath predicate applied to a value which is not a node-set")
+		}
+		for _, pred := range e.preds {
+			if nodes, err = c.filter(nodes, pred); err != nil {
+				return nil, err
+			}
+		}
+		return nodes, nil
+	case xpath:
+		return c.evalPath(e, f)
+	}
+	return nil, fmt.Errorf("xmltree: unknown XPath expression %T", e)
+}
+
+func (c *XPathContext) evalBinary(e xbinary, f xframe) (interface{}, error) {
+	l, err := c.eval(e.l, f)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "and":
+		if !XPathBool(l) {
+			return false, nil
+		}
+		r, err := c.eval(e.r, f)
+		return XPathBool(r), err
+	case "or":
+		if XPathBool(l) {
+			return true, nil
+		}
+		r, err := c.eval(e.r, f)
+		return XPathBool(r), err
+	}
+	r, err := c.eval(e.r, f)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "|":
+		ln, lok := l.([]Node)
+		rn, rok := r.([]Node)
+		if !lok || !rok {
+			return nil, errors.New("xmltree: XPath union of values which are not node-sets")
+		}
+		return c.sortNodes(append(append([]Node{}, ln...), rn...)), nil
+	case "+":
+		return XPathNumber(l) + XPathNumber(r), nil
+	case "-":
+		return XPathNumber(l) - XPathNumber(r), nil
+	case "*":
+		return XPathNumber(l) * XPathNumber(r), nil
+	case "div":
+		return XPathNumber(l) / XPathNumber(r), nil
+	case "mod":
+		return math.Mod(XPathNumber(l), XPathNumber(r)), nil
+	}
+	return compareXPath(e.op, l, r), nil
+}
+
+// compareXPath implements the comparison operators of section 3.4 of
+// the XPath 1.0 recommendation.
+func compareXPath(op string, l, r interface{}) bool {
+	ln, lok := l.([]Node)
+	rn, rok := r.([]Node)
+	switch {
+	case lok && rok:
+		for _, a := range ln {
+			for _, b := range rn {
+				if compareAtoms(op, a.String(), b.String()) {
+					return true
+				}
+			}
+		}
+		return false
+	case lok || rok:
+		nodes, other := ln, r
+		if rok {
+			nodes, other = rn, l
+			op = swapOp(op)
+		}
+		if b, ok := other.(bool); ok {
+			return compareAtoms(op, len(nodes) > 0, b)
+		}
+		for _, n := range nodes {
+			var v interface{} = n.String()
+			if _, ok := other.(float64); ok {
+				v = XPathNumber(v)
+			}
+			if compareAtoms(op, v, other) {
+				return true
+			}
+		}
+		return false
+	}
+	return compareAtoms(op, l, r)
+}
+
+func swapOp(op string) string {
+	switch op {
+	case "<":
+		return ">"
+	case ">":
+		return "<"
+	case "<=":
+		return ">="
+	case ">=":
+		return "<="
+	}
+	return op
+}
+
+func compareAtoms(op string, l, r interface{}) bool {
+	if op == "=" || op == "!=" {
+		var eq bool
+		_, lb := l.(bool)
+		_, rb := r.(bool)
+		_, lf := l.(float64)
+		_, rf := r.(float64)
+		switch {
+		case lb || rb:
+			eq = XPathBool(l) == XPathBool(r)
+		case lf || rf:
+			eq = XPathNumber(l) == XPathNumber(r)
+		default:
+			eq = XPathString(l) == XPathString(r)
+		}
+		return eq == (op == "=")
+	}
+	a, b := XPathNumber(l), XPathNumber(r)
+	switch op {
+	case "<":
+		return a < b
+	case ">":
+		return a > b
+	case "<=":
+		return a <= b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+func (c *XPathContext) evalPath(p xpath, f xframe) (interface{}, error) {
+	var nodes []Node
+	switch {
+	case p.filter != nil:
+		v, err := c.eval(p.filter, f)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if nodes, ok = v.([]Node); !ok {
+			return nil, errors.New("xmltree: XPath path applied to a value which is not a node-set")
+		}
+	case p.abs:
+		nodes = []Node{c.Root()}
+	default:
+		nodes = []Node{f.node}
+	}
+	for _, s := range p.steps {
+		var err error
+		if nodes, err = c.step(s, nodes); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+func (c *XPathContext) step(s xstep, from []Node) ([]Node, error) {
+	var result []Node
+	seen := make(map[Node]bool)
+	for _, n := range from {
+		var matched []Node
+		for _, m := range c.axis(s.axis, n) {
+			ok, err := c.test(s.axis, s.test, m)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				matched = append(matched, m)
+			}
+		}
+		for _, pred := range s.preds {
+			var err error
+			if matched, err = c.filter(matched, pred); err != nil {
+				return nil, err
+			}
+		}
+		for _, m := range matched {
+			if !seen[m] {
+				seen[m] = true
+				result = append(result, m)
+			}
+		}
+	}
+	if len(from) > 1 || s.axis.reverse() {
+		c.sortNodes(result)
+	}
+	return result, nil
+}
+
+// filter applies a predicate to nodes, which are in the order of the
+// axis they were selected from.
+func (c *XPathContext) filter(nodes []Node, pred xexpr) ([]Node, error) {
+	var keep []Node
+	for i, n := range nodes {
+		v, err := c.eval(pred, xframe{node: n, pos: i + 1, size: len(nodes)})
+		if err != nil {
+			return nil, err
+		}
+		if num, ok := v.(float64); ok {
+			if num == float64(i+1) {
+				keep = append(keep, n)
+			}
+		} else if XPathBool(v) {
+			keep = append(keep, n)
+		}
+	}
+	return keep, nil
+}
+
+func (c *XPathContext) test(axis xaxis, t xnodeTest, n Node) (bool, error) {
+	switch t.kind {
+	case "node":
+		return true, nil
+	case "text":
+		return n.Type == TextNode, nil
+	case "comment":
+		return n.Type == CommentNode, nil
+	case "processing-instruction":
+		return n.Type == ProcInstNode && (t.local == "" || t.local == n.Name().Local), nil
+	}
+	principal := ElementNode
+	switch axis {
+	case axisAttribute:
+		principal = AttributeNode
+	case axisNamespace:
+		principal = NamespaceNode
+	}
+	if n.Type != principal {
+		return false, nil
+	}
+	if t.prefix == "" && t.local == "*" {
+		return true, nil
+	}
+	space, err := c.resolvePrefix(t.prefix, principal == ElementNode)
+	if err != nil {
+		return false, err
+	}
+	name := n.Name()
+	return name.Space == space && (t.local == "*" || t.local == name.Local), nil
+}
+
+// resolvePrefix returns the namespace for a prefix in a name test.
+// Unprefixed attribute names are never in a namespace.
+func (c *XPathContext) resolvePrefix(prefix string, element bool) (string, error) {
+	switch prefix {
+	case "":
+		if element {
+			return c.Namespaces[""], nil
+		}
+		return "", nil
+	case "xml":
+		return xmlLangURI, nil
+	}
+	space, ok := c.Namespaces[prefix]
+	if !ok {
+		return "", fmt.Errorf("xmltree: undeclared namespace prefix %q in XPath", prefix)
+	}
+	return space, nil
+}
+
+// sortNodes sorts nodes into document order, and removes duplicates.
+func (c *XPathContext) sortNodes(nodes []Node) []Node {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		ai, bi := c.orderKey(nodes[i])
+		aj, bj := c.orderKey(nodes[j])
+		return ai < aj || ai == aj && bi < bj
+	})
+	out := nodes[:0]
+	for i, n := range nodes {
+		if i == 0 || n != nodes[i-1] {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+func (c *XPathContext) orderKey(n Node) (int, int) {
+	switch n.Type {
+	case DocumentNode:
+		return -1, 0
+	case NamespaceNode:
+		return c.order[n.Element], 1 + n.Index
+	case AttributeNode:
+		return c.order[n.Element], 1<<20 + n.Index
+	case TextNode:
+		if n.Element.Type == XML_Tag {
+			return c.order[n.Element], 1 << 21
+		}
+	}
+	return c.order[n.Element], 0
+}
+
+// children returns the child nodes of n.
+func (c *XPathContext) children(n Node) []Node {
+	switch n.Type {
+	case DocumentNode:
+		return []Node{{Type: ElementNode, Element: c.root}}
+	case ElementNode:
+	default:
+		return nil
+	}
+	el := n.Element
+	if len(el.Children) == 0 {
+		if el.Content != "" {
+			return []Node{{Type: TextNode, Element: el}}
+		}
+		return nil
+	}
+	nodes := make([]Node, 0, len(el.Children))
+	for i := range el.Children {
+		if child := &el.Children[i]; child.Type != XML_Directive {
+			nodes = append(nodes, c.Node(child))
+		}
+	}
+	return nodes
+}
+
+func (c *XPathContext) descendants(n Node, self bool, out []Node) []Node {
+	if self {
+		out = append(out, n)
+	}
+	for _, child := range c.children(n) {
+		out = c.descendants(child, true, out)
+	}
+	return out
+}
+
+// siblings returns the nodes sharing a parent with n, and n's position
+// among them.
+func (c *XPathContext) siblings(n Node) ([]Node, int) {
+	switch n.Type {
+	case DocumentNode, AttributeNode, NamespaceNode:
+		return nil, -1
+	}
+	parent, ok := c.Parent(n)
+	if !ok {
+		return nil, -1
+	}
+	sibs := c.children(parent)
+	for i, s := range sibs {
+		if s == n {
+			return sibs, i
+		}
+	}
+	return nil, -1
+}
+
+// isAncestor reports whether a is an ancestor of n.
+func (c *XPathContext) isAncestor(a, n Node) bool {
+	for p, ok := c.Parent(n); ok; p, ok = c.Parent(p) {
+		if p == a {
+			return true
+		}
+	}
+	return false
+}
+
+// allNodes returns every node other than attribute and namespace nodes,
+// in document order.
+func (c *XPathContext) allNodes() []Node {
+	if c.all == nil {
+		c.all = c.descendants(c.Root(), true, nil)
+	}
+	return c.all
+}
+
+// namespaceNodes returns the namespace nodes of an element: one for
+// each prefix in scope, using the innermost declaration.
+func (c *XPathContext) namespaceNodes(el *Element) []Node {
+	var nodes []Node
+	seen := make(map[string]bool)
+	for i := len(el.Scope.ns) - 1; i >= 0; i-- {
+		ns := el.Scope.ns[i]
+		if seen[ns.Local] {
+			continue
+		}
+		seen[ns.Local] = true
+		if ns.Space != "" {
+			nodes = append(nodes, Node{Type: NamespaceNode, Element: el, Index: i})
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Index < nodes[j].Index })
+	return nodes
+}
+
+// axis returns the nodes along an axis from n, in axis order.
+func (c *XPathContext) axis(a xaxis, n Node) []Node {
+	switch a {
+	case axisSelf:
+		return []Node{n}
+	case axisChild:
+		return c.children(n)
+	case axisDescendant:
+		return c.descendants(n, false, nil)
+	case axisDescendantOrSelf:
+		return c.descendants(n, true, nil)
+	case axisParent:
+		if p, ok := c.Parent(n); ok {
+			return []Node{p}
+		}
+		return nil
+	case axisAncestor, axisAncestorOrSelf:
+		var nodes []Node
+		if a == axisAncestorOrSelf {
+			nodes = append(nodes, n)
+		}
+		for p, ok := c.Parent(n); ok; p, ok = c.Parent(p) {
+			nodes = append(nodes, p)
+		}
+		return nodes
+	case axisFollowingSibling:
+		sibs, i := c.siblings(n)
+		if i < 0 {
+			return nil
+		}
+		return sibs[i+1:]
+	case axisPrecedingSibling:
+		sibs, i := c.siblings(n)
+		var nodes []Node
+		for j := i - 1; j >= 0; j-- {
+			nodes = append(nodes, sibs[j])
+		}
+		return nodes
+	case axisFollowing:
+		var nodes []Node
+		ka, kb := c.orderKey(n)
+		for _, m := range c.allNodes() {
+			ma, mb := c.orderKey(m)
+			if (ma > ka || ma == ka && mb > kb) && !c.isAncestor(n, m) {
+				nodes = append(nodes, m)
+			}
+		}
+		return nodes
+	case axisPreceding:
+		var nodes []Node
+		ka, kb := c.orderKey(n)
+		all := c.allNodes()
+		for i := len(all) - 1; i >= 0; i-- {
+			m := all[i]
+			ma, mb := c.orderKey(m)
+			if (ma < ka || ma == ka && mb < kb) && !c.isAncestor(m, n) {
+				nodes = append(nodes, m)
+			}
+		}
+		return nodes
+	case axisAttribute:
+		if n.Type != ElementNode {
+			return nil
+		}
+		nodes := make([]Node, len(n.Element.StartElement.Attr))
+		for i := range nodes {
+			nodes[i] = Node{Type: AttributeNode, Element: n.Element, Index: i}
+		}
+		return nodes
+	case axisNamespace:
+		if n.Type != ElementNode {
+			return nil
+		}
+		return c.namespaceNodes(n.Element)
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package xmltree
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// An xfunc implements a function of the XPath 1.0 core library. The
+// number of arguments has already been checked.
+type xfunc struct {
+	min, max int // max < 0 means any number of arguments
+	fn       func(c *XPathContext, f xframe, args []interface{}) (interface{}, error)
+}
+
+var coreFuncs map[string]xfunc
+
+func init() {
+	coreFuncs = map[string]xfunc{
+		// Node set functions
+		"last": {0, 0, func(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+			return float64(f.size), nil
+		}},
+		"position": {0, 0, func(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+			return float64(f.pos), nil
+		}},
+		"count": {1, 1, func(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+			nodes, err := nodeArg(args[0], "count")
+			return float64(len(nodes)), err
+		}},
+		"id":            {1, 1, xpathID},
+		"local-name":    {0, 1, nameFunc(func(n Node) string { return n.Name().Local })},
+		"namespace-uri": {0, 1, nameFunc(func(n Node) string { return n.Name().Space })},
+		"name":          {0, 1, nameFunc(qualifiedName)},
+
+		// String functions
+		"string": {0, 1, func(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+			return XPathString(ctxArg(f, args)), nil
+		}},
+		"concat": {2, -1, func(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+			var sb strings.Builder
+			for _, a := range args {
+				sb.WriteString(XPathString(a))
+			}
+			return sb.String(), nil
+		}},
+		"starts-with": {2, 2, func(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+			return strings.HasPrefix(XPathString(args[0]), XPathString(args[1])), nil
+		}},
+		"contains": {2, 2, func(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+			return strings.Contains(XPathString(args[0]), XPathString(args[1])), nil
+		}},
+		"substring-before": {2, 2, func(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+			s, sep := XPathString(args[0]), XPathString(args[1])
+			if i := strings.Index(s, sep); i >= 0 {
+				return s[:i], nil
+			}
+			return "", nil
+		}},
+		"substring-after": {2, 2, func(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+			s, sep := XPathString(args[0]), XPathString(args[1])
+			if i := strings.Index(s, sep); i >= 0 {
+				return s[i+len(sep):], nil
+			}
+			return "", nil
+		}},
+		"substring": {2, 3, xpathSubstring},
+		"string-length": {0, 1, func(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+			return float64(utf8.RuneCountInString(XPathString(ctxArg(f, args)))), nil
+		}},
+		"normalize-space": {0, 1, func(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+			return strings.Join(strings.Fields(XPathString(ctxArg(f, args))), " "), nil
+		}},
+		"translate": {3, 3, func(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+			from := []rune(XPathString(args[1]))
+			to := []rune(XPathString(args[2]))
+			return strings.Map(func(r rune) rune {
+				for i, x := range from {
+					if x == r {
+						if i < len(to) {
+							return to[i]
+						}
+						return -1
+					}
+				}
+				return r
+			}, XPathString(args[0])), nil
+		}},
+
+		// Boolean functions
+		"boolean": {1, 1, func(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+			return XPathBool(args[0]), nil
+		}},
+		"not": {1, 1, func(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+			return !XPathBool(args[0]), nil
+		}},
+		"true": {0, 0, func(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+			return true, nil
+		}},
+		"false": {0, 0, func(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+			return false, nil
+		}},
+		"lang": {1, 1, xpathLang},
+
+		// Number functions
+		"number": {0, 1, func(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+			return XPathNumber(ctxArg(f, args)), nil
+		}},
+		"sum": {1, 1, func(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+			nodes, err := nodeArg(args[0], "sum")
+			var total float64
+			for _, n := range nodes {
+				total += XPathNumber(n.String())
+			}
+			return total, err
+		}},
+		"floor": {1, 1, func(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+			return math.Floor(XPathNumber(args[0])), nil
+		}},
+		"ceiling": {1, 1, func(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+			return math.Ceil(XPathNumber(args[0])), nil
+		}},
+		"round": {1, 1, func(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+			return xpathRound(XPathNumber(args[0])), nil
+		}},
+	}
+}
+
+func (c *XPathContext) call(e xcall, f xframe) (interface{}, error) {
+	args := make([]interface{}, len(e.args))
+	for i, a := range e.args {
+		v, err := c.eval(a, f)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	if fn, ok := c.Functions[e.name]; ok {
+		return fn(c, f.node, args)
+	}
+	fn, ok := coreFuncs[e.name]
+	if !ok {
+		return nil, fmt.Errorf("xmltree: unknown XPath function %s()", e.name)
+	}
+	if len(args) < fn.min || fn.max >= 0 && len(args) > fn.max {
+		return nil, fmt.Errorf("xmltree: wrong number of arguments to XPath function %s()", e.name)
+	}
+	return fn.fn(c, f, args)
+}
+
+// ctxArg returns the optional argument of a function, defaulting to
+// the context node.
+func ctxArg(f xframe, args []interface{}) interface{} {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return []Node{f.node}
+}
+
+func nodeArg(v interface{}, fn string) ([]Node, error) {
+	nodes, ok := v.([]Node)
+	if !ok {
+		return nil, fmt.Errorf("xmltree: argument to XPath function %s() is not a node-set", fn)
+	}
+	return nodes, nil
+}
+
+func nameFunc(name func(Node) string) func(*XPathContext, xframe, []interface{}) (interface{}, error) {
+	return func(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+		nodes := []Node{f.node}
+		if len(args) > 0 {
+			var err error
+			if nodes, err = nodeArg(args[0], "name"); err != nil {
+				return nil, err
+			}
+		}
+		if len(nodes) == 0 {
+			return "", nil
+		}
+		return name(nodes[0]), nil
+	}
+}
+
+// qualifiedName returns the name of a node as it would be written,
+// using the prefixes in scope at the node.
+func qualifiedName(n Node) string {
+	name := n.Name()
+	switch n.Type {
+	case ElementNode, AttributeNode:
+		return qualify(&n.Element.Scope, name)
+	}
+	return name.Local
+}
+
+func xpathID(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+	var ids []string
+	if nodes, ok := args[0].([]Node); ok {
+		for _, n := range nodes {
+			ids = append(ids, strings.Fields(n.String())...)
+		}
+	} else {
+		ids = strings.Fields(XPathString(args[0]))
+	}
+	want := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		want[id] = true
+	}
+	var result []Node
+	for _, n := range c.allNodes() {
+		if n.Type != ElementNode {
+			continue
+		}
+		for _, a := range n.Element.StartElement.Attr {
+			if a.Name.Local == "id" && (a.Name.Space == "" || a.Name.Space == xmlLangURI) && want[a.Value] {
+				result = append(result, n)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+func xpathSubstring(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+	s := []rune(XPathString(args[0]))
+	start := xpathRound(XPathNumber(args[1]))
+	end := math.Inf(1)
+	if len(args) > 2 {
+		end = start + xpathRound(XPathNumber(args[2]))
+	}
+	var sb strings.Builder
+	for i, r := range s {
+		if p := float64(i + 1); p >= start && p < end {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String(), nil
+}
+
+func xpathLang(c *XPathContext, f xframe, args []interface{}) (interface{}, error) {
+	want := strings.ToLower(XPathString(args[0]))
+	n := f.node
+	if n.Type != ElementNode {
+		var ok bool
+		if n, ok = c.Parent(n); !ok {
+			return false, nil
+		}
+	}
+	for ok := true; ok && n.Type == ElementNode; n, ok = c.Parent(n) {
+		for _, a := range n.Element.StartElement.Attr {
+			if a.Name.Space == xmlLangURI && a.Name.Local == "lang" {
+				lang := strings.ToLower(a.Value)
+				return lang == want || strings.HasPrefix(lang, want+"-"), nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func xpathRound(f float64) float64 {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return f
+	}
+	return math.Floor(f + 0.5)
+}
@@ -0,0 +1,593 @@
+package xmltree
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Tokens of the XPath 1.0 expression language.
+type xtokKind uint8
+
+const (
+	xtokEOF      xtokKind = iota
+	xtokName              // NCName or QName, possibly ending in :*
+	xtokStar              // * as a name test
+	xtokOp                // operators, including and, or, mod, div
+	xtokLiteral           // quoted string
+	xtokNumber            // number literal
+	xtokVar               // $name
+	xtokFunc              // name followed by (
+	xtokNodeType          // comment, text, processing-instruction, node followed by (
+	xtokAxis              // axis name followed by ::
+	xtokPunct             // ( ) [ ] . .. @ , ::
+)
+
+type xtoken struct {
+	kind xtokKind
+	val  string
+	num  float64
+}
+
+func isNameStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isNameChar(r rune) bool {
+	return isNameStart(r) || r == '-' || r == '.' || unicode.IsDigit(r) ||
+		unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Mc, r)
+}
+
+// lexXPath splits an expression into tokens, applying the
+// disambiguation rules of section 3.7 of the XPath 1.0 recommendation.
+func lexXPath(s string) ([]xtoken, error) {
+	var out []xtoken
+	// operatorContext reports whether the next token must be read as an
+	// operator: there is a preceding token, and it is not one of
+	// @, ::, (, [, , or an operator.
+	operatorContext := func() bool {
+		if len(out) == 0 {
+			return false
+		}
+		prev := out[len(out)-1]
+		switch prev.kind {
+		case xtokOp:
+			return false
+		case xtokPunct:
+			switch prev.val {
+			case "@", "::", "(", "[", ",":
+				return false
+			}
+		}
+		return true
+	}
+	emit := func(kind xtokKind, val string) {
+		out = append(out, xtoken{kind: kind, val: val})
+	}
+	readName := func(i int) int {
+		for i < len(s) {
+			r, n := utf8.DecodeRuneInString(s[i:])
+			if !isNameChar(r) {
+				break
+			}
+			i += n
+		}
+		return i
+	}
+	skipSpace := func(i int) int {
+		for i < len(s) && strings.IndexByte(" \t\r\n", s[i]) >= 0 {
+			i++
+		}
+		return i
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case strings.IndexByte(" \t\r\n", c) >= 0:
+			i++
+		case c == '(' || c == ')' || c == '[' || c == ']' || c == ',' || c == '@':
+			emit(xtokPunct, s[i:i+1])
+			i++
+		case c == '.' && i+1 < len(s) && s[i+1] == '.':
+			emit(xtokPunct, "..")
+			i += 2
+		case c == '.' && (i+1 >= len(s) || s[i+1] < '0' || s[i+1] > '9'):
+			emit(xtokPunct, ".")
+			i++
+		case c == ':' && i+1 < len(s) && s[i+1] == ':':
+			emit(xtokPunct, "::")
+			i += 2
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("xmltree: unterminated literal in XPath %q", s)
+			}
+			emit(xtokLiteral, s[i+1:i+1+end])
+			i += end + 2
+		case c >= '0' && c <= '9' || c == '.':
+			j := i
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.') {
+				j++
+			}
+			f, err := strconv.ParseFloat(s[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("xmltree: bad number %q in XPath %q", s[i:j], s)
+			}
+			emit(xtokNumber, s[i:j])
+			out[len(out)-1].num = f
+			i = j
+		case c == '$':
+			j := readName(i + 1)
+			if j < len(s) && s[j] == ':' && j+1 < len(s) && s[j+1] != ':' {
+				j = readName(j + 1)
+			}
+			if j == i+1 {
+				return nil, fmt.Errorf("xmltree: bad variable reference in XPath %q", s)
+			}
+			emit(xtokVar, s[i+1:j])
+			i = j
+		case c == '*':
+			if operatorContext() {
+				emit(xtokOp, "*")
+			} else {
+				emit(xtokStar, "*")
+			}
+			i++
+		case c == '/' && i+1 < len(s) && s[i+1] == '/':
+			emit(xtokOp, "//")
+			i += 2
+		case c == '!' && i+1 < len(s) && s[i+1] == '=':
+			emit(xtokOp, "!=")
+			i += 2
+		case (c == '<' || c == '>') && i+1 < len(s) && s[i+1] == '=':
+			emit(xtokOp, s[i:i+2])
+			i += 2
+		case strings.IndexByte("/|+-=<>", c) >= 0:
+			emit(xtokOp, s[i:i+1])
+			i++
+		default:
+			r, _ := utf8.DecodeRuneInString(s[i:])
+			if !isNameStart(r) {
+				return nil, fmt.Errorf("xmltree: unexpected %q in XPath %q", r, s)
+			}
+			j := readName(i)
+			name := s[i:j]
+			if operatorContext() {
+				switch name {
+				case "and", "or", "mod", "div":
+					emit(xtokOp, name)
+					i = j
+					continue
+				}
+				return nil, fmt.Errorf("xmltree: unexpected name %q in XPath %q", name, s)
+			}
+			if j+1 < len(s) && s[j] == ':' && s[j+1] == '*' {
+				emit(xtokName, s[i:j+2])
+				i = j + 2
+				continue
+			}
+			if j+1 < len(s) && s[j] == ':' && s[j+1] != ':' {
+				j = readName(j + 1)
+				name = s[i:j]
+			}
+			k := skipSpace(j)
+			switch {
+			case k+1 < len(s) && s[k] == ':' && s[k+1] == ':':
+				emit(xtokAxis, name)
+			case k < len(s) && s[k] == '(':
+				switch name {
+				case "comment", "text", "processing-instruction", "node":
+					emit(xtokNodeType, name)
+				default:
+					emit(xtokFunc, name)
+				}
+			default:
+				emit(xtokName, name)
+			}
+			i = j
+		}
+	}
+	return out, nil
+}
+
+// The parsed form of an XPath expression.
+type xexpr interface{}
+
+type (
+	xbinary struct {
+		op   string
+		l, r xexpr
+	}
+	xnegate struct {
+		e xexpr
+	}
+	xliteral string
+	xnumber  float64
+	xvarRef  string
+	xcall    struct {
+		name string
+		args []xexpr
+	}
+	// A path is an optional filter expression followed by location
+	// steps. A path with no filter is relative to the context node
+	// unless abs is set.
+	xpath struct {
+		filter xexpr
+		abs    bool
+		steps  []xstep
+	}
+	xfilter struct {
+		e     xexpr
+		preds []xexpr
+	}
+	xstep struct {
+		axis  xaxis
+		test  xnodeTest
+		preds []xexpr
+	}
+	xnodeTest struct {
+		// one of "name", "node", "text", "comment", "processing-instruction"
+		kind string
+		// for name tests, the prefix and local name; local is "*" for
+		// wildcards. For processing-instruction tests, the target.
+		prefix, local string
+	}
+)
+
+type xaxis uint8
+
+const (
+	axisChild xaxis = iota
+	axisDescendant
+	axisDescendantOrSelf
+	axisParent
+	axisAncestor
+	axisAncestorOrSelf
+	axisFollowingSibling
+	axisPrecedingSibling
+	axisFollowing
+	axisPreceding
+	axisAttribute
+	axisNamespace
+	axisSelf
+)
+
+var axisNames = map[string]xaxis{
+	"child":              axisChild,
+	"descendant":         axisDescendant,
+	"descendant-or-self": axisDescendantOrSelf,
+	"parent":             axisParent,
+	"ancestor":           axisAncestor,
+	"ancestor-or-self":   axisAncestorOrSelf,
+	"following-sibling":  axisFollowingSibling,
+	"preceding-sibling":  axisPrecedingSibling,
+	"following":          axisFollowing,
+	"preceding":          axisPreceding,
+	"attribute":          axisAttribute,
+	"namespace":          axisNamespace,
+	"self":               axisSelf,
+}
+
+func (a xaxis) reverse() bool {
+	switch a {
+	case axisParent, axisAncestor, axisAncestorOrSelf, axisPrecedingSibling, axisPreceding:
+		return true
+	}
+	return false
+}
+
+type xparser struct {
+	src  string
+	toks []xtoken
+	pos  int
+}
+
+func (p *xparser) peek() xtoken {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return xtoken{kind: xtokEOF}
+}
+
+func (p *xparser) next() xtoken {
+	t := p.peek()
+	if p.pos < len(p.toks) {
+		p.pos++
+	}
+	return t
+}
+
+func (p *xparser) is(kind xtokKind, val string) bool {
+	t := p.peek()
+	return t.kind == kind && t.val == val
+}
+
+func (p *xparser) expect(kind xtokKind, val string) error {
+	if !p.is(kind, val) {
+		return p.errorf("expected %q", val)
+	}
+	p.next()
+	return nil
+}
+
+func (p *xparser) errorf(format string, args ...interface{}) error {
+	near := "end of expression"
+	if t := p.peek(); t.kind != xtokEOF {
+		near = strconv.Quote(t.val)
+	}
+	return fmt.Errorf("xmltree: XPath %q: %s near %s", p.src, fmt.Sprintf(format, args...), near)
+}
+
+func parseXPath(src string) (xexpr, error) {
+	toks, err := lexXPath(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &xparser{src: src, toks: toks}
+	e, err := p.orExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != xtokEOF {
+		return nil, p.errorf("unexpected token")
+	}
+	return e, nil
+}
+
+// binaryLevel parses a left-associative sequence of operands separated
+// by any of ops.
+func (p *xparser) binaryLevel(operand func() (xexpr, error), ops ...string) (xexpr, error) {
+	l, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != xtokOp {
+			return l, nil
+		}
+		found := false
+		for _, op := range ops {
+			if t.val == op {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return l, nil
+		}
+		p.next()
+		r, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		l = xbinary{op: t.val, l: l, r: r}
+	}
+}
+
+func (p *xparser) orExpr() (xexpr, error) {
+	return p.binaryLevel(p.andExpr, "or")
+}
+
+func (p *xparser) andExpr() (xexpr, error) {
+	return p.binaryLevel(p.equalityExpr, "and")
+}
+
+func (p *xparser) equalityExpr() (xexpr, error) {
+	return p.binaryLevel(p.relationalExpr, "=", "!=")
+}
+
+func (p *xparser) relationalExpr() (xexpr, error) {
+	return p.binaryLevel(p.additiveExpr, "<", ">", "<=", ">=")
+}
+
+func (p *xparser) additiveExpr() (xexpr, error) {
+	return p.binaryLevel(p.multiplicativeExpr, "+", "-")
+}
+
+func (p *xparser) multiplicativeExpr() (xexpr, error) {
+	return p.binaryLevel(p.unaryExpr, "*", "div", "mod")
+}
+
+func (p *xparser) unaryExpr() (xexpr, error) {
+	if p.is(xtokOp, "-") {
+		p.next()
+		e, err := p.unaryExpr()
+		if err != nil {
+			return nil, err
+		}
+		return xnegate{e}, nil
+	}
+	return p.binaryLevel(p.pathExpr, "|")
+}
+
+func (p *xparser) pathExpr() (xexpr, error) {
+	t := p.peek()
+	switch {
+	case t.kind == xtokOp && (t.val == "/" || t.val == "//"):
+		return p.locationPath(xpath{abs: true})
+	case t.kind == xtokLiteral || t.kind == xtokNumber || t.kind == xtokVar ||
+		t.kind == xtokFunc || t.kind == xtokPunct && t.val == "(":
+		e, err := p.primaryExpr()
+		if err != nil {
+			return nil, err
+		}
+		var preds []xexpr
+		for p.is(xtokPunct, "[") {
+			pred, err := p.predicate()
+			if err != nil {
+				return nil, err
+			}
+			preds = append(preds, pred)
+		}
+		if len(preds) > 0 {
+			e = xfilter{e: e, preds: preds}
+		}
+		if t := p.peek(); t.kind == xtokOp && (t.val == "/" || t.val == "//") {
+			return p.locationPath(xpath{filter: e})
+		}
+		return e, nil
+	}
+	return p.locationPath(xpath{})
+}
+
+// locationPath parses the steps of a path. For absolute paths and
+// paths following a filter expression, the leading / or // has not yet
+// been consumed.
+func (p *xparser) locationPath(path xpath) (xexpr, error) {
+	descendant := xstep{axis: axisDescendantOrSelf, test: xnodeTest{kind: "node"}}
+	if path.abs || path.filter != nil {
+		switch p.next().val {
+		case "//":
+			path.steps = append(path.steps, descendant)
+		case "/":
+			if path.abs && !p.startsStep() {
+				return path, nil
+			}
+		}
+	}
+	for {
+		s, err := p.step()
+		if err != nil {
+			return nil, err
+		}
+		path.steps = append(path.steps, s)
+		if p.is(xtokOp, "/") {
+			p.next()
+		} else if p.is(xtokOp, "//") {
+			p.next()
+			path.steps = append(path.steps, descendant)
+		} else {
+			return path, nil
+		}
+	}
+}
+
+func (p *xparser) startsStep() bool {
+	t := p.peek()
+	switch t.kind {
+	case xtokName, xtokStar, xtokNodeType, xtokAxis:
+		return true
+	case xtokPunct:
+		return t.val == "@" || t.val == "." || t.val == ".."
+	}
+	return false
+}
+
+func (p *xparser) step() (xstep, error) {
+	var s xstep
+	t := p.peek()
+	switch {
+	case t.kind == xtokPunct && t.val == ".":
+		p.next()
+		return xstep{axis: axisSelf, test: xnodeTest{kind: "node"}}, nil
+	case t.kind == xtokPunct && t.val == "..":
+		p.next()
+		return xstep{axis: axisParent, test: xnodeTest{kind: "node"}}, nil
+	case t.kind == xtokPunct && t.val == "@":
+		p.next()
+		s.axis = axisAttribute
+	case t.kind == xtokAxis:
+		p.next()
+		axis, ok := axisNames[t.val]
+		if !ok {
+			return s, p.errorf("unknown axis %q", t.val)
+		}
+		s.axis = axis
+		if err := p.expect(xtokPunct, "::"); err != nil {
+			return s, err
+		}
+	}
+	t = p.next()
+	switch t.kind {
+	case xtokStar:
+		s.test = xnodeTest{kind: "name", local: "*"}
+	case xtokName:
+		s.test = xnodeTest{kind: "name", local: t.val}
+		if i := strings.IndexByte(t.val, ':'); i >= 0 {
+			s.test.prefix, s.test.local = t.val[:i], t.val[i+1:]
+		}
+	case xtokNodeType:
+		s.test = xnodeTest{kind: t.val}
+		if err := p.expect(xtokPunct, "("); err != nil {
+			return s, err
+		}
+		if t.val == "processing-instruction" && p.peek().kind == xtokLiteral {
+			s.test.local = p.next().val
+		}
+		if err := p.expect(xtokPunct, ")"); err != nil {
+			return s, err
+		}
+	default:
+		p.pos--
+		return s, p.errorf("expected a node test")
+	}
+	for p.is(xtokPunct, "[") {
+		pred, err := p.predicate()
+		if err != nil {
+			return s, err
+		}
+		s.preds = append(s.preds, pred)
+	}
+	return s, nil
+}
+
+func (p *xparser) predicate() (xexpr, error) {
+	p.next()
+	e, err := p.orExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(xtokPunct, "]"); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (p *xparser) primaryExpr() (xexpr, error) {
+	t := p.next()
+	switch t.kind {
+	case xtokLiteral:
+		return xliteral(t.val), nil
+	case xtokNumber:
+		return xnumber(t.num), nil
+	case xtokVar:
+		return xvarRef(t.val), nil
+	case xtokPunct:
+		e, err := p.orExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(xtokPunct, ")"); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+	// function call
+	call := xcall{name: t.val}
+	if err := p.expect(xtokPunct, "("); err != nil {
+		return nil, err
+	}
+	if p.is(xtokPunct, ")") {
+		p.next()
+		return call, nil
+	}
+	for {
+		arg, err := p.orExpr()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+		if p.is(xtokPunct, ",") {
+			p.next()
+			continue
+		}
+		if err := p.expect(xtokPunct, ")"); err != nil {
+			return nil, err
+		}
+		return call, nil
+	}
+}
@@ -0,0 +1,99 @@
+package xmltree
+
+import (
+	"testing"
+)
+
+func TestXPath(t *testing.T) {
+	root := parseFullDoc(t, []byte(`<library xmlns:x="urn:x">
+	  <book id="b1" year="1954"><title>The Fellowship of the Ring</title><x:price>10</x:price></book>
+	  <book id="b2" year="1937"><title>The Hobbit</title><x:price>7.5</x:price></book>
+	  <!-- out of print -->
+	  <book id="b3" year="1977" xml:lang="en-GB"><title>The Silmarillion</title></book>
+	  <?sort by-year?>
+	  <magazine id="m1"><title>Mixed <b>bold</b> content</title></magazine>
+	</library>`))
+
+	c := NewXPathContext(root)
+	c.Namespaces = map[string]string{"x": "urn:x"}
+	c.Variables = map[string]interface{}{"min": float64(1950)}
+	for _, tt := range []struct {
+		expr, want string
+	}{
+		{`count(//book)`, "3"},
+		{`count(/library/*)`, "4"},
+		{`//book[@year < 1950]/title`, "The Hobbit"},
+		{`//book[last()]/@id`, "b3"},
+		{`string(//book[2]/preceding-sibling::book/@id)`, "b1"},
+		{`//title[. = 'The Hobbit']/../@id`, "b2"},
+		{`sum(//x:price)`, "17.5"},
+		{`count(//x:*)`, "2"},
+		{`//book[@year > $min][2]/@id`, "b3"},
+		{`concat(local-name(/*), ':', name(//x:price))`, "library:x:price"},
+		{`normalize-space(//magazine/title)`, "Mixed bold content"},
+		{`//comment()`, " out of print "},
+		{`//processing-instruction('sort')`, "by-year"},
+		{`count(//book[lang('en')])`, "1"},
+		{`substring('12345', 1.5, 2.6)`, "234"},
+		{`translate('bar', 'abc', 'ABC')`, "BAr"},
+		{`id('b2 m1')/title`, "The Hobbit"},
+		{`count(//title/ancestor::*)`, "5"},
+		{`count(//book[1]/following::title)`, "3"},
+		{`count((//book | //magazine)[position() mod 2 = 1])`, "2"},
+		{`-2 * 3 + 7 div 2 - 5 mod 3`, "-4.5"},
+		{`boolean(//book[@id = 'nope']) or not(false())`, "true"},
+		{`name(//book[1]/namespace::x)`, "x"},
+	} {
+		x, err := CompileXPath(tt.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		got, err := c.EvalString(x, c.Node(root))
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s = %q, wanted %q", tt.expr, got, tt.want)
+		}
+	}
+
+	if v, err := c.EvalAt(MustCompileXPath(`concat(position(), '/', last())`), c.Node(root), 2, 3); err != nil || v != "2/3" {
+		t.Errorf("EvalAt = %v, %v; wanted 2/3", v, err)
+	}
+
+	for _, bad := range []string{`//book[`, `1 +`, `foo::bar`, `'abc`, `//book]`} {
+		if _, err := CompileXPath(bad); err == nil {
+			t.Errorf("expected an error compiling %q", bad)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	root := parseDoc(t, []byte(ovalSample))
+	defs, err := root.Query(`/oval_definitions/definitions/definition[criteria/criterion/@test_ref = 'tst:2']`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) != 1 || defs[0].Attr("", "id") != "def:1" {
+		t.Errorf("got %d definitions, wanted def:1", len(defs))
+	}
+}
+
+const ovalSample = `<oval_definitions xmlns="http://oval.mitre.org/XMLSchema/oval-definitions-5">
+  <definitions>
+    <definition id="def:1" version="1">
+      <title>CVE-1</title>
+      <criteria operator="AND">
+        <criterion test_ref="tst:1"/>
+        <criterion test_ref="tst:2"/>
+      </criteria>
+    </definition>
+    <definition id="def:2" version="1"><title>CVE-2</title></definition>
+  </definitions>
+  <tests>
+    <test id="tst:1"/>
+    <test id="tst:2"/>
+  </tests>
+</oval_definitions>`
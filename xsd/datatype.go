@@ -0,0 +1,108 @@
+package xsd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/pschou/go-xmltree"
+)
+
+// A Datatype is a simple type used outside of a schema, as by RELAX NG,
+// which takes its datatypes from XML Schema.
+type Datatype struct {
+	t *simpleType
+}
+
+// facetNames are the facets which may be given as parameters.
+var facetNames = map[string]bool{
+	"length": true, "minLength": true, "maxLength": true, "pattern": true,
+	"enumeration": true, "whiteSpace": true, "maxInclusive": true,
+	"maxExclusive": true, "minInclusive": true, "minExclusive": true,
+	"totalDigits": true, "fractionDigits": true,
+}
+
+// NewDatatype returns the built-in type with the given local name, such
+// as "integer", restricted by the facets in params, given as pairs of
+// names and values. A value must match every pattern given.
+func NewDatatype(name string, params [][2]string) (*Datatype, error) {
+	t, ok := builtins[name]
+	if !ok || t == anySimpleType {
+		return nil, fmt.Errorf("xsd: unknown datatype %s", name)
+	}
+	if len(params) == 0 {
+		return &Datatype{t}, nil
+	}
+	restriction := new(xmltree.Element)
+	var patterns []*xmltree.Element
+	for _, p := range params {
+		if !facetNames[p[0]] {
+			return nil, fmt.Errorf("xsd: unknown facet %s for datatype %s", p[0], name)
+		}
+		facet := xmltree.Element{StartElement: xml.StartElement{
+			Name: xml.Name{Space: xsdNS, Local: p[0]},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "value"}, Value: p[1]}},
+		}}
+		if p[0] == "pattern" {
+			patterns = append(patterns, &xmltree.Element{Children: []xmltree.Element{facet}})
+		} else {
+			restriction.Children = append(restriction.Children, facet)
+		}
+	}
+	s := new(Schema)
+	t, err := s.restrict(restriction, nil, t, xml.Name{})
+	if err != nil {
+		return nil, err
+	}
+	// Each pattern is a separate step, so that all must match.
+	for _, p := range patterns {
+		if t, err = s.restrict(p, nil, t, xml.Name{}); err != nil {
+			return nil, err
+		}
+	}
+	return &Datatype{t}, nil
+}
+
+// Validate checks a value against the datatype. QName values are
+// resolved using scope.
+func (d *Datatype) Validate(value string, scope *xmltree.Scope) error {
+	_, err := d.t.validate(value, scope)
+	return err
+}
+
+// Equal reports whether two values of the datatype are equal, comparing
+// them in the value space of the type, so that "1" and "01" are equal
+// integers. QName values are resolved using the scope given with each.
+func (d *Datatype) Equal(a string, ascope *xmltree.Scope, b string, bscope *xmltree.Scope) bool {
+	a, b = d.t.normalize(a), d.t.normalize(b)
+	if d.t.variety != atomic || d.t.prim == nil {
+		return a == b
+	}
+	switch d.t.prim.name {
+	case "QName", "NOTATION":
+		an, aok := ascope.ResolveNS(a)
+		bn, bok := bscope.ResolveNS(b)
+		if !aok && strings.Contains(a, ":") || !bok && strings.Contains(b, ":") {
+			return false
+		}
+		return an == bn
+	case "hexBinary":
+		return strings.EqualFold(a, b)
+	case "base64Binary":
+		return strings.ReplaceAll(a, " ", "") == strings.ReplaceAll(b, " ", "")
+	}
+	av, err := d.t.prim.parse(a)
+	if err != nil {
+		return false
+	}
+	bv, err := d.t.prim.parse(b)
+	if err != nil {
+		return false
+	}
+	if d.t.prim.compare != nil {
+		c, ok := d.t.prim.compare(av, bv)
+		return ok && c == 0
+	}
+	return reflect.DeepEqual(av, bv)
+}
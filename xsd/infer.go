@@ -0,0 +1,432 @@
+package xsd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pschou/go-xmltree"
+)
+
+// maxEnumeration is the largest number of distinct values which
+// InferSchema lists as an enumeration.
+const maxEnumeration = 10
+
+// valueTypes are the types tried for the values of attributes and
+// elements with simple content, in order of preference.
+var valueTypes = []string{"integer", "decimal", "boolean", "date", "dateTime", "time"}
+
+// InferSchema returns a schema describing the sample documents, as an
+// xs:schema element which may be encoded or passed to Compile. The roots
+// of the documents must be in the same namespace, which is the target
+// namespace of the schema.
+//
+// Each element is described by one type, merging what is seen wherever
+// an element of that name occurs. Children appearing in a consistent
+// order become a sequence, in which a child is optional if some parent
+// lacks it and unbounded if some parent has several; otherwise the
+// children may appear in any order. Text alongside child elements makes
+// content mixed.
+// Attributes present on every element are required. Values are given
+// the first of xs:integer, xs:decimal, xs:boolean, xs:date, xs:dateTime
+// and xs:time which accepts every sample, and otherwise xs:string, or an
+// enumeration of xs:token when a few values are repeated. Elements from
+// other namespaces are allowed by wildcards, as are attributes in a
+// namespace.
+func InferSchema(docs ...*xmltree.Element) (*xmltree.Element, error) {
+	if len(docs) == 0 {
+		return nil, errors.New("xsd: no documents to infer a schema from")
+	}
+	in := &inferrer{target: docs[0].Name.Space, elems: make(map[xml.Name]*elemStats)}
+	for _, doc := range docs {
+		if doc.Name.Space != in.target {
+			return nil, fmt.Errorf("xsd: documents in namespaces %q and %q", in.target, doc.Name.Space)
+		}
+		known := false
+		for _, name := range in.roots {
+			known = known || name == doc.Name
+		}
+		if !known {
+			in.roots = append(in.roots, doc.Name)
+		}
+		in.observe(doc, 0)
+	}
+	return in.schema(), nil
+}
+
+type inferrer struct {
+	target string
+	elems  map[xml.Name]*elemStats
+	order  []xml.Name // elements in the order first seen
+	roots  []xml.Name
+
+	out       *xmltree.Element
+	typeNames map[string]bool
+	complex   map[xml.Name]string // names of complex types, by element
+	types     []xmltree.Element   // named type definitions
+}
+
+// elemStats is what has been seen of the elements with one name.
+type elemStats struct {
+	count      int
+	attrs      map[string]*valueStats // unqualified attributes, by name
+	attrOrder  []string
+	otherAttrs bool // attributes in a namespace
+	children   map[xml.Name]*childStats
+	childOrder []xml.Name
+	before     map[[2]xml.Name]bool // pairs of children seen in that order
+	unordered  bool
+	withElems  int // elements with child elements
+	withText   int // elements with text other than white space
+	text       valueStats
+}
+
+// otherName stands for the children of an element which are in other
+// namespaces, and are matched by a wildcard.
+var otherName = xml.Name{Space: "##other"}
+
+// childStats counts the children of one name within each parent.
+type childStats struct {
+	seen     int // parents with at least one
+	min, max int
+}
+
+// valueStats summarizes the values of an attribute or simple content.
+type valueStats struct {
+	count  int
+	values map[string]int // collapsed values; nil once there are too many
+	many   bool
+	not    map[string]bool // types from valueTypes which some value is not
+}
+
+func (v *valueStats) add(s string) {
+	v.count++
+	if v.not == nil {
+		v.not = make(map[string]bool)
+		v.values = make(map[string]int)
+	}
+	for _, name := range valueTypes {
+		if !v.not[name] {
+			if _, err := builtins[name].validate(s, nil); err != nil {
+				v.not[name] = true
+			}
+		}
+	}
+	if !v.many {
+		v.values[strings.Join(strings.Fields(s), " ")]++
+		if len(v.values) > maxEnumeration {
+			v.many, v.values = true, nil
+		}
+	}
+}
+
+func (in *inferrer) stats(name xml.Name) *elemStats {
+	st, ok := in.elems[name]
+	if !ok {
+		st = &elemStats{attrs: make(map[string]*valueStats), children: make(map[xml.Name]*childStats), before: make(map[[2]xml.Name]bool)}
+		in.elems[name] = st
+		in.order = append(in.order, name)
+	}
+	return st
+}
+
+// declared reports whether elements with the given name are declared by
+// the schema, rather than matched by a wildcard.
+func (in *inferrer) declared(name xml.Name) bool {
+	return name.Space == in.target || name.Space == ""
+}
+
+func (in *inferrer) observe(el *xmltree.Element, depth int) {
+	st := in.stats(el.Name)
+	st.count++
+	for _, a := range el.StartElement.Attr {
+		switch a.Name.Space {
+		case xsiNS:
+		case "":
+			v, ok := st.attrs[a.Name.Local]
+			if !ok {
+				v = new(valueStats)
+				st.attrs[a.Name.Local] = v
+				st.attrOrder = append(st.attrOrder, a.Name.Local)
+			}
+			v.add(a.Value)
+		default:
+			st.otherAttrs = true
+		}
+	}
+
+	var text strings.Builder
+	text.WriteString(el.Content)
+	counts := make(map[xml.Name]int)
+	var runs []xml.Name // names of the children, with repeats collapsed
+	for i := range el.Children {
+		child := &el.Children[i]
+		switch child.Type {
+		case xmltree.XML_CharData, xmltree.XML_CDATA:
+			text.WriteString(child.Content)
+		case xmltree.XML_Tag:
+			name := child.Name
+			if !in.declared(name) {
+				name = otherName
+			} else if depth < 1000 {
+				in.observe(child, depth+1)
+			}
+			counts[name]++
+			if len(runs) == 0 || runs[len(runs)-1] != name {
+				runs = append(runs, name)
+			}
+		}
+	}
+	hasText := strings.TrimSpace(text.String()) != ""
+	if hasText {
+		st.withText++
+	}
+	if len(counts) > 0 {
+		st.withElems++
+	} else {
+		st.text.add(text.String())
+	}
+
+	for i, name := range runs {
+		cs, ok := st.children[name]
+		if !ok {
+			cs = new(childStats)
+			st.children[name] = cs
+			st.childOrder = append(st.childOrder, name)
+		}
+		for _, prev := range runs[:i] {
+			if prev == name || st.before[[2]xml.Name{name, prev}] {
+				st.unordered = true
+			}
+			st.before[[2]xml.Name{prev, name}] = true
+		}
+	}
+	for name, n := range counts {
+		cs := st.children[name]
+		if cs.seen == 0 || n < cs.min {
+			cs.min = n
+		}
+		if n > cs.max {
+			cs.max = n
+		}
+		cs.seen++
+	}
+}
+
+// sequence returns the children of st in an order consistent with every
+// element seen, or false if there is none.
+func (st *elemStats) sequence() ([]xml.Name, bool) {
+	if st.unordered {
+		return nil, false
+	}
+	var seq []xml.Name
+	done := make(map[xml.Name]bool)
+	for len(seq) < len(st.childOrder) {
+		found := false
+		for _, name := range st.childOrder {
+			if done[name] {
+				continue
+			}
+			ready := true
+			for _, prev := range st.childOrder {
+				if !done[prev] && prev != name && st.before[[2]xml.Name{prev, name}] {
+					ready = false
+				}
+			}
+			if ready {
+				seq = append(seq, name)
+				done[name] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return seq, true
+}
+
+// isComplex reports whether elements described by st need a complex
+// type.
+func (st *elemStats) isComplex() bool {
+	return st.withElems > 0 || len(st.attrs) > 0 || st.otherAttrs || st.withText == 0
+}
+
+func (in *inferrer) xs(local string, attrs ...string) *xmltree.Element {
+	el := &xmltree.Element{Type: xmltree.XML_Tag, Scope: in.out.Scope}
+	el.Name = xml.Name{Space: xsdNS, Local: local}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		el.SetAttr("", attrs[i], attrs[i+1])
+	}
+	return el
+}
+
+// qualify returns the QName of a type defined by the schema.
+func (in *inferrer) qualify(name string) string {
+	if in.target == "" {
+		return name
+	}
+	return "tns:" + name
+}
+
+// typeName returns an unused name for a type, based on name.
+func (in *inferrer) typeName(name string) string {
+	for i := 1; ; i++ {
+		n := name + "Type"
+		if i > 1 {
+			n += strconv.Itoa(i)
+		}
+		if !in.typeNames[n] {
+			in.typeNames[n] = true
+			return n
+		}
+	}
+}
+
+func (in *inferrer) schema() *xmltree.Element {
+	var b bytes.Buffer
+	b.WriteString(`<xs:schema xmlns:xs="` + xsdNS + `"`)
+	if in.target != "" {
+		b.WriteString(` xmlns:tns="`)
+		xml.EscapeText(&b, []byte(in.target))
+		b.WriteString(`" targetNamespace="`)
+		xml.EscapeText(&b, []byte(in.target))
+		b.WriteString(`" elementFormDefault="qualified"`)
+	}
+	b.WriteString(`/>`)
+	out, err := xmltree.Parse(&b)
+	if err != nil {
+		panic(err)
+	}
+	in.out = out
+	in.typeNames = make(map[string]bool)
+	in.complex = make(map[xml.Name]string)
+
+	// Name the complex types first, as they may refer to each other.
+	for _, name := range in.order {
+		if in.elems[name].isComplex() {
+			in.complex[name] = in.typeName(name.Local)
+		}
+	}
+	for _, name := range in.roots {
+		out.Children = append(out.Children, *in.declare(name))
+	}
+	for _, name := range in.order {
+		if tn, ok := in.complex[name]; ok {
+			in.types = append(in.types, *in.complexType(name, tn))
+		}
+	}
+	out.Children = append(out.Children, in.types...)
+	return out
+}
+
+// declare returns an element declaration for elements with name.
+func (in *inferrer) declare(name xml.Name) *xmltree.Element {
+	decl := in.xs("element", "name", name.Local)
+	if tn, ok := in.complex[name]; ok {
+		decl.SetAttr("", "type", in.qualify(tn))
+	} else {
+		decl.SetAttr("", "type", in.valueType(&in.elems[name].text, name.Local))
+	}
+	return decl
+}
+
+// valueType returns the QName of a simple type accepting the values
+// described by v, defining an enumeration named after name if needed.
+func (in *inferrer) valueType(v *valueStats, name string) string {
+	for _, t := range valueTypes {
+		if v.count > 0 && !v.not[t] {
+			return "xs:" + t
+		}
+	}
+	if v.many || len(v.values) == 0 || v.count < 2*len(v.values) {
+		return "xs:string"
+	}
+	values := make([]string, 0, len(v.values))
+	for s := range v.values {
+		values = append(values, s)
+	}
+	sort.Strings(values)
+	restriction := in.xs("restriction", "base", "xs:token")
+	for _, s := range values {
+		restriction.Children = append(restriction.Children, *in.xs("enumeration", "value", s))
+	}
+	tn := in.typeName(name)
+	st := in.xs("simpleType", "name", tn)
+	st.Children = append(st.Children, *restriction)
+	in.types = append(in.types, *st)
+	return in.qualify(tn)
+}
+
+func (in *inferrer) complexType(name xml.Name, tn string) *xmltree.Element {
+	st := in.elems[name]
+	ct := in.xs("complexType", "name", tn)
+	parent := ct // where attributes go
+	var content *xmltree.Element
+
+	switch {
+	case st.withElems > 0:
+		if st.withText > 0 {
+			ct.SetAttr("", "mixed", "true")
+		}
+		var model *xmltree.Element
+		if seq, ok := st.sequence(); ok {
+			model = in.xs("sequence")
+			for _, child := range seq {
+				decl := in.particle(child)
+				cs := st.children[child]
+				if cs.seen < st.count {
+					decl.SetAttr("", "minOccurs", "0")
+				}
+				if cs.max > 1 {
+					decl.SetAttr("", "maxOccurs", "unbounded")
+				}
+				model.Children = append(model.Children, *decl)
+			}
+		} else {
+			model = in.xs("choice", "minOccurs", "0", "maxOccurs", "unbounded")
+			for _, child := range st.childOrder {
+				model.Children = append(model.Children, *in.particle(child))
+			}
+		}
+		ct.Children = append(ct.Children, *model)
+	case st.withText > 0:
+		content = in.xs("simpleContent")
+		parent = in.xs("extension", "base", in.valueType(&st.text, name.Local))
+	}
+
+	for _, local := range st.attrOrder {
+		v := st.attrs[local]
+		attr := in.xs("attribute", "name", local, "type", in.valueType(v, name.Local+"-"+local))
+		if v.count == st.count {
+			attr.SetAttr("", "use", "required")
+		}
+		parent.Children = append(parent.Children, *attr)
+	}
+	if st.otherAttrs {
+		parent.Children = append(parent.Children, *in.xs("anyAttribute", "namespace", "##any", "processContents", "skip"))
+	}
+	if content != nil {
+		content.Children = append(content.Children, *parent)
+		ct.Children = append(ct.Children, *content)
+	}
+	return ct
+}
+
+// particle returns a local declaration of a child element, or a wildcard
+// for otherName.
+func (in *inferrer) particle(name xml.Name) *xmltree.Element {
+	if name == otherName {
+		return in.xs("any", "namespace", "##other", "processContents", "skip")
+	}
+	decl := in.declare(name)
+	if name.Space == "" && in.target != "" {
+		decl.SetAttr("", "form", "unqualified")
+	}
+	return decl
+}
@@ -0,0 +1,90 @@
+package xsd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-xmltree"
+)
+
+func TestInferSchema(t *testing.T) {
+	samples := []string{
+		`<inventory xmlns="urn:inv" xmlns:x="urn:x" updated="2024-01-02">
+			<item sku="a1" status="active" x:note="n">
+				<name>Bolt</name><qty>10</qty><price>0.25</price><tag>metal</tag><tag>small</tag>
+			</item>
+			<item sku="a2" status="retired">
+				<name>Nut</name><qty>0</qty><x:extra/>
+			</item>
+			<remark>Counted <b>twice</b></remark>
+		</inventory>`,
+		`<inventory xmlns="urn:inv" updated="2024-02-01">
+			<item sku="b7" status="active"><name>Washer</name><qty>3</qty><price>1</price></item>
+			<item sku="b8" status="active" taxed="true"><name>Screw</name><qty>7</qty></item>
+			<remark>None</remark>
+			<empty/>
+		</inventory>`,
+	}
+	var docs []*xmltree.Element
+	for _, s := range samples {
+		docs = append(docs, mustParse(t, s))
+	}
+	schema, err := InferSchema(docs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := schema.String()
+	for _, want := range []string{
+		`targetNamespace="urn:inv"`,
+		`<xs:element name="inventory" type="tns:inventoryType"`,
+		`<xs:attribute name="updated" type="xs:date" use="required"`,
+		`<xs:element name="item" type="tns:itemType" maxOccurs="unbounded"`,
+		`<xs:element name="qty" type="xs:integer"`,
+		`<xs:element name="price" type="xs:decimal" minOccurs="0"`,
+		`<xs:element name="tag" type="xs:string" minOccurs="0" maxOccurs="unbounded"`,
+		`<xs:attribute name="taxed" type="xs:boolean"`,
+		`<xs:enumeration value="active"`,
+		`<xs:complexType name="remarkType" mixed="true"`,
+		`<xs:element name="empty" type="tns:emptyType" minOccurs="0"`,
+		`<xs:any namespace="##other" processContents="skip"`,
+		`<xs:anyAttribute namespace="##any" processContents="skip"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("schema does not contain %s:\n%s", want, out)
+		}
+	}
+
+	// The samples are valid against the schema inferred from them.
+	s, err := Compile(schema, nil)
+	if err != nil {
+		t.Fatalf("%v:\n%s", err, out)
+	}
+	for i, doc := range docs {
+		if err := s.Validate(doc); err != nil {
+			t.Errorf("sample %d: %v", i, err)
+		}
+	}
+	if err := s.Validate(mustParse(t, `<inventory xmlns="urn:inv" updated="2024"><item sku="c" status="lost"><qty>1</qty><name/></item></inventory>`)); err == nil {
+		t.Error("document unlike the samples is valid")
+	}
+}
+
+func TestInferSchemaOrder(t *testing.T) {
+	schema, err := InferSchema(
+		mustParse(t, `<list><a>x</a><b/><a>y</a></list>`),
+		mustParse(t, `<list><b/></list>`),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := schema.String()
+	if strings.Contains(out, "targetNamespace") || !strings.Contains(out, `<xs:choice minOccurs="0" maxOccurs="unbounded">`) {
+		t.Errorf("schema for children out of order:\n%s", out)
+	}
+	if _, err := InferSchema(mustParse(t, `<a/>`), mustParse(t, `<a xmlns="urn:a"/>`)); err == nil {
+		t.Error("inferred a schema for two namespaces")
+	}
+	if _, err := InferSchema(); err == nil {
+		t.Error("inferred a schema from no documents")
+	}
+}
@@ -0,0 +1,93 @@
+package xsd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// compilePattern compiles a regular expression written in the syntax
+// of XML Schema, which is always anchored at both ends, into a Go
+// regular expression. Character class subtraction and Unicode block
+// escapes are not supported.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString(`^(?:`)
+	class := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			esc := pattern[i]
+			switch esc {
+			case 'i', 'I', 'c', 'C':
+				set := nameStart
+				if esc == 'c' || esc == 'C' {
+					set = nameChar
+				}
+				switch {
+				case class && (esc == 'I' || esc == 'C'):
+					return nil, fmt.Errorf("xsd: unsupported \\%c within a character class in pattern %q", esc, pattern)
+				case class:
+					b.WriteString(set)
+				case esc == 'I' || esc == 'C':
+					b.WriteString(`[^` + set + `]`)
+				default:
+					b.WriteString(`[` + set + `]`)
+				}
+			case 'd':
+				b.WriteString(`\p{Nd}`)
+			case 'D':
+				b.WriteString(`\P{Nd}`)
+			case 'w':
+				if class {
+					return nil, fmt.Errorf("xsd: unsupported \\w within a character class in pattern %q", pattern)
+				}
+				b.WriteString(`[^\p{P}\p{Z}\p{C}]`)
+			case 'W':
+				if class {
+					return nil, fmt.Errorf("xsd: unsupported \\W within a character class in pattern %q", pattern)
+				}
+				b.WriteString(`[\p{P}\p{Z}\p{C}]`)
+			case 'p', 'P':
+				end := strings.IndexByte(pattern[i:], '}')
+				if end < 0 {
+					return nil, fmt.Errorf("xsd: bad escape in pattern %q", pattern)
+				}
+				if strings.HasPrefix(pattern[i+1:], "{Is") {
+					return nil, fmt.Errorf("xsd: unsupported block escape in pattern %q", pattern)
+				}
+				b.WriteString(`\` + pattern[i:i+end+1])
+				i += end
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(esc)
+			}
+		case c == '[' && !class:
+			class = true
+			b.WriteByte(c)
+			if strings.HasPrefix(pattern[i+1:], "^") {
+				b.WriteByte('^')
+				i++
+			}
+		case c == '[' && class:
+			return nil, fmt.Errorf("xsd: unsupported character class subtraction in pattern %q", pattern)
+		case c == ']' && class:
+			class = false
+			b.WriteByte(c)
+		case (c == '^' || c == '$') && !class:
+			// Not anchors in XML Schema.
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteString(`)$`)
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("xsd: bad pattern %q: %v", pattern, err)
+	}
+	return re, nil
+}
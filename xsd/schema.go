@@ -0,0 +1,1120 @@
+// Package xsd validates trees of xmltree Elements against W3C XML Schema
+// 1.0 definitions. Schemas are themselves read with xmltree.Parse, and
+// the QNames in their attributes are resolved with Scope.Resolve.
+//
+// Complex types with simple, empty, element-only and mixed content are
+// supported, including derivation by extension and restriction, model
+// groups (sequence, choice and all), named groups and attribute groups,
+// occurrence constraints, wildcards and substitution groups. Simple
+// types may be derived by restriction, list and union, and are checked
+// against all of the constraining facets. In documents being validated,
+// xsi:type, xsi:nil and xsi:schemaLocation are honoured, and ID and
+// IDREF values are checked. Identity constraints (unique, key and
+// keyref) and xs:redefine are not supported.
+package xsd
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pschou/go-xmltree"
+	"github.com/pschou/go-xmltree/internal/validation"
+)
+
+const (
+	xsdNS = "http://www.w3.org/2001/XMLSchema"
+	xsiNS = "http://www.w3.org/2001/XMLSchema-instance"
+)
+
+// Options controls how schema documents are found.
+type Options struct {
+	// Load returns the schema document named by a schemaLocation
+	// attribute, either of an xs:include or xs:import element in a
+	// schema, or in the xsi:schemaLocation or
+	// xsi:noNamespaceSchemaLocation attribute of a document being
+	// validated. namespace is the target namespace expected of the
+	// schema, if known. The location is passed as written.
+	//
+	// If Load is nil, xs:include elements are an error, xs:import
+	// elements only refer to namespaces added by Schema.Add, and
+	// schema locations in documents are ignored.
+	Load func(namespace, location string) (*xmltree.Element, error)
+}
+
+// A Schema is a set of compiled schema documents, which may describe
+// several namespaces. A Schema may be used by several goroutines at
+// once.
+type Schema struct {
+	opts Options
+
+	mu         sync.RWMutex    // held for writing while adding definitions
+	loaded     map[string]bool // schema locations already loaded
+	namespaces map[string]bool // target namespaces with definitions
+
+	// Top-level definitions, by name.
+	elements   map[xml.Name]def
+	attributes map[xml.Name]def
+	types      map[xml.Name]def
+	groups     map[xml.Name]def
+	attrGroups map[xml.Name]def
+
+	// Compiled components, by the schema element defining them.
+	elementDecls  map[*xmltree.Element]*elementDecl
+	attrDecls     map[*xmltree.Element]*attrUse
+	simpleTypes   map[*xmltree.Element]*simpleType
+	complexTypes  map[*xmltree.Element]*complexType
+	groupDefs     map[*xmltree.Element]*particle
+	attrGroupDefs map[*xmltree.Element]*attrGroup
+	building      map[*xmltree.Element]bool // to detect circular definitions
+}
+
+// A schemaDoc holds the properties of a schema document which apply to
+// the definitions within it.
+type schemaDoc struct {
+	target        string
+	qualifiedElem bool // elementFormDefault="qualified"
+	qualifiedAttr bool // attributeFormDefault="qualified"
+}
+
+// A def is a top-level definition in a schema document.
+type def struct {
+	el  *xmltree.Element
+	doc *schemaDoc
+}
+
+// Compile compiles a schema document, along with those it includes and
+// imports, into a Schema. A nil opts is the same as a zero Options.
+func Compile(doc *xmltree.Element, opts *Options) (*Schema, error) {
+	s := &Schema{
+		loaded:        make(map[string]bool),
+		namespaces:    make(map[string]bool),
+		elements:      make(map[xml.Name]def),
+		attributes:    make(map[xml.Name]def),
+		types:         make(map[xml.Name]def),
+		groups:        make(map[xml.Name]def),
+		attrGroups:    make(map[xml.Name]def),
+		elementDecls:  make(map[*xmltree.Element]*elementDecl),
+		attrDecls:     make(map[*xmltree.Element]*attrUse),
+		simpleTypes:   make(map[*xmltree.Element]*simpleType),
+		complexTypes:  make(map[*xmltree.Element]*complexType),
+		groupDefs:     make(map[*xmltree.Element]*particle),
+		attrGroupDefs: make(map[*xmltree.Element]*attrGroup),
+		building:      make(map[*xmltree.Element]bool),
+	}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if err := s.Add(doc); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Add adds the definitions in another schema document to the Schema,
+// typically one for another namespace which is imported without a
+// schema location.
+func (s *Schema) Add(doc *xmltree.Element) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.add(doc, nil); err != nil {
+		return err
+	}
+	return s.compile()
+}
+
+// add records the top-level definitions of a schema document. An
+// included document without a target namespace takes that of the
+// document including it.
+func (s *Schema) add(doc *xmltree.Element, includer *schemaDoc) error {
+	if doc.Name.Space != xsdNS || doc.Name.Local != "schema" {
+		return fmt.Errorf("xsd: document element is %s, not xs:schema", doc.Name.Local)
+	}
+	sd := &schemaDoc{
+		target:        doc.Attr("", "targetNamespace"),
+		qualifiedElem: doc.Attr("", "elementFormDefault") == "qualified",
+		qualifiedAttr: doc.Attr("", "attributeFormDefault") == "qualified",
+	}
+	if includer != nil {
+		if sd.target != "" && sd.target != includer.target {
+			return fmt.Errorf("xsd: included schema has target namespace %q, not %q", sd.target, includer.target)
+		}
+		sd.target = includer.target
+	}
+	s.namespaces[sd.target] = true
+	for i := range doc.Children {
+		el := &doc.Children[i]
+		if el.Type != xmltree.XML_Tag || el.Name.Space != xsdNS {
+			continue
+		}
+		var table map[xml.Name]def
+		switch el.Name.Local {
+		case "include":
+			if err := s.load(sd.target, el.Attr("", "schemaLocation"), sd); err != nil {
+				return err
+			}
+			continue
+		case "import":
+			ns := el.Attr("", "namespace")
+			if ns == sd.target {
+				return fmt.Errorf("xsd: schema for %q imports its own namespace", ns)
+			}
+			if loc := el.Attr("", "schemaLocation"); loc != "" && s.opts.Load != nil {
+				if err := s.load(ns, loc, nil); err != nil {
+					return err
+				}
+			}
+			continue
+		case "redefine":
+			return errors.New("xsd: xs:redefine is not supported")
+		case "element":
+			table = s.elements
+		case "attribute":
+			table = s.attributes
+		case "simpleType", "complexType":
+			table = s.types
+		case "group":
+			table = s.groups
+		case "attributeGroup":
+			table = s.attrGroups
+		default:
+			continue
+		}
+		name := xml.Name{Space: sd.target, Local: el.Attr("", "name")}
+		if name.Local == "" {
+			return fmt.Errorf("xsd: top-level xs:%s has no name", el.Name.Local)
+		}
+		if _, dup := table[name]; dup {
+			return fmt.Errorf("xsd: duplicate definition of %s %s", el.Name.Local, describe(name))
+		}
+		table[name] = def{el, sd}
+	}
+	return nil
+}
+
+// load loads and adds the schema document at a location, unless it has
+// already been loaded.
+func (s *Schema) load(namespace, location string, includer *schemaDoc) error {
+	if location == "" {
+		return errors.New("xsd: missing schemaLocation")
+	}
+	if s.loaded[location] {
+		return nil
+	}
+	if s.opts.Load == nil {
+		return fmt.Errorf("xsd: cannot load schema %q without Options.Load", location)
+	}
+	s.loaded[location] = true
+	doc, err := s.opts.Load(namespace, location)
+	if err != nil {
+		return err
+	}
+	if includer == nil {
+		if target := doc.Attr("", "targetNamespace"); target != namespace {
+			return fmt.Errorf("xsd: schema %q has target namespace %q, not %q", location, target, namespace)
+		}
+	}
+	return s.add(doc, includer)
+}
+
+// compile compiles every top-level definition not yet compiled, so that
+// errors in a schema are reported by Compile and Add.
+func (s *Schema) compile() error {
+	for _, d := range s.types {
+		if _, err := s.typeDef(d); err != nil {
+			return err
+		}
+	}
+	for _, d := range s.attributes {
+		if _, err := s.attribute(d.el, d.doc, true); err != nil {
+			return err
+		}
+	}
+	for _, d := range s.groups {
+		if _, err := s.group(d); err != nil {
+			return err
+		}
+	}
+	for _, d := range s.attrGroups {
+		if _, err := s.attributeGroup(d); err != nil {
+			return err
+		}
+	}
+	for _, d := range s.elements {
+		if _, err := s.element(d.el, d.doc, true); err != nil {
+			return err
+		}
+	}
+	// Record the members of substitution groups.
+	for _, d := range s.elements {
+		head := d.el.Attr("", "substitutionGroup")
+		if head == "" {
+			continue
+		}
+		member := s.elementDecls[d.el]
+		if member.head != nil {
+			continue
+		}
+		name, err := resolve(d.el, head)
+		if err != nil {
+			return err
+		}
+		h, ok := s.elements[name]
+		if !ok {
+			return fmt.Errorf("xsd: undefined substitution group head %s", describe(name))
+		}
+		member.head = s.elementDecls[h.el]
+		member.head.members = append(member.head.members, member)
+	}
+	return nil
+}
+
+// resolve resolves a QName in an attribute of a schema element.
+func resolve(el *xmltree.Element, qname string) (xml.Name, error) {
+	qname = strings.TrimSpace(qname)
+	name, ok := el.ResolveNS(qname)
+	if !ok && strings.Contains(qname, ":") {
+		return name, fmt.Errorf("xsd: undeclared namespace prefix in %q", qname)
+	}
+	return name, nil
+}
+
+// describe formats a name for messages.
+func describe(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return "{" + name.Space + "}" + name.Local
+}
+
+// An elementDecl is an element declaration.
+type elementDecl struct {
+	name     xml.Name
+	typ      typeDef
+	nillable bool
+	abstract bool
+	value    *string // default or fixed value
+	fixed    bool
+	head     *elementDecl   // head of its substitution group
+	members  []*elementDecl // members of its substitution group
+}
+
+// A typeDef is a *simpleType or a *complexType.
+type typeDef interface {
+	typeName() xml.Name
+}
+
+// A complexType is a complex type definition.
+type complexType struct {
+	name     xml.Name
+	base     typeDef
+	abstract bool
+	mixed    bool
+	simple   *simpleType // for simple content
+	content  *particle   // nil for empty content
+	attrs    []*attrUse
+	anyAttr  *wildcard
+}
+
+func (t *complexType) typeName() xml.Name { return t.name }
+
+// An attrUse is an attribute declaration, as used in a complex type.
+type attrUse struct {
+	name       xml.Name
+	typ        *simpleType
+	required   bool
+	prohibited bool
+	value      *string // default or fixed value
+	fixed      bool
+}
+
+// An attrGroup is the content of an attribute group definition.
+type attrGroup struct {
+	attrs   []*attrUse
+	anyAttr *wildcard
+}
+
+// The kinds of particle.
+const (
+	elementParticle = iota
+	sequenceParticle
+	choiceParticle
+	allParticle
+	anyParticle
+)
+
+// A particle is a term in a content model with its occurrence
+// constraints.
+type particle struct {
+	kind     int
+	min, max int // max is -1 if unbounded
+	elem     *elementDecl
+	any      *wildcard
+	items    []*particle
+}
+
+// A wildcard is an xs:any or xs:anyAttribute.
+type wildcard struct {
+	other   string   // for ##other, the namespace excluded
+	isOther bool     // ##other
+	list    []string // allowed namespaces, if not ##any or ##other
+	isAny   bool     // ##any
+	process string   // strict, lax or skip
+}
+
+// allows reports whether the wildcard allows a namespace.
+func (w *wildcard) allows(ns string) bool {
+	switch {
+	case w.isAny:
+		return true
+	case w.isOther:
+		return ns != w.other && ns != ""
+	}
+	for _, s := range w.list {
+		if s == ns {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	anyType = &complexType{
+		name:    xml.Name{Space: xsdNS, Local: "anyType"},
+		mixed:   true,
+		anyAttr: &wildcard{isAny: true, process: "lax"},
+	}
+)
+
+func init() {
+	anyType.base = anyType
+	anyType.content = &particle{
+		kind: anyParticle, min: 0, max: -1,
+		any: &wildcard{isAny: true, process: "lax"},
+	}
+}
+
+// lookupType returns the type with the given name.
+func (s *Schema) lookupType(name xml.Name) (typeDef, error) {
+	if name.Space == xsdNS {
+		if name.Local == "anyType" {
+			return anyType, nil
+		}
+		if t, ok := builtins[name.Local]; ok {
+			return t, nil
+		}
+	}
+	d, ok := s.types[name]
+	if !ok {
+		return nil, fmt.Errorf("xsd: undefined type %s", describe(name))
+	}
+	return s.typeDef(d)
+}
+
+// typeDef compiles a top-level type definition.
+func (s *Schema) typeDef(d def) (typeDef, error) {
+	name := xml.Name{Space: d.doc.target, Local: d.el.Attr("", "name")}
+	if d.el.Name.Local == "simpleType" {
+		return s.simpleType(d.el, d.doc, name)
+	}
+	return s.complexType(d.el, d.doc, name)
+}
+
+// typeAttr returns the type named by an attribute such as type or base,
+// or the type defined by an xs:simpleType or xs:complexType child, or
+// nil if there is neither.
+func (s *Schema) typeAttr(el *xmltree.Element, attr string, doc *schemaDoc) (typeDef, error) {
+	if qname := el.Attr("", attr); qname != "" {
+		name, err := resolve(el, qname)
+		if err != nil {
+			return nil, err
+		}
+		return s.lookupType(name)
+	}
+	for _, c := range children(el) {
+		switch c.Name.Local {
+		case "simpleType":
+			return s.simpleType(c, doc, xml.Name{})
+		case "complexType":
+			return s.complexType(c, doc, xml.Name{})
+		}
+	}
+	return nil, nil
+}
+
+// simpleTypeAttr is like typeAttr, but the type must be simple.
+func (s *Schema) simpleTypeAttr(el *xmltree.Element, attr string, doc *schemaDoc) (*simpleType, error) {
+	t, err := s.typeAttr(el, attr, doc)
+	if err != nil || t == nil {
+		return nil, err
+	}
+	st, ok := t.(*simpleType)
+	if !ok {
+		return nil, fmt.Errorf("xsd: %s is not a simple type", describe(t.typeName()))
+	}
+	return st, nil
+}
+
+// children returns the child elements of a schema element in the XML
+// Schema namespace, other than annotations.
+func children(el *xmltree.Element) []*xmltree.Element {
+	var list []*xmltree.Element
+	for i := range el.Children {
+		c := &el.Children[i]
+		if c.Type == xmltree.XML_Tag && c.Name.Space == xsdNS && c.Name.Local != "annotation" {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// enter marks a definition as being compiled, failing if it already is,
+// which means that it is defined in terms of itself.
+func (s *Schema) enter(el *xmltree.Element, what string) error {
+	if s.building[el] {
+		return fmt.Errorf("xsd: circular definition of %s %q", what, el.Attr("", "name"))
+	}
+	s.building[el] = true
+	return nil
+}
+
+// element compiles an element declaration, or a reference to one.
+func (s *Schema) element(el *xmltree.Element, doc *schemaDoc, global bool) (*elementDecl, error) {
+	if ref := el.Attr("", "ref"); ref != "" && !global {
+		name, err := resolve(el, ref)
+		if err != nil {
+			return nil, err
+		}
+		d, ok := s.elements[name]
+		if !ok {
+			return nil, fmt.Errorf("xsd: undefined element %s", describe(name))
+		}
+		return s.element(d.el, d.doc, true)
+	}
+	if e, ok := s.elementDecls[el]; ok {
+		return e, nil
+	}
+	e := &elementDecl{name: xml.Name{Local: el.Attr("", "name")}}
+	if e.name.Local == "" {
+		return nil, errors.New("xsd: element declaration has no name")
+	}
+	// Stored before the type is compiled, as the type may contain the
+	// element itself.
+	s.elementDecls[el] = e
+	form := el.Attr("", "form")
+	if global || form == "qualified" || form == "" && doc.qualifiedElem {
+		e.name.Space = doc.target
+	}
+	e.nillable = isTrue(el.Attr("", "nillable"))
+	e.abstract = isTrue(el.Attr("", "abstract"))
+	if v, ok := validation.AttrValue(el, "fixed"); ok {
+		e.value, e.fixed = &v, true
+	} else if v, ok := validation.AttrValue(el, "default"); ok {
+		e.value = &v
+	}
+	t, err := s.typeAttr(el, "type", doc)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		if head := el.Attr("", "substitutionGroup"); head != "" {
+			name, err := resolve(el, head)
+			if err != nil {
+				return nil, err
+			}
+			d, ok := s.elements[name]
+			if !ok {
+				return nil, fmt.Errorf("xsd: undefined substitution group head %s", describe(name))
+			}
+			if err := s.enter(d.el, "element"); err != nil {
+				return nil, err
+			}
+			h, err := s.element(d.el, d.doc, true)
+			delete(s.building, d.el)
+			if err != nil {
+				return nil, err
+			}
+			t = h.typ
+		}
+	}
+	if t == nil {
+		t = anyType
+	}
+	e.typ = t
+	return e, nil
+}
+
+func isTrue(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "true" || s == "1"
+}
+
+// attribute compiles an attribute declaration, or a reference to one.
+func (s *Schema) attribute(el *xmltree.Element, doc *schemaDoc, global bool) (*attrUse, error) {
+	var a attrUse
+	if ref := el.Attr("", "ref"); ref != "" && !global {
+		name, err := resolve(el, ref)
+		if err != nil {
+			return nil, err
+		}
+		if name.Space == xmlNS {
+			// The attributes of the xml namespace, declared by
+			// the schema at http://www.w3.org/2001/xml.xsd.
+			a = attrUse{name: name, typ: builtins["string"]}
+		} else {
+			d, ok := s.attributes[name]
+			if !ok {
+				return nil, fmt.Errorf("xsd: undefined attribute %s", describe(name))
+			}
+			g, err := s.attribute(d.el, d.doc, true)
+			if err != nil {
+				return nil, err
+			}
+			a = *g
+		}
+	} else if g, ok := s.attrDecls[el]; ok && global {
+		return g, nil
+	} else {
+		a.name = xml.Name{Local: el.Attr("", "name")}
+		if a.name.Local == "" {
+			return nil, errors.New("xsd: attribute declaration has no name")
+		}
+		form := el.Attr("", "form")
+		if global || form == "qualified" || form == "" && doc.qualifiedAttr {
+			a.name.Space = doc.target
+		}
+		t, err := s.simpleTypeAttr(el, "type", doc)
+		if err != nil {
+			return nil, err
+		}
+		if t == nil {
+			t = anySimpleType
+		}
+		a.typ = t
+	}
+	if v, ok := validation.AttrValue(el, "fixed"); ok {
+		a.value, a.fixed = &v, true
+	} else if v, ok := validation.AttrValue(el, "default"); ok {
+		a.value, a.fixed = &v, false
+	}
+	switch el.Attr("", "use") {
+	case "required":
+		a.required = true
+	case "prohibited":
+		a.prohibited = true
+	}
+	if global {
+		s.attrDecls[el] = &a
+	}
+	return &a, nil
+}
+
+const xmlNS = "http://www.w3.org/XML/1998/namespace"
+
+// attrUses compiles the attribute uses and wildcard among the
+// children of a complex type or attribute group.
+func (s *Schema) attrUses(el *xmltree.Element, doc *schemaDoc) ([]*attrUse, *wildcard, error) {
+	var attrs []*attrUse
+	var any *wildcard
+	for _, c := range children(el) {
+		switch c.Name.Local {
+		case "attribute":
+			a, err := s.attribute(c, doc, false)
+			if err != nil {
+				return nil, nil, err
+			}
+			attrs = append(attrs, a)
+		case "attributeGroup":
+			name, err := resolve(c, c.Attr("", "ref"))
+			if err != nil {
+				return nil, nil, err
+			}
+			d, ok := s.attrGroups[name]
+			if !ok {
+				return nil, nil, fmt.Errorf("xsd: undefined attribute group %s", describe(name))
+			}
+			g, err := s.attributeGroup(d)
+			if err != nil {
+				return nil, nil, err
+			}
+			attrs = append(attrs, g.attrs...)
+			if g.anyAttr != nil && any == nil {
+				any = g.anyAttr
+			}
+		case "anyAttribute":
+			any = newWildcard(c, doc)
+		}
+	}
+	return attrs, any, nil
+}
+
+// attributeGroup compiles an attribute group definition.
+func (s *Schema) attributeGroup(d def) (*attrGroup, error) {
+	if g, ok := s.attrGroupDefs[d.el]; ok {
+		return g, nil
+	}
+	if err := s.enter(d.el, "attribute group"); err != nil {
+		return nil, err
+	}
+	defer delete(s.building, d.el)
+	attrs, any, err := s.attrUses(d.el, d.doc)
+	if err != nil {
+		return nil, err
+	}
+	g := &attrGroup{attrs: attrs, anyAttr: any}
+	s.attrGroupDefs[d.el] = g
+	return g, nil
+}
+
+// newWildcard compiles an xs:any or xs:anyAttribute element.
+func newWildcard(el *xmltree.Element, doc *schemaDoc) *wildcard {
+	w := &wildcard{process: el.Attr("", "processContents")}
+	if w.process == "" {
+		w.process = "strict"
+	}
+	ns := strings.TrimSpace(el.Attr("", "namespace"))
+	switch ns {
+	case "", "##any":
+		w.isAny = true
+	case "##other":
+		w.isOther, w.other = true, doc.target
+	default:
+		for _, f := range strings.Fields(ns) {
+			switch f {
+			case "##targetNamespace":
+				f = doc.target
+			case "##local":
+				f = ""
+			}
+			w.list = append(w.list, f)
+		}
+	}
+	return w
+}
+
+// occurs returns the minOccurs and maxOccurs of a particle.
+func occurs(el *xmltree.Element) (int, int, error) {
+	min, max := 1, 1
+	if v, ok := validation.AttrValue(el, "minOccurs"); ok {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("xsd: bad minOccurs %q", v)
+		}
+		min = n
+	}
+	if v, ok := validation.AttrValue(el, "maxOccurs"); ok {
+		if v = strings.TrimSpace(v); v == "unbounded" {
+			max = -1
+		} else {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return 0, 0, fmt.Errorf("xsd: bad maxOccurs %q", v)
+			}
+			max = n
+		}
+	}
+	if max >= 0 && min > max {
+		return 0, 0, fmt.Errorf("xsd: minOccurs %d is greater than maxOccurs %d", min, max)
+	}
+	return min, max, nil
+}
+
+// particle compiles an element, model group, group reference or
+// wildcard within a content model.
+func (s *Schema) particle(el *xmltree.Element, doc *schemaDoc) (*particle, error) {
+	min, max, err := occurs(el)
+	if err != nil {
+		return nil, err
+	}
+	p := &particle{min: min, max: max}
+	switch el.Name.Local {
+	case "element":
+		p.kind = elementParticle
+		if p.elem, err = s.element(el, doc, false); err != nil {
+			return nil, err
+		}
+	case "any":
+		p.kind = anyParticle
+		p.any = newWildcard(el, doc)
+	case "group":
+		name, err := resolve(el, el.Attr("", "ref"))
+		if err != nil {
+			return nil, err
+		}
+		d, ok := s.groups[name]
+		if !ok {
+			return nil, fmt.Errorf("xsd: undefined group %s", describe(name))
+		}
+		g, err := s.group(d)
+		if err != nil {
+			return nil, err
+		}
+		p.kind, p.items = g.kind, g.items
+	case "sequence", "choice", "all":
+		p.kind = map[string]int{"sequence": sequenceParticle, "choice": choiceParticle, "all": allParticle}[el.Name.Local]
+		for _, c := range children(el) {
+			item, err := s.particle(c, doc)
+			if err != nil {
+				return nil, err
+			}
+			if p.kind == allParticle && (item.kind != elementParticle || item.max > 1 || item.max < 0) {
+				return nil, errors.New("xsd: xs:all may only contain elements occurring at most once")
+			}
+			p.items = append(p.items, item)
+		}
+	default:
+		return nil, fmt.Errorf("xsd: unexpected xs:%s in content model", el.Name.Local)
+	}
+	return p, nil
+}
+
+// group compiles the model group of a named group definition. Its
+// occurrence constraints are those of the referring particle.
+func (s *Schema) group(d def) (*particle, error) {
+	if g, ok := s.groupDefs[d.el]; ok {
+		return g, nil
+	}
+	if err := s.enter(d.el, "group"); err != nil {
+		return nil, err
+	}
+	defer delete(s.building, d.el)
+	for _, c := range children(d.el) {
+		switch c.Name.Local {
+		case "sequence", "choice", "all":
+			g, err := s.particle(c, d.doc)
+			if err != nil {
+				return nil, err
+			}
+			s.groupDefs[d.el] = g
+			return g, nil
+		}
+	}
+	return nil, fmt.Errorf("xsd: group %q has no model group", d.el.Attr("", "name"))
+}
+
+// complexType compiles a complex type definition.
+func (s *Schema) complexType(el *xmltree.Element, doc *schemaDoc, name xml.Name) (*complexType, error) {
+	if t, ok := s.complexTypes[el]; ok {
+		return t, nil
+	}
+	t := &complexType{name: name, base: anyType}
+	// Stored before the content is compiled, as the content may
+	// contain elements of the type.
+	s.complexTypes[el] = t
+	t.abstract = isTrue(el.Attr("", "abstract"))
+	t.mixed = isTrue(el.Attr("", "mixed"))
+
+	body := el
+	derivation := ""
+	var base *complexType
+	for _, c := range children(el) {
+		if c.Name.Local != "simpleContent" && c.Name.Local != "complexContent" {
+			continue
+		}
+		if v, ok := validation.AttrValue(c, "mixed"); ok {
+			t.mixed = isTrue(v)
+		}
+		for _, d := range children(c) {
+			if d.Name.Local == "extension" || d.Name.Local == "restriction" {
+				body, derivation = d, d.Name.Local
+			}
+		}
+		if derivation == "" {
+			return nil, fmt.Errorf("xsd: xs:%s without extension or restriction", c.Name.Local)
+		}
+		b, err := s.typeAttr(body, "base", doc)
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			return nil, fmt.Errorf("xsd: xs:%s has no base type", derivation)
+		}
+		t.base = b
+		for d := b; d != anyType; {
+			if d == typeDef(t) {
+				return nil, fmt.Errorf("xsd: circular derivation of type %q", el.Attr("", "name"))
+			}
+			if ct, ok := d.(*complexType); ok {
+				d = ct.base
+			} else {
+				break
+			}
+		}
+		if c.Name.Local == "simpleContent" {
+			if err := s.simpleContent(t, body, doc, derivation); err != nil {
+				return nil, err
+			}
+		} else {
+			ct, ok := b.(*complexType)
+			if !ok {
+				return nil, fmt.Errorf("xsd: complex content derived from simple type %s", describe(b.typeName()))
+			}
+			base = ct
+		}
+	}
+
+	attrs, any, err := s.attrUses(body, doc)
+	if err != nil {
+		return nil, err
+	}
+	if bt, ok := t.base.(*complexType); ok && bt != anyType {
+		t.attrs = mergeAttrs(bt.attrs, attrs)
+		t.anyAttr = any
+		if t.anyAttr == nil && derivation == "extension" {
+			t.anyAttr = bt.anyAttr
+		}
+	} else {
+		t.attrs, t.anyAttr = attrs, any
+	}
+
+	if t.simple != nil {
+		return t, nil
+	}
+	for _, c := range children(body) {
+		switch c.Name.Local {
+		case "sequence", "choice", "all", "group":
+			if t.content, err = s.particle(c, doc); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if base != nil && derivation == "extension" && base.content != nil {
+		if base.simple != nil {
+			return nil, errors.New("xsd: complex content extending a type with simple content")
+		}
+		if t.content == nil {
+			t.content = base.content
+		} else {
+			t.content = &particle{kind: sequenceParticle, min: 1, max: 1, items: []*particle{base.content, t.content}}
+		}
+		t.mixed = t.mixed || base.mixed
+	}
+	return t, nil
+}
+
+// simpleContent compiles the simple content of a complex type derived
+// from base by extension or restriction.
+func (s *Schema) simpleContent(t *complexType, body *xmltree.Element, doc *schemaDoc, derivation string) error {
+	var content *simpleType
+	switch b := t.base.(type) {
+	case *simpleType:
+		if derivation == "restriction" {
+			return fmt.Errorf("xsd: simple content restricting simple type %s", describe(b.name))
+		}
+		content = b
+	case *complexType:
+		if b.simple == nil {
+			if !b.mixed || derivation != "restriction" {
+				return fmt.Errorf("xsd: simple content derived from %s, which does not have simple content", describe(b.name))
+			}
+			content = builtins["string"]
+		} else {
+			content = b.simple
+		}
+	}
+	if derivation == "restriction" {
+		r, err := s.restrict(body, doc, content, xml.Name{})
+		if err != nil {
+			return err
+		}
+		content = r
+	}
+	t.simple = content
+	return nil
+}
+
+// mergeAttrs returns the attribute uses of a type derived from one with
+// the attribute uses base. Uses in derived replace those of the same
+// name in base.
+func mergeAttrs(base, derived []*attrUse) []*attrUse {
+	list := append([]*attrUse(nil), derived...)
+outer:
+	for _, b := range base {
+		for _, d := range derived {
+			if d.name == b.name {
+				continue outer
+			}
+		}
+		list = append(list, b)
+	}
+	return list
+}
+
+// simpleType compiles a simple type definition.
+func (s *Schema) simpleType(el *xmltree.Element, doc *schemaDoc, name xml.Name) (*simpleType, error) {
+	if t, ok := s.simpleTypes[el]; ok {
+		return t, nil
+	}
+	if err := s.enter(el, "type"); err != nil {
+		return nil, err
+	}
+	defer delete(s.building, el)
+	for _, c := range children(el) {
+		var t *simpleType
+		switch c.Name.Local {
+		case "restriction":
+			base, err := s.simpleTypeAttr(c, "base", doc)
+			if err != nil {
+				return nil, err
+			}
+			if base == nil {
+				// Given by an xs:simpleType child.
+				base = anySimpleType
+			}
+			if t, err = s.restrict(c, doc, base, name); err != nil {
+				return nil, err
+			}
+		case "list":
+			item, err := s.simpleTypeAttr(c, "itemType", doc)
+			if err != nil {
+				return nil, err
+			}
+			if item == nil {
+				return nil, errors.New("xsd: xs:list has no item type")
+			}
+			t = &simpleType{name: name, base: anySimpleType, variety: list, item: item, facets: noFacets(wsCollapse)}
+		case "union":
+			t = &simpleType{name: name, base: anySimpleType, variety: union, facets: noFacets(wsCollapse)}
+			for _, qname := range strings.Fields(c.Attr("", "memberTypes")) {
+				n, err := resolve(c, qname)
+				if err != nil {
+					return nil, err
+				}
+				m, err := s.lookupType(n)
+				if err != nil {
+					return nil, err
+				}
+				st, ok := m.(*simpleType)
+				if !ok {
+					return nil, fmt.Errorf("xsd: union member %s is not a simple type", describe(n))
+				}
+				t.members = append(t.members, st)
+			}
+			for _, m := range children(c) {
+				if m.Name.Local == "simpleType" {
+					st, err := s.simpleType(m, doc, xml.Name{})
+					if err != nil {
+						return nil, err
+					}
+					t.members = append(t.members, st)
+				}
+			}
+			if len(t.members) == 0 {
+				return nil, errors.New("xsd: xs:union has no member types")
+			}
+		default:
+			continue
+		}
+		s.simpleTypes[el] = t
+		return t, nil
+	}
+	return nil, fmt.Errorf("xsd: simple type %q has no restriction, list or union", el.Attr("", "name"))
+}
+
+// restrict derives a simple type from base by restriction, with the
+// facets among the children of el.
+func (s *Schema) restrict(el *xmltree.Element, doc *schemaDoc, base *simpleType, name xml.Name) (*simpleType, error) {
+	for _, c := range children(el) {
+		if c.Name.Local == "simpleType" {
+			// An anonymous base type, within simple content.
+			b, err := s.simpleType(c, doc, xml.Name{})
+			if err != nil {
+				return nil, err
+			}
+			base = b
+		}
+	}
+	t := &simpleType{
+		name:    name,
+		base:    base,
+		variety: base.variety,
+		prim:    base.prim,
+		item:    base.item,
+		members: base.members,
+		facets:  base.facets,
+	}
+	f := &t.facets
+	f.patterns = f.patterns[:len(f.patterns):len(f.patterns)]
+	var enum, patterns []string
+	for _, c := range children(el) {
+		value, ok := validation.AttrValue(c, "value")
+		if !ok {
+			continue
+		}
+		v := value
+		var err error
+		switch c.Name.Local {
+		case "enumeration":
+			enum = append(enum, t.normalize(value))
+		case "pattern":
+			patterns = append(patterns, value)
+		case "whiteSpace":
+			switch value {
+			case "preserve":
+				f.whiteSpace = wsPreserve
+			case "replace":
+				f.whiteSpace = wsReplace
+			case "collapse":
+				f.whiteSpace = wsCollapse
+			default:
+				return nil, fmt.Errorf("xsd: bad whiteSpace %q", value)
+			}
+		case "length":
+			f.length, err = facetInt(value)
+		case "minLength":
+			f.minLength, err = facetInt(value)
+		case "maxLength":
+			f.maxLength, err = facetInt(value)
+		case "totalDigits":
+			f.totalDigits, err = facetInt(value)
+		case "fractionDigits":
+			f.fractionDigits, err = facetInt(value)
+		case "minInclusive":
+			f.minInclusive, err = s.bound(t, &v)
+		case "maxInclusive":
+			f.maxInclusive, err = s.bound(t, &v)
+		case "minExclusive":
+			f.minExclusive, err = s.bound(t, &v)
+		case "maxExclusive":
+			f.maxExclusive, err = s.bound(t, &v)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("xsd: bad %s facet %q: %v", c.Name.Local, value, err)
+		}
+	}
+	if enum != nil {
+		f.enum = enum
+	}
+	if patterns != nil {
+		// Patterns given in the same step are alternatives.
+		re, err := compilePattern(strings.Join(patterns, "|"))
+		if err != nil {
+			return nil, err
+		}
+		f.patterns = append(f.patterns, re)
+	}
+	return t, nil
+}
+
+func facetInt(s string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err == nil && n < 0 {
+		err = errors.New("negative value")
+	}
+	return n, err
+}
+
+// bound checks the value of a range facet.
+func (s *Schema) bound(t *simpleType, v *string) (*string, error) {
+	if t.prim == nil || t.prim.compare == nil {
+		return nil, errors.New("type is not ordered")
+	}
+	*v = strings.TrimSpace(*v)
+	if _, err := t.prim.parse(*v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,571 @@
+package xsd
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pschou/go-xmltree"
+)
+
+// The varieties of simple type.
+const (
+	atomic = iota
+	list
+	union
+)
+
+// White space handling, as set by the whiteSpace facet.
+const (
+	wsPreserve = iota
+	wsReplace
+	wsCollapse
+)
+
+// A simpleType is a simple type definition, with the facets in effect
+// after its derivation.
+type simpleType struct {
+	name    xml.Name
+	base    *simpleType
+	variety int
+	prim    *primitive    // for atomic types, nil for anySimpleType
+	item    *simpleType   // for list types
+	members []*simpleType // for union types
+	facets  facets
+}
+
+// facets holds the constraining facets of a simple type. Bounds are kept
+// in their lexical form, and compared as values of the primitive type.
+type facets struct {
+	whiteSpace     int
+	enum           []string
+	patterns       []*regexp.Regexp // all must match
+	length         int              // -1 if not set, as are the others
+	minLength      int
+	maxLength      int
+	totalDigits    int
+	fractionDigits int
+	minInclusive   *string
+	maxInclusive   *string
+	minExclusive   *string
+	maxExclusive   *string
+}
+
+func noFacets(ws int) facets {
+	return facets{whiteSpace: ws, length: -1, minLength: -1, maxLength: -1, totalDigits: -1, fractionDigits: -1}
+}
+
+// A primitive is one of the primitive types of XML Schema.
+type primitive struct {
+	name string
+	// parse checks the lexical form of a value, and returns the value
+	// in a form which can be compared by compare, if the type is
+	// ordered.
+	parse   func(s string) (interface{}, error)
+	compare func(a, b interface{}) (int, bool)
+	// octets returns the length of a value in octets, for binary types.
+	octets func(s string) int
+}
+
+func (t *simpleType) typeName() xml.Name { return t.name }
+
+// is reports whether t is, or is derived by restriction from, the
+// built-in type with the given local name.
+func (t *simpleType) is(local string) bool {
+	for ; t != nil; t = t.base {
+		if t.name.Space == xsdNS && t.name.Local == local {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize applies the white space handling of t to a value.
+func (t *simpleType) normalize(s string) string {
+	switch t.facets.whiteSpace {
+	case wsReplace:
+		return strings.Map(func(r rune) rune {
+			if r == '\t' || r == '\n' || r == '\r' {
+				return ' '
+			}
+			return r
+		}, s)
+	case wsCollapse:
+		return strings.Join(strings.Fields(s), " ")
+	}
+	return s
+}
+
+// validate checks a value against t. QName values are resolved using
+// scope. It returns the value after white space normalization.
+func (t *simpleType) validate(s string, scope *xmltree.Scope) (string, error) {
+	s = t.normalize(s)
+	switch t.variety {
+	case list:
+		items := strings.Fields(s)
+		for _, item := range items {
+			if _, err := t.item.validate(item, scope); err != nil {
+				return s, err
+			}
+		}
+		if err := t.facets.checkLength(len(items), "items"); err != nil {
+			return s, err
+		}
+	case union:
+		valid := false
+		for _, m := range t.members {
+			if _, err := m.validate(s, scope); err == nil {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return s, fmt.Errorf("%q is not valid for any member of the union %s", s, t.describe())
+		}
+	default:
+		if err := t.validateAtomic(s, scope); err != nil {
+			return s, err
+		}
+	}
+	for _, re := range t.facets.patterns {
+		if !re.MatchString(s) {
+			return s, fmt.Errorf("%q does not match the pattern of %s", s, t.describe())
+		}
+	}
+	if t.facets.enum != nil && !t.inEnum(s) {
+		return s, fmt.Errorf("%q is not one of the values allowed by %s", s, t.describe())
+	}
+	return s, nil
+}
+
+func (t *simpleType) validateAtomic(s string, scope *xmltree.Scope) error {
+	if t.prim == nil {
+		return nil
+	}
+	v, err := t.prim.parse(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a valid %s", s, t.describe())
+	}
+	switch t.prim.name {
+	case "QName", "NOTATION":
+		if _, ok := scope.ResolveNS(s); !ok && strings.Contains(s, ":") {
+			return fmt.Errorf("undeclared namespace prefix in %q", s)
+		}
+	case "string", "anyURI":
+		if err := t.facets.checkLength(utf8.RuneCountInString(s), "characters"); err != nil {
+			return err
+		}
+	}
+	if t.prim.octets != nil {
+		if err := t.facets.checkLength(t.prim.octets(s), "octets"); err != nil {
+			return err
+		}
+	}
+	if t.prim.compare != nil {
+		if err := t.checkBounds(v); err != nil {
+			return fmt.Errorf("%q %v", s, err)
+		}
+	}
+	if t.prim.name == "decimal" {
+		total, frac := digits(s)
+		if t.facets.totalDigits >= 0 && total > t.facets.totalDigits {
+			return fmt.Errorf("%q has more than %d digits", s, t.facets.totalDigits)
+		}
+		if t.facets.fractionDigits >= 0 && frac > t.facets.fractionDigits {
+			return fmt.Errorf("%q has more than %d fraction digits", s, t.facets.fractionDigits)
+		}
+	}
+	return nil
+}
+
+// checkBounds checks a parsed value against the range facets.
+func (t *simpleType) checkBounds(v interface{}) error {
+	bounds := []struct {
+		bound *string
+		ok    func(c int) bool
+		desc  string
+	}{
+		{t.facets.minInclusive, func(c int) bool { return c >= 0 }, "is less than"},
+		{t.facets.maxInclusive, func(c int) bool { return c <= 0 }, "is greater than"},
+		{t.facets.minExclusive, func(c int) bool { return c > 0 }, "is not greater than"},
+		{t.facets.maxExclusive, func(c int) bool { return c < 0 }, "is not less than"},
+	}
+	for _, b := range bounds {
+		if b.bound == nil {
+			continue
+		}
+		bv, err := t.prim.parse(*b.bound)
+		if err != nil {
+			continue
+		}
+		if c, ok := t.prim.compare(v, bv); !ok || !b.ok(c) {
+			return fmt.Errorf("%s %s", b.desc, *b.bound)
+		}
+	}
+	return nil
+}
+
+// inEnum reports whether a normalized value is one of the enumerated
+// values of t, compared as values of its primitive type.
+func (t *simpleType) inEnum(s string) bool {
+	for _, e := range t.facets.enum {
+		if e == s {
+			return true
+		}
+		if t.variety != atomic || t.prim == nil || t.prim.compare == nil && t.prim.name != "boolean" {
+			continue
+		}
+		a, err1 := t.prim.parse(s)
+		b, err2 := t.prim.parse(e)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if t.prim.compare == nil {
+			if a == b {
+				return true
+			}
+		} else if c, ok := t.prim.compare(a, b); ok && c == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *facets) checkLength(n int, unit string) error {
+	switch {
+	case f.length >= 0 && n != f.length:
+		return fmt.Errorf("length is %d %s, not %d", n, unit, f.length)
+	case f.minLength >= 0 && n < f.minLength:
+		return fmt.Errorf("length is %d %s, less than %d", n, unit, f.minLength)
+	case f.maxLength >= 0 && n > f.maxLength:
+		return fmt.Errorf("length is %d %s, more than %d", n, unit, f.maxLength)
+	}
+	return nil
+}
+
+// describe names t for error messages.
+func (t *simpleType) describe() string {
+	for ; t != nil; t = t.base {
+		if t.name.Local != "" {
+			return t.name.Local
+		}
+	}
+	return "anonymous type"
+}
+
+// digits returns the number of significant digits in a decimal, and the
+// number of them after the decimal point.
+func digits(s string) (total, frac int) {
+	s = strings.TrimLeft(s, "+-")
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+	intPart = strings.TrimLeft(intPart, "0")
+	fracPart = strings.TrimRight(fracPart, "0")
+	return len(intPart) + len(fracPart), len(fracPart)
+}
+
+var errLexical = errors.New("invalid lexical form")
+
+// matcher returns a parse function accepting strings matching re.
+func matcher(re string) func(string) (interface{}, error) {
+	r := regexp.MustCompile(`^(?:` + re + `)$`)
+	return func(s string) (interface{}, error) {
+		if !r.MatchString(s) {
+			return nil, errLexical
+		}
+		return s, nil
+	}
+}
+
+func parseDecimal(s string) (interface{}, error) {
+	if !decimalRE.MatchString(s) {
+		return nil, errLexical
+	}
+	r, ok := new(big.Rat).SetString(strings.TrimPrefix(s, "+"))
+	if !ok {
+		return nil, errLexical
+	}
+	return r, nil
+}
+
+var decimalRE = regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)$`)
+
+func compareDecimal(a, b interface{}) (int, bool) {
+	return a.(*big.Rat).Cmp(b.(*big.Rat)), true
+}
+
+var floatRE = regexp.MustCompile(`^([+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][+-]?[0-9]+)?|-?INF|NaN)$`)
+
+func parseFloat(bits int) func(string) (interface{}, error) {
+	return func(s string) (interface{}, error) {
+		if !floatRE.MatchString(s) {
+			return nil, errLexical
+		}
+		switch s {
+		case "INF":
+			return math.Inf(1), nil
+		case "-INF":
+			return math.Inf(-1), nil
+		case "NaN":
+			return math.NaN(), nil
+		}
+		f, err := strconv.ParseFloat(s, bits)
+		if err != nil && !errors.Is(err, strconv.ErrRange) {
+			return nil, errLexical
+		}
+		return f, nil
+	}
+}
+
+func compareFloat(a, b interface{}) (int, bool) {
+	x, y := a.(float64), b.(float64)
+	switch {
+	case math.IsNaN(x) || math.IsNaN(y):
+		return 0, false
+	case x < y:
+		return -1, true
+	case x > y:
+		return 1, true
+	}
+	return 0, true
+}
+
+func parseBoolean(s string) (interface{}, error) {
+	switch s {
+	case "true", "1":
+		return true, nil
+	case "false", "0":
+		return false, nil
+	}
+	return nil, errLexical
+}
+
+// A dateValue is a date or time, along with whether it has a time zone.
+type dateValue struct {
+	t  time.Time
+	tz bool
+}
+
+// parseDate returns a parse function for a date or time type, whose
+// lexical form, without the time zone, is described by re. layout
+// parses the matched value, after any "24:00:00" is replaced.
+func parseDate(re, layout string) func(string) (interface{}, error) {
+	r := regexp.MustCompile(`^(` + re + `)(Z|[+-](?:(?:0[0-9]|1[0-3]):[0-5][0-9]|14:00))?$`)
+	return func(s string) (interface{}, error) {
+		m := r.FindStringSubmatch(s)
+		if m == nil {
+			return nil, errLexical
+		}
+		value, zone := m[1], m[2]
+		midnight := strings.Contains(value, "24:00:00")
+		if midnight {
+			if strings.Trim(value[strings.Index(value, "24:00:00")+8:], ".0") != "" {
+				return nil, errLexical
+			}
+			value = strings.Replace(value, "24:00:00", "00:00:00", 1)
+		}
+		loc := time.UTC
+		if zone != "" && zone != "Z" {
+			h, _ := strconv.Atoi(zone[1:3])
+			m, _ := strconv.Atoi(zone[4:6])
+			offset := h*3600 + m*60
+			if zone[0] == '-' {
+				offset = -offset
+			}
+			loc = time.FixedZone(zone, offset)
+		}
+		bce := strings.HasPrefix(layout, "2006") && strings.HasPrefix(value, "-")
+		if bce {
+			value = value[1:]
+		}
+		t, err := time.ParseInLocation(layout, value, loc)
+		if err != nil {
+			return nil, errLexical
+		}
+		if bce {
+			t = time.Date(-t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+		}
+		if midnight {
+			t = t.Add(24 * time.Hour)
+		}
+		return dateValue{t, zone != ""}, nil
+	}
+}
+
+// compareDate compares two dates. Values with and without a time zone
+// are only ordered if they differ by more than fourteen hours.
+func compareDate(a, b interface{}) (int, bool) {
+	x, y := a.(dateValue), b.(dateValue)
+	if x.tz != y.tz {
+		d := x.t.Sub(y.t)
+		if d > -14*time.Hour && d < 14*time.Hour {
+			return 0, false
+		}
+	}
+	switch {
+	case x.t.Before(y.t):
+		return -1, true
+	case x.t.After(y.t):
+		return 1, true
+	}
+	return 0, true
+}
+
+var durationRE = regexp.MustCompile(`^-?P([0-9]+Y)?([0-9]+M)?([0-9]+D)?(T([0-9]+H)?([0-9]+M)?([0-9]+(\.[0-9]+)?S)?)?$`)
+
+func parseDuration(s string) (interface{}, error) {
+	if !durationRE.MatchString(s) || strings.HasSuffix(s, "P") || strings.HasSuffix(s, "T") {
+		return nil, errLexical
+	}
+	return s, nil
+}
+
+func parseHex(s string) (interface{}, error) {
+	if _, err := hex.DecodeString(s); err != nil {
+		return nil, errLexical
+	}
+	return s, nil
+}
+
+func parseBase64(s string) (interface{}, error) {
+	if _, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(s, " ", "")); err != nil {
+		return nil, errLexical
+	}
+	return s, nil
+}
+
+func parseAnyURI(s string) (interface{}, error) {
+	for _, r := range s {
+		if r < ' ' {
+			return nil, errLexical
+		}
+	}
+	return s, nil
+}
+
+const (
+	nameStart = `:A-Z_a-z\x{C0}-\x{D6}\x{D8}-\x{F6}\x{F8}-\x{2FF}\x{370}-\x{37D}\x{37F}-\x{1FFF}\x{200C}-\x{200D}\x{2070}-\x{218F}\x{2C00}-\x{2FEF}\x{3001}-\x{D7FF}\x{F900}-\x{FDCF}\x{FDF0}-\x{FFFD}\x{10000}-\x{EFFFF}`
+	nameChar  = nameStart + `\-.0-9\x{B7}\x{300}-\x{36F}\x{203F}-\x{2040}`
+)
+
+// ncName matches a name without a colon.
+var ncName = `[` + nameStart[1:] + `][` + nameChar[1:] + `]*`
+
+var primitives = map[string]*primitive{
+	"string":       {name: "string", parse: func(s string) (interface{}, error) { return s, nil }},
+	"boolean":      {name: "boolean", parse: parseBoolean},
+	"decimal":      {name: "decimal", parse: parseDecimal, compare: compareDecimal},
+	"float":        {name: "float", parse: parseFloat(32), compare: compareFloat},
+	"double":       {name: "double", parse: parseFloat(64), compare: compareFloat},
+	"duration":     {name: "duration", parse: parseDuration},
+	"dateTime":     {name: "dateTime", parse: parseDate(`-?[0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}(?:\.[0-9]+)?`, "2006-01-02T15:04:05.999999999"), compare: compareDate},
+	"time":         {name: "time", parse: parseDate(`[0-9]{2}:[0-9]{2}:[0-9]{2}(?:\.[0-9]+)?`, "15:04:05.999999999"), compare: compareDate},
+	"date":         {name: "date", parse: parseDate(`-?[0-9]{4}-[0-9]{2}-[0-9]{2}`, "2006-01-02"), compare: compareDate},
+	"gYearMonth":   {name: "gYearMonth", parse: parseDate(`-?[0-9]{4}-[0-9]{2}`, "2006-01"), compare: compareDate},
+	"gYear":        {name: "gYear", parse: parseDate(`-?[0-9]{4}`, "2006"), compare: compareDate},
+	"gMonthDay":    {name: "gMonthDay", parse: parseDate(`--[0-9]{2}-[0-9]{2}`, "--01-02"), compare: compareDate},
+	"gDay":         {name: "gDay", parse: parseDate(`---[0-9]{2}`, "---02"), compare: compareDate},
+	"gMonth":       {name: "gMonth", parse: parseDate(`--[0-9]{2}`, "--01"), compare: compareDate},
+	"hexBinary":    {name: "hexBinary", parse: parseHex, octets: func(s string) int { return len(s) / 2 }},
+	"base64Binary": {name: "base64Binary", parse: parseBase64, octets: base64Octets},
+	"anyURI":       {name: "anyURI", parse: parseAnyURI},
+	"QName":        {name: "QName", parse: matcher(`(?:` + ncName + `:)?` + ncName)},
+	"NOTATION":     {name: "NOTATION", parse: matcher(`(?:` + ncName + `:)?` + ncName)},
+}
+
+func base64Octets(s string) int {
+	b, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		return 0
+	}
+	return len(b)
+}
+
+// builtins holds the built-in simple types, by local name.
+var builtins = make(map[string]*simpleType)
+
+var anySimpleType = &simpleType{name: xml.Name{Space: xsdNS, Local: "anySimpleType"}, facets: noFacets(wsPreserve)}
+
+func init() {
+	builtins["anySimpleType"] = anySimpleType
+	for name, p := range primitives {
+		ws := wsCollapse
+		if name == "string" {
+			ws = wsPreserve
+		}
+		builtins[name] = &simpleType{
+			name:   xml.Name{Space: xsdNS, Local: name},
+			base:   anySimpleType,
+			prim:   p,
+			facets: noFacets(ws),
+		}
+	}
+	derive := func(name, base string, fn func(f *facets)) {
+		b := builtins[base]
+		t := &simpleType{name: xml.Name{Space: xsdNS, Local: name}, base: b, prim: b.prim, facets: b.facets}
+		fn(&t.facets)
+		builtins[name] = t
+	}
+	pattern := func(re string) func(f *facets) {
+		return func(f *facets) {
+			f.patterns = append(f.patterns[:len(f.patterns):len(f.patterns)], regexp.MustCompile(`^(?:`+re+`)$`))
+		}
+	}
+	bounds := func(min, max string) func(f *facets) {
+		return func(f *facets) {
+			if min != "" {
+				f.minInclusive = &min
+			}
+			if max != "" {
+				f.maxInclusive = &max
+			}
+		}
+	}
+	derive("normalizedString", "string", func(f *facets) { f.whiteSpace = wsReplace })
+	derive("token", "normalizedString", func(f *facets) { f.whiteSpace = wsCollapse })
+	derive("language", "token", pattern(`[a-zA-Z]{1,8}(-[a-zA-Z0-9]{1,8})*`))
+	derive("NMTOKEN", "token", pattern(`[`+nameChar+`]+`))
+	derive("Name", "token", pattern(`[`+nameStart+`][`+nameChar+`]*`))
+	derive("NCName", "Name", pattern(ncName))
+	derive("ID", "NCName", func(f *facets) {})
+	derive("IDREF", "NCName", func(f *facets) {})
+	derive("ENTITY", "NCName", func(f *facets) {})
+	derive("integer", "decimal", func(f *facets) {
+		f.fractionDigits = 0
+		pattern(`[+-]?[0-9]+`)(f)
+	})
+	derive("nonPositiveInteger", "integer", bounds("", "0"))
+	derive("negativeInteger", "nonPositiveInteger", bounds("", "-1"))
+	derive("long", "integer", bounds("-9223372036854775808", "9223372036854775807"))
+	derive("int", "long", bounds("-2147483648", "2147483647"))
+	derive("short", "int", bounds("-32768", "32767"))
+	derive("byte", "short", bounds("-128", "127"))
+	derive("nonNegativeInteger", "integer", bounds("0", ""))
+	derive("unsignedLong", "nonNegativeInteger", bounds("", "18446744073709551615"))
+	derive("unsignedInt", "unsignedLong", bounds("", "4294967295"))
+	derive("unsignedShort", "unsignedInt", bounds("", "65535"))
+	derive("unsignedByte", "unsignedShort", bounds("", "255"))
+	derive("positiveInteger", "nonNegativeInteger", bounds("1", ""))
+	for _, name := range []string{"NMTOKEN", "IDREF", "ENTITY"} {
+		t := &simpleType{
+			name:    xml.Name{Space: xsdNS, Local: name + "S"},
+			base:    anySimpleType,
+			variety: list,
+			item:    builtins[name],
+			facets:  noFacets(wsCollapse),
+		}
+		t.facets.minLength = 1
+		builtins[name+"S"] = t
+	}
+}
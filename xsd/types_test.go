@@ -0,0 +1,163 @@
+package xsd
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestBuiltins(t *testing.T) {
+	tests := []struct {
+		typ   string
+		valid []string
+		bad   []string
+	}{
+		{"boolean", []string{"true", "0", " false "}, []string{"yes", ""}},
+		{"decimal", []string{"1", "-1.50", "+.5", "0."}, []string{"1e3", ".", "1,0"}},
+		{"integer", []string{"0", "-12", "+7"}, []string{"1.0", "x"}},
+		{"byte", []string{"-128", "127"}, []string{"128"}},
+		{"unsignedShort", []string{"65535"}, []string{"-1", "65536"}},
+		{"nonPositiveInteger", []string{"0", "-3"}, []string{"1"}},
+		{"double", []string{"1e10", "-INF", "NaN", "1.5E-3"}, []string{"inf", "1e"}},
+		{"date", []string{"2024-02-29", "2023-01-01Z", "-0044-03-15+01:00"}, []string{"2023-02-29", "2023-1-1"}},
+		{"dateTime", []string{"2024-01-01T12:00:00", "2024-01-01T24:00:00Z", "2024-01-01T00:00:00.5-05:00"}, []string{"2024-01-01", "2024-01-01T25:00:00"}},
+		{"time", []string{"23:59:59", "00:00:00.000Z"}, []string{"24:00:01"}},
+		{"gYearMonth", []string{"2024-12"}, []string{"2024-13"}},
+		{"duration", []string{"P1Y2M3DT4H5M6.7S", "-PT1M", "P0D"}, []string{"P", "PT", "1D", "P1.5Y"}},
+		{"hexBinary", []string{"", "0aFF"}, []string{"abc", "zz"}},
+		{"base64Binary", []string{"", "AQID", "AQ=="}, []string{"AQ=", "*"}},
+		{"anyURI", []string{"http://example.com/a b", "#frag"}, nil},
+		{"language", []string{"en", "en-GB"}, []string{"toolonglanguage", "e_n"}},
+		{"NCName", []string{"a", "_b.c-d"}, []string{"a:b", "1a"}},
+		{"Name", []string{"a:b"}, []string{"-a"}},
+		{"NMTOKENS", []string{"a b  1"}, []string{"", "a ,"}},
+		{"QName", []string{"x:a", "a"}, []string{"y:a", ":a"}},
+	}
+	scope := &mustParse(t, `<a xmlns:x="urn:x"/>`).Scope
+	for _, tt := range tests {
+		typ := builtins[tt.typ]
+		if typ == nil {
+			t.Errorf("no builtin %s", tt.typ)
+			continue
+		}
+		for _, s := range tt.valid {
+			if _, err := typ.validate(s, scope); err != nil {
+				t.Errorf("%s %q: %v", tt.typ, s, err)
+			}
+		}
+		for _, s := range tt.bad {
+			if _, err := typ.validate(s, scope); err == nil {
+				t.Errorf("%s %q: valid", tt.typ, s)
+			}
+		}
+	}
+}
+
+func TestFacets(t *testing.T) {
+	s := mustCompile(t, `
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+  <xs:simpleType name="price">
+    <xs:restriction base="xs:decimal">
+      <xs:totalDigits value="5"/>
+      <xs:fractionDigits value="2"/>
+      <xs:minExclusive value="0"/>
+    </xs:restriction>
+  </xs:simpleType>
+  <xs:simpleType name="code">
+    <xs:restriction base="xs:token">
+      <xs:length value="3"/>
+      <xs:pattern value="\i\c*"/>
+    </xs:restriction>
+  </xs:simpleType>
+  <xs:simpleType name="codes">
+    <xs:restriction>
+      <xs:simpleType><xs:list itemType="code"/></xs:simpleType>
+      <xs:maxLength value="2"/>
+    </xs:restriction>
+  </xs:simpleType>
+  <xs:simpleType name="size">
+    <xs:union memberTypes="xs:positiveInteger">
+      <xs:simpleType>
+        <xs:restriction base="xs:string">
+          <xs:enumeration value="small"/>
+          <xs:enumeration value="large"/>
+        </xs:restriction>
+      </xs:simpleType>
+    </xs:union>
+  </xs:simpleType>
+  <xs:simpleType name="since">
+    <xs:restriction base="xs:date">
+      <xs:minInclusive value="2000-01-01"/>
+    </xs:restriction>
+  </xs:simpleType>
+</xs:schema>`, nil)
+	tests := []struct {
+		typ   string
+		valid []string
+		bad   []string
+	}{
+		{"price", []string{"0.01", "999.99", "012.50"}, []string{"0", "123456", "1.005"}},
+		{"code", []string{"abc", " a-b "}, []string{"ab", "1bc", "a b"}},
+		{"codes", []string{"abc", "abc def"}, []string{"abc def ghi", "ab"}},
+		{"size", []string{"3", "small"}, []string{"0", "medium"}},
+		{"since", []string{"2000-01-01", "2024-06-30"}, []string{"1999-12-31"}},
+	}
+	scope := &mustParse(t, `<a xmlns:x="urn:x"/>`).Scope
+	for _, tt := range tests {
+		d, ok := s.types[xml.Name{Local: tt.typ}]
+		if !ok {
+			t.Fatalf("no type %s", tt.typ)
+		}
+		typ := s.simpleTypes[d.el]
+		for _, v := range tt.valid {
+			if _, err := typ.validate(v, scope); err != nil {
+				t.Errorf("%s %q: %v", tt.typ, v, err)
+			}
+		}
+		for _, v := range tt.bad {
+			if _, err := typ.validate(v, scope); err == nil {
+				t.Errorf("%s %q: valid", tt.typ, v)
+			}
+		}
+	}
+}
+
+func TestDatatype(t *testing.T) {
+	d, err := NewDatatype("integer", [][2]string{{"minInclusive", "1"}, {"pattern", "[0-9]+"}, {"pattern", ".*5"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for v, want := range map[string]bool{"5": true, "15": true, " 25 ": true, "+5": false, "0": false, "12": false} {
+		if err := d.Validate(v, nil); (err == nil) != want {
+			t.Errorf("%q: got %v", v, err)
+		}
+	}
+	if _, err := NewDatatype("integer", [][2]string{{"color", "red"}}); err == nil {
+		t.Error("unknown facet accepted")
+	}
+	if _, err := NewDatatype("number", nil); err == nil {
+		t.Error("unknown datatype accepted")
+	}
+
+	scope := &mustParse(t, `<a xmlns:x="urn:x" xmlns:y="urn:x"/>`).Scope
+	equal := []struct {
+		typ, a, b string
+		want      bool
+	}{
+		{"integer", "1", "01", true},
+		{"decimal", "1.0", "1", true},
+		{"token", " a  b ", "a b", true},
+		{"string", "a ", "a", false},
+		{"boolean", "1", "true", true},
+		{"QName", "x:a", "y:a", true},
+		{"hexBinary", "0a", "0A", true},
+	}
+	for _, tt := range equal {
+		d, err := NewDatatype(tt.typ, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := d.Equal(tt.a, scope, tt.b, scope); got != tt.want {
+			t.Errorf("%s: %q = %q is %v", tt.typ, tt.a, tt.b, got)
+		}
+	}
+}
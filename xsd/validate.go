@@ -0,0 +1,578 @@
+package xsd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pschou/go-xmltree"
+	"github.com/pschou/go-xmltree/internal/validation"
+)
+
+// An Error describes an element which is not valid.
+type Error = validation.Error
+
+// Errors is the list of errors returned by Validate, in document order.
+type Errors = validation.Errors
+
+// maxSteps limits the work done matching the children of an element
+// against a content model.
+const maxSteps = 1000000
+
+// maxDepth limits the depth of documents validated.
+const maxDepth = 3000
+
+// Validate checks that root is valid according to the Schema. root must
+// be declared by a top-level element declaration, unless it has an
+// xsi:type attribute. If the document names schemas in
+// xsi:schemaLocation or xsi:noNamespaceSchemaLocation attributes, those
+// for namespaces not already in the Schema are loaded with Options.Load,
+// if set, and added to the Schema.
+//
+// If root is not valid, the error returned is of type Errors, listing
+// every problem found.
+func (s *Schema) Validate(root *xmltree.Element) error {
+	if s.opts.Load != nil {
+		s.mu.Lock()
+		err := s.loadHints(root)
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	// Once compiled, definitions are only read, so documents may be
+	// validated concurrently.
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v := validator{s: s, ids: make(map[string]bool)}
+	path := "/" + validation.QName(root, root.Name)
+	if d, ok := s.elements[root.Name]; ok {
+		v.element(root, s.elementDecls[d.el], path, 0)
+	} else if root.Attr(xsiNS, "type") != "" {
+		v.element(root, &elementDecl{name: root.Name, typ: anyType}, path, 0)
+	} else {
+		v.errorf(root, path, "no declaration for element %s", describe(root.Name))
+	}
+	for _, ref := range v.idrefs {
+		if !v.ids[ref.id] {
+			v.errorf(ref.el, ref.path, "no element has the ID %q", ref.id)
+		}
+	}
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+// loadHints loads the schemas named in xsi:schemaLocation and
+// xsi:noNamespaceSchemaLocation attributes, for namespaces without
+// definitions.
+func (s *Schema) loadHints(root *xmltree.Element) error {
+	var hints [][2]string
+	hint := func(el *xmltree.Element) error {
+		f := strings.Fields(el.Attr(xsiNS, "schemaLocation"))
+		for i := 0; i+1 < len(f); i += 2 {
+			hints = append(hints, [2]string{f[i], f[i+1]})
+		}
+		if loc := strings.TrimSpace(el.Attr(xsiNS, "noNamespaceSchemaLocation")); loc != "" {
+			hints = append(hints, [2]string{"", loc})
+		}
+		return nil
+	}
+	hint(root)
+	root.WalkFunc(hint)
+	added := false
+	for _, h := range hints {
+		if s.namespaces[h[0]] {
+			continue
+		}
+		if err := s.load(h[0], h[1], nil); err != nil {
+			return err
+		}
+		added = true
+	}
+	if added {
+		return s.compile()
+	}
+	return nil
+}
+
+type validator struct {
+	s      *Schema
+	errs   Errors
+	ids    map[string]bool
+	idrefs []idref
+}
+
+// An idref is a reference to an ID, checked once the whole document has
+// been seen.
+type idref struct {
+	id   string
+	el   *xmltree.Element
+	path string
+}
+
+func (v *validator) errorf(el *xmltree.Element, path, format string, args ...interface{}) {
+	v.errs = append(v.errs, &Error{Path: path, Element: el, Msg: fmt.Sprintf(format, args...)})
+}
+
+// element validates an element against its declaration.
+func (v *validator) element(el *xmltree.Element, decl *elementDecl, path string, depth int) {
+	if depth > maxDepth {
+		v.errorf(el, path, "document is too deep")
+		return
+	}
+	if decl.abstract {
+		v.errorf(el, path, "element %s is abstract", describe(decl.name))
+	}
+	typ := decl.typ
+	if qname := el.Attr(xsiNS, "type"); qname != "" {
+		name, ok := el.ResolveNS(strings.TrimSpace(qname))
+		if !ok && strings.Contains(qname, ":") {
+			v.errorf(el, path, "undeclared namespace prefix in xsi:type %q", qname)
+			return
+		}
+		t, err := v.s.lookupType(name)
+		if err != nil {
+			v.errorf(el, path, "xsi:type %s is not defined", describe(name))
+			return
+		}
+		if !derivesFrom(t, typ) {
+			v.errorf(el, path, "xsi:type %s is not derived from the declared type", describe(name))
+			return
+		}
+		typ = t
+	}
+	if ct, ok := typ.(*complexType); ok && ct.abstract {
+		v.errorf(el, path, "type %s is abstract", describe(ct.name))
+	}
+
+	if xsiNil := el.Attr(xsiNS, "nil"); xsiNil != "" {
+		if !decl.nillable {
+			v.errorf(el, path, "element is not nillable")
+		} else if isTrue(xsiNil) {
+			if hasContent(el) {
+				v.errorf(el, path, "element with xsi:nil must be empty")
+			}
+			if decl.fixed {
+				v.errorf(el, path, "element with a fixed value cannot be nil")
+			}
+			if ct, ok := typ.(*complexType); ok {
+				v.attributes(el, ct, path)
+			}
+			return
+		}
+	}
+
+	switch t := typ.(type) {
+	case *simpleType:
+		for _, a := range el.StartElement.Attr {
+			if a.Name.Space != xsiNS {
+				v.errorf(el, path, "attribute %s is not allowed", describe(a.Name))
+			}
+		}
+		v.simpleContent(el, decl, t, path)
+	case *complexType:
+		v.attributes(el, t, path)
+		switch {
+		case t.simple != nil:
+			v.simpleContent(el, decl, t.simple, path)
+		default:
+			v.content(el, t, path, depth)
+		}
+	}
+}
+
+// derivesFrom reports whether t is base or is derived from it.
+func derivesFrom(t, base typeDef) bool {
+	if base == anyType {
+		return true
+	}
+	for {
+		if t == base {
+			return true
+		}
+		switch d := t.(type) {
+		case *simpleType:
+			if d.base == nil {
+				return base == anySimpleType
+			}
+			t = d.base
+		case *complexType:
+			if d == anyType {
+				return false
+			}
+			t = d.base
+		}
+	}
+}
+
+// hasContent reports whether an element has child elements or text.
+func hasContent(el *xmltree.Element) bool {
+	if el.Content != "" {
+		return true
+	}
+	for i := range el.Children {
+		switch el.Children[i].Type {
+		case xmltree.XML_Tag, xmltree.XML_CharData, xmltree.XML_CDATA:
+			return true
+		}
+	}
+	return false
+}
+
+// text returns the character data directly within an element.
+func text(el *xmltree.Element) string {
+	if len(el.Children) == 0 {
+		return el.Content
+	}
+	var b strings.Builder
+	found := false
+	for i := range el.Children {
+		c := &el.Children[i]
+		if c.Type == xmltree.XML_CharData || c.Type == xmltree.XML_CDATA {
+			b.WriteString(c.Content)
+			found = true
+		}
+	}
+	if !found {
+		// Trees built by ParseXML keep the text in Content.
+		return el.Content
+	}
+	return b.String()
+}
+
+// simpleContent validates the text of an element of simple type.
+func (v *validator) simpleContent(el *xmltree.Element, decl *elementDecl, t *simpleType, path string) {
+	for i := range el.Children {
+		if el.Children[i].Type == xmltree.XML_Tag {
+			v.errorf(el, path, "element %s is not allowed in simple content", validation.QName(&el.Children[i], el.Children[i].Name))
+			return
+		}
+	}
+	value := text(el)
+	if value == "" && decl.value != nil {
+		value = *decl.value
+	}
+	norm, err := v.simple(el, t, value, path)
+	if err != nil {
+		return
+	}
+	if decl.fixed {
+		if fixed := t.normalize(*decl.value); norm != fixed {
+			v.errorf(el, path, "value %q does not match the fixed value %q", norm, fixed)
+		}
+	}
+}
+
+// simple validates a value of simple type, and records any IDs and ID
+// references in it.
+func (v *validator) simple(el *xmltree.Element, t *simpleType, value, path string) (string, error) {
+	norm, err := t.validate(value, &el.Scope)
+	if err != nil {
+		v.errorf(el, path, "%v", err)
+		return norm, err
+	}
+	switch {
+	case t.is("ID"):
+		if v.ids[norm] {
+			v.errorf(el, path, "duplicate ID %q", norm)
+		}
+		v.ids[norm] = true
+	case t.is("IDREF"):
+		v.idrefs = append(v.idrefs, idref{norm, el, path})
+	case t.variety == list && t.item.is("IDREF"):
+		for _, id := range strings.Fields(norm) {
+			v.idrefs = append(v.idrefs, idref{id, el, path})
+		}
+	}
+	return norm, nil
+}
+
+// attributes validates the attributes of an element of complex type.
+func (v *validator) attributes(el *xmltree.Element, t *complexType, path string) {
+	seen := make(map[xml.Name]bool)
+	for _, a := range el.StartElement.Attr {
+		if a.Name.Space == xsiNS {
+			switch a.Name.Local {
+			case "type", "nil", "schemaLocation", "noNamespaceSchemaLocation":
+				continue
+			}
+		}
+		apath := path + "/@" + validation.QName(el, a.Name)
+		var use *attrUse
+		for _, u := range t.attrs {
+			if u.name == a.Name {
+				use = u
+				break
+			}
+		}
+		switch {
+		case use != nil && use.prohibited:
+			v.errorf(el, apath, "attribute is prohibited")
+			continue
+		case use != nil:
+			seen[a.Name] = true
+			norm, err := v.simple(el, use.typ, a.Value, apath)
+			if err == nil && use.fixed && norm != use.typ.normalize(*use.value) {
+				v.errorf(el, apath, "value %q does not match the fixed value %q", norm, *use.value)
+			}
+			continue
+		case t.anyAttr != nil && t.anyAttr.allows(a.Name.Space):
+			if t.anyAttr.process == "skip" {
+				continue
+			}
+			d, ok := v.s.attributes[a.Name]
+			if !ok {
+				if t.anyAttr.process == "strict" {
+					v.errorf(el, apath, "no declaration for attribute %s", describe(a.Name))
+				}
+				continue
+			}
+			g := v.s.attrDecls[d.el]
+			norm, err := v.simple(el, g.typ, a.Value, apath)
+			if err == nil && g.fixed && norm != g.typ.normalize(*g.value) {
+				v.errorf(el, apath, "value %q does not match the fixed value %q", norm, *g.value)
+			}
+			continue
+		}
+		v.errorf(el, apath, "attribute is not allowed")
+	}
+	for _, u := range t.attrs {
+		if u.required && !seen[u.name] {
+			v.errorf(el, path, "missing required attribute %s", describe(u.name))
+		}
+	}
+}
+
+// content validates the children of an element of complex type without
+// simple content.
+func (v *validator) content(el *xmltree.Element, t *complexType, path string, depth int) {
+	if !t.mixed && strings.TrimSpace(text(el)) != "" {
+		v.errorf(el, path, "text is not allowed in element-only content")
+	}
+	var elems []*xmltree.Element
+	for i := range el.Children {
+		if el.Children[i].Type == xmltree.XML_Tag {
+			elems = append(elems, &el.Children[i])
+		}
+	}
+	paths := childPaths(elems, path)
+	if t.content == nil {
+		if len(elems) > 0 {
+			v.errorf(el, path, "element must be empty, found %s", validation.QName(elems[0], elems[0].Name))
+		}
+		return
+	}
+
+	m := contentMatcher{elems: elems, decls: make([]*particle, len(elems)), subst: make([]*elementDecl, len(elems))}
+	ok := m.particle(t.content, 0, m.end)
+	switch {
+	case m.steps > maxSteps:
+		v.errorf(el, path, "content model is too complex to check")
+		return
+	case !ok && m.furthest < len(elems):
+		c := elems[m.furthest]
+		v.errorf(c, paths[m.furthest], "unexpected element %s%s", validation.QName(c, c.Name), expecting(m.expected))
+		return
+	case !ok:
+		v.errorf(el, path, "content is incomplete%s", expecting(m.expected))
+		return
+	}
+	for i, child := range elems {
+		p := m.decls[i]
+		if p.kind == elementParticle {
+			v.element(child, m.subst[i], paths[i], depth+1)
+			continue
+		}
+		// A wildcard.
+		switch p.any.process {
+		case "skip":
+			continue
+		case "lax", "strict":
+			if d, ok := v.s.elements[child.Name]; ok {
+				v.element(child, v.s.elementDecls[d.el], paths[i], depth+1)
+			} else if p.any.process == "strict" && child.Attr(xsiNS, "type") == "" {
+				v.errorf(child, paths[i], "no declaration for element %s", describe(child.Name))
+			} else {
+				v.element(child, &elementDecl{name: child.Name, typ: anyType}, paths[i], depth+1)
+			}
+		}
+	}
+}
+
+// childPaths returns the paths of child elements.
+func childPaths(elems []*xmltree.Element, path string) []string {
+	count := make(map[xml.Name]int)
+	paths := make([]string, len(elems))
+	for i, c := range elems {
+		count[c.Name]++
+		paths[i] = path + "/" + validation.QName(c, c.Name) + "[" + strconv.Itoa(count[c.Name]) + "]"
+	}
+	return paths
+}
+
+// expecting describes the elements expected at some point.
+func expecting(names []string) string {
+	switch len(names) {
+	case 0:
+		return ""
+	case 1:
+		return "; expected " + names[0]
+	}
+	return "; expected one of " + strings.Join(names, ", ")
+}
+
+// A contentMatcher matches a list of elements against a content model,
+// by a backtracking search. Each particle is matched by calling a
+// continuation with each position it could end at, until one leads to
+// a match of the whole list.
+type contentMatcher struct {
+	elems []*xmltree.Element
+	decls []*particle    // the particle matching each element
+	subst []*elementDecl // the declaration of each element
+
+	steps    int
+	furthest int      // the furthest position reached
+	expected []string // elements expected at furthest
+}
+
+// particle matches p, with its occurrence constraints, at position i.
+func (m *contentMatcher) particle(p *particle, i int, k func(int) bool) bool {
+	return m.repeat(p, i, 0, k)
+}
+
+// repeat matches further occurrences of p at position i, having already
+// matched n.
+func (m *contentMatcher) repeat(p *particle, i, n int, k func(int) bool) bool {
+	if m.steps++; m.steps > maxSteps {
+		return false
+	}
+	if p.max < 0 || n < p.max {
+		more := m.term(p, i, func(j int) bool {
+			if j == i && n >= p.min {
+				// No progress, so no need for more occurrences.
+				return false
+			}
+			return m.repeat(p, j, n+1, k)
+		})
+		if more {
+			return true
+		}
+	}
+	return n >= p.min && k(i)
+}
+
+// term matches a single occurrence of p at position i.
+func (m *contentMatcher) term(p *particle, i int, k func(int) bool) bool {
+	switch p.kind {
+	case elementParticle, anyParticle:
+		if i < len(m.elems) {
+			el := m.elems[i]
+			var decl *elementDecl
+			if p.kind == elementParticle {
+				decl = substitute(p.elem, el.Name)
+			}
+			if decl != nil || p.kind == anyParticle && p.any.allows(el.Name.Space) {
+				m.decls[i], m.subst[i] = p, decl
+				return k(i + 1)
+			}
+		}
+		m.expect(p, i)
+		return false
+	case sequenceParticle:
+		return m.sequence(p.items, i, k)
+	case choiceParticle:
+		for _, item := range p.items {
+			if m.particle(item, i, k) {
+				return true
+			}
+		}
+		return false
+	case allParticle:
+		return m.all(p.items, make([]bool, len(p.items)), i, k)
+	}
+	return false
+}
+
+func (m *contentMatcher) sequence(items []*particle, i int, k func(int) bool) bool {
+	if len(items) == 0 {
+		return k(i)
+	}
+	return m.particle(items[0], i, func(j int) bool {
+		return m.sequence(items[1:], j, k)
+	})
+}
+
+// all matches the items of an xs:all group not yet used, in any order.
+func (m *contentMatcher) all(items []*particle, used []bool, i int, k func(int) bool) bool {
+	for n, item := range items {
+		if used[n] || i >= len(m.elems) {
+			continue
+		}
+		if decl := substitute(item.elem, m.elems[i].Name); decl != nil {
+			used[n] = true
+			m.decls[i], m.subst[i] = item, decl
+			if m.all(items, used, i+1, k) {
+				return true
+			}
+			used[n] = false
+		}
+	}
+	for n, item := range items {
+		if !used[n] && item.min > 0 {
+			m.expect(item, i)
+			return false
+		}
+	}
+	return k(i)
+}
+
+// substitute returns the declaration of an element with the given name
+// which may appear in place of decl: decl itself, or a member of its
+// substitution group.
+func substitute(decl *elementDecl, name xml.Name) *elementDecl {
+	if decl.name == name {
+		return decl
+	}
+	for _, member := range decl.members {
+		if d := substitute(member, name); d != nil {
+			return d
+		}
+	}
+	return nil
+}
+
+// end is the final continuation of a match, which succeeds only if
+// every element has been consumed. Otherwise it records i, so that an
+// element left over after the content model is complete is reported.
+func (m *contentMatcher) end(i int) bool {
+	if i == len(m.elems) {
+		return true
+	}
+	if i > m.furthest {
+		m.furthest, m.expected = i, nil
+	}
+	return false
+}
+
+// expect records that p was expected at position i.
+func (m *contentMatcher) expect(p *particle, i int) {
+	if i < m.furthest {
+		return
+	}
+	if i > m.furthest {
+		m.furthest, m.expected = i, nil
+	}
+	name := "any element"
+	if p.kind == elementParticle {
+		name = p.elem.name.Local
+	}
+	for _, e := range m.expected {
+		if e == name {
+			return
+		}
+	}
+	m.expected = append(m.expected, name)
+}
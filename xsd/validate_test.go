@@ -0,0 +1,370 @@
+package xsd
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pschou/go-xmltree"
+)
+
+const orderSchema = `
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema"
+    xmlns="urn:order" targetNamespace="urn:order" elementFormDefault="qualified">
+  <xs:element name="order">
+    <xs:complexType>
+      <xs:sequence>
+        <xs:element name="customer" type="xs:string"/>
+        <xs:choice>
+          <xs:element name="email" type="email"/>
+          <xs:element name="phone" type="xs:string"/>
+        </xs:choice>
+        <xs:element name="item" type="item" maxOccurs="3"/>
+        <xs:element name="note" type="xs:string" minOccurs="0" nillable="true"/>
+        <xs:element ref="shipping" minOccurs="0"/>
+      </xs:sequence>
+      <xs:attribute name="id" type="xs:ID" use="required"/>
+      <xs:attribute name="ref" type="xs:IDREF"/>
+      <xs:attribute name="status" default="open">
+        <xs:simpleType>
+          <xs:restriction base="xs:token">
+            <xs:enumeration value="open"/>
+            <xs:enumeration value="closed"/>
+          </xs:restriction>
+        </xs:simpleType>
+      </xs:attribute>
+    </xs:complexType>
+  </xs:element>
+  <xs:simpleType name="email">
+    <xs:restriction base="xs:string">
+      <xs:pattern value="[^@]+@[^@]+"/>
+      <xs:maxLength value="20"/>
+    </xs:restriction>
+  </xs:simpleType>
+  <xs:complexType name="item">
+    <xs:simpleContent>
+      <xs:extension base="quantity">
+        <xs:attribute name="sku" type="xs:NMTOKEN" use="required"/>
+      </xs:extension>
+    </xs:simpleContent>
+  </xs:complexType>
+  <xs:simpleType name="quantity">
+    <xs:restriction base="xs:positiveInteger">
+      <xs:maxInclusive value="100"/>
+    </xs:restriction>
+  </xs:simpleType>
+  <xs:element name="shipping" type="address" abstract="true"/>
+  <xs:element name="post" type="address" substitutionGroup="shipping"/>
+  <xs:element name="courier" type="courierAddress" substitutionGroup="shipping"/>
+  <xs:complexType name="address">
+    <xs:sequence>
+      <xs:element name="street" type="xs:string"/>
+    </xs:sequence>
+  </xs:complexType>
+  <xs:complexType name="courierAddress">
+    <xs:complexContent>
+      <xs:extension base="address">
+        <xs:sequence>
+          <xs:element name="company" type="xs:string"/>
+        </xs:sequence>
+      </xs:extension>
+    </xs:complexContent>
+  </xs:complexType>
+</xs:schema>`
+
+func mustParse(t *testing.T, doc string) *xmltree.Element {
+	t.Helper()
+	root, err := xmltree.Parse(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func mustCompile(t *testing.T, doc string, opts *Options) *Schema {
+	t.Helper()
+	s, err := Compile(mustParse(t, doc), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestValidate(t *testing.T) {
+	s := mustCompile(t, orderSchema, nil)
+	tests := []struct {
+		doc string
+		err string // a substring of the first error, if any
+	}{
+		{doc: `<order xmlns="urn:order" id="a1"><customer>Ann</customer><email>ann@example.com</email><item sku="x-1">2</item></order>`},
+		{doc: `<o:order xmlns:o="urn:order" id="a1" status=" closed "><o:customer/><o:phone>1</o:phone>
+			<o:item sku="x">1</o:item><o:item sku="y">100</o:item><o:note/><o:post><o:street/></o:post></o:order>`},
+		{doc: `<order xmlns="urn:order" id="a1"><customer/><phone/><item sku="x">1</item>
+			<courier><street/><company/></courier></order>`},
+		{doc: `<order xmlns="urn:order" id="a1" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"><customer/><phone/>
+			<item sku="x">1</item><note xsi:nil="true"/></order>`},
+		{doc: `<order xmlns="urn:order" id="a1" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"><customer/><phone/>
+			<item sku="x">1</item><post xsi:type="courierAddress"><street/><company/></post></order>`},
+		{
+			doc: `<order xmlns="urn:order"><customer/><phone/><item sku="x">1</item></order>`,
+			err: "/order: missing required attribute id",
+		},
+		{
+			doc: `<order xmlns="urn:order" id="a1"><customer/><item sku="x">1</item></order>`,
+			err: "/order/item[1]: unexpected element item; expected one of email, phone",
+		},
+		{
+			doc: `<order xmlns="urn:order" id="a1"><customer/><phone/></order>`,
+			err: "/order: content is incomplete; expected item",
+		},
+		{
+			doc: `<order xmlns="urn:order" id="a1"><customer/><phone/><item sku="a">1</item><item sku="b">1</item>
+				<item sku="c">1</item><item sku="d">1</item></order>`,
+			err: "/order/item[4]: unexpected element item",
+		},
+		{
+			doc: `<order xmlns="urn:order" id="a1"><customer/><email>nobody</email><item sku="x">1</item></order>`,
+			err: `/order/email[1]: "nobody" does not match the pattern of email`,
+		},
+		{
+			doc: `<order xmlns="urn:order" id="a1"><customer/><phone/><item sku="x">101</item></order>`,
+			err: `/order/item[1]: "101" is greater than 100`,
+		},
+		{
+			doc: `<order xmlns="urn:order" id="a1"><customer/><phone/><item sku="x">0</item></order>`,
+			err: `/order/item[1]: "0" is less than 1`,
+		},
+		{
+			doc: `<order xmlns="urn:order" id="a1"><customer/><phone/><item>1</item></order>`,
+			err: "/order/item[1]: missing required attribute sku",
+		},
+		{
+			doc: `<order xmlns="urn:order" id="a1" status="lost"><customer/><phone/><item sku="x">1</item></order>`,
+			err: `/order/@status: "lost" is not one of the values allowed`,
+		},
+		{
+			doc: `<order xmlns="urn:order" id="a1" ref="a2"><customer/><phone/><item sku="x">1</item></order>`,
+			err: `/order/@ref: no element has the ID "a2"`,
+		},
+		{
+			doc: `<order xmlns="urn:order" id="1a"><customer/><phone/><item sku="x">1</item></order>`,
+			err: `/order/@id: "1a" does not match the pattern of ID`,
+		},
+		{
+			doc: `<order xmlns="urn:order" id="a1" other="x"><customer/><phone/><item sku="x">1</item></order>`,
+			err: "/order/@other: attribute is not allowed",
+		},
+		{
+			doc: `<order xmlns="urn:order" id="a1"><customer/><phone/>text<item sku="x">1</item></order>`,
+			err: "/order: text is not allowed in element-only content",
+		},
+		{
+			doc: `<order xmlns="urn:order" id="a1"><customer><b/></customer><phone/><item sku="x">1</item></order>`,
+			err: "/order/customer[1]: element b is not allowed in simple content",
+		},
+		{
+			doc: `<order xmlns="urn:order" id="a1"><customer/><phone/><item sku="x">1</item><shipping><street/></shipping></order>`,
+			err: "/order/shipping[1]: element {urn:order}shipping is abstract",
+		},
+		{
+			doc: `<order xmlns="urn:order" id="a1" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"><customer/><phone/>
+				<item sku="x">1</item><post xsi:type="email"/></order>`,
+			err: "/order/post[1]: xsi:type {urn:order}email is not derived from the declared type",
+		},
+		{
+			doc: `<order xmlns="urn:order" id="a1" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"><customer xsi:nil="true"/>
+				<phone/><item sku="x">1</item></order>`,
+			err: "/order/customer[1]: element is not nillable",
+		},
+		{
+			doc: `<order xmlns="urn:order" id="a1" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"><customer/><phone/>
+				<item sku="x">1</item><note xsi:nil="true">text</note></order>`,
+			err: "/order/note[1]: element with xsi:nil must be empty",
+		},
+		{
+			doc: `<invoice xmlns="urn:order"/>`,
+			err: "/invoice: no declaration for element {urn:order}invoice",
+		},
+	}
+	for _, tt := range tests {
+		err := s.Validate(mustParse(t, tt.doc))
+		switch {
+		case err == nil && tt.err != "":
+			t.Errorf("%s: valid, want error %q", tt.doc, tt.err)
+		case err != nil && tt.err == "":
+			t.Errorf("%s: %v", tt.doc, err)
+		case err != nil:
+			errs, ok := err.(Errors)
+			if !ok {
+				t.Errorf("%s: error of type %T", tt.doc, err)
+			} else if !strings.Contains(errs[0].Error(), tt.err) {
+				t.Errorf("%s: got %q, want %q", tt.doc, errs[0], tt.err)
+			}
+		}
+	}
+}
+
+func TestValidateConcurrent(t *testing.T) {
+	s := mustCompile(t, orderSchema, nil)
+	doc := mustParse(t, `<order xmlns="urn:order" id="a1"><customer>Ann</customer><email>ann@example.com</email><item sku="x-1">2</item></order>`)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Validate(doc); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestValidateAll(t *testing.T) {
+	s := mustCompile(t, `
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+  <xs:element name="point">
+    <xs:complexType>
+      <xs:all>
+        <xs:element name="x" type="xs:decimal"/>
+        <xs:element name="y" type="xs:decimal"/>
+        <xs:element name="label" type="xs:string" minOccurs="0"/>
+      </xs:all>
+      <xs:anyAttribute namespace="##other" processContents="skip"/>
+    </xs:complexType>
+  </xs:element>
+</xs:schema>`, nil)
+	for doc, want := range map[string]string{
+		`<point><y>1</y><x>-2.5</x></point>`:                      "",
+		`<point><label/><x>0</x><y>1</y></point>`:                 "",
+		`<point xmlns:a="urn:a" a:b="c"><x>0</x><y>1</y></point>`: "",
+		`<point><x>0</x></point>`:                                 "/point: content is incomplete; expected y",
+		`<point><x>0</x><x>1</x><y>1</y></point>`:                 "/point/x[2]: unexpected element x",
+		`<point><x>1e3</x><y>1</y></point>`:                       `/point/x[1]: "1e3" is not a valid decimal`,
+		`<point b="c"><x>0</x><y>1</y></point>`:                   "/point/@b: attribute is not allowed",
+	} {
+		err := s.Validate(mustParse(t, doc))
+		got := ""
+		if err != nil {
+			got = err.(Errors)[0].Error()
+		}
+		if !strings.HasPrefix(got, want) || (want == "") != (got == "") {
+			t.Errorf("%s: got %q, want %q", doc, got, want)
+		}
+	}
+}
+
+func TestValidateTrailing(t *testing.T) {
+	s := mustCompile(t, `
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+  <xs:element name="shape">
+    <xs:complexType>
+      <xs:sequence>
+        <xs:element name="name" type="xs:string"/>
+        <xs:element name="side" type="xs:decimal" minOccurs="0"/>
+      </xs:sequence>
+    </xs:complexType>
+  </xs:element>
+</xs:schema>`, nil)
+	for doc, want := range map[string]string{
+		`<shape><name>c</name><side>1</side></shape>`:         "",
+		`<shape><name>c</name><side>1</side><r>1</r></shape>`: "/shape/r[1]: unexpected element r",
+		`<shape><name>c</name><r>1</r></shape>`:               "/shape/r[1]: unexpected element r; expected side",
+		`<shape><name>c</name><name>d</name></shape>`:         "/shape/name[2]: unexpected element name; expected side",
+	} {
+		err := s.Validate(mustParse(t, doc))
+		got := ""
+		if err != nil {
+			got = err.(Errors)[0].Error()
+		}
+		if !strings.HasPrefix(got, want) || (want == "") != (got == "") {
+			t.Errorf("%s: got %q, want %q", doc, got, want)
+		}
+	}
+}
+
+func TestSchemaLocation(t *testing.T) {
+	docs := map[string]string{
+		"main.xsd": `
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" targetNamespace="urn:main"
+    xmlns:m="urn:main" xmlns:c="urn:common" elementFormDefault="qualified">
+  <xs:import namespace="urn:common" schemaLocation="common.xsd"/>
+  <xs:include schemaLocation="types.xsd"/>
+  <xs:element name="root">
+    <xs:complexType>
+      <xs:sequence>
+        <xs:element ref="c:name"/>
+        <xs:element name="size" type="m:size"/>
+        <xs:any namespace="##other" processContents="lax" minOccurs="0" maxOccurs="unbounded"/>
+      </xs:sequence>
+    </xs:complexType>
+  </xs:element>
+</xs:schema>`,
+		// A chameleon include, taking the namespace of main.xsd.
+		"types.xsd": `
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+  <xs:simpleType name="size">
+    <xs:list itemType="xs:int"/>
+  </xs:simpleType>
+</xs:schema>`,
+		"common.xsd": `
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" targetNamespace="urn:common">
+  <xs:element name="name" type="xs:string"/>
+</xs:schema>`,
+		"extra.xsd": `
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" targetNamespace="urn:extra">
+  <xs:element name="flag" type="xs:boolean"/>
+</xs:schema>`,
+	}
+	var loads []string
+	opts := &Options{Load: func(namespace, location string) (*xmltree.Element, error) {
+		loads = append(loads, location)
+		doc, ok := docs[location]
+		if !ok {
+			return nil, fmt.Errorf("no schema %s", location)
+		}
+		return xmltree.Parse(strings.NewReader(doc))
+	}}
+	s := mustCompile(t, docs["main.xsd"], opts)
+
+	valid := `<root xmlns="urn:main" xmlns:c="urn:common"><c:name/><size>1 2 3</size></root>`
+	if err := s.Validate(mustParse(t, valid)); err != nil {
+		t.Error(err)
+	}
+	invalid := `<root xmlns="urn:main" xmlns:c="urn:common"><c:name/><size>1 x</size></root>`
+	if err := s.Validate(mustParse(t, invalid)); err == nil {
+		t.Error("list of int with x is valid")
+	}
+
+	hinted := `<root xmlns="urn:main" xmlns:c="urn:common" xmlns:e="urn:extra"
+	    xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"
+	    xsi:schemaLocation="urn:extra extra.xsd"><c:name/><size/><e:flag>maybe</e:flag></root>`
+	err := s.Validate(mustParse(t, hinted))
+	if err == nil || !strings.Contains(err.Error(), `/root/e:flag[1]: "maybe" is not a valid boolean`) {
+		t.Errorf("got %v", err)
+	}
+	if want := "common.xsd types.xsd extra.xsd"; strings.Join(loads, " ") != want {
+		t.Errorf("loaded %q, want %q", loads, want)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for schema, want := range map[string]string{
+		`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema"><xs:element name="a" type="b"/></xs:schema>`: "undefined type b",
+		`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+			<xs:complexType name="a"><xs:complexContent><xs:extension base="b"/></xs:complexContent></xs:complexType>
+			<xs:complexType name="b"><xs:complexContent><xs:extension base="a"/></xs:complexContent></xs:complexType>
+		</xs:schema>`: "circular",
+		`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema"><xs:simpleType name="a">
+			<xs:restriction base="xs:string"><xs:pattern value="[a-z-[aeiou]]"/></xs:restriction>
+		</xs:simpleType></xs:schema>`: "subtraction",
+		`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema"><xs:include schemaLocation="a.xsd"/></xs:schema>`: "without Options.Load",
+		`<schema/>`: "not xs:schema",
+	} {
+		_, err := Compile(mustParse(t, schema), nil)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", schema, err, want)
+		}
+	}
+}
@@ -0,0 +1,595 @@
+package xslt
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pschou/go-xmltree"
+)
+
+// coreFunctions are the functions of XPath 1.0, for function-available.
+var coreFunctions = map[string]bool{
+	"last": true, "position": true, "count": true, "id": true, "local-name": true,
+	"namespace-uri": true, "name": true, "string": true, "concat": true,
+	"starts-with": true, "contains": true, "substring-before": true,
+	"substring-after": true, "substring": true, "string-length": true,
+	"normalize-space": true, "translate": true, "boolean": true, "not": true,
+	"true": true, "false": true, "lang": true, "number": true, "sum": true,
+	"floor": true, "ceiling": true, "round": true,
+	"current": true, "key": true, "generate-id": true, "format-number": true,
+	"system-property": true, "element-available": true, "function-available": true,
+	"unparsed-entity-uri": true,
+}
+
+// instructions are the XSLT instructions, for element-available.
+var instructions = map[string]bool{
+	"apply-imports": true, "apply-templates": true, "attribute": true,
+	"call-template": true, "choose": true, "comment": true, "copy": true,
+	"copy-of": true, "element": true, "fallback": true, "for-each": true,
+	"if": true, "message": true, "number": true, "processing-instruction": true,
+	"text": true, "value-of": true, "variable": true,
+}
+
+// functions returns the XSLT functions, with the extension functions
+// of the stylesheet.
+func (t *transform) functions() map[string]xmltree.XPathFunc {
+	fns := map[string]xmltree.XPathFunc{
+		"current": func(*xmltree.XPathContext, xmltree.Node, []interface{}) (interface{}, error) {
+			return []xmltree.Node{t.current}, nil
+		},
+		"key":           t.key,
+		"generate-id":   t.generateID,
+		"format-number": formatNumberFunc,
+		"document": func(*xmltree.XPathContext, xmltree.Node, []interface{}) (interface{}, error) {
+			return nil, errors.New("xslt: document() is not supported")
+		},
+		"unparsed-entity-uri": func(*xmltree.XPathContext, xmltree.Node, []interface{}) (interface{}, error) {
+			return "", nil
+		},
+		"system-property": func(c *xmltree.XPathContext, _ xmltree.Node, args []interface{}) (interface{}, error) {
+			name, err := argName(c, args, "system-property")
+			if err != nil || name.Space != xslNS {
+				return "", err
+			}
+			switch name.Local {
+			case "version":
+				return 1.0, nil
+			case "vendor":
+				return "xmltree", nil
+			case "vendor-url":
+				return "https://github.com/pschou/go-xmltree", nil
+			}
+			return "", nil
+		},
+		"element-available": func(c *xmltree.XPathContext, _ xmltree.Node, args []interface{}) (interface{}, error) {
+			name, err := argName(c, args, "element-available")
+			return name.Space == xslNS && instructions[name.Local], err
+		},
+		"function-available": func(c *xmltree.XPathContext, _ xmltree.Node, args []interface{}) (interface{}, error) {
+			if len(args) != 1 {
+				return nil, errors.New("xslt: function-available() takes one argument")
+			}
+			name := xmltree.XPathString(args[0])
+			_, ok := c.Functions[name]
+			return ok || coreFunctions[name], nil
+		},
+	}
+	for _, name := range t.s.nodeSet {
+		fns[name] = func(_ *xmltree.XPathContext, _ xmltree.Node, args []interface{}) (interface{}, error) {
+			if len(args) != 1 {
+				return nil, errors.New("xslt: node-set() takes one argument")
+			}
+			if nodes, ok := args[0].([]xmltree.Node); ok {
+				return nodes, nil
+			}
+			// A string becomes a text node of a new fragment.
+			return t.fragment(seq{textInstr(xmltree.XPathString(args[0]))}, &frame{})
+		}
+	}
+	for name, fn := range t.s.functions {
+		fns[name] = fn
+	}
+	return fns
+}
+
+// argName resolves the QName which is the only argument of a function.
+func argName(c *xmltree.XPathContext, args []interface{}, fn string) (xml.Name, error) {
+	if len(args) != 1 {
+		return xml.Name{}, fmt.Errorf("xslt: %s() takes one argument", fn)
+	}
+	s := strings.TrimSpace(xmltree.XPathString(args[0]))
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return xml.Name{Local: s}, nil
+	}
+	uri, ok := c.Namespaces[s[:i]]
+	if !ok {
+		return xml.Name{}, fmt.Errorf("xslt: %s(): undeclared prefix in %q", fn, s)
+	}
+	return xml.Name{Space: uri, Local: s[i+1:]}, nil
+}
+
+// key implements the key() function for the source document.
+func (t *transform) key(c *xmltree.XPathContext, _ xmltree.Node, args []interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errors.New("xslt: key() takes two arguments")
+	}
+	name, err := argName(c, args[:1], "key")
+	if err != nil {
+		return nil, err
+	}
+	index, err := t.keyIndex(name)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	if nodes, ok := args[1].([]xmltree.Node); ok {
+		for _, n := range nodes {
+			values = append(values, n.String())
+		}
+	} else {
+		values = []string{xmltree.XPathString(args[1])}
+	}
+	var result []xmltree.Node
+	seen := make(map[xmltree.Node]bool)
+	for _, v := range values {
+		for _, n := range index[v] {
+			if !seen[n] {
+				seen[n] = true
+				result = append(result, n)
+			}
+		}
+	}
+	if len(values) > 1 {
+		t.sortNodes(result)
+	}
+	return result, nil
+}
+
+// keyIndex returns the nodes of the source document with each value of
+// a key, in document order.
+func (t *transform) keyIndex(name xml.Name) (map[string][]xmltree.Node, error) {
+	if index, ok := t.keys[name]; ok {
+		return index, nil
+	}
+	keys, ok := t.s.keys[name]
+	if !ok {
+		return nil, fmt.Errorf("xslt: no key named %s", name.Local)
+	}
+	index := make(map[string][]xmltree.Node)
+	t.keys[name] = index
+	root := t.c.Root()
+	for _, k := range keys {
+		for _, a := range k.match.alts {
+			var nodes []xmltree.Node
+			if a.root {
+				nodes = []xmltree.Node{root}
+			} else {
+				var err error
+				nodes, err = t.selectNodes(a.e, &frame{node: root, pos: 1, size: 1, vars: t.globals}, "pattern")
+				if err != nil {
+					return nil, err
+				}
+			}
+			for _, n := range nodes {
+				v, err := t.eval(k.use, &frame{node: n, pos: 1, size: 1, vars: t.globals})
+				if err != nil {
+					return nil, err
+				}
+				if used, ok := v.([]xmltree.Node); ok {
+					for _, u := range used {
+						index[u.String()] = append(index[u.String()], n)
+					}
+				} else {
+					s := xmltree.XPathString(v)
+					index[s] = append(index[s], n)
+				}
+			}
+		}
+	}
+	for v, nodes := range index {
+		t.sortNodes(nodes)
+		// Drop nodes matched by more than one alternative.
+		out := nodes[:0]
+		for i, n := range nodes {
+			if i == 0 || n != nodes[i-1] {
+				out = append(out, n)
+			}
+		}
+		index[v] = out
+	}
+	return index, nil
+}
+
+// sortNodes sorts nodes of the source document into document order.
+func (t *transform) sortNodes(nodes []xmltree.Node) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a1, a2 := t.rank(nodes[i])
+		b1, b2 := t.rank(nodes[j])
+		return a1 < b1 || a1 == b1 && a2 < b2
+	})
+}
+
+func (t *transform) rank(n xmltree.Node) (int, int) {
+	switch {
+	case n.Type == xmltree.DocumentNode:
+		return -1, 0
+	case n.Type == xmltree.NamespaceNode:
+		return t.order[n.Element], 1 + n.Index
+	case n.Type == xmltree.AttributeNode:
+		return t.order[n.Element], 1<<16 + n.Index
+	case n.Type == xmltree.TextNode && n.Element.Type == xmltree.XML_Tag:
+		return t.order[n.Element], math.MaxInt32
+	}
+	return t.order[n.Element], 0
+}
+
+// generateID implements generate-id(), numbering nodes as they are
+// first asked for.
+func (t *transform) generateID(_ *xmltree.XPathContext, n xmltree.Node, args []interface{}) (interface{}, error) {
+	if len(args) > 0 {
+		nodes, ok := args[0].([]xmltree.Node)
+		if !ok {
+			return nil, errors.New("xslt: argument to generate-id() is not a node-set")
+		}
+		if len(nodes) == 0 {
+			return "", nil
+		}
+		n = nodes[0]
+		for _, m := range nodes[1:] {
+			a1, a2 := t.rank(m)
+			b1, b2 := t.rank(n)
+			if a1 < b1 || a1 == b1 && a2 < b2 {
+				n = m
+			}
+		}
+	}
+	id, ok := t.ids[n]
+	if !ok {
+		id = len(t.ids) + 1
+		t.ids[n] = id
+	}
+	return "id" + strconv.Itoa(id), nil
+}
+
+// formatNumberFunc implements format-number() with the default decimal
+// format.
+func formatNumberFunc(_ *xmltree.XPathContext, _ xmltree.Node, args []interface{}) (interface{}, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, errors.New("xslt: format-number() takes two or three arguments")
+	}
+	return formatDecimal(xmltree.XPathNumber(args[0]), xmltree.XPathString(args[1]))
+}
+
+// formatDecimal formats a number with a pattern of the JDK
+// DecimalFormat class, as used by format-number().
+func formatDecimal(f float64, pattern string) (string, error) {
+	switch {
+	case math.IsNaN(f):
+		return "NaN", nil
+	case math.IsInf(f, 1):
+		return "Infinity", nil
+	case math.IsInf(f, -1):
+		return "-Infinity", nil
+	}
+	pos, neg := pattern, ""
+	if i := strings.IndexByte(pattern, ';'); i >= 0 {
+		pos, neg = pattern[:i], pattern[i+1:]
+	}
+	sub := pos
+	if f < 0 && neg != "" {
+		sub = neg
+	}
+	// Split the subpattern into prefix, number and suffix.
+	start := strings.IndexAny(sub, "#0,.")
+	if start < 0 {
+		return "", fmt.Errorf("xslt: format-number() pattern %q has no digits", pattern)
+	}
+	end := start
+	for end < len(sub) && strings.IndexByte("#0,.", sub[end]) >= 0 {
+		end++
+	}
+	prefix, num, suffix := sub[:start], sub[start:end], sub[end:]
+	if f < 0 && neg == "" {
+		prefix = "-" + prefix
+	}
+	f = math.Abs(f)
+	switch {
+	case strings.ContainsRune(prefix+suffix, '%'):
+		f *= 100
+	case strings.ContainsRune(prefix+suffix, '‰'):
+		f *= 1000
+	}
+
+	intPart, fracPart := num, ""
+	if i := strings.IndexByte(num, '.'); i >= 0 {
+		intPart, fracPart = num[:i], num[i+1:]
+	}
+	group := 0
+	if i := strings.LastIndexByte(intPart, ','); i >= 0 {
+		group = len(intPart) - i - 1
+	}
+	minInt := strings.Count(intPart, "0")
+	minFrac := strings.Count(fracPart, "0")
+	maxFrac := minFrac + strings.Count(fracPart, "#")
+
+	s := strconv.FormatFloat(f, 'f', maxFrac, 64)
+	digits, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		digits, frac = s[:i], s[i+1:]
+	}
+	for len(frac) > minFrac && strings.HasSuffix(frac, "0") {
+		frac = frac[:len(frac)-1]
+	}
+	digits = strings.TrimLeft(digits, "0")
+	for len(digits) < minInt {
+		digits = "0" + digits
+	}
+	if group > 0 && len(digits) > group {
+		var b strings.Builder
+		for i, d := range digits {
+			if i > 0 && (len(digits)-i)%group == 0 {
+				b.WriteByte(',')
+			}
+			b.WriteRune(d)
+		}
+		digits = b.String()
+	}
+	if digits == "" && frac == "" {
+		digits = "0"
+	}
+	out := prefix + digits
+	if frac != "" {
+		out += "." + frac
+	}
+	return out + suffix, nil
+}
+
+// formatNumber executes xsl:number.
+func (t *transform) formatNumber(in *number, f *frame) (string, error) {
+	var nums []int
+	if in.value != nil {
+		v, err := t.eval(in.value, f)
+		if err != nil {
+			return "", err
+		}
+		n := xmltree.XPathNumber(v)
+		if math.IsNaN(n) || math.IsInf(n, 0) || n < 0.5 {
+			return xmltree.XPathString(n), nil
+		}
+		nums = []int{int(math.Floor(n + 0.5))}
+	} else {
+		var err error
+		if nums, err = t.count(in, f.node); err != nil {
+			return "", err
+		}
+	}
+	format, err := t.avt(in.format, f)
+	if err != nil {
+		return "", err
+	}
+	sep, err := t.avt(in.groupSep, f)
+	if err != nil {
+		return "", err
+	}
+	size, err := t.avt(in.groupSize, f)
+	if err != nil {
+		return "", err
+	}
+	group, _ := strconv.Atoi(size)
+	if sep == "" {
+		group = 0
+	}
+	return formatList(nums, format, sep, group), nil
+}
+
+// count returns the numbers of a node for xsl:number without a value.
+func (t *transform) count(in *number, n xmltree.Node) ([]int, error) {
+	counted := func(m xmltree.Node) (bool, error) {
+		if in.count != nil {
+			return t.matchesPattern(in.count, m)
+		}
+		return m.Type == n.Type && m.Name() == n.Name(), nil
+	}
+	isFrom := func(m xmltree.Node) (bool, error) {
+		if in.from == nil {
+			return false, nil
+		}
+		return t.matchesPattern(in.from, m)
+	}
+	// The node and its ancestors, innermost first, stopping at a
+	// match of from.
+	var chain []xmltree.Node
+	for m, ok := n, true; ok; m, ok = t.c.Parent(m) {
+		if stop, err := isFrom(m); err != nil || stop {
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+		chain = append(chain, m)
+	}
+
+	if in.level == "any" {
+		nodes, err := t.c.Select(anyPreceding, n)
+		if err != nil {
+			return nil, err
+		}
+		total := 0
+		for _, m := range nodes {
+			stop, err := isFrom(m)
+			if err != nil {
+				return nil, err
+			}
+			if stop {
+				total = 0
+				continue
+			}
+			ok, err := counted(m)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				total++
+			}
+		}
+		if total == 0 {
+			return nil, nil
+		}
+		return []int{total}, nil
+	}
+
+	var nums []int
+	for _, m := range chain {
+		ok, err := counted(m)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		sibs, err := t.c.Select(precedingSiblings, m)
+		if err != nil {
+			return nil, err
+		}
+		num := 1
+		for _, s := range sibs {
+			if ok, err := counted(s); err != nil {
+				return nil, err
+			} else if ok {
+				num++
+			}
+		}
+		nums = append([]int{num}, nums...)
+		if in.level == "single" {
+			break
+		}
+	}
+	return nums, nil
+}
+
+var (
+	anyPreceding      = xmltree.MustCompileXPath("preceding::node() | ancestor-or-self::node()")
+	precedingSiblings = xmltree.MustCompileXPath("preceding-sibling::node()")
+)
+
+// formatList formats numbers with the format attribute of xsl:number.
+func formatList(nums []int, format, sep string, group int) string {
+	// Split the format into alternating runs of alphanumeric tokens
+	// and separators.
+	var runs []string
+	var alnum []bool
+	for _, r := range format {
+		a := '0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z'
+		if len(runs) == 0 || alnum[len(alnum)-1] != a {
+			runs = append(runs, "")
+			alnum = append(alnum, a)
+		}
+		runs[len(runs)-1] += string(r)
+	}
+	var prefix, suffix string
+	if len(runs) > 0 && !alnum[0] {
+		prefix, runs, alnum = runs[0], runs[1:], alnum[1:]
+	}
+	if len(runs) > 0 && !alnum[len(alnum)-1] {
+		suffix, runs = runs[len(runs)-1], runs[:len(runs)-1]
+	}
+	var tokens, seps []string
+	for i, r := range runs {
+		if i%2 == 0 {
+			tokens = append(tokens, r)
+		} else {
+			seps = append(seps, r)
+		}
+	}
+	if len(tokens) == 0 {
+		tokens = []string{"1"}
+	}
+
+	var b strings.Builder
+	b.WriteString(prefix)
+	for i, n := range nums {
+		tok := tokens[len(tokens)-1]
+		if i < len(tokens) {
+			tok = tokens[i]
+		}
+		if i > 0 {
+			s := "."
+			if i-1 < len(seps) {
+				s = seps[i-1]
+			} else if len(seps) > 0 {
+				s = seps[len(seps)-1]
+			}
+			b.WriteString(s)
+		}
+		b.WriteString(formatToken(n, tok, sep, group))
+	}
+	b.WriteString(suffix)
+	return b.String()
+}
+
+func formatToken(n int, tok, sep string, group int) string {
+	switch tok {
+	case "a", "A":
+		s := alphabetic(n)
+		if tok == "A" {
+			s = strings.ToUpper(s)
+		}
+		return s
+	case "i", "I":
+		s := roman(n)
+		if tok == "i" {
+			s = strings.ToLower(s)
+		}
+		return s
+	}
+	s := strconv.Itoa(n)
+	if strings.Trim(tok, "0123456789") == "" {
+		for len(s) < len(tok) {
+			s = "0" + s
+		}
+	}
+	if group > 0 && len(s) > group {
+		var b strings.Builder
+		for i, d := range s {
+			if i > 0 && (len(s)-i)%group == 0 {
+				b.WriteString(sep)
+			}
+			b.WriteRune(d)
+		}
+		s = b.String()
+	}
+	return s
+}
+
+// alphabetic numbers as a, b, ..., z, aa, ab, ...
+func alphabetic(n int) string {
+	var s []byte
+	for n > 0 {
+		n--
+		s = append([]byte{byte('a' + n%26)}, s...)
+		n /= 26
+	}
+	return string(s)
+}
+
+func roman(n int) string {
+	if n <= 0 || n >= 4000 {
+		return strconv.Itoa(n)
+	}
+	values := []int{1000, 900, 500, 400, 100, 90, 50, 40, 10, 9, 5, 4, 1}
+	symbols := []string{"M", "CM", "D", "CD", "C", "XC", "L", "XL", "X", "IX", "V", "IV", "I"}
+	var b strings.Builder
+	for i, v := range values {
+		for n >= v {
+			b.WriteString(symbols[i])
+			n -= v
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,159 @@
+package xslt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"github.com/pschou/go-xmltree"
+)
+
+// method returns the output method for a result tree.
+func (o *Output) method(result *xmltree.Element) string {
+	if o.Method != "" {
+		return o.Method
+	}
+	for i := range result.Children {
+		switch n := &result.Children[i]; n.Type {
+		case xmltree.XML_Tag:
+			if n.Name.Space == "" && strings.EqualFold(n.Name.Local, "html") {
+				return "html"
+			}
+			return "xml"
+		case xmltree.XML_CharData:
+			if strings.TrimSpace(n.Content) != "" {
+				return "xml"
+			}
+		}
+	}
+	return "xml"
+}
+
+// write writes a result tree to w.
+func (o *Output) write(w io.Writer, result *xmltree.Element) error {
+	bw := bufio.NewWriter(w)
+	method := o.method(result)
+	if method == "text" {
+		bw.WriteString(result.Text())
+		return bw.Flush()
+	}
+	if method == "xml" && !o.OmitXMLDeclaration {
+		version := o.Version
+		if version == "" {
+			version = "1.0"
+		}
+		bw.WriteString(`<?xml version="` + version + `" encoding="UTF-8"`)
+		if o.Standalone != "" {
+			bw.WriteString(` standalone="` + o.Standalone + `"`)
+		}
+		bw.WriteString("?>\n")
+	}
+	o.cdata(result)
+	o.doctype(bw, method, result)
+	for i := range result.Children {
+		n := &result.Children[i]
+		switch n.Type {
+		case xmltree.XML_Tag:
+			var err error
+			switch {
+			case method == "html":
+				err = encodeHTML(bw, n)
+			case o.Indent:
+				err = xmltree.Format(bw, n, nil)
+			default:
+				err = xmltree.Encode(bw, n)
+			}
+			if err != nil {
+				return err
+			}
+		case xmltree.XML_CharData:
+			xml.EscapeText(bw, []byte(n.Content))
+		case xmltree.XML_Comment:
+			bw.WriteString("<!--" + n.Content + "-->")
+		case xmltree.XML_ProcInst:
+			bw.WriteString("<?" + n.Name.Local)
+			if n.Content != "" {
+				bw.WriteString(" " + n.Content)
+			}
+			bw.WriteString("?>")
+		}
+	}
+	if method == "xml" && !o.Indent {
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
+
+// doctype writes the document type declaration for the document
+// element of a result tree.
+func (o *Output) doctype(w *bufio.Writer, method string, result *xmltree.Element) {
+	switch {
+	case method == "xml" && o.DoctypeSystem == "":
+		return
+	case method == "html" && o.DoctypeSystem == "" && o.DoctypePublic == "":
+		return
+	}
+	var root *xmltree.Element
+	for i := range result.Children {
+		if result.Children[i].Type == xmltree.XML_Tag {
+			root = &result.Children[i]
+			break
+		}
+	}
+	if root == nil {
+		return
+	}
+	w.WriteString("<!DOCTYPE " + root.Prefix(root.Name))
+	if o.DoctypePublic != "" {
+		w.WriteString(` PUBLIC "` + o.DoctypePublic + `"`)
+	} else {
+		w.WriteString(" SYSTEM")
+	}
+	if o.DoctypeSystem != "" {
+		w.WriteString(` "` + o.DoctypeSystem + `"`)
+	}
+	w.WriteString(">\n")
+}
+
+// encodeHTML writes el as HTML, without the <!DOCTYPE html> which
+// xmltree.EncodeHTML writes before an html element; the stylesheet's
+// doctype-public and doctype-system decide the declaration.
+func encodeHTML(w io.Writer, el *xmltree.Element) error {
+	var buf bytes.Buffer
+	if err := xmltree.EncodeHTML(&buf, el); err != nil {
+		return err
+	}
+	_, err := w.Write(bytes.TrimPrefix(buf.Bytes(), []byte("<!DOCTYPE html>")))
+	return err
+}
+
+// cdata moves the text of the elements named by cdata-section-elements
+// into CDATA sections.
+func (o *Output) cdata(el *xmltree.Element) {
+	if len(o.CDATASectionElements) == 0 {
+		return
+	}
+	for i := range el.Children {
+		c := &el.Children[i]
+		if c.Type != xmltree.XML_Tag {
+			continue
+		}
+		for _, name := range o.CDATASectionElements {
+			if c.Name != name {
+				continue
+			}
+			if len(c.Children) == 0 && c.Content != "" {
+				c.Children = []xmltree.Element{{Type: xmltree.XML_CDATA, Content: c.Content}}
+				c.Content = ""
+			}
+			for j := range c.Children {
+				if c.Children[j].Type == xmltree.XML_CharData {
+					c.Children[j].Type = xmltree.XML_CDATA
+				}
+			}
+		}
+		o.cdata(c)
+	}
+}
@@ -0,0 +1,1112 @@
+package xslt
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pschou/go-xmltree"
+)
+
+// maxDepth limits the nesting of templates, to stop runaway recursion.
+const maxDepth = 1000
+
+// Transform applies the stylesheet to the document rooted at doc, and
+// returns the document element of the result tree. params holds the
+// values of the stylesheet's global parameters, as strings, float64s,
+// bools or []xmltree.Nodes of doc. It is an error if the result is not
+// a single element, as is usual for the text output method; use
+// TransformTo for those.
+func (s *Stylesheet) Transform(doc *xmltree.Element, params map[string]interface{}) (*xmltree.Element, error) {
+	result, err := s.run(doc, params)
+	if err != nil {
+		return nil, err
+	}
+	var root *xmltree.Element
+	for i := range result.Children {
+		switch n := &result.Children[i]; n.Type {
+		case xmltree.XML_Tag:
+			if root != nil {
+				return nil, errors.New("xslt: result has more than one document element")
+			}
+			root = n
+		case xmltree.XML_CharData:
+			if strings.TrimSpace(n.Content) != "" {
+				return nil, errors.New("xslt: result has text outside the document element")
+			}
+		}
+	}
+	if root == nil {
+		return nil, errors.New("xslt: result has no document element")
+	}
+	return root, nil
+}
+
+// TransformTo applies the stylesheet to the document rooted at doc, and
+// writes the result to w as set by the Output of the stylesheet.
+func (s *Stylesheet) TransformTo(w io.Writer, doc *xmltree.Element, params map[string]interface{}) error {
+	result, err := s.run(doc, params)
+	if err != nil {
+		return err
+	}
+	return s.Output.write(w, result)
+}
+
+// A transform is the state of one application of a stylesheet.
+type transform struct {
+	s       *Stylesheet
+	c       *xmltree.XPathContext
+	current xmltree.Node // for current()
+	params  map[string]interface{}
+	globals map[string]interface{}
+	pending map[*variable]bool // globals being evaluated
+	matched map[matchKey]map[xmltree.Node]bool
+	keys    map[xml.Name]map[string][]xmltree.Node
+	order   map[*xmltree.Element]int
+	frag    map[*xmltree.Element]*xmltree.Element // the root of each fragment
+	ids     map[xmltree.Node]int
+	decls   map[xml.Name]*xmltree.Scope
+	depth   int
+}
+
+type matchKey struct {
+	alt  *alt
+	root xmltree.Node
+}
+
+// A frame is the context of an instruction.
+type frame struct {
+	node      xmltree.Node
+	pos, size int
+	vars      map[string]interface{}
+	rule      *rule // the template rule being applied
+	mode      xml.Name
+}
+
+func (s *Stylesheet) run(doc *xmltree.Element, params map[string]interface{}) (*xmltree.Element, error) {
+	if len(s.space) > 0 {
+		doc = doc.Clone()
+		s.stripSpace(doc, false)
+	}
+	t := &transform{
+		s:       s,
+		c:       xmltree.NewXPathContext(doc),
+		params:  params,
+		globals: make(map[string]interface{}),
+		pending: make(map[*variable]bool),
+		matched: make(map[matchKey]map[xmltree.Node]bool),
+		keys:    make(map[xml.Name]map[string][]xmltree.Node),
+		order:   make(map[*xmltree.Element]int),
+		frag:    make(map[*xmltree.Element]*xmltree.Element),
+		ids:     make(map[xmltree.Node]int),
+		decls:   make(map[xml.Name]*xmltree.Scope),
+	}
+	t.number(doc)
+	t.c.Functions = t.functions()
+
+	root := t.c.Root()
+	for _, v := range s.globals {
+		if err := t.global(v); err != nil {
+			return nil, err
+		}
+	}
+	out := &xmltree.Element{Type: xmltree.XML_Tag}
+	f := &frame{node: root, pos: 1, size: 1, vars: t.globals}
+	if err := t.apply([]xmltree.Node{root}, f, xml.Name{}, nil, out); err != nil {
+		return nil, err
+	}
+	finish(out)
+	return out, nil
+}
+
+// number records the document order of the elements of a tree.
+func (t *transform) number(el *xmltree.Element) {
+	t.order[el] = len(t.order)
+	for i := range el.Children {
+		t.number(&el.Children[i])
+	}
+}
+
+// stripSpace removes text of nothing but white space from the elements
+// named by xsl:strip-space.
+func (s *Stylesheet) stripSpace(el *xmltree.Element, preserve bool) {
+	switch el.Attr(xmlNS, "space") {
+	case "preserve":
+		preserve = true
+	case "default":
+		preserve = false
+	}
+	strip := !preserve && s.strips(el.Name)
+	if strip && len(el.Children) == 0 && strings.TrimSpace(el.Content) == "" {
+		el.Content = ""
+	}
+	kids := el.Children[:0]
+	for i := range el.Children {
+		child := &el.Children[i]
+		if strip && child.Type == xmltree.XML_CharData && strings.TrimSpace(child.Content) == "" {
+			continue
+		}
+		if child.Type == xmltree.XML_Tag {
+			s.stripSpace(child, preserve)
+		}
+		kids = append(kids, *child)
+	}
+	el.Children = kids
+}
+
+// strips reports whether white space is stripped from an element,
+// following the xsl:strip-space or xsl:preserve-space rule which
+// matches it best.
+func (s *Stylesheet) strips(name xml.Name) bool {
+	var best *spaceRule
+	for i := range s.space {
+		r := &s.space[i]
+		if !r.any && r.name != name && (r.name.Local != "*" || r.name.Space != name.Space) {
+			continue
+		}
+		if best == nil || r.prec > best.prec || r.prec == best.prec && r.priority >= best.priority {
+			best = r
+		}
+	}
+	return best != nil && best.strip
+}
+
+// eval evaluates an expression in the context of f.
+func (t *transform) eval(e *expr, f *frame) (interface{}, error) {
+	ns, vars, cur := t.c.Namespaces, t.c.Variables, t.current
+	defer func() { t.c.Namespaces, t.c.Variables, t.current = ns, vars, cur }()
+	t.c.Namespaces, t.c.Variables, t.current = e.ns, f.vars, f.node
+	return t.c.EvalAt(e.x, f.node, f.pos, f.size)
+}
+
+func (t *transform) evalString(e *expr, f *frame) (string, error) {
+	v, err := t.eval(e, f)
+	return xmltree.XPathString(v), err
+}
+
+// selectNodes evaluates an expression which must select nodes.
+func (t *transform) selectNodes(e *expr, f *frame, what string) ([]xmltree.Node, error) {
+	v, err := t.eval(e, f)
+	if err != nil {
+		return nil, err
+	}
+	nodes, ok := v.([]xmltree.Node)
+	if !ok {
+		return nil, fmt.Errorf("xslt: %s %q does not select nodes", what, e.x)
+	}
+	return nodes, nil
+}
+
+func (t *transform) avt(a avt, f *frame) (string, error) {
+	if len(a) == 1 && a[0].e == nil {
+		return a[0].text, nil
+	}
+	var b strings.Builder
+	for _, p := range a {
+		if p.e == nil {
+			b.WriteString(p.text)
+			continue
+		}
+		s, err := t.evalString(p.e, f)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(s)
+	}
+	return b.String(), nil
+}
+
+// global evaluates a global variable, first evaluating those it refers
+// to.
+func (t *transform) global(v *variable) error {
+	if _, ok := t.globals[v.name]; ok {
+		return nil
+	}
+	if t.pending[v] {
+		return fmt.Errorf("xslt: global variable $%s refers to itself", v.name)
+	}
+	t.pending[v] = true
+	defer delete(t.pending, v)
+	for _, name := range v.refs {
+		for _, g := range t.s.globals {
+			if g.name == name {
+				if err := t.global(g); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if p, ok := t.params[v.name]; ok && v.param {
+		t.globals[v.name] = p
+		return nil
+	}
+	root := t.c.Root()
+	val, err := t.value(v, &frame{node: root, pos: 1, size: 1, vars: t.globals})
+	if err != nil {
+		return err
+	}
+	t.globals[v.name] = val
+	return nil
+}
+
+// value evaluates a variable or parameter.
+func (t *transform) value(v *variable, f *frame) (interface{}, error) {
+	switch {
+	case v.sel != nil:
+		return t.eval(v.sel, f)
+	case len(v.body) > 0:
+		return t.fragment(v.body, f)
+	}
+	return "", nil
+}
+
+// fragment evaluates a sequence of instructions as a result tree
+// fragment. It is held in an element with no name, which stands for
+// the root of the fragment, and may be used as a node-set.
+func (t *transform) fragment(body seq, f *frame) (interface{}, error) {
+	root := &xmltree.Element{Type: xmltree.XML_Tag}
+	if err := t.exec(body, f, root); err != nil {
+		return nil, err
+	}
+	finish(root)
+	var index func(el *xmltree.Element)
+	index = func(el *xmltree.Element) {
+		t.frag[el] = root
+		for i := range el.Children {
+			index(&el.Children[i])
+		}
+	}
+	index(root)
+	return []xmltree.Node{{Type: xmltree.ElementNode, Element: root}}, nil
+}
+
+// with returns a copy of vars with a variable added.
+func with(vars map[string]interface{}, name string, v interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(vars)+1)
+	for k, x := range vars {
+		m[k] = x
+	}
+	m[name] = v
+	return m
+}
+
+// exec executes a sequence of instructions, adding the result to out.
+func (t *transform) exec(body seq, f *frame, out *xmltree.Element) error {
+	for _, in := range body {
+		if err := t.instruction(in, f, out); err != nil {
+			return err
+		}
+		if v, ok := in.(*variable); ok {
+			// The variable is visible to the instructions after it.
+			val, err := t.value(v, f)
+			if err != nil {
+				return err
+			}
+			next := *f
+			next.vars = with(f.vars, v.name, val)
+			f = &next
+		}
+	}
+	return nil
+}
+
+func (t *transform) instruction(in interface{}, f *frame, out *xmltree.Element) error {
+	switch in := in.(type) {
+	case *variable:
+	case textInstr:
+		appendText(out, string(in))
+	case *literal:
+		el, err := t.newElement(out, in.name, in.prefix, in.ns)
+		if err != nil {
+			return err
+		}
+		if err := t.useSets(in.sets, f, el, nil); err != nil {
+			return err
+		}
+		for _, a := range in.attrs {
+			v, err := t.avt(a.value, f)
+			if err != nil {
+				return err
+			}
+			if err := t.setAttr(el, a.name, a.prefix, v); err != nil {
+				return err
+			}
+		}
+		if err := t.exec(in.body, f, el); err != nil {
+			return err
+		}
+		out.Children = append(out.Children, *el)
+	case *applyTemplates:
+		var nodes []xmltree.Node
+		var err error
+		if in.sel == nil {
+			nodes, err = t.c.Select(children, f.node)
+		} else {
+			nodes, err = t.selectNodes(in.sel, f, "apply-templates select")
+		}
+		if err != nil {
+			return err
+		}
+		if nodes, err = t.sort(nodes, in.sorts, f); err != nil {
+			return err
+		}
+		params, err := t.withParams(in.params, f)
+		if err != nil {
+			return err
+		}
+		return t.apply(nodes, f, in.mode, params, out)
+	case *callTemplate:
+		tmpl, ok := t.s.named[in.name]
+		if !ok {
+			return fmt.Errorf("xslt: no template named %s", in.name.Local)
+		}
+		params, err := t.withParams(in.params, f)
+		if err != nil {
+			return err
+		}
+		next := *f
+		return t.invoke(tmpl, &next, params, out)
+	case applyImports:
+		if f.rule == nil {
+			return errors.New("xslt: xsl:apply-imports outside a template rule")
+		}
+		r, err := t.find(f.node, f.mode, f.rule.t.prec)
+		if err != nil {
+			return err
+		}
+		next := *f
+		if r == nil {
+			return t.builtin(&next, out)
+		}
+		next.rule = r
+		return t.invoke(r.t, &next, nil, out)
+	case *forEach:
+		nodes, err := t.selectNodes(in.sel, f, "for-each select")
+		if err != nil {
+			return err
+		}
+		if nodes, err = t.sort(nodes, in.sorts, f); err != nil {
+			return err
+		}
+		for i, n := range nodes {
+			next := &frame{node: n, pos: i + 1, size: len(nodes), vars: f.vars, mode: f.mode}
+			if err := t.exec(in.body, next, out); err != nil {
+				return err
+			}
+		}
+	case *valueOf:
+		s, err := t.evalString(in.sel, f)
+		if err != nil {
+			return err
+		}
+		appendText(out, s)
+	case *copyOf:
+		v, err := t.eval(in.sel, f)
+		if err != nil {
+			return err
+		}
+		nodes, ok := v.([]xmltree.Node)
+		if !ok {
+			appendText(out, xmltree.XPathString(v))
+			return nil
+		}
+		for _, n := range nodes {
+			if err := t.copy(n, out); err != nil {
+				return err
+			}
+		}
+	case *copyNode:
+		return t.shallowCopy(in, f, out)
+	case *element:
+		name, prefix, err := t.computedName(in.name, in.space, in.hasSpace, in.scope, f, false)
+		if err != nil {
+			return err
+		}
+		el, err := t.newElement(out, name, prefix, nil)
+		if err != nil {
+			return err
+		}
+		if err := t.useSets(in.sets, f, el, nil); err != nil {
+			return err
+		}
+		if err := t.exec(in.body, f, el); err != nil {
+			return err
+		}
+		out.Children = append(out.Children, *el)
+	case *attribute:
+		name, prefix, err := t.computedName(in.name, in.space, in.hasSpace, in.scope, f, true)
+		if err != nil {
+			return err
+		}
+		v, err := t.text(in.body, f)
+		if err != nil {
+			return err
+		}
+		return t.setAttr(out, name, prefix, v)
+	case *comment:
+		v, err := t.text(in.body, f)
+		if err != nil {
+			return err
+		}
+		out.Children = append(out.Children, xmltree.Element{Type: xmltree.XML_Comment, Content: v})
+	case *procInst:
+		name, err := t.avt(in.name, f)
+		if err != nil {
+			return err
+		}
+		v, err := t.text(in.body, f)
+		if err != nil {
+			return err
+		}
+		pi := xmltree.Element{Type: xmltree.XML_ProcInst, Content: v}
+		pi.Name.Local = name
+		out.Children = append(out.Children, pi)
+	case *ifInstr:
+		ok := true
+		if in.test != nil {
+			v, err := t.eval(in.test, f)
+			if err != nil {
+				return err
+			}
+			ok = xmltree.XPathBool(v)
+		}
+		if ok {
+			return t.exec(in.body, f, out)
+		}
+	case *choose:
+		for _, w := range in.whens {
+			v, err := t.eval(w.test, f)
+			if err != nil {
+				return err
+			}
+			if xmltree.XPathBool(v) {
+				return t.exec(w.body, f, out)
+			}
+		}
+		return t.exec(in.otherwise, f, out)
+	case *message:
+		v, err := t.text(in.body, f)
+		if err != nil {
+			return err
+		}
+		if in.terminate {
+			return fmt.Errorf("xslt: terminated by xsl:message: %s", v)
+		}
+		if t.s.messages != nil {
+			fmt.Fprintln(t.s.messages, v)
+		}
+	case *number:
+		s, err := t.formatNumber(in, f)
+		if err != nil {
+			return err
+		}
+		appendText(out, s)
+	default:
+		return fmt.Errorf("xslt: unknown instruction %T", in)
+	}
+	return nil
+}
+
+// text evaluates a sequence of instructions as a string, such as the
+// value of an attribute.
+func (t *transform) text(body seq, f *frame) (string, error) {
+	if len(body) == 1 {
+		if s, ok := body[0].(textInstr); ok {
+			return string(s), nil
+		}
+	}
+	tmp := &xmltree.Element{Type: xmltree.XML_Tag}
+	if err := t.exec(body, f, tmp); err != nil {
+		return "", err
+	}
+	return tmp.Text(), nil
+}
+
+// withParams evaluates the xsl:with-param children of an instruction.
+func (t *transform) withParams(params []*variable, f *frame) (map[string]interface{}, error) {
+	if len(params) == 0 {
+		return nil, nil
+	}
+	m := make(map[string]interface{}, len(params))
+	for _, p := range params {
+		v, err := t.value(p, f)
+		if err != nil {
+			return nil, err
+		}
+		m[p.name] = v
+	}
+	return m, nil
+}
+
+// apply applies the templates of a mode to each of a list of nodes.
+func (t *transform) apply(nodes []xmltree.Node, f *frame, mode xml.Name, params map[string]interface{}, out *xmltree.Element) error {
+	for i, n := range nodes {
+		next := &frame{node: n, pos: i + 1, size: len(nodes), vars: f.vars, mode: mode}
+		r, err := t.find(n, mode, math.MaxInt32)
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			if err := t.builtin(next, out); err != nil {
+				return err
+			}
+			continue
+		}
+		next.rule = r
+		if err := t.invoke(r.t, next, params, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// find returns the template rule of a mode which matches n, among those
+// with an import precedence below prec, or nil if there is none.
+func (t *transform) find(n xmltree.Node, mode xml.Name, prec int) (*rule, error) {
+	for _, r := range t.s.modes[mode] {
+		if r.t.prec >= prec {
+			continue
+		}
+		ok, err := t.matches(r.alt, n)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return r, nil
+		}
+	}
+	return nil, nil
+}
+
+// root returns the root of the tree holding n, which is the document
+// node or the root of a result tree fragment.
+func (t *transform) root(n xmltree.Node) xmltree.Node {
+	if root, ok := t.frag[n.Element]; ok {
+		return xmltree.Node{Type: xmltree.ElementNode, Element: root}
+	}
+	return t.c.Root()
+}
+
+// matches reports whether an alternative of a pattern matches n. The
+// nodes matched are found once for each tree.
+func (t *transform) matches(a *alt, n xmltree.Node) (bool, error) {
+	root := t.root(n)
+	if a.root {
+		return n == root && n.Type == xmltree.DocumentNode, nil
+	}
+	k := matchKey{a, root}
+	set, ok := t.matched[k]
+	if !ok {
+		nodes, err := t.selectNodes(a.e, &frame{node: root, pos: 1, size: 1, vars: t.globals}, "pattern")
+		if err != nil {
+			return false, err
+		}
+		set = make(map[xmltree.Node]bool, len(nodes))
+		for _, m := range nodes {
+			set[m] = true
+		}
+		t.matched[k] = set
+	}
+	return set[n], nil
+}
+
+// matchesPattern reports whether any alternative of p matches n.
+func (t *transform) matchesPattern(p *pattern, n xmltree.Node) (bool, error) {
+	for _, a := range p.alts {
+		if ok, err := t.matches(a, n); ok || err != nil {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// invoke instantiates a template, binding its parameters.
+func (t *transform) invoke(tmpl *template, f *frame, params map[string]interface{}, out *xmltree.Element) error {
+	if t.depth >= maxDepth {
+		return fmt.Errorf("xslt: templates nested more than %d deep", maxDepth)
+	}
+	t.depth++
+	defer func() { t.depth-- }()
+	f.vars = t.globals
+	for _, p := range tmpl.params {
+		v, ok := params[p.name]
+		if !ok {
+			var err error
+			if v, err = t.value(p, f); err != nil {
+				return err
+			}
+		}
+		f.vars = with(f.vars, p.name, v)
+	}
+	return t.exec(tmpl.body, f, out)
+}
+
+// builtin applies the built-in template rules.
+func (t *transform) builtin(f *frame, out *xmltree.Element) error {
+	switch f.node.Type {
+	case xmltree.DocumentNode, xmltree.ElementNode:
+		nodes, err := t.c.Select(children, f.node)
+		if err != nil {
+			return err
+		}
+		return t.apply(nodes, f, f.mode, nil, out)
+	case xmltree.TextNode, xmltree.AttributeNode:
+		appendText(out, f.node.String())
+	}
+	return nil
+}
+
+// sort sorts nodes by the keys of xsl:sort elements.
+func (t *transform) sort(nodes []xmltree.Node, keys []*sortKey, f *frame) ([]xmltree.Node, error) {
+	if len(keys) == 0 {
+		return nodes, nil
+	}
+	type sortable struct {
+		node xmltree.Node
+		text []string
+		num  []float64
+	}
+	type keyOpts struct {
+		desc, number, upperFirst bool
+	}
+	opts := make([]keyOpts, len(keys))
+	for i, k := range keys {
+		order, err := t.avt(k.order, f)
+		if err != nil {
+			return nil, err
+		}
+		dataType, err := t.avt(k.dataType, f)
+		if err != nil {
+			return nil, err
+		}
+		caseOrder, err := t.avt(k.caseOrder, f)
+		if err != nil {
+			return nil, err
+		}
+		opts[i] = keyOpts{order == "descending", dataType == "number", caseOrder == "upper-first"}
+	}
+	items := make([]sortable, len(nodes))
+	for i, n := range nodes {
+		items[i].node = n
+		kf := &frame{node: n, pos: i + 1, size: len(nodes), vars: f.vars, mode: f.mode}
+		for j, k := range keys {
+			v, err := t.eval(k.sel, kf)
+			if err != nil {
+				return nil, err
+			}
+			if opts[j].number {
+				items[i].num = append(items[i].num, xmltree.XPathNumber(v))
+				items[i].text = append(items[i].text, "")
+			} else {
+				items[i].num = append(items[i].num, 0)
+				items[i].text = append(items[i].text, xmltree.XPathString(v))
+			}
+		}
+	}
+	sort.SliceStable(items, func(a, b int) bool {
+		for j, o := range opts {
+			var c int
+			if o.number {
+				c = compareNumbers(items[a].num[j], items[b].num[j])
+			} else {
+				c = compareText(items[a].text[j], items[b].text[j], o.upperFirst)
+			}
+			if o.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	sorted := make([]xmltree.Node, len(items))
+	for i := range items {
+		sorted[i] = items[i].node
+	}
+	return sorted, nil
+}
+
+// compareNumbers orders numbers, with NaN before all others.
+func compareNumbers(a, b float64) int {
+	switch {
+	case math.IsNaN(a) && math.IsNaN(b):
+		return 0
+	case math.IsNaN(a):
+		return -1
+	case math.IsNaN(b):
+		return 1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareText orders strings ignoring case, and then by case.
+func compareText(a, b string, upperFirst bool) int {
+	if c := strings.Compare(strings.ToLower(a), strings.ToLower(b)); c != 0 {
+		return c
+	}
+	c := strings.Compare(a, b)
+	if !upperFirst {
+		c = -c
+	}
+	return c
+}
+
+// useSets adds the attributes of named attribute sets to el.
+func (t *transform) useSets(names []xml.Name, f *frame, el *xmltree.Element, seen map[xml.Name]bool) error {
+	for _, name := range names {
+		sets, ok := t.s.attrSets[name]
+		if !ok {
+			return fmt.Errorf("xslt: no attribute set named %s", name.Local)
+		}
+		if seen[name] {
+			return fmt.Errorf("xslt: attribute set %s uses itself", name.Local)
+		}
+		if seen == nil {
+			seen = make(map[xml.Name]bool)
+		}
+		seen[name] = true
+		for _, set := range sets {
+			if err := t.useSets(set.uses, f, el, seen); err != nil {
+				return err
+			}
+			// Attribute sets see only global variables.
+			gf := *f
+			gf.vars = t.globals
+			if err := t.exec(set.attrs, &gf, el); err != nil {
+				return err
+			}
+		}
+		delete(seen, name)
+	}
+	return nil
+}
+
+// computedName evaluates the name and namespace of xsl:element or
+// xsl:attribute, returning the name and the prefix to use for it.
+func (t *transform) computedName(nameAVT, spaceAVT avt, hasSpace bool, scope xmltree.Scope, f *frame, isAttr bool) (xml.Name, string, error) {
+	q, err := t.avt(nameAVT, f)
+	if err != nil {
+		return xml.Name{}, "", err
+	}
+	q = strings.TrimSpace(q)
+	prefix, local := "", q
+	if i := strings.IndexByte(q, ':'); i >= 0 {
+		prefix, local = q[:i], q[i+1:]
+	}
+	if !isNCName(local) || prefix != "" && !isNCName(prefix) || isAttr && q == "xmlns" {
+		return xml.Name{}, "", fmt.Errorf("xslt: %q is not a valid name", q)
+	}
+	name := xml.Name{Local: local}
+	switch {
+	case hasSpace:
+		if name.Space, err = t.avt(spaceAVT, f); err != nil {
+			return xml.Name{}, "", err
+		}
+	case prefix != "":
+		n, ok := scope.ResolveNS(q)
+		if !ok {
+			return xml.Name{}, "", fmt.Errorf("xslt: undeclared prefix in name %q", q)
+		}
+		name.Space = n.Space
+	case !isAttr:
+		name.Space = scope.Resolve(local).Space
+	}
+	if prefix == "xml" {
+		name.Space = xmlNS
+	}
+	return name, prefix, nil
+}
+
+func isNCName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r == ':' || !(r == '_' || xmlLetter(r) || i > 0 && (r == '-' || r == '.' || '0' <= r && r <= '9' || r == 0xB7)) {
+			return false
+		}
+	}
+	return true
+}
+
+func xmlLetter(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || r >= 0xC0 && r != 0xD7 && r != 0xF7
+}
+
+// shallowCopy executes xsl:copy.
+func (t *transform) shallowCopy(in *copyNode, f *frame, out *xmltree.Element) error {
+	n := f.node
+	switch n.Type {
+	case xmltree.DocumentNode:
+		return t.exec(in.body, f, out)
+	case xmltree.ElementNode:
+		if root, ok := t.frag[n.Element]; ok && root == n.Element {
+			return t.exec(in.body, f, out)
+		}
+		ns, err := t.namespaceNodes(n)
+		if err != nil {
+			return err
+		}
+		el, err := t.newElement(out, n.Element.Name, prefixOf(n.Element, n.Element.Name), ns)
+		if err != nil {
+			return err
+		}
+		if err := t.useSets(in.sets, f, el, nil); err != nil {
+			return err
+		}
+		if err := t.exec(in.body, f, el); err != nil {
+			return err
+		}
+		out.Children = append(out.Children, *el)
+		return nil
+	}
+	return t.copy(n, out)
+}
+
+// copy adds a deep copy of a node to out.
+func (t *transform) copy(n xmltree.Node, out *xmltree.Element) error {
+	switch n.Type {
+	case xmltree.DocumentNode:
+		return t.copyChildren(n, out)
+	case xmltree.ElementNode:
+		if root, ok := t.frag[n.Element]; ok && root == n.Element {
+			return t.copyChildren(n, out)
+		}
+		ns, err := t.namespaceNodes(n)
+		if err != nil {
+			return err
+		}
+		el, err := t.newElement(out, n.Element.Name, prefixOf(n.Element, n.Element.Name), ns)
+		if err != nil {
+			return err
+		}
+		for _, a := range n.Element.StartElement.Attr {
+			if err := t.setAttr(el, a.Name, prefixOf(n.Element, a.Name), a.Value); err != nil {
+				return err
+			}
+		}
+		if err := t.copyChildren(n, el); err != nil {
+			return err
+		}
+		out.Children = append(out.Children, *el)
+	case xmltree.AttributeNode:
+		a := n.Attr()
+		return t.setAttr(out, a.Name, prefixOf(n.Element, a.Name), a.Value)
+	case xmltree.TextNode:
+		appendText(out, n.String())
+	case xmltree.CommentNode, xmltree.ProcInstNode:
+		c := *n.Element
+		c.Scope = xmltree.Scope{}
+		out.Children = append(out.Children, c)
+	}
+	return nil
+}
+
+func (t *transform) copyChildren(n xmltree.Node, out *xmltree.Element) error {
+	kids, err := t.c.Select(children, n)
+	if err != nil {
+		return err
+	}
+	for _, k := range kids {
+		if err := t.copy(k, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prefixOf returns the prefix used for a name in the scope of el.
+func prefixOf(el *xmltree.Element, name xml.Name) string {
+	if q := el.Prefix(name); strings.Contains(q, ":") {
+		return q[:strings.IndexByte(q, ':')]
+	}
+	return ""
+}
+
+// namespaceNodes returns the namespace declarations in scope at an
+// element, as namespace URIs and prefixes.
+func (t *transform) namespaceNodes(n xmltree.Node) ([]xml.Name, error) {
+	nodes, err := t.c.Select(nsAxis, n)
+	if err != nil {
+		return nil, err
+	}
+	var ns []xml.Name
+	for _, m := range nodes {
+		if p := m.Name().Local; p != "xml" {
+			ns = append(ns, xml.Name{Space: m.String(), Local: p})
+		}
+	}
+	return ns, nil
+}
+
+// appendText adds text to out, joining it to any text before it.
+func appendText(out *xmltree.Element, s string) {
+	if s == "" {
+		return
+	}
+	if n := len(out.Children); n > 0 && out.Children[n-1].Type == xmltree.XML_CharData {
+		out.Children[n-1].Content += s
+		return
+	}
+	out.Children = append(out.Children, xmltree.Element{Type: xmltree.XML_CharData, Content: s})
+}
+
+// finish stores the text of elements with nothing but text as their
+// Content, as Parse does.
+func finish(el *xmltree.Element) {
+	for i := range el.Children {
+		if c := &el.Children[i]; c.Type == xmltree.XML_Tag {
+			finish(c)
+		}
+	}
+	if el.Name.Local != "" && len(el.Children) == 1 && el.Children[0].Type == xmltree.XML_CharData {
+		el.Content = el.Children[0].Content
+		el.Children = nil
+	}
+}
+
+// declScope returns a Scope holding a single namespace declaration.
+func (t *transform) declScope(d xml.Name) (*xmltree.Scope, error) {
+	if s, ok := t.decls[d]; ok {
+		return s, nil
+	}
+	var b strings.Builder
+	b.WriteString("<x xmlns")
+	if d.Local != "" {
+		b.WriteString(":" + d.Local)
+	}
+	b.WriteString(`="`)
+	xml.EscapeText(&b, []byte(d.Space))
+	b.WriteString(`"/>`)
+	el, err := xmltree.Parse(strings.NewReader(b.String()))
+	if err != nil {
+		return nil, fmt.Errorf("xslt: declaring namespace prefix %q: %v", d.Local, err)
+	}
+	t.decls[d] = &el.Scope
+	return &el.Scope, nil
+}
+
+// declare returns scope with namespace declarations added.
+func (t *transform) declare(scope xmltree.Scope, decls []xml.Name) (xmltree.Scope, error) {
+	if len(decls) == 0 {
+		return scope, nil
+	}
+	s := (&xmltree.Scope{}).JoinScope(&scope)
+	for _, d := range decls {
+		ds, err := t.declScope(d)
+		if err != nil {
+			return scope, err
+		}
+		s = s.JoinScope(ds)
+	}
+	return *s, nil
+}
+
+// bound reports whether prefix is bound to uri in scope.
+func bound(scope *xmltree.Scope, prefix, uri string) bool {
+	if prefix == "" {
+		return scope.Resolve("x").Space == uri
+	}
+	n, ok := scope.ResolveNS(prefix + ":x")
+	return ok && n.Space == uri
+}
+
+// newElement returns a new element to be added to out, declaring the
+// namespaces ns and that of its name, unless they are already in scope.
+// The name is written with prefix, if that can be declared.
+func (t *transform) newElement(out *xmltree.Element, name xml.Name, prefix string, ns []xml.Name) (*xmltree.Element, error) {
+	var decls []xml.Name
+	has := func(p, uri string) bool {
+		for i := len(decls) - 1; i >= 0; i-- {
+			if decls[i].Local == p {
+				return decls[i].Space == uri
+			}
+		}
+		return bound(&out.Scope, p, uri)
+	}
+	for _, d := range ns {
+		if !has(d.Local, d.Space) {
+			decls = append(decls, d)
+		}
+	}
+	switch {
+	case name.Space == xmlNS:
+	case name.Space == "" && !has("", ""):
+		kept := decls[:0]
+		for _, d := range decls {
+			if d.Local != "" {
+				kept = append(kept, d)
+			}
+		}
+		decls = append(kept, xml.Name{})
+	case name.Space != "" && !has(prefix, name.Space):
+		for _, d := range decls {
+			if d.Local == prefix {
+				prefix = t.freePrefix(&out.Scope, decls)
+				break
+			}
+		}
+		decls = append(decls, xml.Name{Space: name.Space, Local: prefix})
+	}
+	scope, err := t.declare(out.Scope, decls)
+	if err != nil {
+		return nil, err
+	}
+	return &xmltree.Element{Type: xmltree.XML_Tag, StartElement: xml.StartElement{Name: name}, Scope: scope}, nil
+}
+
+// freePrefix returns a prefix bound neither in scope nor by decls.
+func (t *transform) freePrefix(scope *xmltree.Scope, decls []xml.Name) string {
+	for i := 0; ; i++ {
+		p := "ns" + strconv.Itoa(i)
+		if _, ok := scope.ResolveNS(p + ":x"); ok {
+			continue
+		}
+		free := true
+		for _, d := range decls {
+			if d.Local == p {
+				free = false
+			}
+		}
+		if free {
+			return p
+		}
+	}
+}
+
+// setAttr sets an attribute of el, declaring its namespace if need be.
+// Attributes added to the root of the result, or after children, are
+// ignored, as XSLT allows.
+func (t *transform) setAttr(el *xmltree.Element, name xml.Name, prefix, value string) error {
+	if el.Name.Local == "" || len(el.Children) > 0 {
+		return nil
+	}
+	if name.Space != "" && name.Space != xmlNS && (prefix == "" || !bound(&el.Scope, prefix, name.Space)) {
+		if _, ok := el.Scope.ResolveNS(prefix + ":x"); prefix == "" || ok {
+			prefix = t.freePrefix(&el.Scope, nil)
+		}
+		scope, err := t.declare(el.Scope, []xml.Name{{Space: name.Space, Local: prefix}})
+		if err != nil {
+			return err
+		}
+		el.Scope = scope
+	}
+	for i, a := range el.StartElement.Attr {
+		if a.Name == name {
+			el.StartElement.Attr[i].Value = value
+			return nil
+		}
+	}
+	el.StartElement.Attr = append(el.StartElement.Attr, xml.Attr{Name: name, Value: value})
+	return nil
+}
@@ -0,0 +1,1331 @@
+// Package xslt implements XSLT 1.0 transformations of trees of
+// xmltree.Elements.
+//
+// A stylesheet is compiled once by Compile, and may then be applied to
+// any number of documents by Transform, which returns the result tree,
+// or TransformTo, which writes it as directed by the xsl:output
+// declaration.
+//
+// Stylesheets should be parsed with xmltree.WhitespacePreserve, so that
+// the text of xsl:text instructions and literal text is kept as
+// written. Text nodes of nothing but white space are stripped from
+// stylesheets as XSLT requires.
+//
+// All of XSLT 1.0 is supported except the document() function,
+// xsl:namespace-alias and xsl:decimal-format, which are ignored, and
+// disable-output-escaping. Result tree fragments may be used wherever
+// node-sets may, as with the EXSLT node-set() function, which is also
+// provided.
+package xslt
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pschou/go-xmltree"
+)
+
+const (
+	xslNS      = "http://www.w3.org/1999/XSL/Transform"
+	xmlNS      = "http://www.w3.org/XML/1998/namespace"
+	exsltNS    = "http://exslt.org/common"
+	msxslNS    = "urn:schemas-microsoft-com:xslt"
+	maxImports = 32
+)
+
+// Options control the compilation of a stylesheet. A nil *Options is
+// the same as a zero Options.
+type Options struct {
+	// Load returns the stylesheet named by the href of an xsl:include
+	// or xsl:import. Stylesheets which include or import others cannot
+	// be compiled if it is nil.
+	Load func(href string) (*xmltree.Element, error)
+	// Functions holds extension functions for the expressions of the
+	// stylesheet, keyed by name as written.
+	Functions map[string]xmltree.XPathFunc
+	// Messages receives the text of xsl:message instructions, one per
+	// line. They are discarded if it is nil.
+	Messages io.Writer
+}
+
+// Output holds the properties of the xsl:output declarations of a
+// stylesheet, which control how TransformTo writes the result tree.
+type Output struct {
+	// Method is "xml", "html" or "text". If it is empty, the result
+	// is written as HTML if its document element is an html element
+	// in no namespace, and as XML otherwise.
+	Method string
+	// Version, Encoding and Standalone are written in the XML
+	// declaration. The result is always encoded in UTF-8.
+	Version, Encoding, Standalone string
+	// OmitXMLDeclaration leaves out the XML declaration.
+	OmitXMLDeclaration bool
+	// DoctypePublic and DoctypeSystem are the identifiers of the
+	// document type declaration written before the document element.
+	// XML output has none if DoctypeSystem is empty, and HTML output
+	// none if both are empty.
+	DoctypePublic, DoctypeSystem string
+	// CDATASectionElements lists the elements whose text is written
+	// in CDATA sections.
+	CDATASectionElements []xml.Name
+	// Indent indents XML output.
+	Indent bool
+	// MediaType is the media type of the output.
+	MediaType string
+}
+
+// A Stylesheet is a compiled XSLT stylesheet. It may be used by several
+// goroutines at once.
+type Stylesheet struct {
+	// Output holds the properties of the xsl:output declarations.
+	Output Output
+
+	named     map[xml.Name]*template
+	modes     map[xml.Name][]*rule // in order of precedence
+	globals   []*variable
+	keys      map[xml.Name][]*key
+	attrSets  map[xml.Name][]*attrSet
+	space     []spaceRule
+	functions map[string]xmltree.XPathFunc
+	nodeSet   []string // names of the node-set() extension function
+	messages  io.Writer
+}
+
+// An expr is a compiled XPath expression, with the namespace prefixes
+// in scope where it was written.
+type expr struct {
+	x  *xmltree.XPath
+	ns map[string]string
+}
+
+// A pattern is a compiled XSLT pattern, one expression for each of its
+// alternatives.
+type pattern struct {
+	src  string
+	alts []*alt
+}
+
+// An alt is one alternative of a pattern. The nodes it matches are
+// those selected by the expression from the root of their tree, or
+// the root itself for the pattern "/".
+type alt struct {
+	e        *expr
+	root     bool
+	priority float64
+}
+
+type template struct {
+	match  *pattern
+	name   xml.Name
+	mode   xml.Name
+	prec   int
+	params []*variable
+	body   seq
+}
+
+// A rule is one alternative of the pattern of a template, which is
+// treated as a template of its own.
+type rule struct {
+	t        *template
+	alt      *alt
+	priority float64
+	pos      int
+}
+
+type variable struct {
+	name   string
+	sel    *expr
+	body   seq
+	param  bool
+	prec   int
+	refs   []string // variables referred to by a global variable
+	global bool
+}
+
+type key struct {
+	match *pattern
+	use   *expr
+}
+
+type attrSet struct {
+	uses  []xml.Name
+	attrs seq
+}
+
+// A spaceRule is a name test of an xsl:strip-space or
+// xsl:preserve-space declaration.
+type spaceRule struct {
+	name     xml.Name // Local is "*" for any name in the namespace
+	any      bool     // the test "*"
+	strip    bool
+	prec     int
+	priority float64
+}
+
+// An avt is an attribute value template.
+type avt []avtPart
+
+type avtPart struct {
+	text string
+	e    *expr
+}
+
+// A seq is a sequence of instructions, of the types below.
+type seq []interface{}
+
+type (
+	textInstr string
+
+	literal struct {
+		name   xml.Name
+		prefix string
+		ns     []xml.Name // namespace nodes copied to the result
+		attrs  []literalAttr
+		sets   []xml.Name
+		body   seq
+	}
+	literalAttr struct {
+		name   xml.Name
+		prefix string
+		value  avt
+	}
+	applyTemplates struct {
+		sel    *expr
+		mode   xml.Name
+		sorts  []*sortKey
+		params []*variable
+	}
+	callTemplate struct {
+		name   xml.Name
+		params []*variable
+	}
+	applyImports struct{}
+	forEach      struct {
+		sel   *expr
+		sorts []*sortKey
+		body  seq
+	}
+	valueOf  struct{ sel *expr }
+	copyOf   struct{ sel *expr }
+	copyNode struct {
+		sets []xml.Name
+		body seq
+	}
+	element struct {
+		name, space avt
+		hasSpace    bool
+		scope       xmltree.Scope // for resolving the prefix of name
+		sets        []xml.Name
+		body        seq
+	}
+	attribute struct {
+		name, space avt
+		hasSpace    bool
+		scope       xmltree.Scope
+		body        seq
+	}
+	comment  struct{ body seq }
+	procInst struct {
+		name avt
+		body seq
+	}
+	ifInstr struct {
+		test *expr
+		body seq
+	}
+	choose struct {
+		whens     []ifInstr
+		otherwise seq
+	}
+	message struct {
+		body      seq
+		terminate bool
+	}
+	number struct {
+		level       string
+		count, from *pattern
+		value       *expr
+		format      avt
+		groupSep    avt
+		groupSize   avt
+	}
+)
+
+type sortKey struct {
+	sel                        *expr
+	order, dataType, caseOrder avt
+}
+
+// A compiler compiles the modules of a stylesheet.
+type compiler struct {
+	opts    *Options
+	s       *Stylesheet
+	c       *xmltree.XPathContext // of the module being compiled
+	exclude []map[string]bool     // namespaces excluded from the result
+	loading map[string]bool
+	prec    int
+	pos     int
+	refs    *[]string // where variable references are collected
+	nsCache map[*xmltree.Element]map[string]string
+}
+
+// Compile compiles an XSLT 1.0 stylesheet, which is either an
+// xsl:stylesheet or xsl:transform element, or a literal result element
+// with an xsl:version attribute.
+func Compile(doc *xmltree.Element, opts *Options) (*Stylesheet, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	s := &Stylesheet{
+		named:     make(map[xml.Name]*template),
+		modes:     make(map[xml.Name][]*rule),
+		keys:      make(map[xml.Name][]*key),
+		attrSets:  make(map[xml.Name][]*attrSet),
+		functions: opts.Functions,
+		messages:  opts.Messages,
+	}
+	c := &compiler{opts: opts, s: s, loading: make(map[string]bool), nsCache: make(map[*xmltree.Element]map[string]string)}
+	if err := c.module(doc, 0); err != nil {
+		return nil, err
+	}
+	for mode := range s.modes {
+		rules := s.modes[mode]
+		sort.SliceStable(rules, func(i, j int) bool {
+			a, b := rules[i], rules[j]
+			if a.t.prec != b.t.prec {
+				return a.t.prec > b.t.prec
+			}
+			if a.priority != b.priority {
+				return a.priority > b.priority
+			}
+			return a.pos > b.pos
+		})
+	}
+	// Of global variables with the same name, the one with the highest
+	// import precedence is used.
+	best := make(map[string]*variable)
+	for _, v := range s.globals {
+		if b, ok := best[v.name]; !ok || v.prec >= b.prec {
+			best[v.name] = v
+		}
+	}
+	var globals []*variable
+	for _, v := range s.globals {
+		if best[v.name] == v {
+			globals = append(globals, v)
+		}
+	}
+	s.globals = globals
+	return s, nil
+}
+
+func isXSL(el *xmltree.Element, local string) bool {
+	return el.Type == xmltree.XML_Tag && el.Name.Space == xslNS && el.Name.Local == local
+}
+
+// load loads a stylesheet module for xsl:include or xsl:import.
+func (c *compiler) load(el *xmltree.Element) (*xmltree.Element, string, error) {
+	href := el.Attr("", "href")
+	switch {
+	case href == "":
+		return nil, "", fmt.Errorf("xslt: xsl:%s without an href", el.Name.Local)
+	case c.opts.Load == nil:
+		return nil, "", fmt.Errorf("xslt: xsl:%s of %s without Options.Load", el.Name.Local, href)
+	case c.loading[href] || len(c.loading) >= maxImports:
+		return nil, "", fmt.Errorf("xslt: xsl:%s of %s includes itself", el.Name.Local, href)
+	}
+	doc, err := c.opts.Load(href)
+	if err != nil {
+		return nil, "", fmt.Errorf("xslt: xsl:%s of %s: %v", el.Name.Local, href, err)
+	}
+	return doc, href, nil
+}
+
+// module compiles a stylesheet module and those it imports. Modules
+// imported later have higher precedence than those imported earlier,
+// and the importing module higher than all of them.
+func (c *compiler) module(doc *xmltree.Element, depth int) error {
+	if doc.Name.Space != xslNS {
+		// A literal result element used as a stylesheet.
+		if _, ok := attr(doc, xml.Name{Space: xslNS, Local: "version"}); !ok {
+			return fmt.Errorf("xslt: %s is not a stylesheet", doc.Name.Local)
+		}
+		saved := c.c
+		c.c = xmltree.NewXPathContext(doc)
+		defer func() { c.c = saved }()
+		body, err := c.instruction(doc)
+		if err != nil {
+			return err
+		}
+		t := &template{match: &pattern{src: "/", alts: []*alt{{root: true, priority: 0.5}}}, prec: c.prec, body: seq{body}}
+		c.prec++
+		return c.addTemplate(t)
+	}
+	if doc.Name.Local != "stylesheet" && doc.Name.Local != "transform" {
+		return fmt.Errorf("xslt: xsl:%s is not a stylesheet", doc.Name.Local)
+	}
+	for _, child := range elements(doc) {
+		if !isXSL(child, "import") {
+			continue
+		}
+		sub, href, err := c.load(child)
+		if err != nil {
+			return err
+		}
+		c.loading[href] = true
+		err = c.module(sub, depth+1)
+		delete(c.loading, href)
+		if err != nil {
+			return err
+		}
+	}
+	prec := c.prec
+	c.prec++
+	return c.declarations(doc, prec)
+}
+
+// declarations compiles the top-level elements of a module, or of a
+// module it includes.
+func (c *compiler) declarations(doc *xmltree.Element, prec int) error {
+	if !isXSL(doc, "stylesheet") && !isXSL(doc, "transform") {
+		return fmt.Errorf("xslt: included %s is not a stylesheet", doc.Name.Local)
+	}
+	saved, savedExclude := c.c, c.exclude
+	c.c = xmltree.NewXPathContext(doc)
+	c.exclude = nil
+	defer func() { c.c, c.exclude = saved, savedExclude }()
+	exclude, err := c.excluded(doc, "exclude-result-prefixes")
+	if err != nil {
+		return err
+	}
+	ext, err := c.excluded(doc, "extension-element-prefixes")
+	if err != nil {
+		return err
+	}
+	for uri := range ext {
+		exclude[uri] = true
+	}
+	c.exclude = []map[string]bool{exclude}
+	for prefix, uri := range c.namespaces(doc) {
+		if prefix != "" && (uri == exsltNS || uri == msxslNS) {
+			name := prefix + ":node-set"
+			if !contains(c.s.nodeSet, name) {
+				c.s.nodeSet = append(c.s.nodeSet, name)
+			}
+		}
+	}
+	sort.Strings(c.s.nodeSet)
+
+	for _, el := range elements(doc) {
+		if el.Name.Space != xslNS {
+			continue // user-defined data
+		}
+		var err error
+		switch el.Name.Local {
+		case "import":
+		case "include":
+			var sub *xmltree.Element
+			var href string
+			if sub, href, err = c.load(el); err == nil {
+				c.loading[href] = true
+				err = c.declarations(sub, prec)
+				delete(c.loading, href)
+			}
+		case "template":
+			err = c.template(el, prec)
+		case "variable", "param":
+			var v *variable
+			refs := []string{}
+			c.refs = &refs
+			v, err = c.variable(el)
+			c.refs = nil
+			if err == nil {
+				v.prec, v.refs, v.global = prec, refs, true
+				c.s.globals = append(c.s.globals, v)
+			}
+		case "output":
+			err = c.output(el)
+		case "key":
+			err = c.key(el)
+		case "attribute-set":
+			err = c.attributeSet(el)
+		case "strip-space", "preserve-space":
+			err = c.space(el, prec)
+		case "namespace-alias", "decimal-format":
+		default:
+			err = fmt.Errorf("xslt: unknown declaration xsl:%s", el.Name.Local)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// excluded returns the namespaces named by a list of prefixes, such as
+// the exclude-result-prefixes attribute of xsl:stylesheet.
+func (c *compiler) excluded(el *xmltree.Element, local string) (map[string]bool, error) {
+	name := xml.Name{Local: local}
+	if el.Name.Space != xslNS {
+		name.Space = xslNS
+	}
+	list, _ := attr(el, name)
+	uris := make(map[string]bool)
+	ns := c.namespaces(el)
+	for _, prefix := range strings.Fields(list) {
+		if prefix == "#default" {
+			prefix = ""
+		}
+		uri, ok := ns[prefix]
+		if !ok {
+			return nil, fmt.Errorf("xslt: %s: undeclared prefix %q", local, prefix)
+		}
+		uris[uri] = true
+	}
+	return uris, nil
+}
+
+// namespaces returns the namespaces in scope at el, by prefix,
+// including the default namespace under "".
+func (c *compiler) namespaces(el *xmltree.Element) map[string]string {
+	if ns, ok := c.nsCache[el]; ok {
+		return ns
+	}
+	ns := make(map[string]string)
+	nodes, _ := c.c.Select(nsAxis, c.c.Node(el))
+	for _, n := range nodes {
+		ns[n.Name().Local] = n.String()
+	}
+	c.nsCache[el] = ns
+	return ns
+}
+
+var (
+	nsAxis   = xmltree.MustCompileXPath("namespace::*")
+	children = xmltree.MustCompileXPath("node()")
+	varRef   = regexp.MustCompile(`\$([\pL_][\pL\pN_.\-]*(:[\pL_][\pL\pN_.\-]*)?)`)
+)
+
+// expr compiles an expression written in el.
+func (c *compiler) expr(el *xmltree.Element, src string) (*expr, error) {
+	x, err := xmltree.CompileXPath(src)
+	if err != nil {
+		return nil, fmt.Errorf("xslt: %s: %v", src, err)
+	}
+	ns := make(map[string]string)
+	for prefix, uri := range c.namespaces(el) {
+		if prefix != "" {
+			ns[prefix] = uri
+		}
+	}
+	if c.refs != nil {
+		for _, m := range varRef.FindAllStringSubmatch(src, -1) {
+			*c.refs = append(*c.refs, m[1])
+		}
+	}
+	return &expr{x: x, ns: ns}, nil
+}
+
+// required compiles the expression in an attribute which must be
+// present.
+func (c *compiler) required(el *xmltree.Element, local string) (*expr, error) {
+	src, ok := attr(el, xml.Name{Local: local})
+	if !ok {
+		return nil, fmt.Errorf("xslt: xsl:%s without a %s attribute", el.Name.Local, local)
+	}
+	return c.expr(el, src)
+}
+
+// optional compiles the expression in an attribute, or returns nil if
+// it is absent.
+func (c *compiler) optional(el *xmltree.Element, local string) (*expr, error) {
+	src, ok := attr(el, xml.Name{Local: local})
+	if !ok {
+		return nil, nil
+	}
+	return c.expr(el, src)
+}
+
+// avt compiles an attribute value template.
+func (c *compiler) avt(el *xmltree.Element, s string) (avt, error) {
+	var parts avt
+	var text strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == '{' && strings.HasPrefix(s[i:], "{{"), ch == '}' && strings.HasPrefix(s[i:], "}}"):
+			text.WriteByte(ch)
+			i++
+		case ch == '}':
+			return nil, fmt.Errorf("xslt: unmatched } in attribute value template %q", s)
+		case ch == '{':
+			end := exprEnd(s, i+1)
+			if end < 0 {
+				return nil, fmt.Errorf("xslt: unterminated expression in attribute value template %q", s)
+			}
+			e, err := c.expr(el, s[i+1:end])
+			if err != nil {
+				return nil, err
+			}
+			if text.Len() > 0 {
+				parts = append(parts, avtPart{text: text.String()})
+				text.Reset()
+			}
+			parts = append(parts, avtPart{e: e})
+			i = end
+		default:
+			text.WriteByte(ch)
+		}
+	}
+	if text.Len() > 0 || len(parts) == 0 {
+		parts = append(parts, avtPart{text: text.String()})
+	}
+	return parts, nil
+}
+
+// exprEnd returns the index of the } which ends the expression of an
+// attribute value template starting at i, skipping string literals.
+func exprEnd(s string, i int) int {
+	var quote byte
+	for ; i < len(s); i++ {
+		switch ch := s[i]; {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '}':
+			return i
+		}
+	}
+	return -1
+}
+
+// avtAttr compiles an attribute value template in an attribute, which
+// is empty if the attribute is absent.
+func (c *compiler) avtAttr(el *xmltree.Element, local string) (avt, error) {
+	s, _ := attr(el, xml.Name{Local: local})
+	return c.avt(el, s)
+}
+
+// qname resolves a QName written in el. Unlike the names of elements,
+// an unprefixed QName is in no namespace.
+func qname(el *xmltree.Element, s string) (xml.Name, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, ":") {
+		return xml.Name{Local: s}, nil
+	}
+	name, ok := el.ResolveNS(s)
+	if !ok {
+		return xml.Name{}, fmt.Errorf("xslt: undeclared prefix in %q", s)
+	}
+	return name, nil
+}
+
+func qnames(el *xmltree.Element, list string) ([]xml.Name, error) {
+	var names []xml.Name
+	for _, s := range strings.Fields(list) {
+		name, err := qname(el, s)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// pattern compiles a pattern. Each alternative is rewritten as an
+// expression selecting the nodes it matches from the root of a tree.
+func (c *compiler) pattern(el *xmltree.Element, src string) (*pattern, error) {
+	p := &pattern{src: src}
+	for _, s := range splitUnion(src) {
+		if s == "" {
+			return nil, fmt.Errorf("xslt: empty alternative in pattern %q", src)
+		}
+		a := &alt{priority: defaultPriority(s)}
+		if s == "/" {
+			a.root = true
+			p.alts = append(p.alts, a)
+			continue
+		}
+		if !strings.HasPrefix(s, "/") && !strings.HasPrefix(s, "id(") && !strings.HasPrefix(s, "key(") {
+			s = "descendant-or-self::node()/" + s
+		}
+		e, err := c.expr(el, s)
+		if err != nil {
+			return nil, fmt.Errorf("xslt: invalid pattern %q", src)
+		}
+		a.e = e
+		p.alts = append(p.alts, a)
+	}
+	return p, nil
+}
+
+// splitUnion splits a pattern into its alternatives.
+func splitUnion(src string) []string {
+	var alts []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i <= len(src); i++ {
+		if i == len(src) || quote == 0 && depth == 0 && src[i] == '|' {
+			alts = append(alts, strings.TrimSpace(src[start:i]))
+			start = i + 1
+			continue
+		}
+		switch ch := src[i]; {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '(' || ch == '[':
+			depth++
+		case ch == ')' || ch == ']':
+			depth--
+		}
+	}
+	return alts
+}
+
+var (
+	nameTest     = regexp.MustCompile(`^(child::|attribute::|@)?\s*[\pL_][\pL\pN_.\-]*(:[\pL_][\pL\pN_.\-]*)?$`)
+	nsTest       = regexp.MustCompile(`^(child::|attribute::|@)?\s*[\pL_][\pL\pN_.\-]*:\*$`)
+	nodeTypeTest = regexp.MustCompile(`^(child::|attribute::|@)?\s*(\*|node\(\s*\)|text\(\s*\)|comment\(\s*\)|processing-instruction\(\s*\))$`)
+	piTest       = regexp.MustCompile(`^(child::)?processing-instruction\(\s*('[^']*'|"[^"]*")\s*\)$`)
+)
+
+// defaultPriority returns the default priority of an alternative of a
+// pattern.
+func defaultPriority(s string) float64 {
+	switch {
+	case nameTest.MatchString(s), piTest.MatchString(s):
+		return 0
+	case nsTest.MatchString(s):
+		return -0.25
+	case nodeTypeTest.MatchString(s):
+		return -0.5
+	}
+	return 0.5
+}
+
+func (c *compiler) addTemplate(t *template) error {
+	if t.name.Local != "" {
+		old, ok := c.s.named[t.name]
+		switch {
+		case !ok || t.prec > old.prec:
+			c.s.named[t.name] = t
+		case t.prec == old.prec:
+			return fmt.Errorf("xslt: more than one template named %s", t.name.Local)
+		}
+	}
+	if t.match == nil {
+		return nil
+	}
+	for _, a := range t.match.alts {
+		c.pos++
+		c.s.modes[t.mode] = append(c.s.modes[t.mode], &rule{t: t, alt: a, priority: a.priority, pos: c.pos})
+	}
+	return nil
+}
+
+func (c *compiler) template(el *xmltree.Element, prec int) error {
+	t := &template{prec: prec}
+	var err error
+	match, hasMatch := attr(el, xml.Name{Local: "match"})
+	name, hasName := attr(el, xml.Name{Local: "name"})
+	if !hasMatch && !hasName {
+		return errors.New("xslt: xsl:template without a match or name attribute")
+	}
+	if hasName {
+		if t.name, err = qname(el, name); err != nil {
+			return err
+		}
+	}
+	if hasMatch {
+		if t.match, err = c.pattern(el, match); err != nil {
+			return err
+		}
+	}
+	if mode, ok := attr(el, xml.Name{Local: "mode"}); ok {
+		if t.mode, err = qname(el, mode); err != nil {
+			return err
+		}
+	}
+	if p, ok := attr(el, xml.Name{Local: "priority"}); ok && t.match != nil {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return fmt.Errorf("xslt: template priority %q is not a number", p)
+		}
+		for _, a := range t.match.alts {
+			a.priority = f
+		}
+	}
+	// Parameters come first.
+	kids := nodes(el)
+	for len(kids) > 0 && (isXSL(&kids[0], "param") || isBlank(&kids[0])) {
+		if isXSL(&kids[0], "param") {
+			v, err := c.variable(&kids[0])
+			if err != nil {
+				return err
+			}
+			t.params = append(t.params, v)
+		}
+		kids = kids[1:]
+	}
+	if t.body, err = c.sequence(el, kids); err != nil {
+		return err
+	}
+	return c.addTemplate(t)
+}
+
+// variable compiles an xsl:variable, xsl:param or xsl:with-param.
+func (c *compiler) variable(el *xmltree.Element) (*variable, error) {
+	name, ok := attr(el, xml.Name{Local: "name"})
+	if !ok {
+		return nil, fmt.Errorf("xslt: xsl:%s without a name attribute", el.Name.Local)
+	}
+	v := &variable{name: strings.TrimSpace(name), param: el.Name.Local == "param"}
+	var err error
+	if v.sel, err = c.optional(el, "select"); err != nil {
+		return nil, err
+	}
+	if v.sel == nil {
+		if v.body, err = c.body(el); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func (c *compiler) output(el *xmltree.Element) error {
+	o := &c.s.Output
+	for _, a := range el.StartElement.Attr {
+		if a.Name.Space != "" {
+			continue
+		}
+		switch a.Name.Local {
+		case "method":
+			o.Method = a.Value
+		case "version":
+			o.Version = a.Value
+		case "encoding":
+			o.Encoding = a.Value
+		case "standalone":
+			o.Standalone = a.Value
+		case "omit-xml-declaration":
+			o.OmitXMLDeclaration = a.Value == "yes"
+		case "doctype-public":
+			o.DoctypePublic = a.Value
+		case "doctype-system":
+			o.DoctypeSystem = a.Value
+		case "indent":
+			o.Indent = a.Value == "yes"
+		case "media-type":
+			o.MediaType = a.Value
+		case "cdata-section-elements":
+			names, err := qnames(el, a.Value)
+			if err != nil {
+				return err
+			}
+			// Unprefixed names are in the default namespace.
+			for i := range names {
+				if names[i].Space == "" {
+					names[i] = el.Resolve(names[i].Local)
+				}
+			}
+			o.CDATASectionElements = append(o.CDATASectionElements, names...)
+		}
+	}
+	switch o.Method {
+	case "", "xml", "html", "text":
+	default:
+		return fmt.Errorf("xslt: unknown output method %q", o.Method)
+	}
+	return nil
+}
+
+func (c *compiler) key(el *xmltree.Element) error {
+	name, ok := attr(el, xml.Name{Local: "name"})
+	match, ok2 := attr(el, xml.Name{Local: "match"})
+	if !ok || !ok2 {
+		return errors.New("xslt: xsl:key without a name or match attribute")
+	}
+	n, err := qname(el, name)
+	if err != nil {
+		return err
+	}
+	k := &key{}
+	if k.match, err = c.pattern(el, match); err != nil {
+		return err
+	}
+	if k.use, err = c.required(el, "use"); err != nil {
+		return err
+	}
+	c.s.keys[n] = append(c.s.keys[n], k)
+	return nil
+}
+
+func (c *compiler) attributeSet(el *xmltree.Element) error {
+	name, err := qname(el, el.Attr("", "name"))
+	if err != nil {
+		return err
+	}
+	set := &attrSet{}
+	if set.uses, err = qnames(el, el.Attr("", "use-attribute-sets")); err != nil {
+		return err
+	}
+	for _, child := range elements(el) {
+		if !isXSL(child, "attribute") {
+			return fmt.Errorf("xslt: %s in xsl:attribute-set", child.Name.Local)
+		}
+		in, err := c.instruction(child)
+		if err != nil {
+			return err
+		}
+		set.attrs = append(set.attrs, in)
+	}
+	c.s.attrSets[name] = append(c.s.attrSets[name], set)
+	return nil
+}
+
+func (c *compiler) space(el *xmltree.Element, prec int) error {
+	list, ok := attr(el, xml.Name{Local: "elements"})
+	if !ok {
+		return fmt.Errorf("xslt: xsl:%s without an elements attribute", el.Name.Local)
+	}
+	for _, s := range strings.Fields(list) {
+		r := spaceRule{strip: el.Name.Local == "strip-space", prec: prec}
+		switch {
+		case s == "*":
+			r.any, r.priority = true, -0.5
+		case strings.HasSuffix(s, ":*"):
+			name, err := qname(el, strings.TrimSuffix(s, "*")+"x")
+			if err != nil {
+				return err
+			}
+			r.name, r.priority = xml.Name{Space: name.Space, Local: "*"}, -0.25
+		default:
+			name, err := qname(el, s)
+			if err != nil {
+				return err
+			}
+			r.name = name
+		}
+		c.s.space = append(c.s.space, r)
+	}
+	return nil
+}
+
+// nodes returns the children of a stylesheet element, with text held
+// in its Content as a child of its own.
+func nodes(el *xmltree.Element) []xmltree.Element {
+	if len(el.Children) == 0 && el.Content != "" {
+		return []xmltree.Element{{Type: xmltree.XML_CharData, Content: el.Content}}
+	}
+	return el.Children
+}
+
+func elements(el *xmltree.Element) []*xmltree.Element {
+	var list []*xmltree.Element
+	for i := range el.Children {
+		if el.Children[i].Type == xmltree.XML_Tag {
+			list = append(list, &el.Children[i])
+		}
+	}
+	return list
+}
+
+func isBlank(el *xmltree.Element) bool {
+	switch el.Type {
+	case xmltree.XML_CharData, xmltree.XML_CDATA:
+		return strings.TrimSpace(el.Content) == ""
+	case xmltree.XML_Comment, xmltree.XML_ProcInst, xmltree.XML_Directive:
+		return true
+	}
+	return false
+}
+
+// attr returns the value of an attribute, and whether it is present.
+func attr(el *xmltree.Element, name xml.Name) (string, bool) {
+	for _, a := range el.StartElement.Attr {
+		if a.Name == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// body compiles the content of an element as a sequence of
+// instructions.
+func (c *compiler) body(el *xmltree.Element) (seq, error) {
+	return c.sequence(el, nodes(el))
+}
+
+func (c *compiler) sequence(parent *xmltree.Element, kids []xmltree.Element) (seq, error) {
+	var body seq
+	preserve := parent.Attr(xmlNS, "space") == "preserve"
+	for i := range kids {
+		kid := &kids[i]
+		switch kid.Type {
+		case xmltree.XML_CharData, xmltree.XML_CDATA:
+			if preserve || strings.TrimSpace(kid.Content) != "" {
+				body = append(body, textInstr(kid.Content))
+			}
+		case xmltree.XML_Tag:
+			in, err := c.instruction(kid)
+			if err != nil {
+				return nil, err
+			}
+			if in != nil {
+				body = append(body, in)
+			}
+		}
+	}
+	return body, nil
+}
+
+// instruction compiles an instruction or a literal result element.
+func (c *compiler) instruction(el *xmltree.Element) (interface{}, error) {
+	if el.Name.Space != xslNS {
+		return c.literal(el)
+	}
+	var err error
+	switch el.Name.Local {
+	case "apply-templates":
+		in := &applyTemplates{}
+		if in.sel, err = c.optional(el, "select"); err != nil {
+			return nil, err
+		}
+		if mode, ok := attr(el, xml.Name{Local: "mode"}); ok {
+			if in.mode, err = qname(el, mode); err != nil {
+				return nil, err
+			}
+		}
+		in.sorts, in.params, err = c.sortsAndParams(el)
+		return in, err
+	case "call-template":
+		in := &callTemplate{}
+		if in.name, err = qname(el, el.Attr("", "name")); err != nil {
+			return nil, err
+		}
+		_, in.params, err = c.sortsAndParams(el)
+		return in, err
+	case "apply-imports":
+		return applyImports{}, nil
+	case "for-each":
+		in := &forEach{}
+		if in.sel, err = c.required(el, "select"); err != nil {
+			return nil, err
+		}
+		kids := nodes(el)
+		for len(kids) > 0 && (isXSL(&kids[0], "sort") || isBlank(&kids[0])) {
+			if isXSL(&kids[0], "sort") {
+				k, err := c.sortKey(&kids[0])
+				if err != nil {
+					return nil, err
+				}
+				in.sorts = append(in.sorts, k)
+			}
+			kids = kids[1:]
+		}
+		in.body, err = c.sequence(el, kids)
+		return in, err
+	case "value-of":
+		in := &valueOf{}
+		in.sel, err = c.required(el, "select")
+		return in, err
+	case "copy-of":
+		in := &copyOf{}
+		in.sel, err = c.required(el, "select")
+		return in, err
+	case "copy":
+		in := &copyNode{}
+		if in.sets, err = qnames(el, el.Attr("", "use-attribute-sets")); err != nil {
+			return nil, err
+		}
+		in.body, err = c.body(el)
+		return in, err
+	case "element", "attribute":
+		name, ok := attr(el, xml.Name{Local: "name"})
+		if !ok {
+			return nil, fmt.Errorf("xslt: xsl:%s without a name attribute", el.Name.Local)
+		}
+		nameAVT, err := c.avt(el, name)
+		if err != nil {
+			return nil, err
+		}
+		space, hasSpace := attr(el, xml.Name{Local: "namespace"})
+		spaceAVT, err := c.avt(el, space)
+		if err != nil {
+			return nil, err
+		}
+		body, err := c.body(el)
+		if err != nil {
+			return nil, err
+		}
+		if el.Name.Local == "attribute" {
+			return &attribute{name: nameAVT, space: spaceAVT, hasSpace: hasSpace, scope: el.Scope, body: body}, nil
+		}
+		in := &element{name: nameAVT, space: spaceAVT, hasSpace: hasSpace, scope: el.Scope, body: body}
+		in.sets, err = qnames(el, el.Attr("", "use-attribute-sets"))
+		return in, err
+	case "text":
+		return textInstr(el.Text()), nil
+	case "comment":
+		in := &comment{}
+		in.body, err = c.body(el)
+		return in, err
+	case "processing-instruction":
+		in := &procInst{}
+		if in.name, err = c.avtAttr(el, "name"); err != nil {
+			return nil, err
+		}
+		in.body, err = c.body(el)
+		return in, err
+	case "if":
+		in := &ifInstr{}
+		if in.test, err = c.required(el, "test"); err != nil {
+			return nil, err
+		}
+		in.body, err = c.body(el)
+		return in, err
+	case "choose":
+		in := &choose{}
+		for _, child := range elements(el) {
+			switch {
+			case isXSL(child, "when") && in.otherwise == nil:
+				w := ifInstr{}
+				if w.test, err = c.required(child, "test"); err != nil {
+					return nil, err
+				}
+				if w.body, err = c.body(child); err != nil {
+					return nil, err
+				}
+				in.whens = append(in.whens, w)
+			case isXSL(child, "otherwise") && in.otherwise == nil:
+				if in.otherwise, err = c.body(child); err != nil {
+					return nil, err
+				}
+				if in.otherwise == nil {
+					in.otherwise = seq{}
+				}
+			default:
+				return nil, fmt.Errorf("xslt: %s out of place in xsl:choose", child.Name.Local)
+			}
+		}
+		if len(in.whens) == 0 {
+			return nil, errors.New("xslt: xsl:choose without xsl:when")
+		}
+		return in, nil
+	case "variable":
+		return c.variable(el)
+	case "param":
+		return nil, errors.New("xslt: xsl:param out of place")
+	case "message":
+		in := &message{terminate: el.Attr("", "terminate") == "yes"}
+		in.body, err = c.body(el)
+		return in, err
+	case "number":
+		return c.number(el)
+	case "fallback":
+		return nil, nil
+	}
+	// An instruction from a later version of XSLT is replaced by its
+	// fallback, if any.
+	if v, _ := strconv.ParseFloat(c.version(el), 64); v > 1 {
+		var body seq
+		for _, child := range elements(el) {
+			if isXSL(child, "fallback") {
+				b, err := c.body(child)
+				if err != nil {
+					return nil, err
+				}
+				body = append(body, b...)
+			}
+		}
+		return &ifInstr{body: body}, nil
+	}
+	return nil, fmt.Errorf("xslt: unknown instruction xsl:%s", el.Name.Local)
+}
+
+// version returns the XSLT version in effect at el.
+func (c *compiler) version(el *xmltree.Element) string {
+	for n, ok := c.c.Node(el), true; ok; n, ok = c.c.Parent(n) {
+		if n.Type != xmltree.ElementNode {
+			break
+		}
+		e := n.Element
+		if v, ok := attr(e, xml.Name{Local: "version"}); ok && e.Name.Space == xslNS && (e.Name.Local == "stylesheet" || e.Name.Local == "transform") {
+			return v
+		}
+		if v, ok := attr(e, xml.Name{Space: xslNS, Local: "version"}); ok {
+			return v
+		}
+	}
+	return "1.0"
+}
+
+func (c *compiler) sortKey(el *xmltree.Element) (*sortKey, error) {
+	k := &sortKey{}
+	var err error
+	src := "."
+	if s, ok := attr(el, xml.Name{Local: "select"}); ok {
+		src = s
+	}
+	if k.sel, err = c.expr(el, src); err != nil {
+		return nil, err
+	}
+	if k.order, err = c.avtAttr(el, "order"); err != nil {
+		return nil, err
+	}
+	if k.dataType, err = c.avtAttr(el, "data-type"); err != nil {
+		return nil, err
+	}
+	k.caseOrder, err = c.avtAttr(el, "case-order")
+	return k, err
+}
+
+// sortsAndParams compiles the xsl:sort and xsl:with-param children of
+// xsl:apply-templates and xsl:call-template.
+func (c *compiler) sortsAndParams(el *xmltree.Element) ([]*sortKey, []*variable, error) {
+	var sorts []*sortKey
+	var params []*variable
+	for _, child := range elements(el) {
+		switch {
+		case isXSL(child, "sort") && el.Name.Local == "apply-templates":
+			k, err := c.sortKey(child)
+			if err != nil {
+				return nil, nil, err
+			}
+			sorts = append(sorts, k)
+		case isXSL(child, "with-param"):
+			v, err := c.variable(child)
+			if err != nil {
+				return nil, nil, err
+			}
+			params = append(params, v)
+		default:
+			return nil, nil, fmt.Errorf("xslt: %s in xsl:%s", child.Name.Local, el.Name.Local)
+		}
+	}
+	return sorts, params, nil
+}
+
+func (c *compiler) number(el *xmltree.Element) (interface{}, error) {
+	in := &number{level: "single"}
+	var err error
+	if lv, ok := attr(el, xml.Name{Local: "level"}); ok {
+		switch lv {
+		case "single", "multiple", "any":
+			in.level = lv
+		default:
+			return nil, fmt.Errorf("xslt: xsl:number level %q", lv)
+		}
+	}
+	for _, p := range []struct {
+		local string
+		dst   **pattern
+	}{{"count", &in.count}, {"from", &in.from}} {
+		if s, ok := attr(el, xml.Name{Local: p.local}); ok {
+			if *p.dst, err = c.pattern(el, s); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if in.value, err = c.optional(el, "value"); err != nil {
+		return nil, err
+	}
+	format := "1"
+	if s, ok := attr(el, xml.Name{Local: "format"}); ok {
+		format = s
+	}
+	if in.format, err = c.avt(el, format); err != nil {
+		return nil, err
+	}
+	if in.groupSep, err = c.avtAttr(el, "grouping-separator"); err != nil {
+		return nil, err
+	}
+	in.groupSize, err = c.avtAttr(el, "grouping-size")
+	return in, err
+}
+
+// literal compiles a literal result element.
+func (c *compiler) literal(el *xmltree.Element) (interface{}, error) {
+	lit := &literal{name: el.Name}
+	if q := el.Prefix(el.Name); strings.Contains(q, ":") {
+		lit.prefix = q[:strings.IndexByte(q, ':')]
+	}
+	exclude, err := c.excluded(el, "exclude-result-prefixes")
+	if err != nil {
+		return nil, err
+	}
+	ext, err := c.excluded(el, "extension-element-prefixes")
+	if err != nil {
+		return nil, err
+	}
+	for uri := range ext {
+		exclude[uri] = true
+	}
+	c.exclude = append(c.exclude, exclude)
+	defer func() { c.exclude = c.exclude[:len(c.exclude)-1] }()
+
+	var prefixes []string
+	ns := c.namespaces(el)
+	for prefix := range ns {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+outer:
+	for _, prefix := range prefixes {
+		uri := ns[prefix]
+		if uri == xslNS || prefix == "xml" {
+			continue
+		}
+		for _, ex := range c.exclude {
+			if ex[uri] {
+				continue outer
+			}
+		}
+		lit.ns = append(lit.ns, xml.Name{Space: uri, Local: prefix})
+	}
+
+	for _, a := range el.StartElement.Attr {
+		if a.Name.Space == xslNS {
+			if a.Name.Local == "use-attribute-sets" {
+				if lit.sets, err = qnames(el, a.Value); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		la := literalAttr{name: a.Name}
+		if q := el.Prefix(a.Name); strings.Contains(q, ":") {
+			la.prefix = q[:strings.IndexByte(q, ':')]
+		}
+		if la.value, err = c.avt(el, a.Value); err != nil {
+			return nil, err
+		}
+		lit.attrs = append(lit.attrs, la)
+	}
+	if lit.body, err = c.body(el); err != nil {
+		return nil, err
+	}
+	return lit, nil
+}
+
+// contains reports whether list holds s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
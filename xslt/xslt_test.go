@@ -0,0 +1,292 @@
+package xslt
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-xmltree"
+)
+
+func parse(t *testing.T, s string) *xmltree.Element {
+	t.Helper()
+	el, err := xmltree.ParseWithOptions(strings.NewReader(s), &xmltree.ParseOptions{Whitespace: xmltree.WhitespacePreserve})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return el
+}
+
+func transformString(t *testing.T, sheet, doc string, opts *Options, params map[string]interface{}) string {
+	t.Helper()
+	s, err := Compile(parse(t, sheet), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := s.TransformTo(&buf, parse(t, doc), params); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+const benchmark = `<Benchmark xmlns="http://checklists.nist.gov/xccdf/1.2" id="b1">
+  <title>Sample Benchmark</title>
+  <Rule id="r2" severity="high" weight="10"><title>Disable telnet</title></Rule>
+  <Rule id="r1" severity="low" weight="2.5"><title>Set a banner</title></Rule>
+  <Rule id="r3" severity="high" weight="1"><title>Enable auditing</title></Rule>
+  <TestResult id="t1">
+    <rule-result idref="r1"><result>pass</result></rule-result>
+    <rule-result idref="r2"><result>fail</result></rule-result>
+    <rule-result idref="r3"><result>pass</result></rule-result>
+  </TestResult>
+</Benchmark>`
+
+const report = `<xsl:stylesheet version="1.0"
+    xmlns:xsl="http://www.w3.org/1999/XSL/Transform"
+    xmlns:x="http://checklists.nist.gov/xccdf/1.2"
+    exclude-result-prefixes="x">
+  <xsl:output method="html"/>
+  <xsl:param name="heading" select="'Report'"/>
+  <xsl:key name="result" match="x:rule-result" use="@idref"/>
+  <xsl:variable name="failed" select="count(//x:rule-result[x:result = 'fail'])"/>
+  <xsl:attribute-set name="cell">
+    <xsl:attribute name="class">cell</xsl:attribute>
+  </xsl:attribute-set>
+
+  <xsl:template match="/">
+    <html>
+      <head><title><xsl:value-of select="$heading"/></title></head>
+      <body><xsl:apply-templates select="x:Benchmark"/></body>
+    </html>
+  </xsl:template>
+
+  <xsl:template match="x:Benchmark">
+    <h1 id="{@id}"><xsl:value-of select="x:title"/></h1>
+    <p><xsl:value-of select="$failed"/> failed</p>
+    <table>
+      <xsl:apply-templates select="x:Rule">
+        <xsl:sort select="@weight" data-type="number" order="descending"/>
+        <xsl:with-param name="suffix" select="'!'"/>
+      </xsl:apply-templates>
+    </table>
+    <ul><xsl:apply-templates select="x:Rule" mode="toc"/></ul>
+  </xsl:template>
+
+  <xsl:template match="x:Rule">
+    <xsl:param name="suffix"/>
+    <tr>
+      <td xsl:use-attribute-sets="cell"><xsl:number/></td>
+      <td><xsl:value-of select="concat(x:title, $suffix)"/></td>
+      <td><xsl:value-of select="key('result', @id)/x:result"/></td>
+      <td><xsl:value-of select="format-number(@weight, '0.00')"/></td>
+    </tr>
+  </xsl:template>
+
+  <xsl:template match="x:Rule[@severity = 'high']" mode="toc">
+    <li class="high"><xsl:value-of select="@id"/></li>
+  </xsl:template>
+
+  <xsl:template match="x:Rule" mode="toc">
+    <li><xsl:value-of select="@id"/></li>
+  </xsl:template>
+</xsl:stylesheet>`
+
+func TestReport(t *testing.T) {
+	got := transformString(t, report, benchmark, nil, map[string]interface{}{"heading": "Scan"})
+	want := `<html><head><title>Scan</title></head><body>` +
+		`<h1 id="b1">Sample Benchmark</h1><p>1 failed</p><table>` +
+		`<tr><td class="cell">1</td><td>Disable telnet!</td><td>fail</td><td>10.00</td></tr>` +
+		`<tr><td class="cell">2</td><td>Set a banner!</td><td>pass</td><td>2.50</td></tr>` +
+		`<tr><td class="cell">3</td><td>Enable auditing!</td><td>pass</td><td>1.00</td></tr>` +
+		`</table><ul><li class="high">r2</li><li>r1</li><li class="high">r3</li></ul></body></html>`
+	if got != want {
+		t.Errorf("got\n%s\nwanted\n%s", got, want)
+	}
+}
+
+func TestHTMLDoctype(t *testing.T) {
+	const sheet = `<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:output method="html" %s/>
+  <xsl:template match="/"><html><body><br/></body></html></xsl:template>
+</xsl:stylesheet>`
+	for attrs, want := range map[string]string{
+		``: `<html><body><br></body></html>`,
+		`doctype-public="-//W3C//DTD HTML 4.01//EN"`: "<!DOCTYPE html PUBLIC \"-//W3C//DTD HTML 4.01//EN\">\n<html><body><br></body></html>",
+		`doctype-system="about:legacy-compat"`:       "<!DOCTYPE html SYSTEM \"about:legacy-compat\">\n<html><body><br></body></html>",
+	} {
+		if got := transformString(t, fmt.Sprintf(sheet, attrs), `<doc/>`, nil, nil); got != want {
+			t.Errorf("%s: got %q, want %q", attrs, got, want)
+		}
+	}
+}
+
+func TestInstructions(t *testing.T) {
+	const doc = `<doc xml:lang="en"><a x="1" y="2"> t <b>u</b></a><a x="3"/><?pi data?><!-- note --><c><d/><d/></c><c><d/></c></doc>`
+	for _, tt := range []struct{ body, want string }{
+		{`<xsl:for-each select="//a"><xsl:value-of select="@x"/>,</xsl:for-each>`, `1,3,`},
+		{`<xsl:for-each select="//@*"><xsl:sort select="." order="descending"/><xsl:value-of select="name()"/></xsl:for-each>`, `xml:langxyx`},
+		{`<xsl:for-each select="//a"><xsl:value-of select="position()"/>/<xsl:value-of select="last()"/>;</xsl:for-each>`, `1/2;2/2;`},
+		{`<xsl:variable name="n" select="count(//d)"/><xsl:value-of select="$n * 2"/>`, `6`},
+		{`<xsl:variable name="f"><i>b</i><i>a</i></xsl:variable><xsl:for-each select="exsl:node-set($f)/i"><xsl:sort/><xsl:value-of select="."/></xsl:for-each>`, `ab`},
+		{`<xsl:variable name="f">x<i>y</i></xsl:variable><xsl:value-of select="concat($f, '|', string-length($f))"/>`, `xy|2`},
+		{`<xsl:call-template name="fact"><xsl:with-param name="n" select="5"/></xsl:call-template>`, `120`},
+		{`<xsl:if test="//a[@y]">yes</xsl:if><xsl:if test="//a[@z]">no</xsl:if>`, `yes`},
+		{`<xsl:choose><xsl:when test="false()">1</xsl:when><xsl:when test="true()">2</xsl:when><xsl:otherwise>3</xsl:otherwise></xsl:choose>`, `2`},
+		{`<xsl:apply-templates select="//c" mode="num"/>`, `1:1.1 1.2 2:2.1 `},
+		{`<xsl:for-each select="//d"><xsl:number level="any" format="i"/><xsl:text> </xsl:text></xsl:for-each>`, `i ii iii `},
+		{`<xsl:number value="1234567" grouping-separator="," grouping-size="3"/>;<xsl:number value="28" format="A"/>;<xsl:number value="3" format="01"/>`, `1,234,567;AB;03`},
+		{`<xsl:value-of select="format-number(0.256, '#.0%')"/>;<xsl:value-of select="format-number(1234.5, '#,##0.00')"/>`, `25.6%;1,234.50`},
+		{`<xsl:value-of select="generate-id(//a) = generate-id(//a[1])"/>;<xsl:value-of select="generate-id(//a[1]) = generate-id(//a[2])"/>`, `true;false`},
+		{`<xsl:for-each select="//a[2]"><xsl:value-of select="count(key('x', current()/@x))"/></xsl:for-each>`, `1`},
+		{`<xsl:value-of select="system-property('xsl:version')"/>;<xsl:value-of select="function-available('key')"/>;<xsl:value-of select="element-available('xsl:sort')"/>`, `1;true;false`},
+		{`<xsl:value-of select="//processing-instruction()"/>|<xsl:value-of select="//comment()"/>`, `data| note `},
+		{`<xsl:text>  kept  </xsl:text>`, `  kept  `},
+		{`<xsl:apply-templates select="//a[1]"/>`, ` t u`},
+		{`<i xsl:version="2.0"><xsl:frobnicate><xsl:fallback>fell back</xsl:fallback></xsl:frobnicate></i>`, `fell back`},
+	} {
+		sheet := `<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform" xmlns:exsl="http://exslt.org/common">
+  <xsl:output method="text"/>
+  <xsl:key name="x" match="a" use="@x"/>
+  <xsl:template match="/">` + tt.body + `</xsl:template>
+  <xsl:template name="fact">
+    <xsl:param name="n"/>
+    <xsl:param name="acc" select="1"/>
+    <xsl:choose>
+      <xsl:when test="$n &lt;= 1"><xsl:value-of select="$acc"/></xsl:when>
+      <xsl:otherwise>
+        <xsl:call-template name="fact">
+          <xsl:with-param name="n" select="$n - 1"/>
+          <xsl:with-param name="acc" select="$acc * $n"/>
+        </xsl:call-template>
+      </xsl:otherwise>
+    </xsl:choose>
+  </xsl:template>
+  <xsl:template match="c" mode="num"><xsl:number/>:<xsl:apply-templates mode="num"/></xsl:template>
+  <xsl:template match="d" mode="num"><xsl:number level="multiple" count="c|d" format="1.1"/><xsl:text> </xsl:text></xsl:template>
+</xsl:stylesheet>`
+		got := transformString(t, sheet, doc, nil, nil)
+		if got != tt.want {
+			t.Errorf("%s = %q, wanted %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	files := map[string]string{
+		"base.xsl": `<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:variable name="v" select="'base'"/>
+  <xsl:template match="item">[<xsl:value-of select="."/>]</xsl:template>
+</xsl:stylesheet>`,
+		"named.xsl": `<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:template name="hello">hello</xsl:template>
+</xsl:stylesheet>`,
+	}
+	var messages bytes.Buffer
+	opts := &Options{
+		Load: func(href string) (*xmltree.Element, error) {
+			s, ok := files[href]
+			if !ok {
+				return nil, fmt.Errorf("no such file %s", href)
+			}
+			return parse(t, s), nil
+		},
+		Messages: &messages,
+	}
+	got := transformString(t, `<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:import href="base.xsl"/>
+  <xsl:include href="named.xsl"/>
+  <xsl:output method="text"/>
+  <xsl:variable name="v" select="'main'"/>
+  <xsl:template match="/">
+    <xsl:call-template name="hello"/>:<xsl:value-of select="$v"/>:<xsl:apply-templates select="//item"/>
+  </xsl:template>
+  <xsl:template match="item[@x]">
+    <xsl:message>item <xsl:value-of select="@x"/></xsl:message>
+    <xsl:text>{</xsl:text><xsl:apply-imports/><xsl:text>}</xsl:text>
+  </xsl:template>
+</xsl:stylesheet>`, `<r><item x="1">a</item><item>b</item></r>`, opts, nil)
+	if want := "hello:main:{[a]}[b]"; got != want {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+	if want := "item 1\n"; messages.String() != want {
+		t.Errorf("messages %q, wanted %q", messages.String(), want)
+	}
+
+	_, err := Compile(parse(t, `<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:import href="missing.xsl"/>
+</xsl:stylesheet>`), opts)
+	if err == nil || !strings.Contains(err.Error(), "missing.xsl") {
+		t.Errorf("got %v, wanted an error loading missing.xsl", err)
+	}
+}
+
+func TestOutput(t *testing.T) {
+	got := transformString(t, `<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:output indent="yes" doctype-system="r.dtd" doctype-public="-//R//EN" cdata-section-elements="r:code" standalone="yes" xmlns:r="urn:r"/>
+  <xsl:template match="/">
+    <xsl:processing-instruction name="style">href="r.css"</xsl:processing-instruction>
+    <r xmlns="urn:r" xmlns:q="urn:q">
+      <code>a &lt; b</code>
+      <p>x<b>y</b></p>
+      <xsl:element name="q:e" namespace="urn:other">
+        <xsl:attribute name="p:a" namespace="urn:p">v</xsl:attribute>
+      </xsl:element>
+      <xsl:comment>done</xsl:comment>
+    </r>
+  </xsl:template>
+</xsl:stylesheet>`, `<r/>`, nil, nil)
+	want := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<!DOCTYPE r PUBLIC "-//R//EN" "r.dtd">
+<?style href="r.css"?><r xmlns="urn:r" xmlns:q="urn:q">
+  <code><![CDATA[a < b]]></code>
+  <p>x<b>y</b></p>
+  <q:e p:a="v" xmlns:q="urn:other" xmlns:p="urn:p" />
+  <!--done-->
+</r>
+`
+	if got != want {
+		t.Errorf("got\n%s\nwanted\n%s", got, want)
+	}
+
+	s, err := Compile(parse(t, `<out xsl:version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:copy-of select="/r/@*"/>
+  <xsl:value-of select="count(//i)"/>
+</out>`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	el, err := s.Transform(parse(t, `<r a="1"><i/><i/></r>`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if el.Name.Local != "out" || el.Attr("", "a") != "1" || el.Content != "2" {
+		t.Errorf("got %s", el)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	const head = `<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">`
+	for _, tt := range []struct{ sheet, want string }{
+		{`<notxsl/>`, "not a stylesheet"},
+		{head + `<xsl:template match="a["/></xsl:stylesheet>`, `invalid pattern "a["`},
+		{head + `<xsl:bogus/></xsl:stylesheet>`, "unknown declaration xsl:bogus"},
+		{head + `<xsl:template match="/"><xsl:value-of/></xsl:template></xsl:stylesheet>`, "without a select attribute"},
+		{head + `<xsl:include href="x.xsl"/></xsl:stylesheet>`, "without Options.Load"},
+		{head + `<xsl:template name="a"/><xsl:template name="a"/></xsl:stylesheet>`, "more than one template named a"},
+		{head + `<xsl:template match="/"><xsl:call-template name="x"/></xsl:template></xsl:stylesheet>`, "no template named x"},
+		{head + `<xsl:template match="/" name="x"><xsl:call-template name="x"/></xsl:template></xsl:stylesheet>`, "nested more than"},
+		{head + `<xsl:variable name="a" select="$b"/><xsl:variable name="b" select="$a"/><xsl:template match="/"><x/></xsl:template></xsl:stylesheet>`, "refers to itself"},
+		{head + `<xsl:template match="/"><a/><b/></xsl:template></xsl:stylesheet>`, "more than one document element"},
+		{head + `<xsl:template match="/"><xsl:message terminate="yes">stop</xsl:message></xsl:template></xsl:stylesheet>`, "terminated by xsl:message: stop"},
+	} {
+		s, err := Compile(parse(t, tt.sheet), nil)
+		if err == nil {
+			_, err = s.Transform(parse(t, `<r/>`), nil)
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, wanted an error containing %q", tt.sheet, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Package yamlconv converts trees of xmltree Elements to and from YAML,
+// using the same conventions as xmltree.ToJSON and xmltree.FromJSON.
+// It is kept apart from the xmltree package so that only programs
+// which use YAML depend on a YAML library.
+package yamlconv
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pschou/go-xmltree"
+	"gopkg.in/yaml.v3"
+)
+
+// maxDepth limits the nesting of the documents converted.
+const maxDepth = 3000
+
+var errDeep = errors.New("yamlconv: document too deeply nested")
+
+// ToYAML converts el to YAML. The mapping is the same as that made by
+// xmltree.ToJSON with conv, so attributes, text and repeated elements
+// are represented the same way in both. The JSONLossless style, used
+// when conv is nil, keeps everything needed to rebuild the document,
+// while JSONFriendly is better suited to writing fragments by hand,
+// particularly along with conv.Namespaces. Text is written in block
+// style when it spans several lines, and with other styles than
+// JSONLossless, text which reads as a number or boolean is not quoted.
+func ToYAML(el *xmltree.Element, conv *xmltree.JSONConvention) ([]byte, error) {
+	data, err := xmltree.ToJSON(el, conv)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	friendly := conv != nil && conv.Style != xmltree.JSONLossless
+	n, err := yamlNode(d, friendly, 0)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(n); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// FromYAML builds a tree of Elements from YAML written as by ToYAML
+// with conv. Scalars of any type are taken as text, as written, so
+// version: 2.0 gives the attribute or text "2.0". Only the first
+// document in r is read.
+func FromYAML(r io.Reader, conv *xmltree.JSONConvention) (*xmltree.Element, error) {
+	var doc yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, &doc, 0); err != nil {
+		return nil, err
+	}
+	return xmltree.FromJSON(&buf, conv)
+}
+
+// yamlNode reads a JSON value from d and converts it to a YAML node,
+// keeping the order of object members. Unless the mapping is lossless,
+// text which reads as a number or boolean is written without quotes;
+// FromYAML takes it back as written.
+func yamlNode(d *json.Decoder, friendly bool, depth int) (*yaml.Node, error) {
+	if depth > maxDepth {
+		return nil, errDeep
+	}
+	tok, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch v := tok.(type) {
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(v)}, nil
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(v.String(), ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: v.String()}, nil
+	case string:
+		n := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v}
+		if _, err := strconv.ParseFloat(v, 64); friendly && (err == nil || v == "true" || v == "false") {
+			n.Tag = ""
+		}
+		if strings.Contains(strings.TrimRight(v, "\n"), "\n") && strings.TrimSpace(v) == v {
+			n.Style = yaml.LiteralStyle
+		}
+		return n, nil
+	case json.Delim:
+		n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		if v == '{' {
+			n.Kind, n.Tag = yaml.MappingNode, "!!map"
+		}
+		for d.More() {
+			item, err := yamlNode(d, friendly, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			n.Content = append(n.Content, item)
+		}
+		if _, err := d.Token(); err != nil { // the closing delimiter
+			return nil, err
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("yamlconv: unexpected JSON token %v", tok)
+}
+
+// writeJSON writes a YAML node as JSON, with every scalar other than
+// null as a string.
+func writeJSON(buf *bytes.Buffer, n *yaml.Node, depth int) error {
+	if depth > maxDepth {
+		return errDeep
+	}
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return errors.New("yamlconv: empty YAML document")
+		}
+		return writeJSON(buf, n.Content[0], depth+1)
+	case yaml.AliasNode:
+		return writeJSON(buf, n.Alias, depth+1)
+	case yaml.ScalarNode:
+		if n.ShortTag() == "!!null" {
+			buf.WriteString("null")
+			return nil
+		}
+		return writeString(buf, n.Value)
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, item := range n.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSON(buf, item, depth+1); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	case yaml.MappingNode:
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i]
+			if key.Kind != yaml.ScalarNode {
+				return fmt.Errorf("yamlconv: YAML mapping keys must be scalars, at line %d", key.Line)
+			}
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeString(buf, key.Value); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := writeJSON(buf, n.Content[i+1], depth+1); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+		return nil
+	}
+	return fmt.Errorf("yamlconv: unexpected YAML node at line %d", n.Line)
+}
+
+func writeString(buf *bytes.Buffer, s string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	buf.Write(data)
+	return nil
+}
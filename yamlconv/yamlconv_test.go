@@ -0,0 +1,101 @@
+package yamlconv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-xmltree"
+)
+
+const sample = `<oval_definitions xmlns="http://oval.mitre.org/XMLSchema/oval-definitions-5" xmlns:oval="http://oval.mitre.org/XMLSchema/oval-common-5">
+  <generator><oval:schema_version>5.11</oval:schema_version></generator>
+  <definitions>
+    <definition id="def:1" version="2"><title>CVE-1</title><reference source="CVE" ref_id="CVE-1"/></definition>
+  </definitions>
+  <tests>
+    <test id="tst:1" check="all"/>
+    <test id="tst:2" check="all"/>
+  </tests>
+</oval_definitions>`
+
+func parse(t *testing.T, s string) *xmltree.Element {
+	t.Helper()
+	root, err := xmltree.Parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	root := parse(t, sample)
+	for _, style := range []xmltree.JSONStyle{xmltree.JSONLossless, xmltree.JSONFriendly, xmltree.JSONBadgerFish, xmltree.JSONGData} {
+		conv := &xmltree.JSONConvention{Style: style}
+		data, err := ToYAML(root, conv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		back, err := FromYAML(bytes.NewReader(data), conv)
+		if err != nil {
+			t.Fatalf("style %d: %v\n%s", style, err, data)
+		}
+		if !xmltree.Equal(root, back) {
+			t.Errorf("style %d: did not round trip:\n%s\n%s", style, data, back)
+		}
+	}
+
+	const mixed = `<description xmlns:h="http://www.w3.org/1999/xhtml">Run <h:code>umask</h:code>:<h:pre xml:space="preserve">umask 077
+id -u</h:pre><!-- note --></description>`
+	root = parse(t, mixed)
+	data, err := ToYAML(root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := FromYAML(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := back.String(); got != root.String() {
+		t.Errorf("lossless YAML did not round trip:\n%s\n%s", data, got)
+	}
+}
+
+func TestFromYAMLFragment(t *testing.T) {
+	const fragment = `
+textfilecontent54_state:
+  '@id': oval:example:ste:1
+  '@version': 1
+  subexpression:
+    '@operation': pattern match
+    '#text': ^0?77$
+  instance:
+    '@datatype': int
+    '#text': 1
+`
+	conv := &xmltree.JSONConvention{
+		Style: xmltree.JSONFriendly,
+		Namespaces: map[string]string{
+			"": "http://oval.mitre.org/XMLSchema/oval-definitions-5#independent",
+		},
+	}
+	el, err := FromYAML(strings.NewReader(fragment), conv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<textfilecontent54_state xmlns="http://oval.mitre.org/XMLSchema/oval-definitions-5#independent" id="oval:example:ste:1" version="1">` +
+		`<subexpression operation="pattern match">^0?77$</subexpression><instance datatype="int">1</instance></textfilecontent54_state>`
+	if !xmltree.Equal(el, parse(t, want)) {
+		t.Errorf("got %s, wanted %s", el, want)
+	}
+
+	// Writing the fragment back leaves out the known namespace, and
+	// does not quote numbers.
+	data, err := ToYAML(el, conv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "xmlns") || !strings.Contains(string(data), "'@version': 1\n") {
+		t.Errorf("unexpected YAML:\n%s", data)
+	}
+}